@@ -133,6 +133,7 @@ type PullRequestClient interface {
 	ListPullRequestComments(org, repo string, number int) ([]ReviewComment, error)
 	CreatePullRequestReviewComment(org, repo string, number int, rc ReviewComment) error
 	ListReviews(org, repo string, number int) ([]Review, error)
+	ListReviewThreads(org, repo string, number int) ([]ReviewThread, error)
 	ClosePR(org, repo string, number int) error
 	ReopenPR(org, repo string, number int) error
 	CreateReview(org, repo string, number int, r DraftReview) error
@@ -2287,6 +2288,55 @@ func (c *client) ListReviews(org, repo string, number int) ([]Review, error) {
 	return reviews, nil
 }
 
+// ListReviewThreads returns the review threads of a pull request and whether
+// they are resolved, which the REST API doesn't expose.
+//
+// See https://docs.github.com/en/graphql/reference/objects#pullrequestreviewthread
+func (c *client) ListReviewThreads(org, repo string, number int) ([]ReviewThread, error) {
+	durationLogger := c.log("ListReviewThreads", org, repo, number)
+	defer durationLogger()
+
+	if c.fake {
+		return nil, nil
+	}
+	vars := map[string]interface{}{
+		"org":    githubql.String(org),
+		"repo":   githubql.String(repo),
+		"number": githubql.Int(number),
+		"cursor": (*githubql.String)(nil),
+	}
+	var threads []ReviewThread
+	for {
+		var query struct {
+			Repository struct {
+				PullRequest struct {
+					ReviewThreads struct {
+						Nodes []struct {
+							Path       githubql.String
+							IsResolved githubql.Boolean
+						}
+						PageInfo struct {
+							HasNextPage githubql.Boolean
+							EndCursor   githubql.String
+						}
+					} `graphql:"reviewThreads(first: 100, after: $cursor)"`
+				} `graphql:"pullRequest(number: $number)"`
+			} `graphql:"repository(owner: $org, name: $repo)"`
+		}
+		if err := c.QueryWithGitHubAppsSupport(context.Background(), &query, vars, org); err != nil {
+			return nil, fmt.Errorf("failed to list review threads of %s/%s#%d: %w", org, repo, number, err)
+		}
+		page := query.Repository.PullRequest.ReviewThreads
+		for _, node := range page.Nodes {
+			threads = append(threads, ReviewThread{Path: string(node.Path), IsResolved: bool(node.IsResolved)})
+		}
+		if !page.PageInfo.HasNextPage {
+			return threads, nil
+		}
+		vars["cursor"] = githubql.NewString(page.PageInfo.EndCursor)
+	}
+}
+
 // CreateStatus creates or updates the status of a commit.
 //
 // See https://docs.github.com/en/free-pro-team@latest/rest/reference/repos#create-a-commit-status
@@ -2000,6 +2000,42 @@ func TestListIssueEvents(t *testing.T) {
 	}
 }
 
+func TestListIssueEventsPaginated(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Bad method: %s", r.Method)
+		}
+		if r.URL.Path == "/repos/org/repo/issues/1/events" {
+			events := []ListedIssueEvent{{Event: IssueActionLabeled}}
+			b, err := json.Marshal(events)
+			if err != nil {
+				t.Fatalf("Didn't expect error: %v", err)
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<blorp>; rel="first", <https://%s/someotherpath>; rel="next"`, r.Host))
+			fmt.Fprint(w, string(b))
+		} else if r.URL.Path == "/someotherpath" {
+			events := []ListedIssueEvent{{Event: IssueActionClosed}}
+			b, err := json.Marshal(events)
+			if err != nil {
+				t.Fatalf("Didn't expect error: %v", err)
+			}
+			fmt.Fprint(w, string(b))
+		} else {
+			t.Errorf("Bad request path: %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := getClient(ts.URL)
+	events, err := c.ListIssueEvents("org", "repo", 1)
+	if err != nil {
+		t.Errorf("Didn't expect error: %v", err)
+	} else if len(events) != 2 {
+		t.Errorf("Expected two events, found %d: %v", len(events), events)
+	} else if events[0].Event != IssueActionLabeled || events[1].Event != IssueActionClosed {
+		t.Errorf("Wrong events: %v", events)
+	}
+}
+
 func TestThrottle(t *testing.T) {
 	logrus.SetLevel(logrus.DebugLevel)
 	t.Parallel()
@@ -54,6 +54,7 @@ type FakeClient struct {
 	PullRequestReviewComments  map[int][]github.ReviewComment
 	ReviewID                   int
 	Reviews                    map[int][]github.Review
+	ReviewThreads              map[int][]github.ReviewThread
 	CombinedStatuses           map[string]*github.CombinedStatus
 	CreatedStatuses            map[string][]github.Status
 	IssueEvents                map[int][]github.ListedIssueEvent
@@ -222,6 +223,13 @@ func (f *FakeClient) ListReviews(owner, repo string, number int) ([]github.Revie
 	return append([]github.Review{}, f.Reviews[number]...), nil
 }
 
+// ListReviewThreads lists the review threads of a PR
+func (f *FakeClient) ListReviewThreads(owner, repo string, number int) ([]github.ReviewThread, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return append([]github.ReviewThread{}, f.ReviewThreads[number]...), nil
+}
+
 // ListIssueEvents returns issue events
 func (f *FakeClient) ListIssueEvents(owner, repo string, number int) ([]github.ListedIssueEvent, error) {
 	f.lock.RLock()
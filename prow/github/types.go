@@ -691,6 +691,14 @@ const (
 	IssueActionLocked IssueEventAction = "locked"
 	// IssueActionUnlocked means the issue was unlocked.
 	IssueActionUnlocked IssueEventAction = "unlocked"
+	// IssueActionReadyForReview means the draft PR was marked as ready for review.
+	IssueActionReadyForReview IssueEventAction = "ready_for_review"
+	// IssueActionConvertToDraft means the PR was converted to a draft.
+	IssueActionConvertToDraft IssueEventAction = "convert_to_draft"
+	// IssueActionReviewDismissed means a review of the pull request was dismissed.
+	IssueActionReviewDismissed IssueEventAction = "review_dismissed"
+	// IssueActionRenamed means the title of the issue or PR was changed.
+	IssueActionRenamed IssueEventAction = "renamed"
 )
 
 // IssueEvent represents an issue event from a webhook payload (not from the events API).
@@ -713,6 +721,23 @@ type ListedIssueEvent struct {
 	Actor     User             `json:"actor"`
 	Label     Label            `json:"label"`
 	CreatedAt time.Time        `json:"created_at"`
+	// DismissedReview is specified for IssueActionReviewDismissed events.
+	DismissedReview *DismissedReview `json:"dismissed_review,omitempty"`
+	// Rename is specified for IssueActionRenamed events.
+	Rename *IssueRename `json:"rename,omitempty"`
+}
+
+// IssueRename is the title change of an IssueActionRenamed event.
+type IssueRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DismissedReview identifies the review dismissed by an IssueActionReviewDismissed event.
+type DismissedReview struct {
+	State            ReviewState `json:"state"`
+	ReviewID         int         `json:"review_id"`
+	DismissalMessage string      `json:"dismissal_message"`
 }
 
 // IssueCommentEventAction enumerates the triggers for this
@@ -911,6 +936,12 @@ type Review struct {
 	SubmittedAt time.Time   `json:"submitted_at"`
 }
 
+// ReviewThread is a conversation on the diff of a pull request.
+type ReviewThread struct {
+	Path       string
+	IsResolved bool
+}
+
 // ReviewCommentEventAction enumerates the triggers for this
 // webhook payload type. See also:
 // https://developer.github.com/v3/activity/events/types/#pullrequestreviewcommentevent
@@ -17,22 +17,33 @@ limitations under the License.
 package approve
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/url"
+	"path"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	"k8s.io/apimachinery/pkg/util/clock"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
 	"k8s.io/test-infra/prow/config"
 	"k8s.io/test-infra/prow/github"
 	"k8s.io/test-infra/prow/labels"
 	"k8s.io/test-infra/prow/pluginhelp"
 	"k8s.io/test-infra/prow/plugins"
 	"k8s.io/test-infra/prow/plugins/approve/approvers"
+	"k8s.io/test-infra/prow/plugins/ownersconfig"
 	"k8s.io/test-infra/prow/repoowners"
 )
 
@@ -40,35 +51,142 @@ const (
 	// PluginName defines this plugin's registered name.
 	PluginName = "approve"
 
-	approveCommand       = "APPROVE"
-	cancelArgument       = "cancel"
-	lgtmCommand          = "LGTM"
-	noIssueArgument      = "no-issue"
-	removeApproveCommand = "REMOVE-APPROVE"
+	afterPrefix           = "after:"
+	aliasPrefix           = "as:"
+	approveCommand        = "APPROVE"
+	assignArgument        = "assign"
+	cancelArgument        = "cancel"
+	deletionsOnlyArgument = "deletions-only"
+	diffArgument          = "diff"
+	dumpArgument          = "dump"
+	durationPrefix        = "for:"
+	forceArgument         = "force"
+	historyArgument       = "history"
+	lgtmCommand           = "LGTM"
+	noIssueArgument       = "no-issue"
+	refreshArgument       = "refresh"
+	removeApproveCommand  = "REMOVE-APPROVE"
+	requireIssueArgument  = "require-issue"
+	shaPrefix             = "sha:"
+	simulateArgument      = "simulate"
+	stackArgument         = "stack"
+	uptoPrefix            = "upto:"
+
+	// notificationAttempts is how often posting the notification is attempted
+	// before giving up until the next event.
+	notificationAttempts = 3
+
+	// minApprovalSHALength is the minimum length of the commit SHA prefix of
+	// an approval such as "/approve sha:abc1234", as with git short SHAs.
+	minApprovalSHALength = 7
+
+	// deprecatedBotName is the login of the bot that posted approval
+	// notifications before this plugin did.
+	deprecatedBotName = "k8s-merge-robot"
+
+	// statusContext is the context of the commit status published when
+	// PublishCommitStatus is enabled.
+	statusContext = "approve"
 )
 
 var (
+	// The issue regex formats are filled in with the pattern matching the
+	// org part of issue links, see issueLinkPattern.
 	associatedIssueRegexFormat = `(?:%s/[^/]+/issues/|#)(\d+)`
-	commandRegex               = regexp.MustCompile(`(?m)^/([^\s]+)[\t ]*([^\n\r]*)`)
-	notificationRegex          = regexp.MustCompile(`(?is)^\[` + approvers.ApprovalNotificationName + `\] *?([^\n]*)(?:\n\n(.*))?`)
+	// closingIssueRegexFormat matches the keywords GitHub recognizes for
+	// closing issues, e.g. "Fixes #1" or "Closes: https://github.com/org/repo/issues/1".
+	closingIssueRegexFormat = `(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?):?\s+(?:%s/[^/]+/issues/|#)(\d+)`
+	linkedPullRegexFormat   = `(?:%s/%s/pull/|#)(\d+)`
+	// originalPRRegex matches the trailer naming the PR that a PR was created
+	// from, e.g. when it was rebased into a merge queue branch.
+	originalPRRegex = regexp.MustCompile(`(?mi)^Original-PR:[\t ]*#(\d+)[\t ]*$`)
+	// approvalReasonRegex matches the justification of an approval such as
+	// `/approve reason:"reviewed the threat model"`, or a single word reason.
+	approvalReasonRegex = regexp.MustCompile(`(?i)(?:^|\s)reason:(?:"([^"]*)"|(\S+))`)
+	// authorityApprovalRegex matches the lines of AuthorityAccount comments
+	// listing the logins that approved, e.g. "Approved-By: @alice, @bob".
+	authorityApprovalRegex = regexp.MustCompile(`(?mi)^Approved-By:[\t ]*([^\n\r]*)$`)
+	// commandRegex also accepts commands with an extra leading slash, such as
+	// "//approve", which some chat integrations produce.
+	commandRegex      = regexp.MustCompile(`(?m)^//?([^\s/][^\s]*)[\t ]*([^\n\r]*)`)
+	notificationRegex = regexp.MustCompile(`(?is)^\[` + approvers.ApprovalNotificationName + `\] *?([^\n]*)(?:\n\n(.*))?`)
 
 	// handleFunc is used to allow mocking out the behavior of 'handle' while testing.
 	handleFunc = handle
+	// pluginClock is used to allow faking the current time while testing.
+	pluginClock clock.PassiveClock = clock.RealClock{}
+	// botUserCheckers memoizes the bot user checker across events.
+	botUserCheckers = &botUserCheckerCache{}
+	// notificationRetryBackoff is the delay before retrying to post the
+	// notification, doubled with each further attempt.
+	notificationRetryBackoff = time.Second
+	// notificationSleep waits between attempts to post the notification, which
+	// is faked while testing.
+	notificationSleep = time.Sleep
+)
+
+var (
+	// suggestedApprovers and ownersDepth are observed for every PR, to find
+	// repos whose ownership is too narrow or too deep.
+	suggestedApprovers = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "approve_suggested_approvers",
+		Help:    "Number of approvers suggested in the approval notification of a PR.",
+		Buckets: []float64{0, 1, 2, 3, 5, 8, 13, 21},
+	}, []string{"org", "repo"})
+	ownersDepth = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "approve_owners_max_depth",
+		Help:    "Directory depth of the deepest OWNERS file that needs to approve a PR.",
+		Buckets: []float64{0, 1, 2, 3, 4, 5, 6, 8, 10},
+	}, []string{"org", "repo"})
 )
 
-type githubClient interface {
+// GitHubClient is the GitHub client used by the plugin. The List methods are
+// expected to return all results rather than a single page, as the approval
+// state is computed from the whole history of the PR. The GitHub client does
+// so by following the pagination links of the API.
+type GitHubClient interface {
 	GetPullRequest(org, repo string, number int) (*github.PullRequest, error)
 	GetPullRequestChanges(org, repo string, number int) ([]github.PullRequestChange, error)
 	GetIssueLabels(org, repo string, number int) ([]github.Label, error)
+	GetIssue(org, repo string, number int) (*github.Issue, error)
+	FindIssues(query, sort string, asc bool) ([]github.Issue, error)
 	ListIssueComments(org, repo string, number int) ([]github.IssueComment, error)
 	ListReviews(org, repo string, number int) ([]github.Review, error)
+	ListReviewThreads(org, repo string, number int) ([]github.ReviewThread, error)
 	ListPullRequestComments(org, repo string, number int) ([]github.ReviewComment, error)
 	DeleteComment(org, repo string, ID int) error
 	CreateComment(org, repo string, number int, comment string) error
+	EditComment(org, repo string, ID int, comment string) error
+	CreateReview(org, repo string, number int, r github.DraftReview) error
 	BotUserChecker() (func(candidate string) bool, error)
+	CreateStatus(org, repo, SHA string, s github.Status) error
 	AddLabel(org, repo string, number int, label string) error
+	AssignIssue(org, repo string, number int, logins []string) error
 	RemoveLabel(org, repo string, number int, label string) error
 	ListIssueEvents(org, repo string, num int) ([]github.ListedIssueEvent, error)
+	ListPRCommits(org, repo string, number int) ([]github.RepositoryCommit, error)
+	GetSingleCommit(org, repo, SHA string) (github.RepositoryCommit, error)
+}
+
+// botClient is the part of the GitHub client that identifies the bot.
+type botClient interface {
+	BotUserChecker() (func(candidate string) bool, error)
+}
+
+// notificationClient is the subset of the GitHub client that
+// CleanupNotifications needs.
+type notificationClient interface {
+	botClient
+	ListIssueComments(org, repo string, number int) ([]github.IssueComment, error)
+	DeleteComment(org, repo string, ID int) error
+}
+
+// staleApprovalClient is the subset of the GitHub client that
+// LabelStaleUnapprovedPRs needs.
+type staleApprovalClient interface {
+	GetPullRequests(org, repo string) ([]github.PullRequest, error)
+	AddLabel(org, repo string, number int, label string) error
+	RemoveLabel(org, repo string, number int, label string) error
 }
 
 type ownersClient interface {
@@ -76,21 +194,49 @@ type ownersClient interface {
 }
 
 type state struct {
-	org    string
-	repo   string
-	branch string
-	number int
+	org     string
+	repo    string
+	branch  string
+	number  int
+	headSHA string
+	draft   bool
 
 	body      string
 	author    string
 	assignees []github.User
 	htmlURL   string
+	createdAt time.Time
+
+	// previousBranch is the base branch the PR was retargeted from by the
+	// event being handled, if any.
+	previousBranch string
+	// opened is set if the event being handled opened or reopened the PR.
+	opened bool
+	// fromFork is whether the head branch of the PR lives in another repo
+	// than the base branch.
+	fromFork bool
+
+	// actor is the user that triggered the event being handled.
+	actor string
+	// commentBody is the body of the comment or review that triggered the
+	// event being handled, if any.
+	commentBody string
+	// dump is set if an admin approver asked for the approval state with
+	// "/approve dump", in which case only the state is posted.
+	dump bool
+	// simulate are the logins of "/approve simulate @alice", in which case
+	// only whether their approval would approve the PR is posted.
+	simulate []string
+	// history is set if someone asked for the approval history with
+	// "/approve history", in which case only the history is posted.
+	history bool
 }
 
 func init() {
 	plugins.RegisterGenericCommentHandler(PluginName, handleGenericCommentEvent, helpProvider)
 	plugins.RegisterReviewEventHandler(PluginName, handleReviewEvent, helpProvider)
 	plugins.RegisterPullRequestHandler(PluginName, handlePullRequestEvent, helpProvider)
+	prometheus.MustRegister(suggestedApprovers, ownersDepth)
 }
 
 func helpProvider(config *plugins.Configuration, enabledRepos []config.OrgRepo) (*pluginhelp.PluginHelp, error) {
@@ -144,9 +290,247 @@ func helpProvider(config *plugins.Configuration, enabledRepos []config.OrgRepo)
 		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
 		Examples:    []string{"/approve", "/approve no-issue", "/remove-approve"},
 	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve for:<duration>",
+		Description: "Approves a pull request for the given duration only, e.g. for a conditional approval. The approval, and any earlier approval it replaced, is dropped once it expired and the pull request is processed again.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
+		Examples:    []string{"/approve for:72h"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve after:#<number>",
+		Description: "Approves a pull request once the given pull request of the same repository merged. The approval counts from the next time the pull request is processed after the merge.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
+		Examples:    []string{"/approve after:#123"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       `/approve reason:"<justification>"`,
+		Description: "Approves the pull request giving a justification, which approving changes to sensitive paths requires. The reason is shown in the approval notification.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
+		Examples:    []string{`/approve reason:"reviewed the threat model"`},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve as:<alias>",
+		Description: "Approves the pull request in the capacity of an OWNERS_ALIASES alias the approver is a member of, which the approval notification shows.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
+		Examples:    []string{"/approve as:security-reviewers"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve upto:<commit>",
+		Description: "Approves the changes of a pull request up to the given commit, e.g. the last one reviewed before a rebase. The approval doesn't cover the files changed by later commits.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
+		Examples:    []string{"/approve upto:abc1234"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve sha:<commit>",
+		Description: "Approves a pull request as of the given commit only. The approval, and any earlier approval it replaced, is dropped once the head of the pull request moves on.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
+		Examples:    []string{"/approve sha:1a2b3c4d"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve deletions-only",
+		Description: "Approves only the files that the pull request deletes. OWNERS files covering added or modified files still need another approval.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
+		Examples:    []string{"/approve deletions-only"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve diff",
+		Description: "Lists the files changed by the commits pushed since your latest approval of the pull request, to help re-reviewing it.",
+		WhoCanUse:   "Anyone",
+		Examples:    []string{"/approve diff"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve require-issue",
+		Description: "Requires an associated issue for the pull request even if the repository doesn't, withholding the '" + labels.Approved + "' label until an issue is linked.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files, or as 'admin_approvers' in the approve plugin configuration.",
+		Examples:    []string{"/approve require-issue"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve force <reason>",
+		Description: "Applies the '" + labels.Approved + "' label regardless of OWNERS and associated issue requirements. The reason is recorded in an audit comment.",
+		WhoCanUse:   "Users listed as 'admin_approvers' in the approve plugin configuration.",
+		Examples:    []string{"/approve force fixing a production outage"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve refresh",
+		Description: "Reprocesses the pull request from scratch, recomputing the '" + labels.Approved + "' label and the approval notification. Use this if an event was missed.",
+		WhoCanUse:   "Users listed as 'admin_approvers' in the approve plugin configuration.",
+		Examples:    []string{"/approve refresh"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve dump",
+		Description: "Comments the current approvers, unapproved directories and plugin options of the pull request as JSON, for scripting. Labels are left untouched.",
+		WhoCanUse:   "Users listed as 'admin_approvers' in the approve plugin configuration.",
+		Examples:    []string{"/approve dump"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve history",
+		Description: "Comments the chronological list of approvals and cancellations of the pull request. Labels are left untouched.",
+		WhoCanUse:   "Anyone",
+		Examples:    []string{"/approve history"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve simulate @<user>",
+		Description: "Comments whether the pull request would be approved if the mentioned users approved it as well. Nothing is approved and labels are left untouched.",
+		WhoCanUse:   "Anyone",
+		Examples:    []string{"/approve simulate @alice", "/approve simulate @alice @bob"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve stack",
+		Description: "Approves the pull request and asks for approval of the stacked pull requests referenced in its body.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files, in repos with 'stack_approvals' enabled.",
+		Examples:    []string{"/approve stack"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve assign @<user> [@<user>...]",
+		Description: "Assigns the given approvers of the unapproved OWNERS files and lists them as the designated approvers in the approval notification. Users that can't approve any of the unapproved files are ignored.",
+		WhoCanUse:   "Users listed as 'approvers' in appropriate OWNERS files.",
+		Examples:    []string{"/approve assign @alice @bob"},
+	})
+	pluginHelp.AddCommand(pluginhelp.Command{
+		Usage:       "/approve cancel @<user>",
+		Description: "Cancels the approval of another user, e.g. one who is no longer involved with the PR.",
+		WhoCanUse:   "Users listed as 'admin_approvers' in the approve plugin configuration.",
+		Examples:    []string{"/approve cancel @alice"},
+	})
 	return pluginHelp, nil
 }
 
+// ApprovalTransition describes the approved label being added to or removed
+// from a PR by the plugin.
+type ApprovalTransition struct {
+	Org    string
+	Repo   string
+	Number int
+	// Approved is true if the PR became approved and false if it lost approval.
+	Approved bool
+}
+
+// ApprovalTransitionHandler is called whenever the approval of a PR changes.
+type ApprovalTransitionHandler func(log *logrus.Entry, transition ApprovalTransition)
+
+var (
+	approvalTransitionHandlersLock sync.RWMutex
+	approvalTransitionHandlers     []ApprovalTransitionHandler
+)
+
+// RegisterApprovalTransitionHandler registers a handler that is called after
+// the plugin added or removed the approved label of a PR, e.g. to notify
+// downstream automation without polling. It is not called when the label was
+// already in the desired state.
+func RegisterApprovalTransitionHandler(handler ApprovalTransitionHandler) {
+	approvalTransitionHandlersLock.Lock()
+	defer approvalTransitionHandlersLock.Unlock()
+	approvalTransitionHandlers = append(approvalTransitionHandlers, handler)
+}
+
+func notifyApprovalTransition(log *logrus.Entry, transition ApprovalTransition) {
+	approvalTransitionHandlersLock.RLock()
+	defer approvalTransitionHandlersLock.RUnlock()
+	for _, handler := range approvalTransitionHandlers {
+		handler(log, transition)
+	}
+}
+
+// OwnershipResolverFactory returns the resolver of the approvers of the PRs
+// to branch in org/repo, or nil to resolve them from the OWNERS files.
+type OwnershipResolverFactory func(org, repo, branch string) approvers.OwnershipResolver
+
+var (
+	ownershipResolverFactoryLock sync.RWMutex
+	ownershipResolverFactory     OwnershipResolverFactory
+)
+
+// RegisterOwnershipResolverFactory makes the plugin resolve the approvers of
+// PRs with the resolvers of factory instead of the OWNERS files, for ownership
+// systems that aren't file based. A nil factory restores the OWNERS files.
+func RegisterOwnershipResolverFactory(factory OwnershipResolverFactory) {
+	ownershipResolverFactoryLock.Lock()
+	defer ownershipResolverFactoryLock.Unlock()
+	ownershipResolverFactory = factory
+}
+
+func ownershipResolverFor(org, repo, branch string) approvers.OwnershipResolver {
+	ownershipResolverFactoryLock.RLock()
+	defer ownershipResolverFactoryLock.RUnlock()
+	if ownershipResolverFactory == nil {
+		return nil
+	}
+	return ownershipResolverFactory(org, repo, branch)
+}
+
+// OwnersLoadError is returned when the OWNERS files of the branch a PR targets
+// cannot be loaded, e.g. because one of them is malformed.
+type OwnersLoadError struct {
+	Org  string
+	Repo string
+	Base string
+	Err  error
+}
+
+func (e *OwnersLoadError) Error() string {
+	return fmt.Sprintf("failed to load OWNERS files for %s/%s@%s: %v", e.Org, e.Repo, e.Base, e.Err)
+}
+
+func (e *OwnersLoadError) Unwrap() error {
+	return e.Err
+}
+
+// loadRepoOwners resolves the OWNERS of the base branch. On failure it returns
+// an *OwnersLoadError and, if configured, explains the failure on the PR.
+func loadRepoOwners(log *logrus.Entry, ghc GitHubClient, oc ownersClient, opts *plugins.Approve, org, repo, base string, number int) (repoowners.RepoOwner, error) {
+	owners, err := oc.LoadRepoOwners(org, repo, base)
+	if err == nil {
+		cycle := aliasCycle(owners)
+		if len(cycle) == 0 {
+			return owners, nil
+		}
+		err = fmt.Errorf("the OWNERS aliases form a cycle: %s", strings.Join(cycle, " -> "))
+	}
+	loadErr := &OwnersLoadError{Org: org, Repo: repo, Base: base, Err: err}
+	if opts.ReportOwnersErrors {
+		if err := reportOwnersLoadError(ghc, number, loadErr); err != nil {
+			log.WithError(err).Error("Failed to report OWNERS load error.")
+		}
+	}
+	return nil, loadErr
+}
+
+// aliasCycle returns the cycle among the aliases of owners, if they can tell.
+// Aliases listing each other don't resolve to the logins of their members, so
+// approvals through them would silently fail.
+func aliasCycle(owners repoowners.RepoOwner) []string {
+	finder, ok := owners.(interface {
+		FindAliasCycle() []string
+	})
+	if !ok {
+		return nil
+	}
+	return finder.FindAliasCycle()
+}
+
+func ownersLoadErrorMessage(loadErr *OwnersLoadError) string {
+	return fmt.Sprintf("The approval state of this PR could not be computed because the OWNERS files of the `%s` branch could not be loaded:\n\n```\n%v\n```\n\nIf this PR modifies an OWNERS file, please make sure it is valid.", loadErr.Base, loadErr.Err)
+}
+
+// reportOwnersLoadError comments on the PR about loadErr unless the bot already did.
+func reportOwnersLoadError(ghc GitHubClient, number int, loadErr *OwnersLoadError) error {
+	botUserChecker, err := botUserCheckers.get(ghc)
+	if err != nil {
+		return err
+	}
+	issueComments, err := ghc.ListIssueComments(loadErr.Org, loadErr.Repo, number)
+	if err != nil {
+		return err
+	}
+	message := ownersLoadErrorMessage(loadErr)
+	for _, ic := range issueComments {
+		if botUserChecker(ic.User.Login) && ic.Body == message {
+			return nil
+		}
+	}
+	return ghc.CreateComment(loadErr.Org, loadErr.Repo, number, message)
+}
+
 func handleGenericCommentEvent(pc plugins.Agent, ce github.GenericCommentEvent) error {
 	return handleGenericComment(
 		pc.Logger,
@@ -158,27 +542,52 @@ func handleGenericCommentEvent(pc plugins.Agent, ce github.GenericCommentEvent)
 	)
 }
 
-func handleGenericComment(log *logrus.Entry, ghc githubClient, oc ownersClient, githubConfig config.GitHubOptions, config *plugins.Configuration, ce *github.GenericCommentEvent) error {
+func handleGenericComment(log *logrus.Entry, ghc GitHubClient, oc ownersClient, githubConfig config.GitHubOptions, config *plugins.Configuration, ce *github.GenericCommentEvent) error {
 	funcStart := time.Now()
 	defer func() {
 		log.WithField("duration", time.Since(funcStart).String()).Debug("Completed handleGenericComment")
 	}()
-	if ce.Action != github.GenericCommentActionCreated || !ce.IsPR || ce.IssueState == "closed" {
-		log.Debug("Event is not a creation of a comment on an open PR, skipping.")
+	edited := ce.Action == github.GenericCommentActionEdited
+	if (ce.Action != github.GenericCommentActionCreated && !edited) || !ce.IsPR || ce.IssueState == "closed" {
+		log.Debug("Event is not a creation or edit of a comment on an open PR, skipping.")
 		return nil
 	}
 
-	botUserChecker, err := ghc.BotUserChecker()
+	botUserChecker, err := botUserCheckers.get(ghc)
 	if err != nil {
 		return err
 	}
 
 	opts := config.ApproveFor(ce.Repo.Owner.Login, ce.Repo.Name)
-	if !isApprovalCommand(botUserChecker, opts.LgtmActsAsApprove, &comment{Body: ce.Body, Author: ce.User.Login}) {
+	isIgnored := ignoredApproverChecker(botUserChecker, opts)
+	// A refresh by an admin approver reprocesses the PR from scratch, e.g. when
+	// an event was missed and the label or notification drifted.
+	refresh := isRefreshCommand(ce.Body) && isAdminApprover(opts, ce.User.Login)
+	dump := isDumpCommand(ce.Body) && ce.Action == github.GenericCommentActionCreated && isAdminApprover(opts, ce.User.Login)
+	// The event doesn't carry the previous body of an edited comment, so any
+	// edit by a potential approver is reprocessed in case it removed an
+	// approval command.
+	var simulate []string
+	history := false
+	if ce.Action == github.GenericCommentActionCreated && ce.User.Login != "" && !botUserChecker(ce.User.Login) {
+		simulate = simulatedApprovers(ce.Body)
+		history = isHistoryCommand(ce.Body)
+	}
+	reprocess := refresh || dump || len(simulate) > 0 || history || (edited && !isIgnored(ce.User.Login)) || len(authorityApprovedLogins(opts, ce.User.Login, ce.Body)) > 0
+	if !reprocess && !isApprovalCommand(isIgnored, opts.LgtmMayApprove(), &comment{Body: ce.Body, Author: ce.User.Login}) {
+		if opts.SuggestOnTypo && ce.Action == github.GenericCommentActionCreated && ce.User.Login != "" && !botUserChecker(ce.User.Login) {
+			suggestOnTypo(log, ghc, ce, botUserChecker)
+		}
 		log.Debug("Comment does not constitute approval, skipping event.")
 		return nil
 	}
 
+	if isDiffCommand(ce.Body) && ce.Action == github.GenericCommentActionCreated && ce.User.Login != "" && !botUserChecker(ce.User.Login) {
+		if err := postApprovalDiff(log, ghc, ce.Repo.Owner.Login, ce.Repo.Name, ce.Number, ce.User.Login, opts.CancelArgument()); err != nil {
+			log.WithError(err).Errorf("Failed to post the files changed since the approval of %s on %s/%s#%d.", ce.User.Login, ce.Repo.Owner.Login, ce.Repo.Name, ce.Number)
+		}
+	}
+
 	log.Debug("Resolving pull request...")
 	pr, err := ghc.GetPullRequest(ce.Repo.Owner.Login, ce.Repo.Name, ce.Number)
 	if err != nil {
@@ -186,7 +595,7 @@ func handleGenericComment(log *logrus.Entry, ghc githubClient, oc ownersClient,
 	}
 
 	log.Debug("Resolving repository owners...")
-	repo, err := oc.LoadRepoOwners(ce.Repo.Owner.Login, ce.Repo.Name, pr.Base.Ref)
+	repo, err := loadRepoOwners(log, ghc, oc, opts, ce.Repo.Owner.Login, ce.Repo.Name, pr.Base.Ref, ce.Number)
 	if err != nil {
 		return err
 	}
@@ -198,14 +607,23 @@ func handleGenericComment(log *logrus.Entry, ghc githubClient, oc ownersClient,
 		githubConfig,
 		opts,
 		&state{
-			org:       ce.Repo.Owner.Login,
-			repo:      ce.Repo.Name,
-			branch:    pr.Base.Ref,
-			number:    ce.Number,
-			body:      ce.IssueBody,
-			author:    ce.IssueAuthor.Login,
-			assignees: ce.Assignees,
-			htmlURL:   ce.IssueHTMLURL,
+			org:         ce.Repo.Owner.Login,
+			repo:        ce.Repo.Name,
+			branch:      pr.Base.Ref,
+			number:      ce.Number,
+			headSHA:     pr.Head.SHA,
+			draft:       pr.Draft,
+			body:        ce.IssueBody,
+			author:      ce.IssueAuthor.Login,
+			assignees:   ce.Assignees,
+			htmlURL:     ce.IssueHTMLURL,
+			createdAt:   pr.CreatedAt,
+			actor:       ce.User.Login,
+			commentBody: ce.Body,
+			fromFork:    isForkPR(pr),
+			dump:        dump,
+			simulate:    simulate,
+			history:     history,
 		},
 	)
 }
@@ -223,7 +641,7 @@ func handleReviewEvent(pc plugins.Agent, re github.ReviewEvent) error {
 	)
 }
 
-func handleReview(log *logrus.Entry, ghc githubClient, oc ownersClient, githubConfig config.GitHubOptions, config *plugins.Configuration, re *github.ReviewEvent) error {
+func handleReview(log *logrus.Entry, ghc GitHubClient, oc ownersClient, githubConfig config.GitHubOptions, config *plugins.Configuration, re *github.ReviewEvent) error {
 	funcStart := time.Now()
 	defer func() {
 		log.WithField("duration", time.Since(funcStart).String()).Debug("Completed handleReview")
@@ -233,30 +651,31 @@ func handleReview(log *logrus.Entry, ghc githubClient, oc ownersClient, githubCo
 		return nil
 	}
 
-	botUserChecker, err := ghc.BotUserChecker()
+	botUserChecker, err := botUserCheckers.get(ghc)
 	if err != nil {
 		return err
 	}
 
 	opts := config.ApproveFor(re.Repo.Owner.Login, re.Repo.Name)
+	isIgnoredApprover := ignoredApproverChecker(botUserChecker, opts)
 
 	// Check for an approval command is in the body. If one exists, let the
 	// genericCommentEventHandler handle this event. Approval commands override
 	// review state.
-	if isApprovalCommand(botUserChecker, opts.LgtmActsAsApprove, &comment{Body: re.Review.Body, Author: re.Review.User.Login}) {
+	if isApprovalCommand(isIgnoredApprover, opts.LgtmMayApprove(), &comment{Body: re.Review.Body, Author: re.Review.User.Login}) {
 		log.Debug("Review constitutes approval, skipping event.")
 		return nil
 	}
 
 	// Check for an approval command via review state. If none exists, don't
 	// handle this event.
-	if !isApprovalState(botUserChecker, opts.ConsiderReviewState(), &comment{Author: re.Review.User.Login, ReviewState: re.Review.State}) {
+	if !isApprovalState(isIgnoredApprover, opts.ConsiderReviewState(), &comment{Author: re.Review.User.Login, ReviewState: re.Review.State}) {
 		log.Debug("Review does not constitute approval, skipping event.")
 		return nil
 	}
 
 	log.Debug("Resolving repository owners...")
-	repo, err := oc.LoadRepoOwners(re.Repo.Owner.Login, re.Repo.Name, re.PullRequest.Base.Ref)
+	repo, err := loadRepoOwners(log, ghc, oc, opts, re.Repo.Owner.Login, re.Repo.Name, re.PullRequest.Base.Ref, re.PullRequest.Number)
 	if err != nil {
 		return err
 	}
@@ -268,14 +687,20 @@ func handleReview(log *logrus.Entry, ghc githubClient, oc ownersClient, githubCo
 		githubConfig,
 		opts,
 		&state{
-			org:       re.Repo.Owner.Login,
-			repo:      re.Repo.Name,
-			branch:    re.PullRequest.Base.Ref,
-			number:    re.PullRequest.Number,
-			body:      re.PullRequest.Body,
-			author:    re.PullRequest.User.Login,
-			assignees: re.PullRequest.Assignees,
-			htmlURL:   re.PullRequest.HTMLURL,
+			org:         re.Repo.Owner.Login,
+			repo:        re.Repo.Name,
+			branch:      re.PullRequest.Base.Ref,
+			number:      re.PullRequest.Number,
+			headSHA:     re.PullRequest.Head.SHA,
+			draft:       re.PullRequest.Draft,
+			body:        re.PullRequest.Body,
+			author:      re.PullRequest.User.Login,
+			assignees:   re.PullRequest.Assignees,
+			htmlURL:     re.PullRequest.HTMLURL,
+			createdAt:   re.PullRequest.CreatedAt,
+			actor:       re.Review.User.Login,
+			commentBody: re.Review.Body,
+			fromFork:    isForkPR(&re.PullRequest),
 		},
 	)
 
@@ -292,30 +717,39 @@ func handlePullRequestEvent(pc plugins.Agent, pre github.PullRequestEvent) error
 	)
 }
 
-func handlePullRequest(log *logrus.Entry, ghc githubClient, oc ownersClient, githubConfig config.GitHubOptions, config *plugins.Configuration, pre *github.PullRequestEvent) error {
+func handlePullRequest(log *logrus.Entry, ghc GitHubClient, oc ownersClient, githubConfig config.GitHubOptions, config *plugins.Configuration, pre *github.PullRequestEvent) error {
 	funcStart := time.Now()
 	defer func() {
 		log.WithField("duration", time.Since(funcStart).String()).Debug("Completed handlePullRequest")
 	}()
-	if pre.Action != github.PullRequestActionOpened &&
-		pre.Action != github.PullRequestActionReopened &&
-		pre.Action != github.PullRequestActionSynchronize &&
-		pre.Action != github.PullRequestActionLabeled {
+	opts := config.ApproveFor(pre.Repo.Owner.Login, pre.Repo.Name)
+	var previousBranch string
+	titleApproval := pre.Action == github.PullRequestActionEdited && addsTitleApprovalPhrase(pre, opts.TitleApprovalPhrase)
+	// Removing a required lgtm label withdraws the approved label, whatever
+	// the actions that trigger reprocessing.
+	lgtmRemoval := pre.Action == github.PullRequestActionUnlabeled && pre.Label.Name == labels.LGTM && (opts.RequireLgtmLabel || opts.RequireLgtmAfterApproval)
+	if pre.Action == github.PullRequestActionEdited && opts.ReapproveOnBaseChange {
+		previousBranch = previousBaseRef(pre)
+		if previousBranch == "" && !titleApproval {
+			log.Debug("Pull request edit does not change the base branch, skipping...")
+			return nil
+		}
+	} else if !titleApproval && !lgtmRemoval && !opts.TriggersOn(string(pre.Action)) {
 		log.Debug("Pull request event action cannot constitute approval, skipping...")
 		return nil
 	}
-	botUserChecker, err := ghc.BotUserChecker()
+	botUserChecker, err := botUserCheckers.get(ghc)
 	if err != nil {
 		return err
 	}
 	if pre.Action == github.PullRequestActionLabeled &&
-		(pre.Label.Name != labels.Approved || botUserChecker(pre.Sender.Login) || pre.PullRequest.State == "closed") {
+		(!isApprovalLabel(opts, pre.Label.Name) || botUserChecker(pre.Sender.Login) || pre.PullRequest.State == "closed") {
 		log.Debug("Pull request label event does not constitute approval, skipping...")
 		return nil
 	}
 
 	log.Debug("Resolving repository owners...")
-	repo, err := oc.LoadRepoOwners(pre.Repo.Owner.Login, pre.Repo.Name, pre.PullRequest.Base.Ref)
+	repo, err := loadRepoOwners(log, ghc, oc, opts, pre.Repo.Owner.Login, pre.Repo.Name, pre.PullRequest.Base.Ref, pre.Number)
 	if err != nil {
 		return err
 	}
@@ -325,36 +759,366 @@ func handlePullRequest(log *logrus.Entry, ghc githubClient, oc ownersClient, git
 		ghc,
 		repo,
 		githubConfig,
-		config.ApproveFor(pre.Repo.Owner.Login, pre.Repo.Name),
+		opts,
 		&state{
 			org:       pre.Repo.Owner.Login,
 			repo:      pre.Repo.Name,
 			branch:    pre.PullRequest.Base.Ref,
 			number:    pre.Number,
+			headSHA:   pre.PullRequest.Head.SHA,
+			draft:     pre.PullRequest.Draft,
 			body:      pre.PullRequest.Body,
 			author:    pre.PullRequest.User.Login,
 			assignees: pre.PullRequest.Assignees,
 			htmlURL:   pre.PullRequest.HTMLURL,
+			createdAt: pre.PullRequest.CreatedAt,
+			actor:     pre.Sender.Login,
+			fromFork:  isForkPR(&pre.PullRequest),
+
+			previousBranch: previousBranch,
+			opened:         pre.Action == github.PullRequestActionOpened || pre.Action == github.PullRequestActionReopened,
 		},
 	)
 }
 
+// frozenUntil returns the end of the freeze windows containing now, or the zero
+// time if approvals aren't frozen.
+func frozenUntil(windows []plugins.FreezeWindow, now time.Time) time.Time {
+	var until time.Time
+	for _, window := range windows {
+		if window.Contains(now) && window.EndTime.After(until) {
+			until = window.EndTime
+		}
+	}
+	return until
+}
+
+// autoApprovedPaths returns the path filters of the AutoApproveRules that apply
+// to PRs of author.
+func autoApprovedPaths(opts *plugins.Approve, author string) []*regexp.Regexp {
+	var filters []*regexp.Regexp
+	for _, rule := range opts.AutoApproveRules {
+		if rule.PathRe != nil && strings.EqualFold(rule.Author, author) {
+			filters = append(filters, rule.PathRe)
+		}
+	}
+	return filters
+}
+
+// isTrivialChange returns whether all filenames match one of the filters, so
+// that the PR only changes files such as generated code.
+func isTrivialChange(filenames []string, filters []*regexp.Regexp) bool {
+	if len(filenames) == 0 || len(filters) == 0 {
+		return false
+	}
+	for _, filename := range filenames {
+		matched := false
+		for _, filter := range filters {
+			if filter.MatchString(filename) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// isApprovalLabel returns whether adding the label may change the approval
+// state of the PR.
+func isApprovalLabel(opts *plugins.Approve, name string) bool {
+	return name == labels.Approved || ((opts.RequireLgtmLabel || opts.RequireLgtmAfterApproval) && name == labels.LGTM)
+}
+
+// isForkPR returns whether the head branch of the PR lives in another repo than
+// its base branch. A head repo that was deleted counts as a fork.
+func isForkPR(pr *github.PullRequest) bool {
+	return !strings.EqualFold(pr.Head.Repo.FullName, pr.Base.Repo.FullName)
+}
+
+// addsTitleApprovalPhrase returns true if the edit of the PR added phrase to
+// its title.
+func addsTitleApprovalPhrase(pre *github.PullRequestEvent, phrase string) bool {
+	var changes struct {
+		Title *struct {
+			From string `json:"from"`
+		} `json:"title"`
+	}
+	if phrase == "" || json.Unmarshal(pre.Changes, &changes) != nil || changes.Title == nil {
+		return false
+	}
+	return strings.Contains(pre.PullRequest.Title, phrase) && !strings.Contains(changes.Title.From, phrase)
+}
+
+// previousBaseRef returns the base branch an edited PR was retargeted from, or
+// "" if the edit didn't change the base branch.
+func previousBaseRef(pre *github.PullRequestEvent) string {
+	var changes struct {
+		Base struct {
+			Ref struct {
+				From string `json:"from"`
+			} `json:"ref"`
+		} `json:"base"`
+	}
+	if err := json.Unmarshal(pre.Changes, &changes); err != nil {
+		// The changes are inspected best-effort.
+		return ""
+	}
+	if changes.Base.Ref.From == pre.PullRequest.Base.Ref {
+		return ""
+	}
+	return changes.Base.Ref.From
+}
+
+// issueLinkPattern returns the pattern matching the part of an issue link up
+// to and including the org. Links are restricted to the host of baseURL, such
+// as a GitHub Enterprise instance, unless baseURL is empty.
+func issueLinkPattern(org, baseURL string) (string, error) {
+	if baseURL == "" {
+		return `\S*` + regexp.QuoteMeta(org), nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid issue base URL %q: %w", baseURL, err)
+	}
+	return `https?://` + regexp.QuoteMeta(u.Host+strings.TrimSuffix(u.Path, "/")+"/"+org), nil
+}
+
 // Returns associated issue, or 0 if it can't find any.
 // This is really simple, and could be improved later.
-func findAssociatedIssue(body, org string) (int, error) {
-	associatedIssueRegex, err := regexp.Compile(fmt.Sprintf(associatedIssueRegexFormat, org))
-	if err != nil {
+func findAssociatedIssue(body, org, baseURL string, requireClosingKeyword bool) (int, error) {
+	issues, err := findAssociatedIssues(body, org, baseURL, requireClosingKeyword)
+	if err != nil || len(issues) == 0 {
 		return 0, err
 	}
-	match := associatedIssueRegex.FindStringSubmatch(body)
-	if len(match) == 0 {
-		return 0, nil
+	return issues[0], nil
+}
+
+// findAssociatedIssues returns all issues associated in body, in order of
+// appearance and without duplicates.
+func findAssociatedIssues(body, org, baseURL string, requireClosingKeyword bool) ([]int, error) {
+	format := associatedIssueRegexFormat
+	if requireClosingKeyword {
+		format = closingIssueRegexFormat
 	}
-	v, err := strconv.Atoi(match[1])
+	link, err := issueLinkPattern(org, baseURL)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	associatedIssueRegex, err := regexp.Compile(fmt.Sprintf(format, link))
+	if err != nil {
+		return nil, err
+	}
+	var issues []int
+	seen := map[int]bool{}
+	for _, match := range associatedIssueRegex.FindAllStringSubmatch(body, -1) {
+		v, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		if !seen[v] {
+			seen[v] = true
+			issues = append(issues, v)
+		}
+	}
+	return issues, nil
+}
+
+// formatIssues formats issue numbers as "#1, #2".
+func formatIssues(issues []int) string {
+	var formatted []string
+	for _, issue := range issues {
+		formatted = append(formatted, fmt.Sprintf("#%d", issue))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// closedIssues returns the issues that are closed, looking them up in the repo
+// of pr. Issues that can't be looked up are assumed to be open.
+func closedIssues(log *logrus.Entry, ghc GitHubClient, pr *state, issues []int) []int {
+	var closed []int
+	for _, number := range issues {
+		issue, err := ghc.GetIssue(pr.org, pr.repo, number)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to get associated issue %s/%s#%d.", pr.org, pr.repo, number)
+			continue
+		}
+		if issue.State == "closed" {
+			closed = append(closed, number)
+		}
+	}
+	return closed
+}
+
+// approvalAliases maps the OWNERS_ALIASES aliases that approvals such as
+// "/approve as:security-reviewers" among approveComments name to their
+// members. Aliases are only known if repo expands them, as repoowners does.
+func approvalAliases(repo approvers.Repo, approveComments []*comment, cancel string) map[string]sets.String {
+	expander, ok := repo.(interface {
+		ExpandAlias(alias string) sets.String
+	})
+	if !ok {
+		return nil
+	}
+	aliases := map[string]sets.String{}
+	for _, c := range approveComments {
+		for _, command := range approvalCommands(c.Body, cancel) {
+			if command.name == approveCommand && command.alias != "" {
+				aliases[command.alias] = expander.ExpandAlias(command.alias)
+			}
+		}
+	}
+	return aliases
+}
+
+// unmergedDependencies returns the PRs that the conditional approvals such as
+// "/approve after:#123" among approveComments wait for, which haven't merged
+// yet. A PR that can't be looked up is considered unmerged.
+func unmergedDependencies(log *logrus.Entry, ghc GitHubClient, pr *state, approveComments []*comment, cancel string) map[int]bool {
+	unmerged := map[int]bool{}
+	checked := map[int]bool{}
+	for _, c := range approveComments {
+		for _, command := range approvalCommands(c.Body, cancel) {
+			number := command.dependency
+			if command.name != approveCommand || number == 0 || checked[number] {
+				continue
+			}
+			checked[number] = true
+			dependency, err := ghc.GetPullRequest(pr.org, pr.repo, number)
+			if err != nil {
+				log.WithError(err).Warnf("Failed to get pull request %s/%s#%d that an approval depends on.", pr.org, pr.repo, number)
+				unmerged[number] = true
+				continue
+			}
+			if !dependency.Merged {
+				unmerged[number] = true
+			}
+		}
+	}
+	return unmerged
+}
+
+// filesChangedAfter maps the commits that scoped approvals such as
+// "/approve upto:abc1234" among approveComments reviewed the PR through to the
+// files changed by the later commits of the PR. Commits that aren't part of
+// the PR, or whose later changes can't be looked up, are left out.
+func filesChangedAfter(log *logrus.Entry, ghc GitHubClient, pr *state, approveComments []*comment, cancel string) map[string]sets.String {
+	var scopes []string
+	for _, c := range approveComments {
+		for _, command := range approvalCommands(c.Body, cancel) {
+			if command.name == approveCommand && command.upto != "" {
+				scopes = append(scopes, command.upto)
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	commits, err := ghc.ListPRCommits(pr.org, pr.repo, pr.number)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to list the commits of %s/%s#%d that approvals are scoped to.", pr.org, pr.repo, pr.number)
+		return nil
+	}
+	commitFiles := map[string][]github.CommitFile{}
+	changed := map[string]sets.String{}
+scopes:
+	for _, upto := range scopes {
+		if _, ok := changed[upto]; ok {
+			continue
+		}
+		index := -1
+		for i, commit := range commits {
+			if strings.HasPrefix(strings.ToLower(commit.SHA), upto) {
+				index = i
+			}
+		}
+		if index < 0 {
+			continue
+		}
+		files := sets.NewString()
+		for _, commit := range commits[index+1:] {
+			if _, ok := commitFiles[commit.SHA]; !ok {
+				details, err := ghc.GetSingleCommit(pr.org, pr.repo, commit.SHA)
+				if err != nil {
+					log.WithError(err).Warnf("Failed to get commit %s of %s/%s#%d.", commit.SHA, pr.org, pr.repo, pr.number)
+					continue scopes
+				}
+				commitFiles[commit.SHA] = details.Files
+			}
+			for _, file := range commitFiles[commit.SHA] {
+				files.Insert(file.Filename)
+			}
+		}
+		changed[upto] = files
+	}
+	return changed
+}
+
+// findLinkedPullRequests returns the numbers referenced in the body of PR
+// number, in order of appearance. References may be issues rather than PRs.
+func findLinkedPullRequests(body, org, repo string, number int) ([]int, error) {
+	linkedPullRegex, err := regexp.Compile(fmt.Sprintf(linkedPullRegexFormat, regexp.QuoteMeta(org), regexp.QuoteMeta(repo)))
+	if err != nil {
+		return nil, err
+	}
+	var linked []int
+	seen := map[int]bool{number: true}
+	for _, match := range linkedPullRegex.FindAllStringSubmatch(body, -1) {
+		v, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		if !seen[v] {
+			seen[v] = true
+			linked = append(linked, v)
+		}
 	}
-	return v, nil
+	return linked, nil
+}
+
+// PullRequestState is the state of a pull request whose approval is computed
+// by Handle.
+type PullRequestState struct {
+	Org     string
+	Repo    string
+	Branch  string
+	Number  int
+	HeadSHA string
+	Draft   bool
+
+	Body      string
+	Author    string
+	Assignees []github.User
+	HTMLURL   string
+	CreatedAt time.Time
+
+	// FromFork is whether the head branch of the PR lives in another repo
+	// than the base branch.
+	FromFork bool
+}
+
+// Handle computes the approval state of the PR and updates its notification
+// and labels, like the event handlers of the plugin do after resolving the
+// OWNERS of the base branch. Title approvals are taken from the rename events
+// of the PR, like in the event handlers. It drives the whole approval flow for
+// integration tests with a fake GitHub client.
+func Handle(log *logrus.Entry, ghc GitHubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr PullRequestState) error {
+	return handle(log, ghc, repo, githubConfig, opts, &state{
+		org:       pr.Org,
+		repo:      pr.Repo,
+		branch:    pr.Branch,
+		number:    pr.Number,
+		headSHA:   pr.HeadSHA,
+		draft:     pr.Draft,
+		body:      pr.Body,
+		author:    pr.Author,
+		assignees: pr.Assignees,
+		htmlURL:   pr.HTMLURL,
+		createdAt: pr.CreatedAt,
+		fromFork:  pr.FromFork,
+	})
 }
 
 // handle is the workhorse the will actually make updates to the PR.
@@ -364,6 +1128,7 @@ func findAssociatedIssue(body, org string) (int, error) {
 //     - (Issue/PR comments, PR review comments, and PR review bodies are considered as comments)
 //   - If anyone said "/approve", add them to approverSet.
 //   - If anyone said "/lgtm" AND LgtmActsAsApprove is enabled, add them to approverSet.
+//   - If a reviewer who is also an approver said "/lgtm" AND UnifyLgtmApprove is enabled, add them to approverSet.
 //   - If anyone created an approved review AND ReviewActsAsApprove is enabled, add them to approverSet.
 // - Then, for each file, we see if any approver of this file is in approverSet and keep track of files without approval
 //   - An approver of a file is defined as:
@@ -372,7 +1137,7 @@ func findAssociatedIssue(body, org string) (int, error) {
 // - Iff all files have been approved, the bot will add the "approved" label.
 // - Iff a cancel command is found, that reviewer will be removed from the approverSet
 // 	and the munger will remove the approved label if it has been applied
-func handle(log *logrus.Entry, ghc githubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr *state) error {
+func handle(log *logrus.Entry, ghc GitHubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr *state) error {
 	funcStart := time.Now()
 	defer func() {
 		log.WithField("duration", time.Since(funcStart).String()).Debug("Completed handle")
@@ -381,27 +1146,49 @@ func handle(log *logrus.Entry, ghc githubClient, repo approvers.Repo, githubConf
 		return fmt.Errorf("failed to get %s for %s/%s#%d: %v", context, pr.org, pr.repo, pr.number, err)
 	}
 
+	if isIgnoredAuthor(opts, pr.author) {
+		log.Debugf("PR author %q is ignored by the approve plugin configuration, skipping.", pr.author)
+		return nil
+	}
+
 	start := time.Now()
 	changes, err := ghc.GetPullRequestChanges(pr.org, pr.repo, pr.number)
 	if err != nil {
 		return fetchErr("PR file changes", err)
 	}
 	var filenames []string
+	deleted := sets.NewString()
 	for _, change := range changes {
+		if change.Status == github.PullRequestFileRemoved {
+			if !opts.RequiresApprovalForDeletions() {
+				continue
+			}
+			deleted.Insert(change.Filename)
+		}
 		filenames = append(filenames, change.Filename)
+		// A rename moves ownership from the old location to the new one, so the
+		// owners of both paths need to approve.
+		if change.Status == github.PullRequestFileRenamed && change.PreviousFilename != "" && change.PreviousFilename != change.Filename {
+			filenames = append(filenames, change.PreviousFilename)
+		}
 	}
 	issueLabels, err := ghc.GetIssueLabels(pr.org, pr.repo, pr.number)
 	if err != nil {
 		return fetchErr("issue labels", err)
 	}
-	hasApprovedLabel := false
+	hasApprovedLabel, hasPartialApprovalLabel, hasLGTMLabel := false, false, false
 	for _, label := range issueLabels {
 		if label.Name == labels.Approved {
 			hasApprovedLabel = true
-			break
+		}
+		if label.Name == labels.LGTM {
+			hasLGTMLabel = true
+		}
+		if opts.PartialApprovalLabel != "" && label.Name == opts.PartialApprovalLabel {
+			hasPartialApprovalLabel = true
 		}
 	}
-	botUserChecker, err := ghc.BotUserChecker()
+	botUserChecker, err := botUserCheckers.get(ghc)
 	if err != nil {
 		return fetchErr("bot name", err)
 	}
@@ -417,114 +1204,1517 @@ func handle(log *logrus.Entry, ghc githubClient, repo approvers.Repo, githubConf
 	if err != nil {
 		return fetchErr("reviews", err)
 	}
+	// Several options need the issue events, which are listed once at most.
+	listIssueEvents := issueEventsLister(ghc, pr)
+	if opts.TreatDismissalAsCancel && opts.ConsiderReviewState() {
+		events, err := listIssueEvents()
+		if err != nil {
+			return fetchErr("issue events", err)
+		}
+		reviews = applyReviewDismissals(reviews, events)
+	}
+	var titleComments []*comment
+	if opts.TitleApprovalPhrase != "" {
+		events, err := listIssueEvents()
+		if err != nil {
+			return fetchErr("issue events", err)
+		}
+		titleComments = titleApprovals(events, opts.TitleApprovalPhrase, pr.htmlURL)
+	}
+	unresolvedThreads := 0
+	if opts.RequireResolvedThreads {
+		threads, err := ghc.ListReviewThreads(pr.org, pr.repo, pr.number)
+		if err != nil {
+			return fetchErr("review threads", err)
+		}
+		for _, thread := range threads {
+			if !thread.IsResolved {
+				unresolvedThreads++
+			}
+		}
+	}
+	var signers sets.String
+	if opts.RequireSignedCommits {
+		commits, err := ghc.ListPRCommits(pr.org, pr.repo, pr.number)
+		if err != nil {
+			return fetchErr("commits", err)
+		}
+		signers = verifiedCommitAuthors(commits)
+	}
 	log.WithField("duration", time.Since(start).String()).Debug("Completed github functions in handle")
 
 	start = time.Now()
-	approversHandler := approvers.NewApprovers(
-		approvers.NewOwners(
+	var owners approvers.Owners
+	if resolver := ownershipResolverFor(pr.org, pr.repo, pr.branch); resolver != nil {
+		owners, err = approvers.NewOwnersFromResolver(log, filenames, resolver, int64(pr.number))
+		if err != nil {
+			return fetchErr("approvers", err)
+		}
+	} else {
+		owners = approvers.NewOwners(
 			log,
 			filenames,
 			repo,
 			int64(pr.number),
-		),
-	)
-	approversHandler.AssociatedIssue, err = findAssociatedIssue(pr.body, pr.org)
+		)
+	}
+	owners = owners.ExcludeFiles(opts.UnownedPathRe).ExcludeFiles(autoApprovedPaths(opts, pr.author))
+	approversHandler := approvers.NewApprovers(owners)
+	approversHandler.DeletedFiles = deleted
+	approversHandler.SensitiveFiles = sensitiveFiles(opts, filenames)
+	approversHandler.EnforceRequiredReviewers = opts.EnforceRequiredReviewers
+	approversHandler.AssociatedIssues, err = findAssociatedIssues(pr.body, pr.org, opts.IssueBaseURL, opts.RequireClosingKeyword)
 	if err != nil {
 		log.WithError(err).Errorf("Failed to find associated issue from PR body: %v", err)
 	}
-	approversHandler.RequireIssue = opts.IssueRequired
-	approversHandler.ManuallyApproved = humanAddedApproved(ghc, log, pr.org, pr.repo, pr.number, botUserChecker, hasApprovedLabel)
-
-	// Author implicitly approves their own PR if config allows it
-	if opts.HasSelfApproval() {
-		approversHandler.AddAuthorSelfApprover(pr.author, pr.htmlURL+"#", false)
-	} else {
-		// Treat the author as an assignee, and suggest them if possible
-		approversHandler.AddAssignees(pr.author)
+	if len(approversHandler.AssociatedIssues) > 0 {
+		approversHandler.AssociatedIssue = approversHandler.AssociatedIssues[0]
+	}
+	approversHandler.RequireIssue = opts.IssueRequired && !(opts.WaiveIssueForTrivial && isTrivialChange(filenames, opts.UnownedPathRe))
+	approversHandler.RequiredApprovers = opts.RequiredApprovers
+	forkRestricted := opts.ForkRequiresExtraApprover && pr.fromFork
+	if forkRestricted {
+		approversHandler.RequiredApprovers = 2
+		if opts.RequiredApprovers > 1 {
+			approversHandler.RequiredApprovers = opts.RequiredApprovers + 1
+		}
+	}
+	if opts.OwnersChangeRequiredApprovers > approversHandler.RequiredApprovers && modifiesOwners(filenames, repo.Filenames()) {
+		approversHandler.RequiredApprovers = opts.OwnersChangeRequiredApprovers
+	}
+	if opts.QuorumMode {
+		approversHandler.QuorumCount = opts.QuorumCount
+	}
+	approversHandler.ApproverWeights = opts.ApproverWeights
+	approversHandler.AreaApprovers = areaApprovers(opts, issueLabels)
+	approversHandler.Draft = opts.SkipDrafts && pr.draft
+	approversHandler.UnresolvedThreads = unresolvedThreads
+	approversHandler.NoIssueRequiresConsensus = opts.NoIssueRequiresConsensus
+	approversHandler.HideImplicitSelfApprove = opts.HideImplicitSelfApprove
+	approversHandler.ShowApprovalTimes = opts.ShowApprovalTimes
+	approversHandler.ShowBlockedReasons = opts.ShowBlockedReasons
+	approversHandler.NotificationFooter = opts.NotificationFooter
+	approversHandler.LegacyNotificationFormat = opts.LegacyNotificationFormat
+	approversHandler.CompactNotification = opts.CompactNotification
+	approversHandler.NotificationLocale = opts.NotificationLocale
+	approversHandler.AnonymizeApprovers = opts.AnonymizeApprovers
+	if opts.CancelKeyword != "" {
+		approversHandler.CancelKeyword = opts.CancelArgument()
+	}
+	approversHandler.Author = pr.author
+	approversHandler.FrozenUntil = frozenUntil(opts.FreezeWindows, pluginClock.Now())
+
+	// Author implicitly approves their own PR if config allows it
+	if opts.HasSelfApproval() && !forkRestricted {
+		if approverAllowlisted(opts, pr.author) {
+			approversHandler.AddImplicitSelfApprover(pr.author, pr.htmlURL+"#")
+		}
 	}
 	log.WithField("duration", time.Since(start).String()).Debug("Completed configuring approversHandler in handle")
 
 	start = time.Now()
 	commentsFromIssueComments := commentsFromIssueComments(issueComments)
-	comments := append(commentsFromReviewComments(reviewComments), commentsFromIssueComments...)
-	comments = append(comments, commentsFromReviews(reviews)...)
+	comments := append(commentsFromReviewsAndReviewComments(reviews, reviewComments), commentsFromIssueComments...)
+	comments = append(comments, titleComments...)
+	if opts.InheritOriginalApprovals {
+		comments = append(comments, inheritedApprovals(log, ghc, pr, changes, botUserChecker, ignoredApproverChecker(botUserChecker, opts), owners)...)
+	}
+	if opts.AuthorityAccount != "" {
+		comments = append(comments, authorityApprovals(comments, owners, ignoredApproverChecker(botUserChecker, opts), opts)...)
+	}
 	sort.SliceStable(comments, func(i, j int) bool {
 		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
 	})
-	approveComments := filterComments(comments, approvalMatcher(botUserChecker, opts.LgtmActsAsApprove, opts.ConsiderReviewState()))
-	addApprovers(&approversHandler, approveComments, pr.author, opts.ConsiderReviewState())
+	approveComments := filterComments(comments, approvalMatcher(ignoredApproverChecker(botUserChecker, opts), opts.LgtmMayApprove(), opts.ConsiderReviewState()))
+	if opts.RequireSignedCommits {
+		approveComments = filterComments(approveComments, signedApprovalMatcher(log, signers))
+	}
+	notifications := filterComments(commentsFromIssueComments, notificationMatcher(botUserChecker, opts.LegacyNotificationFormat))
+	latestNotification := getLast(notifications)
+	if opts.NotificationAsReview {
+		// Notifications posted as comments before are still deleted below, but
+		// those posted as reviews can't be, so only the latest review counts.
+		latestNotification = getLast(filterComments(commentsFromReviews(reviews), notificationMatcher(botUserChecker, opts.LegacyNotificationFormat)))
+	}
+	if opts.EditNotificationInPlace {
+		// Notifications without a marker, e.g. posted before the option was
+		// enabled, are replaced by a tracked one.
+		latestNotification = canonicalNotification(notifications)
+	}
+	if opts.SkipDrafts && opts.IgnoreDraftApprovals && !pr.draft {
+		if readyAt := latestReadyForReview(log, listIssueEvents, pr); !readyAt.IsZero() {
+			approveComments = filterComments(approveComments, func(c *comment) bool {
+				return !c.CreatedAt.Before(readyAt)
+			})
+		}
+	}
+	if opts.ReapproveOnIssueChange || opts.ReapproveOnBaseChange {
+		baseChanged := opts.ReapproveOnBaseChange && pr.previousBranch != ""
+		approversHandler.ApprovalsResetAt, approversHandler.ApprovalsResetOnBaseChange = approvalsResetAt(latestNotification, approversHandler.AssociatedIssue, opts.ReapproveOnIssueChange, baseChanged, pluginClock.Now())
+		if !approversHandler.ApprovalsResetAt.IsZero() {
+			approveComments = filterComments(approveComments, func(c *comment) bool {
+				return !c.CreatedAt.Before(approversHandler.ApprovalsResetAt)
+			})
+		}
+	}
+	approversHandler.UnmergedDependencies = unmergedDependencies(log, ghc, pr, approveComments, opts.CancelArgument())
+	approversHandler.FilesChangedAfter = filesChangedAfter(log, ghc, pr, approveComments, opts.CancelArgument())
+	approversHandler.AliasMembers = approvalAliases(repo, approveComments, opts.CancelArgument())
+	addApprovers(&approversHandler, approveComments, pr.author, pr.headSHA, owners, opts)
+	for _, approval := range approversHandler.ListApprovals() {
+		if approval.Reason != "" {
+			log.WithFields(logrus.Fields{"approver": approval.Login, "reason": approval.Reason, "reference": approval.Reference}).Info("Approval with a reason.")
+		}
+	}
+	if !approversHandler.RequireIssue && findIssueRequirement(approveComments, owners, opts) != nil {
+		approversHandler.RequireIssue = true
+	}
+	if opts.RequireOpenIssues && approversHandler.RequireIssue {
+		approversHandler.ClosedIssues = closedIssues(log, ghc, pr, approversHandler.AssociatedIssues)
+	}
 	log.WithField("duration", time.Since(start).String()).Debug("Completed filtering approval comments in handle")
 
+	// A forced approval is sticky just like a manually added label, so that
+	// subsequent events don't remove the label again.
+	forced := findForcedApproval(approveComments, opts)
+	humanApproved := humanAddedApproved(log, listIssueEvents, pr, botUserChecker, hasApprovedLabel)
+	approversHandler.ManuallyApproved = func() bool {
+		return forced != nil || humanApproved()
+	}
+
+	// Approvals are only valid against the OWNERS files of the base branch as
+	// they are now, so approvers that have since been removed no longer count.
+	if removed := approversHandler.RemoveNonApprovers(); len(removed) != 0 {
+		log.Debugf("Ignoring approvals from %v, who are not approvers in the OWNERS files of %s.", removed, pr.branch)
+	}
+
 	for _, user := range pr.assignees {
 		approversHandler.AddAssignees(user.Login)
 	}
 
-	start = time.Now()
-	notifications := filterComments(commentsFromIssueComments, notificationMatcher(botUserChecker))
-	latestNotification := getLast(notifications)
-	newMessage := updateNotification(githubConfig.LinkURL, opts.CommandHelpLink, opts.PrProcessLink, pr.org, pr.repo, pr.branch, latestNotification, approversHandler)
-	log.WithField("duration", time.Since(start).String()).Debug("Completed getting notifications in handle")
-	start = time.Now()
-	if newMessage != nil {
-		for _, notif := range notifications {
-			if err := ghc.DeleteComment(pr.org, pr.repo, notif.ID); err != nil {
-				log.WithError(err).Errorf("Failed to delete comment from %s/%s#%d, ID: %d.", pr.org, pr.repo, pr.number, notif.ID)
+	// Events may race with the PR being merged, after which re-adding labels
+	// confuses Tide, so nothing is changed once the PR is closed.
+	if isClosedPullRequest(log, ghc, pr) {
+		log.Infof("Not updating the approval of %s/%s#%d, as it was closed in the meantime.", pr.org, pr.repo, pr.number)
+		return nil
+	}
+
+	if designated := findDesignatedApprovers(approveComments, owners); len(designated) > 0 {
+		approversHandler.DesignatedApprovers, approversHandler.IgnoredDesignatedApprovers = splitDesignatedApprovers(owners, approversHandler.UnapprovedFiles(), designated)
+		assignDesignatedApprovers(log, ghc, pr, approversHandler.DesignatedApprovers)
+		approversHandler.AddAssignees(approversHandler.DesignatedApprovers...)
+	}
+
+	if pr.history {
+		return ghc.CreateComment(pr.org, pr.repo, pr.number, approvalHistoryMessage(approveComments, opts))
+	}
+	if len(pr.simulate) > 0 {
+		return ghc.CreateComment(pr.org, pr.repo, pr.number, simulationMessage(approversHandler, pr.simulate, pr.htmlURL, repo.Filenames().Owners))
+	}
+	if pr.dump {
+		message, err := approvalDumpMessage(approversHandler, opts)
+		if err != nil {
+			return err
+		}
+		return ghc.CreateComment(pr.org, pr.repo, pr.number, message)
+	}
+
+	observeApprovalMetrics(pr, owners, approversHandler)
+
+	if opts.UnifyLgtmApprove && !hasLGTMLabel && isMaintainerApproval(approversHandler, owners, pr) {
+		if err := ghc.AddLabel(pr.org, pr.repo, pr.number, labels.LGTM); err != nil {
+			log.WithError(err).Errorf("Failed to add %q label to %s/%s#%d.", labels.LGTM, pr.org, pr.repo, pr.number)
+		} else {
+			hasLGTMLabel = true
+		}
+	}
+	if approversHandler.IsApproved() {
+		approversHandler.LgtmMissing = (opts.RequireLgtmLabel || opts.RequireLgtmAfterApproval) && !hasLGTMLabel
+		if opts.RequireLgtmAfterApproval && !approversHandler.LgtmMissing && !hasApprovedLabel {
+			approversHandler.AwaitingLgtm = !lgtmFollowsApproval(log, listIssueEvents, pr, approversHandler)
+		}
+	}
+
+	if opts.AnonymizeApprovers {
+		log.WithField("approvers", approversHandler.GetCurrentApproversSetCased().List()).Infof("Anonymizing the approvers of %s/%s#%d in the notification.", pr.org, pr.repo, pr.number)
+	}
+	start = time.Now()
+	newMessage := updateNotification(githubConfig.LinkURL, opts.CommandHelpLink, opts.PrProcessLink, pr.org, pr.repo, pr.branch, latestNotification, approversHandler)
+	log.WithField("duration", time.Since(start).String()).Debug("Completed getting notifications in handle")
+	start = time.Now()
+	if opts.EditNotificationInPlace {
+		editNotificationInPlace(log, ghc, pr, botUserChecker, notifications, latestNotification, newMessage)
+	} else if newMessage != nil {
+		for _, notif := range notifications {
+			if err := ghc.DeleteComment(pr.org, pr.repo, notif.ID); err != nil {
+				log.WithError(err).Errorf("Failed to delete comment from %s/%s#%d, ID: %d.", pr.org, pr.repo, pr.number, notif.ID)
+			}
+		}
+		if opts.NotificationAsReview {
+			if err := retryNotification(log, func() error {
+				return ghc.CreateReview(pr.org, pr.repo, pr.number, github.DraftReview{Body: *newMessage, Action: github.Comment})
+			}); err != nil {
+				log.WithError(err).Errorf("Failed to create review on %s/%s#%d: %q.", pr.org, pr.repo, pr.number, *newMessage)
+			}
+		} else if err := retryNotification(log, func() error {
+			return ghc.CreateComment(pr.org, pr.repo, pr.number, *newMessage)
+		}); err != nil {
+			log.WithError(err).Errorf("Failed to create comment on %s/%s#%d: %q.", pr.org, pr.repo, pr.number, *newMessage)
+		}
+	}
+	if forced != nil && !hasForceAudit(issueComments, botUserChecker, forced) {
+		if err := ghc.CreateComment(pr.org, pr.repo, pr.number, forceAuditMessage(forced)); err != nil {
+			log.WithError(err).Errorf("Failed to create force approval audit comment on %s/%s#%d.", pr.org, pr.repo, pr.number)
+		}
+	}
+	if isApprovalStalled(opts, pr, approversHandler) && !hasBotComment(issueComments, botUserChecker, escalationMarker) {
+		if err := ghc.CreateComment(pr.org, pr.repo, pr.number, escalationMessage(opts.EscalationApprovers)); err != nil {
+			log.WithError(err).Errorf("Failed to create escalation comment on %s/%s#%d.", pr.org, pr.repo, pr.number)
+		}
+	}
+	// Searching the merged PRs of the author is rate limited, so it is only
+	// done when the PR is opened.
+	if opts.FirstTimeInstructions && pr.opened && !hasBotComment(issueComments, botUserChecker, firstTimeInstructionsMarker) && isFirstTimeAuthor(log, ghc, pr) {
+		if err := ghc.CreateComment(pr.org, pr.repo, pr.number, firstTimeInstructions(pr, repo.Filenames().Owners, opts.CommandHelpLink)); err != nil {
+			log.WithError(err).Errorf("Failed to create first-time instructions comment on %s/%s#%d.", pr.org, pr.repo, pr.number)
+		}
+	}
+	if opts.StackApprovals {
+		if stacked := findStackApproval(approveComments, owners, opts.CancelArgument()); stacked != nil {
+			cascadeStackApproval(log, ghc, pr, botUserChecker, stacked)
+		}
+	}
+	log.WithField("duration", time.Since(start).String()).Debug("Completed adding/deleting approval comments in handle")
+
+	start = time.Now()
+	// labelWithheld is set if an approved PR doesn't get the approved label
+	// yet, which keeps its commit status pending as well.
+	labelWithheld := !approversHandler.FrozenUntil.IsZero() || approversHandler.LgtmMissing || approversHandler.AwaitingLgtm
+	if !approversHandler.IsApproved() {
+		if hasApprovedLabel && keepsApprovedLabel(log, listIssueEvents, pr, botUserChecker, opts) {
+			log.Infof("Not removing %q label from %s/%s#%d, as it may not have been added by the bot.", labels.Approved, pr.org, pr.repo, pr.number)
+		} else if hasApprovedLabel {
+			if err := ghc.RemoveLabel(pr.org, pr.repo, pr.number, labels.Approved); err != nil {
+				log.WithError(err).Errorf("Failed to remove %q label from %s/%s#%d.", labels.Approved, pr.org, pr.repo, pr.number)
+			} else {
+				notifyApprovalTransition(log, ApprovalTransition{Org: pr.org, Repo: pr.repo, Number: pr.number, Approved: false})
+				if opts.ExplainApprovalLoss {
+					message := approvalLossMessage(approversHandler, approveComments, pr.headSHA, opts)
+					if !hasApprovalLossNote(issueComments, botUserChecker, message) {
+						if err := ghc.CreateComment(pr.org, pr.repo, pr.number, message); err != nil {
+							log.WithError(err).Errorf("Failed to create approval loss comment on %s/%s#%d.", pr.org, pr.repo, pr.number)
+						}
+					}
+				}
+			}
+		}
+	} else if !approversHandler.FrozenUntil.IsZero() {
+		log.Infof("Not adding %q label to %s/%s#%d during a freeze window ending at %s.", labels.Approved, pr.org, pr.repo, pr.number, approversHandler.FrozenUntil)
+	} else if approversHandler.LgtmMissing {
+		if !hasApprovedLabel {
+			log.Infof("Not adding %q label to %s/%s#%d until it has the %q label.", labels.Approved, pr.org, pr.repo, pr.number, labels.LGTM)
+		} else if keepsApprovedLabel(log, listIssueEvents, pr, botUserChecker, opts) {
+			log.Infof("Not removing %q label from %s/%s#%d without the %q label, as it may not have been added by the bot.", labels.Approved, pr.org, pr.repo, pr.number, labels.LGTM)
+		} else if err := ghc.RemoveLabel(pr.org, pr.repo, pr.number, labels.Approved); err != nil {
+			log.WithError(err).Errorf("Failed to remove %q label from %s/%s#%d.", labels.Approved, pr.org, pr.repo, pr.number)
+		} else {
+			notifyApprovalTransition(log, ApprovalTransition{Org: pr.org, Repo: pr.repo, Number: pr.number, Approved: false})
+		}
+	} else if approversHandler.AwaitingLgtm {
+		log.Infof("Not adding %q label to %s/%s#%d until the %q label is added after its approval.", labels.Approved, pr.org, pr.repo, pr.number, labels.LGTM)
+	} else if !hasApprovedLabel {
+		if err := ghc.AddLabel(pr.org, pr.repo, pr.number, labels.Approved); err != nil {
+			log.WithError(err).Errorf("Failed to add %q label to %s/%s#%d.", labels.Approved, pr.org, pr.repo, pr.number)
+		} else {
+			notifyApprovalTransition(log, ApprovalTransition{Org: pr.org, Repo: pr.repo, Number: pr.number, Approved: true})
+		}
+	}
+	if opts.PartialApprovalLabel != "" {
+		partiallyApproved := approversHandler.IsPartiallyApproved()
+		if partiallyApproved && !hasPartialApprovalLabel {
+			if err := ghc.AddLabel(pr.org, pr.repo, pr.number, opts.PartialApprovalLabel); err != nil {
+				log.WithError(err).Errorf("Failed to add %q label to %s/%s#%d.", opts.PartialApprovalLabel, pr.org, pr.repo, pr.number)
+			}
+		} else if !partiallyApproved && hasPartialApprovalLabel {
+			if err := ghc.RemoveLabel(pr.org, pr.repo, pr.number, opts.PartialApprovalLabel); err != nil {
+				log.WithError(err).Errorf("Failed to remove %q label from %s/%s#%d.", opts.PartialApprovalLabel, pr.org, pr.repo, pr.number)
+			}
+		}
+	}
+	log.WithField("duration", time.Since(start).String()).Debug("Completed adding/deleting approval labels in handle")
+
+	if opts.PublishCommitStatus && pr.headSHA != "" {
+		status := github.Status{
+			State:       github.StatusPending,
+			Description: "Needs approval from an approver in each of the OWNERS files.",
+			Context:     statusContext,
+			TargetURL:   pr.htmlURL,
+		}
+		if approversHandler.IsApproved() && !labelWithheld {
+			status.State = github.StatusSuccess
+			status.Description = "Approved."
+		}
+		if err := ghc.CreateStatus(pr.org, pr.repo, pr.headSHA, status); err != nil {
+			log.WithError(err).Errorf("Failed to create %q status on %s/%s#%d.", statusContext, pr.org, pr.repo, pr.number)
+		}
+	}
+	return nil
+}
+
+// issueEventsLister returns a function listing the issue events of pr, which
+// only lists them from GitHub the first time it's called.
+func issueEventsLister(ghc GitHubClient, pr *state) func() ([]github.ListedIssueEvent, error) {
+	var events []github.ListedIssueEvent
+	var err error
+	listed := false
+	return func() ([]github.ListedIssueEvent, error) {
+		if !listed {
+			events, err = ghc.ListIssueEvents(pr.org, pr.repo, pr.number)
+			listed = true
+		}
+		return events, err
+	}
+}
+
+func humanAddedApproved(log *logrus.Entry, listIssueEvents func() ([]github.ListedIssueEvent, error), pr *state, isBot func(string) bool, hasLabel bool) func() bool {
+	findOut := func() bool {
+		if !hasLabel {
+			return false
+		}
+		events, err := listIssueEvents()
+		if err != nil {
+			// The label is kept anyway, see keepsApprovedLabel, but the PR
+			// isn't reported as approved by it.
+			log.WithError(err).Errorf("Failed to list issue events for %s/%s#%d, not treating the %q label as added by a human.", pr.org, pr.repo, pr.number, labels.Approved)
+			return false
+		}
+		login := lastLabeler(events, labels.Approved)
+		if login == "" || isBot(login) {
+			return false
+		}
+		return true
+	}
+
+	var cache *bool
+	return func() bool {
+		if cache == nil {
+			val := findOut()
+			cache = &val
+		}
+		return *cache
+	}
+}
+
+// keepsApprovedLabel returns true if the approved label must be kept although
+// the PR isn't approved, or lacks the lgtm label that opts require: if the issue events that tell who added it can't be
+// listed, so that a transient failure doesn't remove an approval that a human
+// may have added, or if opts don't let the plugin manage the label exclusively
+// and the events don't attribute it to the bot. Labels that someone else added
+// never get here, they count as manual approvals, see humanAddedApproved.
+func keepsApprovedLabel(log *logrus.Entry, listIssueEvents func() ([]github.ListedIssueEvent, error), pr *state, isBot func(string) bool, opts *plugins.Approve) bool {
+	events, err := listIssueEvents()
+	if err != nil {
+		log.WithError(err).Errorf("Failed to list issue events for %s/%s#%d, not removing the %q label.", pr.org, pr.repo, pr.number, labels.Approved)
+		return true
+	}
+	if opts.ManagesLabelExclusively() {
+		return false
+	}
+	login := lastLabeler(events, labels.Approved)
+	return login == "" || !isBot(login)
+}
+
+// lastLabeler returns the login of whoever added label last according to
+// events, or "" if none of the events added it.
+func lastLabeler(events []github.ListedIssueEvent, label string) string {
+	return lastLabeling(events, label).Actor.Login
+}
+
+// lastLabeling returns the last of events that added label, or the zero event
+// if none of them did.
+func lastLabeling(events []github.ListedIssueEvent, label string) github.ListedIssueEvent {
+	var lastAdded github.ListedIssueEvent
+	for _, event := range events {
+		// Only consider events adding the label.
+		if event.Event != github.IssueActionLabeled || event.Label.Name != label {
+			continue
+		}
+		lastAdded = event
+	}
+	return lastAdded
+}
+
+// lgtmFollowsApproval returns true if the lgtm label was last added after the
+// PR became approved, that is after the latest of the ApprovalTimes of ap.
+func lgtmFollowsApproval(log *logrus.Entry, listIssueEvents func() ([]github.ListedIssueEvent, error), pr *state, ap approvers.Approvers) bool {
+	var approvedAt time.Time
+	for _, at := range ap.ApprovalTimes() {
+		if at.After(approvedAt) {
+			approvedAt = at
+		}
+	}
+	events, err := listIssueEvents()
+	if err != nil {
+		log.WithError(err).Errorf("Failed to list issue events for %s/%s#%d, treating the %q label as added before the approval.", pr.org, pr.repo, pr.number, labels.LGTM)
+		return false
+	}
+	lgtmAt := lastLabeling(events, labels.LGTM).CreatedAt
+	return !lgtmAt.IsZero() && lgtmAt.After(approvedAt)
+}
+
+// isClosedPullRequest returns true if the PR is closed or merged by now. The PR
+// is treated as open if it can't be looked up, so that a transient failure
+// doesn't hold up its approval.
+func isClosedPullRequest(log *logrus.Entry, ghc GitHubClient, pr *state) bool {
+	current, err := ghc.GetPullRequest(pr.org, pr.repo, pr.number)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to check whether %s/%s#%d is still open.", pr.org, pr.repo, pr.number)
+		return false
+	}
+	return current.State == "closed" || current.Merged
+}
+
+// observeApprovalMetrics records how many approvers are suggested for the PR
+// and how deep the deepest of the OWNERS files that need to approve it is,
+// with the root OWNERS file at depth 0.
+func observeApprovalMetrics(pr *state, owners approvers.Owners, ap approvers.Approvers) {
+	suggestedApprovers.WithLabelValues(pr.org, pr.repo).Observe(float64(len(ap.GetCCs())))
+	var depth int
+	for dir := range owners.GetOwnersSet() {
+		if dir == "" || dir == "." {
+			continue
+		}
+		if d := strings.Count(dir, "/") + 1; d > depth {
+			depth = d
+		}
+	}
+	ownersDepth.WithLabelValues(pr.org, pr.repo).Observe(float64(depth))
+}
+
+// isApprovalStalled returns whether the PR still isn't approved EscalateAfter
+// after it was created.
+func isApprovalStalled(opts *plugins.Approve, pr *state, ap approvers.Approvers) bool {
+	if opts.EscalateAfterDuration <= 0 || pr.createdAt.IsZero() {
+		return false
+	}
+	return pluginClock.Now().Sub(pr.createdAt) >= opts.EscalateAfterDuration && !ap.IsApproved()
+}
+
+// escalationMarker identifies the comment mentioning the EscalationApprovers.
+var escalationMarker = fmt.Sprintf("<!-- %s escalation -->", PluginName)
+
+// escalationMessage mentions the escalationApprovers of a PR whose approval
+// stalled.
+func escalationMessage(escalationApprovers []string) string {
+	return fmt.Sprintf("This PR has been waiting for approval for a while, escalating to @%s.\n%s", strings.Join(escalationApprovers, ", @"), escalationMarker)
+}
+
+// latestReadyForReview returns when the PR was last marked as ready for review,
+// or the zero time if it never was a draft.
+func latestReadyForReview(log *logrus.Entry, listIssueEvents func() ([]github.ListedIssueEvent, error), pr *state) time.Time {
+	events, err := listIssueEvents()
+	if err != nil {
+		log.WithError(err).Errorf("Failed to list issue events for %s/%s#%d.", pr.org, pr.repo, pr.number)
+		return time.Time{}
+	}
+	var readyAt time.Time
+	for _, event := range events {
+		if event.Event == github.IssueActionReadyForReview && event.CreatedAt.After(readyAt) {
+			readyAt = event.CreatedAt
+		}
+	}
+	return readyAt
+}
+
+// approvalsResetAt returns since when approvals count, and whether they were
+// reset because of a base branch change. Approvals are reset when the base
+// branch just changed, or when issueChange is set and the associated issue of
+// the PR differs from the one recorded in the latest notification. The reset
+// is carried over from the latest notification otherwise.
+func approvalsResetAt(latestNotification *comment, associatedIssue int, issueChange, baseChanged bool, now time.Time) (time.Time, bool) {
+	if baseChanged {
+		return now, true
+	}
+	if latestNotification == nil {
+		return time.Time{}, false
+	}
+	status, err := approvers.ParseNotificationStatus(latestNotification.Body)
+	if err != nil || status == nil {
+		return time.Time{}, false
+	}
+	if issueChange && status.AssociatedIssue != 0 && status.AssociatedIssue != associatedIssue {
+		return now, false
+	}
+	if status.ApprovalsResetAt != nil {
+		return *status.ApprovalsResetAt, status.ApprovalsResetOnBaseChange
+	}
+	return time.Time{}, false
+}
+
+// isOwnersApprover returns true if login is listed as an approver in any of
+// the OWNERS files that cover the changed files.
+func isOwnersApprover(owners approvers.Owners, login string) bool {
+	_, ok := owners.GetReverseMap(owners.GetApprovers())[strings.ToLower(login)]
+	return ok
+}
+
+// isMaintainerApproval returns true if the event being handled is an
+// "/approve" by a user that is both a reviewer and an approver of the PR, and
+// that approval counts. Only the event itself applies the lgtm label, so that
+// the label isn't added back after it was removed, e.g. on new commits.
+func isMaintainerApproval(ap approvers.Approvers, owners approvers.Owners, pr *state) bool {
+	if _, ok := owners.GetReverseMap(owners.GetReviewers())[strings.ToLower(pr.actor)]; !ok || !isOwnersApprover(owners, pr.actor) {
+		return false
+	}
+	approveCommandFound := false
+	for _, match := range commandRegex.FindAllStringSubmatch(pr.commentBody, -1) {
+		if strings.ToUpper(match[1]) == approveCommand {
+			approveCommandFound = true
+		}
+	}
+	if !approveCommandFound {
+		return false
+	}
+	for _, approval := range ap.ListApprovals() {
+		if strings.EqualFold(approval.Login, pr.actor) && approval.How == "Approved" && !approval.Implicit {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnoredAuthor returns true if PRs by author should not be processed at all.
+func isIgnoredAuthor(opts *plugins.Approve, author string) bool {
+	if opts.IgnoreBots && strings.HasSuffix(author, "[bot]") {
+		return true
+	}
+	for _, ignored := range opts.IgnoreAuthors {
+		if strings.EqualFold(ignored, author) {
+			return true
+		}
+	}
+	return false
+}
+
+// areaApprovers returns the AreaApprovers groups of the labels of the PR.
+func areaApprovers(opts *plugins.Approve, issueLabels []github.Label) map[string][]string {
+	var groups map[string][]string
+	for _, label := range issueLabels {
+		if logins, ok := opts.AreaApprovers[label.Name]; ok {
+			if groups == nil {
+				groups = map[string][]string{}
+			}
+			groups[label.Name] = logins
+		}
+	}
+	return groups
+}
+
+// ignoredApproverChecker wraps isBot so that the approval commands of the
+// AllowedBotApprovers are counted and those of the ExcludedApprovers, or of
+// anyone missing from a non-empty ApproverAllowlist, are not.
+func ignoredApproverChecker(isBot func(string) bool, opts *plugins.Approve) func(string) bool {
+	return func(login string) bool {
+		if loginListed(opts.ExcludedApprovers, login) || !approverAllowlisted(opts, login) {
+			return true
+		}
+		return isBot(login) && !loginListed(opts.AllowedBotApprovers, login)
+	}
+}
+
+// verifiedCommitAuthors returns the lower cased logins of the authors of the
+// commits whose signature GitHub verified.
+func verifiedCommitAuthors(commits []github.RepositoryCommit) sets.String {
+	signers := sets.NewString()
+	for _, commit := range commits {
+		if commit.Author.Login != "" && commit.Commit.Verification != nil && commit.Commit.Verification.Verified {
+			signers.Insert(strings.ToLower(commit.Author.Login))
+		}
+	}
+	return signers
+}
+
+// signedApprovalMatcher matches the approval commands of signers, the authors of
+// verified commits of the PR, so that the approvals of anyone else don't count.
+func signedApprovalMatcher(log *logrus.Entry, signers sets.String) func(*comment) bool {
+	return func(c *comment) bool {
+		if signers.Has(strings.ToLower(c.Author)) {
+			return true
+		}
+		log.Infof("Ignoring the approval commands of %s, who authored no verified commit of the PR.", c.Author)
+		return false
+	}
+}
+
+// approverAllowlisted returns true if the approvals of login may count, which
+// is the case for everyone unless the ApproverAllowlist is set.
+func approverAllowlisted(opts *plugins.Approve, login string) bool {
+	return len(opts.ApproverAllowlist) == 0 || loginListed(opts.ApproverAllowlist, login)
+}
+
+// loginListed returns true if login is in logins, ignoring case and the
+// "[bot]" suffix of GitHub App logins.
+func loginListed(logins []string, login string) bool {
+	login = strings.TrimSuffix(login, "[bot]")
+	for _, l := range logins {
+		if strings.EqualFold(strings.TrimSuffix(l, "[bot]"), login) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAdminApprover(opts *plugins.Approve, login string) bool {
+	for _, admin := range opts.AdminApprovers {
+		if strings.EqualFold(admin, login) {
+			return true
+		}
+	}
+	return false
+}
+
+// forceReason returns the reason given to an "/approve force <reason>"
+// command and whether args constitute such a command at all.
+func forceReason(args string) (string, bool) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], forceArgument) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimSpace(args)[len(fields[0]):]), true
+}
+
+// isRefreshArgument returns true if args are those of an "/approve refresh".
+func isRefreshArgument(args string) bool {
+	return strings.EqualFold(strings.TrimSpace(args), refreshArgument)
+}
+
+// isDiffArgument returns true if args are those of an "/approve diff".
+func isDiffArgument(args string) bool {
+	return strings.EqualFold(strings.TrimSpace(args), diffArgument)
+}
+
+// isRequireIssueArgument returns true if args are those of an
+// "/approve require-issue".
+func isRequireIssueArgument(args string) bool {
+	return strings.EqualFold(strings.TrimSpace(args), requireIssueArgument)
+}
+
+// findIssueRequirement returns the latest "/approve require-issue" comment
+// from an OWNERS or admin approver, which requires an associated issue for the
+// PR even if the repo doesn't.
+func findIssueRequirement(approveComments []*comment, owners approvers.Owners, opts *plugins.Approve) *comment {
+	var required *comment
+	for _, c := range approveComments {
+		if !isOwnersApprover(owners, c.Author) && !isAdminApprover(opts, c.Author) {
+			continue
+		}
+		for _, match := range commandRegex.FindAllStringSubmatch(c.Body, -1) {
+			if strings.ToUpper(match[1]) == approveCommand && isRequireIssueArgument(match[2]) {
+				required = c
+			}
+		}
+	}
+	return required
+}
+
+// isDiffCommand returns true if body contains an "/approve diff".
+func isDiffCommand(body string) bool {
+	for _, match := range commandRegex.FindAllStringSubmatch(body, -1) {
+		if strings.ToUpper(match[1]) == approveCommand && isDiffArgument(match[2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// latestApprovalTime returns when login last approved, with "/approve" or an
+// approving review, or the zero time if they never did.
+func latestApprovalTime(comments []*comment, login, cancel string) time.Time {
+	var approvedAt time.Time
+	for _, c := range comments {
+		if !strings.EqualFold(c.Author, login) || !c.CreatedAt.After(approvedAt) {
+			continue
+		}
+		if c.ReviewState == github.ReviewStateApproved {
+			approvedAt = c.CreatedAt
+			continue
+		}
+		for _, command := range approvalCommands(c.Body, cancel) {
+			if command.name == approveCommand && !command.other && !command.forced && !command.cancel {
+				approvedAt = c.CreatedAt
+			}
+		}
+	}
+	return approvedAt
+}
+
+// postApprovalDiff comments the files changed by the commits on the PR that
+// were committed after the latest approval of login. Commit times are taken
+// as they are, so commits rebased after the approval count as changed.
+func postApprovalDiff(log *logrus.Entry, ghc GitHubClient, org, repo string, number int, login, cancel string) error {
+	issueComments, err := ghc.ListIssueComments(org, repo, number)
+	if err != nil {
+		return err
+	}
+	reviews, err := ghc.ListReviews(org, repo, number)
+	if err != nil {
+		return err
+	}
+	approvedAt := latestApprovalTime(append(commentsFromIssueComments(issueComments), commentsFromReviews(reviews)...), login, cancel)
+	if approvedAt.IsZero() {
+		return ghc.CreateComment(org, repo, number, fmt.Sprintf("@%s, you haven't approved this PR, so there is nothing to compare against.", login))
+	}
+	commits, err := ghc.ListPRCommits(org, repo, number)
+	if err != nil {
+		return err
+	}
+	files := sets.NewString()
+	for _, commit := range commits {
+		if !commit.Commit.Committer.Date.After(approvedAt) {
+			continue
+		}
+		details, err := ghc.GetSingleCommit(org, repo, commit.SHA)
+		if err != nil {
+			return err
+		}
+		for _, file := range details.Files {
+			files.Insert(file.Filename)
+		}
+	}
+	since := approvedAt.UTC().Format("2006-01-02 15:04 MST")
+	var message string
+	if files.Len() == 0 {
+		message = fmt.Sprintf("@%s, no files changed since your approval at %s.", login, since)
+	} else {
+		message = fmt.Sprintf("@%s, files changed since your approval at %s:\n", login, since)
+		for _, file := range files.List() {
+			message += fmt.Sprintf("\n- `%s`", file)
+		}
+	}
+	log.Debugf("Posting the %d files changed since the approval of %s.", files.Len(), login)
+	return ghc.CreateComment(org, repo, number, message)
+}
+
+// isRefreshCommand returns true if body contains an "/approve refresh".
+func isRefreshCommand(body string) bool {
+	for _, match := range commandRegex.FindAllStringSubmatch(body, -1) {
+		if strings.ToUpper(match[1]) == approveCommand && isRefreshArgument(match[2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDumpArgument returns true if args are those of an "/approve dump".
+func isDumpArgument(args string) bool {
+	return strings.EqualFold(strings.TrimSpace(args), dumpArgument)
+}
+
+// isDumpCommand returns true if body contains an "/approve dump".
+func isDumpCommand(body string) bool {
+	for _, match := range commandRegex.FindAllStringSubmatch(body, -1) {
+		if strings.ToUpper(match[1]) == approveCommand && isDumpArgument(match[2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSimulateArgument returns true if args are those of an
+// "/approve simulate @alice".
+func isSimulateArgument(args string) bool {
+	fields := strings.Fields(args)
+	return len(fields) > 0 && strings.EqualFold(fields[0], simulateArgument)
+}
+
+// simulatedApprovers returns the logins mentioned by the
+// "/approve simulate @alice" commands in body.
+func simulatedApprovers(body string) []string {
+	var logins []string
+	for _, match := range commandRegex.FindAllStringSubmatch(body, -1) {
+		if strings.ToUpper(match[1]) == approveCommand && isSimulateArgument(match[2]) {
+			logins = append(logins, mentionedLogins(match[2])...)
+		}
+	}
+	return logins
+}
+
+// simulationMessage tells whether the PR would be approved if logins approved
+// in addition to its current approvers, without changing ap.
+func simulationMessage(ap approvers.Approvers, logins []string, reference, ownersFilename string) string {
+	simulated := ap.WithHypotheticalApprovers(reference, logins...)
+	who := "@" + strings.Join(logins, ", @")
+	if simulated.IsApproved() {
+		return fmt.Sprintf("If %s approved, this PR would be approved.", who)
+	}
+	message := fmt.Sprintf("If %s approved, this PR would still not be approved.", who)
+	if unapproved := simulated.UnapprovedFiles().List(); len(unapproved) > 0 {
+		var files []string
+		for _, dir := range unapproved {
+			files = append(files, "`"+path.Join(dir, ownersFilename)+"`")
+		}
+		message += fmt.Sprintf(" These OWNERS files would still need approval: %s.", strings.Join(files, ", "))
+	}
+	if reasons := simulated.BlockedReasons(); len(reasons) > 0 {
+		var blocked []string
+		for _, reason := range reasons {
+			blocked = append(blocked, "`"+string(reason)+"`")
+		}
+		message += fmt.Sprintf(" Approval would be blocked by: %s.", strings.Join(blocked, ", "))
+	}
+	return message
+}
+
+// isHistoryArgument returns true if args are those of an "/approve history".
+func isHistoryArgument(args string) bool {
+	return strings.EqualFold(strings.TrimSpace(args), historyArgument)
+}
+
+// isHistoryCommand returns true if body contains an "/approve history".
+func isHistoryCommand(body string) bool {
+	for _, match := range commandRegex.FindAllStringSubmatch(body, -1) {
+		if strings.ToUpper(match[1]) == approveCommand && isHistoryArgument(match[2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// approvalHistoryMessage lists the approvals, lgtms and cancellations of
+// approveComments, which are sorted chronologically, one per line.
+func approvalHistoryMessage(approveComments []*comment, opts *plugins.Approve) string {
+	var lines []string
+	add := func(c *comment, kind, action string) {
+		who, link := approvalSource(opts.AnonymizeApprovers, c, kind)
+		lines = append(lines, fmt.Sprintf("- %s %s %s%s", c.CreatedAt.UTC().Format("2006-01-02 15:04 MST"), who, action, link))
+	}
+	for _, c := range approveComments {
+		if c.Author == "" {
+			continue
+		}
+		if opts.ConsiderReviewState() {
+			switch c.ReviewState {
+			case github.ReviewStateApproved:
+				add(c, "review", "approved")
+			case github.ReviewStateChangesRequested:
+				add(c, "review", "requested changes")
+			case github.ReviewStateDismissed:
+				add(c, "review", "had a review dismissed")
+			}
+		}
+		for _, command := range approvalCommands(c.Body, opts.CancelArgument()) {
+			switch {
+			case command.other:
+			case command.name == lgtmCommand && command.cancel:
+				add(c, "comment", "cancelled their lgtm")
+			case command.name == lgtmCommand:
+				add(c, "comment", "lgtm'd")
+			case command.cancel:
+				add(c, "comment", "cancelled their approval")
+			default:
+				add(c, "comment", "approved")
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return "Nobody approved or cancelled approval of this PR yet."
+	}
+	return "Approval history of this PR:\n\n" + strings.Join(lines, "\n")
+}
+
+// approvalDump is the approval state posted by "/approve dump".
+type approvalDump struct {
+	Status  approvers.NotificationStatus `json:"status"`
+	Options *plugins.Approve             `json:"options"`
+}
+
+// approvalDumpMessage renders the approval state of ap and the options in
+// effect as JSON within a collapsed block.
+func approvalDumpMessage(ap approvers.Approvers, opts *plugins.Approve) (string, error) {
+	bytes, err := json.MarshalIndent(approvalDump{Status: approvers.NewNotificationStatus(ap), Options: opts}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<details>\n<summary>Approval state of this PR</summary>\n\n```json\n%s\n```\n</details>", bytes), nil
+}
+
+// forcedApproval records a break-glass "/approve force" by an admin approver.
+type forcedApproval struct {
+	login     string
+	reason    string
+	reference string
+	id        int
+}
+
+// findForcedApproval returns the latest forced approval from an admin approver
+// that has not been cancelled by an admin since, or nil if there is none.
+func findForcedApproval(approveComments []*comment, opts *plugins.Approve) *forcedApproval {
+	var forced *forcedApproval
+	for _, c := range approveComments {
+		if !isAdminApprover(opts, c.Author) {
+			continue
+		}
+		for _, command := range approvalCommands(c.Body, opts.CancelArgument()) {
+			switch {
+			case command.name == lgtmCommand:
+			case command.forced && command.forceReason != "":
+				forced = &forcedApproval{login: c.Author, reason: command.forceReason, reference: c.HTMLURL, id: c.ID}
+			case command.cancel:
+				forced = nil
+			}
+		}
+	}
+	return forced
+}
+
+func forceAuditMarker(forced *forcedApproval) string {
+	return fmt.Sprintf("<!-- %s force: %d -->", PluginName, forced.id)
+}
+
+func forceAuditMessage(forced *forcedApproval) string {
+	return fmt.Sprintf("**Approval forced** by @%s: %s\n\nThe `%s` label was applied bypassing OWNERS and associated issue requirements ([source](%s)).\n%s",
+		forced.login, forced.reason, labels.Approved, forced.reference, forceAuditMarker(forced))
+}
+
+// hasForceAudit returns true if the bot already posted the audit comment for forced.
+func hasForceAudit(issueComments []github.IssueComment, isBot func(string) bool, forced *forcedApproval) bool {
+	return hasBotComment(issueComments, isBot, forceAuditMarker(forced))
+}
+
+// hasBotComment returns true if the bot posted one of issueComments with
+// marker.
+func hasBotComment(issueComments []github.IssueComment, isBot func(string) bool, marker string) bool {
+	for _, ic := range issueComments {
+		if isBot(ic.User.Login) && strings.Contains(ic.Body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstTimeInstructionsMarker identifies the comment explaining approval to
+// first-time authors.
+var firstTimeInstructionsMarker = fmt.Sprintf("<!-- %s first-time-instructions -->", PluginName)
+
+// isFirstTimeAuthor returns true if the author of pr has no merged PRs in the
+// repo. Failures to search are logged and treated as if the author contributed
+// before, so that the instructions aren't posted by mistake.
+func isFirstTimeAuthor(log *logrus.Entry, ghc GitHubClient, pr *state) bool {
+	query := fmt.Sprintf("is:pr is:merged repo:%s/%s author:%s", pr.org, pr.repo, pr.author)
+	merged, err := ghc.FindIssues(query, "", false)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to search merged PRs of %s in %s/%s.", pr.author, pr.org, pr.repo)
+		return false
+	}
+	for _, issue := range merged {
+		if issue.Number != pr.number {
+			return false
+		}
+	}
+	return true
+}
+
+// firstTimeInstructions explains to the author of pr how approval works.
+func firstTimeInstructions(pr *state, ownersFilename, commandHelpLink string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Thanks for your first pull request to %s/%s, @%s! Here is how it gets approved:\n\n", pr.org, pr.repo, pr.author)
+	b.WriteString("- Reviewers comment `/lgtm` once the changes look good to them.\n")
+	fmt.Fprintf(&b, "- Approvers listed in the `%s` files of the changed directories approve the PR with `/approve`.\n", ownersFilename)
+	b.WriteString("- The approval notification on this PR lists the files that still need approval and suggests approvers to assign.\n")
+	if commandHelpLink != "" {
+		fmt.Fprintf(&b, "\nAll available commands are listed [here](%s).\n", commandHelpLink)
+	}
+	b.WriteString(firstTimeInstructionsMarker)
+	return b.String()
+}
+
+// typoCommands are the commands that SuggestOnTypo detects misspellings of.
+var typoCommands = []string{"approve", "lgtm"}
+
+// typoHintMarker identifies the hint about a misspelled command given to
+// login, so that each commenter gets it once.
+func typoHintMarker(login string) string {
+	return fmt.Sprintf("<!-- %s typo-hint: %s -->", PluginName, strings.ToLower(login))
+}
+
+// maxTypoDistance is the largest edit distance of a slash command to one of
+// typoCommands that is considered a typo, e.g. "/lgmt" for "/lgtm".
+const maxTypoDistance = 2
+
+// misspelledCommands returns the commands in typoCommands that the slash
+// commands of body are close misspellings of, e.g. "approve" for "/aprove".
+func misspelledCommands(body string) []string {
+	suggestions := sets.NewString()
+	for _, match := range commandRegex.FindAllStringSubmatch(body, -1) {
+		name := strings.ToLower(match[1])
+		for _, command := range typoCommands {
+			if name == command {
+				continue
+			}
+			if levenshtein(name, command) <= maxTypoDistance {
+				suggestions.Insert(command)
+			}
+		}
+	}
+	return suggestions.List()
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = current[j-1] + 1
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// suggestOnTypo replies to the misspelled approval commands of the comment of
+// ce with the correct ones, unless its author got such a hint before.
+func suggestOnTypo(log *logrus.Entry, ghc GitHubClient, ce *github.GenericCommentEvent, isBot func(string) bool) {
+	commands := misspelledCommands(ce.Body)
+	if len(commands) == 0 {
+		return
+	}
+	org, repo := ce.Repo.Owner.Login, ce.Repo.Name
+	issueComments, err := ghc.ListIssueComments(org, repo, ce.Number)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to list comments of %s/%s#%d.", org, repo, ce.Number)
+		return
+	}
+	marker := typoHintMarker(ce.User.Login)
+	if hasBotComment(issueComments, isBot, marker) {
+		return
+	}
+	message := fmt.Sprintf("@%s, did you mean `/%s`? Commands are only recognized when spelled exactly.\n%s", ce.User.Login, strings.Join(commands, "` or `/"), marker)
+	if err := ghc.CreateComment(org, repo, ce.Number, message); err != nil {
+		log.WithError(err).Errorf("Failed to create typo hint comment on %s/%s#%d.", org, repo, ce.Number)
+	}
+}
+
+// approvalLossMarker identifies the comments explaining why the approved label
+// was removed.
+var approvalLossMarker = fmt.Sprintf("<!-- %s approval-loss -->", PluginName)
+
+// approvalLossMessage explains why the PR lost approval: which OWNERS files
+// are no longer approved, and the cancellations and other events behind it.
+func approvalLossMessage(ap approvers.Approvers, approveComments []*comment, headSHA string, opts *plugins.Approve) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Approval removed**: the `%s` label was removed from this PR.\n", labels.Approved)
+	if unapproved := ap.UnapprovedFiles().List(); len(unapproved) > 0 {
+		b.WriteString("\nDirectories that lost approval:\n")
+		for _, fn := range unapproved {
+			fmt.Fprintf(&b, "- `%s`\n", fn)
+		}
+	}
+	var reasons []string
+	if !ap.ApprovalsResetAt.IsZero() {
+		change := "associated issue"
+		if ap.ApprovalsResetOnBaseChange {
+			change = "base branch"
+		}
+		reasons = append(reasons, fmt.Sprintf("The %s of this PR changed, so approvals given before were reset.", change))
+	}
+	reasons = append(reasons, lostApprovals(ap, approveComments, headSHA, opts)...)
+	if ap.Draft {
+		reasons = append(reasons, "The PR was converted to a draft.")
+	}
+	if areas := ap.UnapprovedAreas(); len(areas) > 0 {
+		reasons = append(reasons, fmt.Sprintf("The area labels `%s` still need approval from an area approver.", strings.Join(areas, "`, `")))
+	}
+	if !ap.IsIssueRequirementMet() {
+		if len(ap.ClosedIssues) > 0 {
+			reasons = append(reasons, fmt.Sprintf("The associated issues %s are closed.", formatIssues(ap.ClosedIssues)))
+		} else {
+			reasons = append(reasons, "The PR has no associated issue.")
+		}
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "The approvals no longer cover all changed files, e.g. because new commits touched other directories.")
+	}
+	b.WriteString("\nWhy:\n")
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, "- %s\n", reason)
+	}
+	b.WriteString(approvalLossMarker)
+	return b.String()
+}
+
+// lostApprovals describes the latest cancellation, expiry or outdated commit
+// pin of each login whose approval doesn't count anymore, mirroring how
+// addApprovers processes approveComments.
+func lostApprovals(ap approvers.Approvers, approveComments []*comment, headSHA string, opts *plugins.Approve) []string {
+	lost := map[string]string{}
+	for _, c := range approveComments {
+		if c.Author == "" {
+			continue
+		}
+		if opts.ConsiderReviewState() {
+			who, link := approvalSource(opts.AnonymizeApprovers, c, "review")
+			switch c.ReviewState {
+			case github.ReviewStateApproved:
+				delete(lost, strings.ToLower(c.Author))
+			case github.ReviewStateChangesRequested:
+				lost[strings.ToLower(c.Author)] = fmt.Sprintf("%s requested changes%s.", capitalize(who), link)
+			default:
+				if cancelsOnDismissal(opts) && isDismissedReview(c) {
+					lost[strings.ToLower(c.Author)] = fmt.Sprintf("A review of %s was dismissed and needs to be followed by a new approval%s.", who, link)
+				}
+			}
+		}
+		who, link := approvalSource(opts.AnonymizeApprovers, c, "comment")
+		for _, command := range approvalCommands(c.Body, opts.CancelArgument()) {
+			switch {
+			case command.other || command.forced:
+			case command.cancel && len(command.targets) == 0:
+				lost[strings.ToLower(c.Author)] = fmt.Sprintf("%s cancelled their approval%s.", capitalize(who), link)
+			case command.cancel:
+				if isAdminApprover(opts, c.Author) {
+					for _, target := range command.targets {
+						targetWho, _ := approvalSource(opts.AnonymizeApprovers, &comment{Author: target}, "comment")
+						lost[strings.ToLower(target)] = fmt.Sprintf("%s cancelled the approval of %s%s.", capitalize(who), targetWho, link)
+					}
+				}
+			default:
+				if lapse := command.lapse(ap, headSHA, c, opts.AnonymizeApprovers); lapse != "" {
+					lost[strings.ToLower(c.Author)] = lapse
+				} else {
+					delete(lost, strings.ToLower(c.Author))
+				}
+			}
+		}
+	}
+	current := ap.GetCurrentApproversSet()
+	var reasons []string
+	for _, login := range sets.StringKeySet(lost).List() {
+		if !current.Has(login) {
+			reasons = append(reasons, lost[login])
+		}
+	}
+	return reasons
+}
+
+// approvalSource returns how the messages explaining approvals refer to the
+// author of c, and the link to c as kind. Like the notification, they refer to
+// "an approver" without a link if the approvers are anonymized.
+func approvalSource(anonymize bool, c *comment, kind string) (string, string) {
+	if anonymize {
+		return "an approver", ""
+	}
+	return "@" + c.Author, sourceLink(kind, c.HTMLURL)
+}
+
+// capitalize returns s with its first letter in upper case.
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// sourceLink returns a parenthesized link named kind to url, or "" if url is
+// unknown, e.g. because the account that commented was deleted.
+func sourceLink(kind, url string) string {
+	if url == "" {
+		return ""
+	}
+	return fmt.Sprintf(" ([%s](%s))", kind, url)
+}
+
+// hasApprovalLossNote returns true if the latest comment of the bot explaining
+// a loss of approval is message, so that it isn't repeated.
+func hasApprovalLossNote(issueComments []github.IssueComment, isBot func(string) bool, message string) bool {
+	latest := ""
+	for _, ic := range issueComments {
+		if isBot(ic.User.Login) && strings.Contains(ic.Body, approvalLossMarker) {
+			latest = ic.Body
+		}
+	}
+	return latest == message
+}
+
+// findStackApproval returns the latest "/approve stack" comment from an OWNERS
+// approver, unless its author cancelled their approval afterwards.
+func findStackApproval(approveComments []*comment, owners approvers.Owners, cancel string) *comment {
+	var stacked *comment
+	for _, c := range approveComments {
+		if !isOwnersApprover(owners, c.Author) {
+			continue
+		}
+		for _, command := range approvalCommands(c.Body, cancel) {
+			switch {
+			case command.name == approveCommand && command.stack:
+				stacked = c
+			case stacked != nil && stacked.Author == c.Author && command.name != lgtmCommand && command.cancel:
+				stacked = nil
+			}
+		}
+	}
+	return stacked
+}
+
+// isAssignArgument returns true if args are those of an "/approve assign".
+func isAssignArgument(args string) bool {
+	fields := strings.Fields(args)
+	return len(fields) > 0 && strings.EqualFold(fields[0], assignArgument)
+}
+
+// findDesignatedApprovers returns the logins named by the latest
+// "/approve assign" comment from an OWNERS approver.
+func findDesignatedApprovers(approveComments []*comment, owners approvers.Owners) []string {
+	var designated []string
+	for _, c := range approveComments {
+		if !isOwnersApprover(owners, c.Author) {
+			continue
+		}
+		for _, match := range commandRegex.FindAllStringSubmatch(c.Body, -1) {
+			if strings.ToUpper(match[1]) == approveCommand && isAssignArgument(match[2]) {
+				designated = mentionedLogins(match[2])
+			}
+		}
+	}
+	return designated
+}
+
+// splitDesignatedApprovers splits designated into the approvers of any of the
+// unapproved OWNERS files and the other logins, which are ignored.
+func splitDesignatedApprovers(owners approvers.Owners, unapproved sets.String, designated []string) (valid, ignored []string) {
+	ownersApprovers := owners.GetApprovers()
+	seen := sets.NewString()
+	for _, login := range designated {
+		if seen.Has(strings.ToLower(login)) {
+			continue
+		}
+		seen.Insert(strings.ToLower(login))
+		canApprove := false
+		for _, file := range unapproved.List() {
+			if ownersApprovers[file].Has(strings.ToLower(login)) {
+				canApprove = true
+				break
+			}
+		}
+		if canApprove {
+			valid = append(valid, login)
+		} else {
+			ignored = append(ignored, login)
+		}
+	}
+	return valid, ignored
+}
+
+// assignDesignatedApprovers assigns the designated approvers that aren't
+// assigned to pr yet.
+func assignDesignatedApprovers(log *logrus.Entry, ghc GitHubClient, pr *state, designated []string) {
+	assigned := sets.NewString()
+	for _, user := range pr.assignees {
+		assigned.Insert(strings.ToLower(user.Login))
+	}
+	var logins []string
+	for _, login := range designated {
+		if !assigned.Has(strings.ToLower(login)) {
+			logins = append(logins, login)
+		}
+	}
+	if len(logins) == 0 {
+		return
+	}
+	if err := ghc.AssignIssue(pr.org, pr.repo, pr.number, logins); err != nil {
+		log.WithError(err).Errorf("Failed to assign %v to %s/%s#%d.", logins, pr.org, pr.repo, pr.number)
+	}
+}
+
+// inheritedApprovals returns an "/approve" comment for each approver of the PR
+// named by the "Original-PR: #N" trailer in the body of pr, linking to and made
+// at the time of the notification recording them. They are replayed with the
+// other comments, so the same filters apply and a later cancel wins.
+//
+// The approvals are only inherited from a closed and unmerged PR of the same
+// author with the same head commit or the same changes as pr, if its latest
+// notification says it was approved and all of its approvers other than its
+// author still are OWNERS approvers of pr.
+func inheritedApprovals(log *logrus.Entry, ghc GitHubClient, pr *state, changes []github.PullRequestChange, isBot, isIgnoredApprover func(string) bool, owners approvers.Owners) []*comment {
+	match := originalPRRegex.FindStringSubmatch(pr.body)
+	if match == nil {
+		return nil
+	}
+	number, err := strconv.Atoi(match[1])
+	if err != nil || number == pr.number {
+		return nil
+	}
+	original, err := ghc.GetPullRequest(pr.org, pr.repo, number)
+	if err != nil {
+		log.WithError(err).Debugf("Not inheriting approvals from %s/%s#%d, which is not a pull request.", pr.org, pr.repo, number)
+		return nil
+	}
+	if !strings.EqualFold(original.User.Login, pr.author) || original.State != "closed" || original.Merged {
+		log.Infof("Not inheriting approvals from %s/%s#%d, which is not a closed and unmerged PR of %s.", pr.org, pr.repo, number, pr.author)
+		return nil
+	}
+	if pr.headSHA == "" || original.Head.SHA != pr.headSHA {
+		originalChanges, err := ghc.GetPullRequestChanges(pr.org, pr.repo, number)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to get the file changes of %s/%s#%d.", pr.org, pr.repo, number)
+			return nil
+		}
+		if !sameChanges(changes, originalChanges) {
+			log.Infof("Not inheriting approvals from %s/%s#%d, which has other changes.", pr.org, pr.repo, number)
+			return nil
+		}
+	}
+	issueComments, err := ghc.ListIssueComments(pr.org, pr.repo, number)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to list comments on %s/%s#%d.", pr.org, pr.repo, number)
+		return nil
+	}
+	comments := commentsFromIssueComments(issueComments)
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+	notification := getLast(filterComments(comments, notificationMatcher(isBot, false)))
+	if notification == nil {
+		return nil
+	}
+	status, err := approvers.ParseNotificationStatus(notification.Body)
+	if err != nil || status == nil || !status.Approved {
+		return nil
+	}
+	var approvals []*comment
+	for _, login := range status.Approvers {
+		if strings.EqualFold(login, original.User.Login) {
+			continue
+		}
+		if !isOwnersApprover(owners, login) || isIgnoredApprover(login) {
+			log.Infof("Not inheriting approvals from %s/%s#%d, since %s can no longer approve.", pr.org, pr.repo, number, login)
+			return nil
+		}
+		approvals = append(approvals, &comment{
+			Body:      "/approve",
+			Author:    login,
+			CreatedAt: notification.CreatedAt,
+			HTMLURL:   notification.HTMLURL,
+		})
+	}
+	return approvals
+}
+
+// sameChanges returns true if both lists hold the same file changes, in any
+// order.
+func sameChanges(a, b []github.PullRequestChange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(change github.PullRequestChange) [4]string {
+		return [4]string{change.Filename, change.PreviousFilename, change.Status, change.Patch}
+	}
+	counts := map[[4]string]int{}
+	for _, change := range a {
+		counts[key(change)]++
+	}
+	for _, change := range b {
+		if counts[key(change)] == 0 {
+			return false
+		}
+		counts[key(change)]--
+	}
+	return true
+}
+
+// authorityApprovedLogins returns the logins that a comment by the
+// AuthorityAccount lists as having approved. Comments by anyone else don't
+// grant any approval.
+func authorityApprovedLogins(opts *plugins.Approve, author, body string) []string {
+	if opts.AuthorityAccount == "" || !strings.EqualFold(author, opts.AuthorityAccount) {
+		return nil
+	}
+	var logins []string
+	for _, match := range authorityApprovalRegex.FindAllStringSubmatch(body, -1) {
+		for _, field := range strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			if login := strings.TrimPrefix(field, "@"); login != "" {
+				logins = append(logins, login)
 			}
 		}
-		if err := ghc.CreateComment(pr.org, pr.repo, pr.number, *newMessage); err != nil {
-			log.WithError(err).Errorf("Failed to create comment on %s/%s#%d: %q.", pr.org, pr.repo, pr.number, *newMessage)
-		}
 	}
-	log.WithField("duration", time.Since(start).String()).Debug("Completed adding/deleting approval comments in handle")
+	return logins
+}
 
-	start = time.Now()
-	if !approversHandler.IsApproved() {
-		if hasApprovedLabel {
-			if err := ghc.RemoveLabel(pr.org, pr.repo, pr.number, labels.Approved); err != nil {
-				log.WithError(err).Errorf("Failed to remove %q label from %s/%s#%d.", labels.Approved, pr.org, pr.repo, pr.number)
+// authorityApprovals returns the approvals granted by comments of the
+// AuthorityAccount as approval comments of the listed logins, made at the time
+// of the granting comment. Like any other approval, they are cancelled by
+// later cancellations and dropped by resets.
+func authorityApprovals(comments []*comment, owners approvers.Owners, isIgnoredApprover func(string) bool, opts *plugins.Approve) []*comment {
+	var approvals []*comment
+	for _, c := range comments {
+		for _, login := range authorityApprovedLogins(opts, c.Author, c.Body) {
+			if !isOwnersApprover(owners, login) || isIgnoredApprover(login) {
+				continue
 			}
-		}
-	} else if !hasApprovedLabel {
-		if err := ghc.AddLabel(pr.org, pr.repo, pr.number, labels.Approved); err != nil {
-			log.WithError(err).Errorf("Failed to add %q label to %s/%s#%d.", labels.Approved, pr.org, pr.repo, pr.number)
+			approvals = append(approvals, &comment{
+				Body:      "/approve",
+				Author:    login,
+				CreatedAt: c.CreatedAt,
+				HTMLURL:   c.HTMLURL,
+			})
 		}
 	}
-	log.WithField("duration", time.Since(start).String()).Debug("Completed adding/deleting approval labels in handle")
-	return nil
+	return approvals
 }
 
-func humanAddedApproved(ghc githubClient, log *logrus.Entry, org, repo string, number int, isBot func(string) bool, hasLabel bool) func() bool {
-	findOut := func() bool {
-		if !hasLabel {
-			return false
+func stackCascadeMarker(pr *state, stacked *comment) string {
+	return fmt.Sprintf("<!-- %s stack: %s/%s#%d %d -->", PluginName, pr.org, pr.repo, pr.number, stacked.ID)
+}
+
+// cascadeStackApproval asks for approval of the open PRs linked from the body
+// of pr, since they are part of the stack that stacked approved.
+func cascadeStackApproval(log *logrus.Entry, ghc GitHubClient, pr *state, isBot func(string) bool, stacked *comment) {
+	linked, err := findLinkedPullRequests(pr.body, pr.org, pr.repo, pr.number)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to find linked pull requests from PR body: %v", err)
+		return
+	}
+	marker := stackCascadeMarker(pr, stacked)
+	for _, number := range linked {
+		child, err := ghc.GetPullRequest(pr.org, pr.repo, number)
+		if err != nil {
+			log.WithError(err).Debugf("Skipping %s/%s#%d, which is not a pull request.", pr.org, pr.repo, number)
+			continue
+		}
+		if child.State == "closed" {
+			continue
 		}
-		events, err := ghc.ListIssueEvents(org, repo, number)
+		issueComments, err := ghc.ListIssueComments(pr.org, pr.repo, number)
 		if err != nil {
-			log.WithError(err).Errorf("Failed to list issue events for %s/%s#%d.", org, repo, number)
-			return false
+			log.WithError(err).Errorf("Failed to list comments on %s/%s#%d.", pr.org, pr.repo, number)
+			continue
 		}
-		var lastAdded github.ListedIssueEvent
-		for _, event := range events {
-			// Only consider "approved" label added events.
-			if event.Event != github.IssueActionLabeled || event.Label.Name != labels.Approved {
-				continue
+		cascaded := false
+		for _, ic := range issueComments {
+			if isBot(ic.User.Login) && strings.Contains(ic.Body, marker) {
+				cascaded = true
+				break
 			}
-			lastAdded = event
 		}
-
-		if lastAdded.Actor.Login == "" || isBot(lastAdded.Actor.Login) {
-			return false
+		if cascaded {
+			continue
 		}
-		return true
-	}
-
-	var cache *bool
-	return func() bool {
-		if cache == nil {
-			val := findOut()
-			cache = &val
+		message := fmt.Sprintf("@%s approved the stack containing this PR in #%d ([source](%s)).\n\nIf this PR is ready, an approver can approve it as well by writing `/approve` in a comment.\n%s",
+			stacked.Author, pr.number, stacked.HTMLURL, marker)
+		if err := ghc.CreateComment(pr.org, pr.repo, number, message); err != nil {
+			log.WithError(err).Errorf("Failed to create stack approval comment on %s/%s#%d.", pr.org, pr.repo, number)
 		}
-		return *cache
 	}
 }
 
@@ -535,12 +2725,16 @@ func approvalMatcher(isBot func(string) bool, lgtmActsAsApprove, reviewActsAsApp
 }
 
 func isApprovalCommand(isBot func(string) bool, lgtmActsAsApprove bool, c *comment) bool {
-	if isBot(c.Author) {
+	// Comments of deleted accounts have no author and never approve.
+	if c.Author == "" || isBot(c.Author) {
 		return false
 	}
 
 	for _, match := range commandRegex.FindAllStringSubmatch(c.Body, -1) {
 		cmd := strings.ToUpper(match[1])
+		if cmd == approveCommand && isReportArgument(match[2]) {
+			continue
+		}
 		if (cmd == lgtmCommand && lgtmActsAsApprove) || cmd == approveCommand || cmd == removeApproveCommand {
 			return true
 		}
@@ -549,7 +2743,7 @@ func isApprovalCommand(isBot func(string) bool, lgtmActsAsApprove bool, c *comme
 }
 
 func isApprovalState(isBot func(string) bool, reviewActsAsApprove bool, c *comment) bool {
-	if isBot(c.Author) {
+	if c.Author == "" || isBot(c.Author) {
 		return false
 	}
 
@@ -571,9 +2765,119 @@ func isApprovalState(isBot func(string) bool, reviewActsAsApprove bool, c *comme
 	return false
 }
 
-func notificationMatcher(isBot func(string) bool) func(*comment) bool {
+// isDismissedReview returns true if c is a dismissed review. Once dismissed, a
+// review doesn't tell whether it requested changes or approved, so with
+// RequireReapprovalAfterChanges or TreatDismissalAsCancel either invalidates the
+// earlier approvals of its author.
+func isDismissedReview(c *comment) bool {
+	return strings.EqualFold(string(c.ReviewState), string(github.ReviewStateDismissed))
+}
+
+// cancelsOnDismissal returns true if a dismissed review cancels the earlier
+// approvals of its author under opts.
+func cancelsOnDismissal(opts *plugins.Approve) bool {
+	return opts.RequireReapprovalAfterChanges || opts.TreatDismissalAsCancel
+}
+
+// titleApprovals returns an "/approve" comment for each title edit in events
+// that added phrase to the title, authored by the editor at the time of the
+// edit. They are replayed with the other comments, so the same filters apply
+// and a later cancel wins. Removing the phrase again doesn't cancel.
+func titleApprovals(events []github.ListedIssueEvent, phrase, htmlURL string) []*comment {
+	var approvals []*comment
+	for _, event := range events {
+		if event.Event != github.IssueActionRenamed || event.Rename == nil || event.Actor.Login == "" {
+			continue
+		}
+		if strings.Contains(event.Rename.To, phrase) && !strings.Contains(event.Rename.From, phrase) {
+			approvals = append(approvals, &comment{
+				Body:      "/approve",
+				Author:    event.Actor.Login,
+				CreatedAt: event.CreatedAt,
+				HTMLURL:   htmlURL,
+			})
+		}
+	}
+	return approvals
+}
+
+// applyReviewDismissals marks the reviews dismissed by the "review_dismissed"
+// events as dismissed, in case the listed reviews predate the dismissal. The
+// reviews keep their time, so that the dismissal drops the approval standing
+// at the review but not the approvals its author gave after it.
+func applyReviewDismissals(reviews []github.Review, events []github.ListedIssueEvent) []github.Review {
+	dismissedIDs := sets.NewInt()
+	for _, event := range events {
+		if event.Event == github.IssueActionReviewDismissed && event.DismissedReview != nil {
+			dismissedIDs.Insert(event.DismissedReview.ReviewID)
+		}
+	}
+	if dismissedIDs.Len() == 0 {
+		return reviews
+	}
+	dismissed := make([]github.Review, 0, len(reviews))
+	for _, review := range reviews {
+		if dismissedIDs.Has(review.ID) {
+			review.State = github.ReviewStateDismissed
+		}
+		dismissed = append(dismissed, review)
+	}
+	return dismissed
+}
+
+// normalizedBotUserChecker returns the bot user checker of ghc, matching the
+// bot regardless of whether the login carries the "[bot]" suffix of GitHub
+// Apps, e.g. both "myapp" and "myapp[bot]".
+func normalizedBotUserChecker(ghc botClient) (func(string) bool, error) {
+	isBot, err := ghc.BotUserChecker()
+	if err != nil {
+		return nil, err
+	}
+	return func(login string) bool {
+		bare := strings.TrimSuffix(login, "[bot]")
+		return isBot(bare) || isBot(bare+"[bot]")
+	}, nil
+}
+
+// botUserCheckerCache memoizes the normalized bot user checker for the process
+// lifetime, as the identity of the bot doesn't change and resolving it can take
+// an API call for GitHub Apps. Failures aren't cached, so that they are retried
+// on the next event.
+type botUserCheckerCache struct {
+	lock  sync.Mutex
+	isBot func(string) bool
+}
+
+// get returns the cached bot user checker, resolving it from ghc first if
+// needed. It is safe for concurrent use.
+func (c *botUserCheckerCache) get(ghc botClient) (func(string) bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.isBot == nil {
+		isBot, err := normalizedBotUserChecker(ghc)
+		if err != nil {
+			return nil, err
+		}
+		c.isBot = isBot
+	}
+	return c.isBot, nil
+}
+
+// reset forgets the cached bot user checker, so that the next get resolves it
+// again. Tests use it to not leak the bot of one fake client into the next.
+func (c *botUserCheckerCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.isBot = nil
+}
+
+// notificationMatcher matches the notifications of the bot, whether they were
+// posted as issue comments or as reviews. With legacy set, those posted by the
+// deprecated bot are matched as well, so that they are replaced rather than
+// left behind during the migration.
+func notificationMatcher(isBot func(string) bool, legacy bool) func(*comment) bool {
 	return func(c *comment) bool {
-		if !isBot(c.Author) {
+		if !isBot(c.Author) && !(legacy && c.Author == deprecatedBotName) {
 			return false
 		}
 		match := notificationRegex.FindStringSubmatch(c.Body)
@@ -581,73 +2885,566 @@ func notificationMatcher(isBot func(string) bool) func(*comment) bool {
 	}
 }
 
+// CleanupNotifications deletes all but the latest approval notification of the
+// bot on the given issue or PR, e.g. duplicates left behind on open PRs, or
+// all obsolete ones on closed PRs. It is meant for on-demand maintenance and
+// stops early when ctx is done. Notifications posted as reviews can't be
+// deleted and are left alone.
+func CleanupNotifications(ctx context.Context, ghc notificationClient, org, repo string, number int) error {
+	isBot, err := normalizedBotUserChecker(ghc)
+	if err != nil {
+		return err
+	}
+	issueComments, err := ghc.ListIssueComments(org, repo, number)
+	if err != nil {
+		return err
+	}
+	comments := commentsFromIssueComments(issueComments)
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+	notifications := filterComments(comments, notificationMatcher(isBot, false))
+	var errs []error
+	for i, notif := range notifications {
+		if i == len(notifications)-1 {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if err := ghc.DeleteComment(org, repo, notif.ID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete comment %d from %s/%s#%d: %w", notif.ID, org, repo, number, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// LabelStaleUnapprovedPRs applies the StaleApprovalLabel of opts to the open
+// PRs of org/repo that are still not approved threshold after they were
+// created, and removes it from those approved since. Drafts aren't labeled.
+// It is meant to run periodically and stops early when ctx is done.
+func LabelStaleUnapprovedPRs(ctx context.Context, ghc staleApprovalClient, opts *plugins.Approve, org, repo string, threshold time.Duration) error {
+	prs, err := ghc.GetPullRequests(org, repo)
+	if err != nil {
+		return fmt.Errorf("failed to list the open pull requests of %s/%s: %w", org, repo, err)
+	}
+	label := opts.StaleApprovalLabelName()
+	cutoff := pluginClock.Now().Add(-threshold)
+	var errs []error
+	for _, pr := range prs {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		approved, stale := github.HasLabel(labels.Approved, pr.Labels), github.HasLabel(label, pr.Labels)
+		switch {
+		case approved && stale:
+			if err := ghc.RemoveLabel(org, repo, pr.Number, label); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove the %q label from %s/%s#%d: %w", label, org, repo, pr.Number, err))
+			}
+		case !approved && !stale && !pr.Draft && pr.CreatedAt.Before(cutoff):
+			if err := ghc.AddLabel(org, repo, pr.Number, label); err != nil {
+				errs = append(errs, fmt.Errorf("failed to add the %q label to %s/%s#%d: %w", label, org, repo, pr.Number, err))
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
 func updateNotification(linkURL *url.URL, commandHelpLink, prProcessLink, org, repo, branch string, latestNotification *comment, approversHandler approvers.Approvers) *string {
 	message := approvers.GetMessage(approversHandler, linkURL, commandHelpLink, prProcessLink, org, repo, branch)
-	if message == nil || (latestNotification != nil && strings.Contains(latestNotification.Body, *message)) {
+	if message == nil || latestNotification == nil {
+		return message
+	}
+	// Notifications carrying a content hash are compared semantically, so that
+	// cosmetic differences such as the order of files don't cause churn. Legacy
+	// notifications don't carry one, so they are always compared as text.
+	if status, err := approvers.ParseNotificationStatus(latestNotification.Body); err == nil && status != nil && status.Hash != "" && !approversHandler.LegacyNotificationFormat {
+		if current, err := approvers.ParseNotificationStatus(*message); err == nil && current != nil && status.Hash == current.Hash {
+			return nil
+		}
+		return message
+	}
+	if strings.Contains(latestNotification.Body, *message) {
 		return nil
 	}
 	return message
 }
 
+// notificationIDMarker records the comment ID of the canonical notification
+// in its body when EditNotificationInPlace is enabled.
+func notificationIDMarker(id int) string {
+	return fmt.Sprintf("\n<!-- %s notification: %d -->", PluginName, id)
+}
+
+// canonicalNotification returns the latest notification that carries the
+// marker with its own comment ID, if any.
+func canonicalNotification(notifications []*comment) *comment {
+	var canonical *comment
+	for _, notif := range notifications {
+		if strings.Contains(notif.Body, notificationIDMarker(notif.ID)) {
+			canonical = notif
+		}
+	}
+	return canonical
+}
+
+// editNotificationInPlace deletes all notifications but the canonical one and
+// edits it to newMessage, if any. Without a canonical notification, e.g. because
+// a user deleted it, the notification is recreated and tagged with its ID.
+func editNotificationInPlace(log *logrus.Entry, ghc GitHubClient, pr *state, isBot func(string) bool, notifications []*comment, canonical *comment, newMessage *string) {
+	for _, notif := range notifications {
+		if notif == canonical {
+			continue
+		}
+		if err := ghc.DeleteComment(pr.org, pr.repo, notif.ID); err != nil {
+			log.WithError(err).Errorf("Failed to delete comment from %s/%s#%d, ID: %d.", pr.org, pr.repo, pr.number, notif.ID)
+		}
+	}
+	if newMessage == nil {
+		return
+	}
+	if canonical != nil {
+		if err := retryNotification(log, func() error {
+			return ghc.EditComment(pr.org, pr.repo, canonical.ID, *newMessage+notificationIDMarker(canonical.ID))
+		}); err != nil {
+			log.WithError(err).Errorf("Failed to edit comment on %s/%s#%d, ID: %d.", pr.org, pr.repo, pr.number, canonical.ID)
+		}
+		return
+	}
+	if err := retryNotification(log, func() error {
+		return ghc.CreateComment(pr.org, pr.repo, pr.number, *newMessage)
+	}); err != nil {
+		log.WithError(err).Errorf("Failed to create comment on %s/%s#%d: %q.", pr.org, pr.repo, pr.number, *newMessage)
+		return
+	}
+	// The ID of the new comment is only known once it is listed.
+	issueComments, err := ghc.ListIssueComments(pr.org, pr.repo, pr.number)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to list comments on %s/%s#%d.", pr.org, pr.repo, pr.number)
+		return
+	}
+	for i := len(issueComments) - 1; i >= 0; i-- {
+		if ic := issueComments[i]; isBot(ic.User.Login) && ic.Body == *newMessage {
+			if err := ghc.EditComment(pr.org, pr.repo, ic.ID, *newMessage+notificationIDMarker(ic.ID)); err != nil {
+				log.WithError(err).Errorf("Failed to edit comment on %s/%s#%d, ID: %d.", pr.org, pr.repo, pr.number, ic.ID)
+			}
+			return
+		}
+	}
+}
+
+// retryNotification calls post up to notificationAttempts times with an
+// exponential backoff, so that a transient failure of GitHub doesn't leave the
+// PR without notification until the next event.
+func retryNotification(log *logrus.Entry, post func() error) error {
+	backoff := notificationRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := post()
+		if err == nil || attempt == notificationAttempts {
+			return err
+		}
+		log.WithError(err).Warnf("Failed to post the notification, retrying in %v.", backoff)
+		notificationSleep(backoff)
+		backoff *= 2
+	}
+}
+
+// mentionedLogins returns the logins mentioned in the arguments of a command,
+// e.g. "cancel @alice @bob".
+func mentionedLogins(args string) []string {
+	var targets []string
+	for _, field := range strings.Fields(args) {
+		if login := strings.TrimPrefix(field, "@"); login != field && login != "" {
+			targets = append(targets, login)
+		}
+	}
+	return targets
+}
+
+// approvalDuration returns the duration of a time-boxed approval such as
+// "/approve for:72h", or 0 if args don't time-box the approval, along with the
+// remaining arguments.
+func approvalDuration(args string) (time.Duration, string, error) {
+	var rest []string
+	var duration time.Duration
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, durationPrefix) {
+			rest = append(rest, field)
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimPrefix(field, durationPrefix))
+		if err != nil {
+			return 0, args, err
+		}
+		if d <= 0 {
+			return 0, args, fmt.Errorf("approval duration must be positive, got %v", d)
+		}
+		duration = d
+	}
+	return duration, strings.Join(rest, " "), nil
+}
+
+// approvalSHA returns the commit SHA (prefix) given with prefix, such as the
+// commit an approval like "/approve sha:abc1234" is tied to, or "" if args
+// don't name a commit, along with the remaining arguments.
+func approvalSHA(args, prefix string) (string, string, error) {
+	var rest []string
+	var sha string
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, prefix) {
+			rest = append(rest, field)
+			continue
+		}
+		sha = strings.TrimPrefix(field, prefix)
+		if len(sha) < minApprovalSHALength {
+			return "", args, fmt.Errorf("approval commit SHA %q must have at least %d characters", sha, minApprovalSHALength)
+		}
+	}
+	return sha, strings.Join(rest, " "), nil
+}
+
+// approvalReason returns the justification given with an approval such as
+// `/approve reason:"reviewed the threat model"`, or "" if args don't give one,
+// along with the remaining arguments.
+func approvalReason(args string) (string, string) {
+	match := approvalReasonRegex.FindStringSubmatchIndex(args)
+	if match == nil {
+		return "", args
+	}
+	var reason string
+	if match[2] >= 0 {
+		reason = args[match[2]:match[3]]
+	} else {
+		reason = args[match[4]:match[5]]
+	}
+	return strings.TrimSpace(reason), strings.TrimSpace(args[:match[0]] + " " + args[match[1]:])
+}
+
+// modifiesOwners returns true if any of filenames is an OWNERS or
+// OWNERS_ALIASES file, as named by ownersFilenames.
+func modifiesOwners(filenames []string, ownersFilenames ownersconfig.Filenames) bool {
+	for _, filename := range filenames {
+		if base := path.Base(filename); base == ownersFilenames.Owners || base == ownersFilenames.OwnersAliases {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveFiles returns the filenames in the SensitivePaths of opts.
+func sensitiveFiles(opts *plugins.Approve, filenames []string) sets.String {
+	sensitive := sets.NewString()
+	for _, filename := range filenames {
+		if opts.IsSensitivePath(filename) {
+			sensitive.Insert(filename)
+		}
+	}
+	return sensitive
+}
+
+// approvalAlias returns the alias that an approval such as
+// "/approve as:security-reviewers" is given as, or "" if args don't name one,
+// along with the remaining arguments.
+func approvalAlias(args string) (string, string) {
+	var rest []string
+	var alias string
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, aliasPrefix) {
+			rest = append(rest, field)
+			continue
+		}
+		alias = strings.TrimPrefix(field, aliasPrefix)
+	}
+	return alias, strings.Join(rest, " ")
+}
+
+// approvalDependency returns the number of the PR a conditional approval such
+// as "/approve after:#123" waits for, or 0 if args don't make the approval
+// conditional, along with the remaining arguments.
+func approvalDependency(args string) (int, string, error) {
+	var rest []string
+	var number int
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, afterPrefix) {
+			rest = append(rest, field)
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(field, afterPrefix), "#"))
+		if err != nil {
+			return 0, args, err
+		}
+		if n <= 0 {
+			return 0, args, fmt.Errorf("approval dependency must be a pull request number, got %d", n)
+		}
+		number = n
+	}
+	return number, strings.Join(rest, " "), nil
+}
+
+// approvalCommand is an "/approve", "/lgtm" or "/remove-approve" command of a
+// comment, with its arguments parsed once for all the functions that replay
+// the approval history of a PR.
+type approvalCommand struct {
+	// name is the upper cased command, e.g. approveCommand.
+	name string
+	// other is set for commands that neither approve nor cancel, such as
+	// "/approve refresh", and for commands with invalid arguments.
+	other bool
+	// forced is set for an "/approve force <reason>", see findForcedApproval.
+	forced      bool
+	forceReason string
+	// cancel is set for "/remove-approve" and cancelling commands, which
+	// cancel the approvals of targets if an admin approver names any.
+	cancel  bool
+	targets []string
+
+	noIssue       bool
+	deletionsOnly bool
+	stack         bool
+	duration      time.Duration
+	sha           string
+	dependency    int
+	upto          string
+	alias         string
+	reason        string
+}
+
+// approvalCommands returns the approval commands of body in order. cancel is
+// the argument that cancels an "/approve", while an "/lgtm" is always
+// cancelled by "/lgtm cancel", as with the lgtm plugin.
+func approvalCommands(body, cancel string) []approvalCommand {
+	var commands []approvalCommand
+	for _, match := range commandRegex.FindAllStringSubmatch(body, -1) {
+		name := strings.ToUpper(match[1])
+		if name != approveCommand && name != lgtmCommand && name != removeApproveCommand {
+			continue
+		}
+		commands = append(commands, parseApprovalCommand(name, match[2], cancel))
+	}
+	return commands
+}
+
+func parseApprovalCommand(name, args, cancel string) approvalCommand {
+	command := approvalCommand{name: name}
+	if name == removeApproveCommand {
+		command.cancel = true
+		return command
+	}
+	// The reason of a forced approval is the rest of the arguments, and both
+	// reasons keep their case, so they are split off before lower casing.
+	if reason, ok := forceReason(args); ok {
+		command.forced, command.forceReason = true, reason
+		return command
+	}
+	command.reason, args = approvalReason(strings.TrimSpace(args))
+	args = strings.ToLower(args)
+	if name == approveCommand && isNonApprovalArgument(args) {
+		command.other = true
+		return command
+	}
+	if name == lgtmCommand {
+		cancel = cancelArgument
+	}
+	if hasArgument(args, cancel) {
+		command.cancel = true
+		command.targets = mentionedLogins(args)
+		return command
+	}
+	var err error
+	if command.duration, args, err = approvalDuration(args); err != nil {
+		command.other = true
+		return command
+	}
+	if command.sha, args, err = approvalSHA(args, shaPrefix); err != nil {
+		command.other = true
+		return command
+	}
+	if command.dependency, args, err = approvalDependency(args); err != nil {
+		command.other = true
+		return command
+	}
+	if command.upto, args, err = approvalSHA(args, uptoPrefix); err != nil {
+		command.other = true
+		return command
+	}
+	command.alias, args = approvalAlias(args)
+	command.noIssue = args == noIssueArgument
+	command.deletionsOnly = args == deletionsOnlyArgument
+	command.stack = args == stackArgument
+	return command
+}
+
+// lapse describes why the approval of c no longer counts although it was not
+// cancelled, e.g. because it expired or names a commit that is no longer the
+// head of the PR, or returns "" if it still counts. Such an approval replaces
+// earlier approvals of its author just like a cancel does. The author is
+// referred to as by approvalSource.
+func (command approvalCommand) lapse(ap approvers.Approvers, headSHA string, c *comment, anonymize bool) string {
+	_, reviewed := ap.FilesChangedAfter[command.upto]
+	who, link := approvalSource(anonymize, c, "comment")
+	switch {
+	case ap.UnmergedDependencies[command.dependency]:
+		return fmt.Sprintf("The approval of %s waits for #%d to merge%s.", who, command.dependency, link)
+	case command.upto != "" && !reviewed:
+		return fmt.Sprintf("%s approved up to commit `%s`, which isn't part of this PR%s.", capitalize(who), command.upto, link)
+	case command.sha != "" && !strings.HasPrefix(strings.ToLower(headSHA), command.sha):
+		return fmt.Sprintf("%s approved commit `%s`, but new commits were pushed since%s.", capitalize(who), command.sha, link)
+	case command.duration > 0 && !pluginClock.Now().Before(c.CreatedAt.Add(command.duration)):
+		return fmt.Sprintf("The approval of %s expired%s.", who, link)
+	}
+	return ""
+}
+
+// isNonApprovalArgument returns true if args are those of an "/approve" that
+// neither approves nor cancels, such as "/approve refresh".
+func isNonApprovalArgument(args string) bool {
+	return isReportArgument(args) || isAssignArgument(args) || isDiffArgument(args) || isRequireIssueArgument(args)
+}
+
+// isReportArgument returns true if args are those of an "/approve" that only
+// refreshes or reports on the approval state, whose comments are left out of
+// the approval comments altogether.
+func isReportArgument(args string) bool {
+	return isRefreshArgument(args) || isDumpArgument(args) || isSimulateArgument(args) || isHistoryArgument(args)
+}
+
+// hasArgument returns true if argument is one of the whitespace separated
+// args, so that e.g. a cancel keyword doesn't match part of another argument.
+func hasArgument(args, argument string) bool {
+	for _, field := range strings.Fields(args) {
+		if strings.EqualFold(field, argument) {
+			return true
+		}
+	}
+	return false
+}
+
 // addApprovers iterates through the list of comments on a PR
 // and identifies all of the people that have said /approve and adds
 // them to the Approvers.  The function uses the latest approve or cancel comment
 // to determine the Users intention. A review in requested changes state is
 // considered a cancel.
-func addApprovers(approversHandler *approvers.Approvers, approveComments []*comment, author string, reviewActsAsApprove bool) {
+// A cancel only ever affects the comment author, except for admin approvers
+// who may cancel the approval of others with "/approve cancel @user".
+// Approvals are only recorded from approvers in the OWNERS files of the PR, as
+// they are on the base branch now, and LGTMs only from reviewers or approvers.
+// Approvals from users that have since been removed from OWNERS don't count.
+// A time-boxed approval like "/approve for:72h" is dropped once it expired.
+// Since no event fires on expiry, that takes effect when the PR is next
+// processed. An approval tied to a commit like "/approve sha:abc1234" doesn't
+// count unless headSHA, the current head of the PR, starts with that SHA.
+// Like a cancel, an expired or stale approval also drops the earlier approvals
+// of its author, which it replaced.
+// A conditional approval like "/approve after:#123" doesn't count while #123 is
+// in the UnmergedDependencies of approversHandler. A scoped approval like
+// "/approve upto:abc1234" doesn't approve the files changed after abc1234, as
+// given by the FilesChangedAfter of approversHandler. An approval given as an
+// alias like "/approve as:security-reviewers" is attributed to the alias if the
+// approver is one of its AliasMembers, and counts as a plain approval otherwise.
+// Only approvals giving a reason like `/approve reason:"..."` approve the
+// SensitiveFiles of approversHandler.
+func addApprovers(approversHandler *approvers.Approvers, approveComments []*comment, author, headSHA string, owners approvers.Owners, opts *plugins.Approve) {
+	reviewActsAsApprove := opts.ConsiderReviewState()
+	approverFiles := owners.GetReverseMap(owners.GetApprovers())
+	reviewerFiles := owners.GetReverseMap(owners.GetReviewers())
+	canApprove := func(login string) bool {
+		_, ok := approverFiles[strings.ToLower(login)]
+		return ok
+	}
+	canLGTM := func(login string) bool {
+		_, ok := reviewerFiles[strings.ToLower(login)]
+		return ok || canApprove(login)
+	}
+	isMaintainer := func(login string) bool {
+		_, ok := reviewerFiles[strings.ToLower(login)]
+		return ok && canApprove(login)
+	}
 	for _, c := range approveComments {
 		if c.Author == "" {
 			continue
 		}
 
-		if reviewActsAsApprove && c.ReviewState == github.ReviewStateApproved {
+		if reviewActsAsApprove && c.ReviewState == github.ReviewStateApproved && canApprove(c.Author) {
 			approversHandler.AddApprover(
 				c.Author,
 				c.HTMLURL,
 				false,
 			)
+			approversHandler.SetApprovalTime(c.Author, c.HTMLURL, c.CreatedAt)
 		}
-		if reviewActsAsApprove && c.ReviewState == github.ReviewStateChangesRequested {
+		if reviewActsAsApprove && (c.ReviewState == github.ReviewStateChangesRequested || (cancelsOnDismissal(opts) && isDismissedReview(c))) {
 			approversHandler.RemoveApprover(c.Author)
 		}
 
-		for _, match := range commandRegex.FindAllStringSubmatch(c.Body, -1) {
-			name := strings.ToUpper(match[1])
-			if name == removeApproveCommand {
-				approversHandler.RemoveApprover(c.Author)
+		for _, command := range approvalCommands(c.Body, opts.CancelArgument()) {
+			// With UnifyLgtmApprove alone, only the "/lgtm" of maintainers
+			// counts as approval.
+			if command.name == lgtmCommand && !opts.LgtmActsAsApprove && opts.UnifyLgtmApprove && !isMaintainer(c.Author) {
+				continue
+			}
+			// Forced approvals are handled separately by findForcedApproval.
+			if command.other || command.forced {
 				continue
 			}
-			if name != approveCommand && name != lgtmCommand {
+			if command.cancel {
+				if len(command.targets) == 0 {
+					approversHandler.RemoveApprover(c.Author)
+				} else if isAdminApprover(opts, c.Author) {
+					for _, target := range command.targets {
+						approversHandler.RemoveApprover(target)
+					}
+				}
 				continue
 			}
-			args := strings.ToLower(strings.TrimSpace(match[2]))
-			if strings.Contains(args, cancelArgument) {
+			if command.lapse(*approversHandler, headSHA, c, opts.AnonymizeApprovers) != "" {
 				approversHandler.RemoveApprover(c.Author)
 				continue
 			}
+			if (command.name == approveCommand && !canApprove(c.Author)) || (command.name == lgtmCommand && !canLGTM(c.Author)) {
+				continue
+			}
 
 			if c.Author == author {
 				approversHandler.AddAuthorSelfApprover(
 					c.Author,
 					c.HTMLURL,
-					args == noIssueArgument,
+					command.noIssue,
 				)
 			}
 
-			if name == approveCommand {
+			if command.name == approveCommand {
 				approversHandler.AddApprover(
 					c.Author,
 					c.HTMLURL,
-					args == noIssueArgument,
+					command.noIssue,
 				)
 			} else {
 				approversHandler.AddLGTMer(
 					c.Author,
 					c.HTMLURL,
-					args == noIssueArgument,
+					command.noIssue,
 				)
 			}
-
+			var expiry time.Time
+			if command.duration > 0 {
+				expiry = c.CreatedAt.Add(command.duration)
+			}
+			approversHandler.SetApprovalTime(c.Author, c.HTMLURL, c.CreatedAt)
+			approversHandler.SetApprovalExpiry(c.Author, c.HTMLURL, expiry)
+			if command.deletionsOnly {
+				approversHandler.SetApprovalDeletionsOnly(c.Author, c.HTMLURL)
+			}
+			if command.upto != "" {
+				approversHandler.SetApprovalUnreviewedFiles(c.Author, c.HTMLURL, approversHandler.FilesChangedAfter[command.upto])
+			}
+			if command.alias != "" && approversHandler.AliasMembers[command.alias].Has(strings.ToLower(c.Author)) {
+				approversHandler.SetApprovalAlias(c.Author, c.HTMLURL, command.alias)
+			}
+			if command.reason != "" {
+				approversHandler.SetApprovalReason(c.Author, c.HTMLURL, command.reason)
+			}
 		}
 	}
 }
@@ -674,9 +3471,14 @@ func commentFromIssueComment(ic *github.IssueComment) *comment {
 	}
 }
 
+// commentsFromIssueComments converts ics, leaving out those without author,
+// such as the comments of deleted accounts.
 func commentsFromIssueComments(ics []github.IssueComment) []*comment {
 	comments := make([]*comment, 0, len(ics))
 	for i := range ics {
+		if ics[i].User.Login == "" {
+			continue
+		}
 		comments = append(comments, commentFromIssueComment(&ics[i]))
 	}
 	return comments
@@ -695,36 +3497,67 @@ func commentFromReviewComment(rc *github.ReviewComment) *comment {
 	}
 }
 
-func commentsFromReviewComments(rcs []github.ReviewComment) []*comment {
-	comments := make([]*comment, 0, len(rcs))
-	for i := range rcs {
-		comments = append(comments, commentFromReviewComment(&rcs[i]))
-	}
-	return comments
-}
-
 func commentFromReview(review *github.Review) *comment {
 	if review == nil {
 		return nil
 	}
 	return &comment{
-		Body:        review.Body,
-		Author:      review.User.Login,
-		CreatedAt:   review.SubmittedAt,
-		HTMLURL:     review.HTMLURL,
-		ID:          review.ID,
-		ReviewState: review.State,
+		Body:      review.Body,
+		Author:    review.User.Login,
+		CreatedAt: review.SubmittedAt,
+		HTMLURL:   review.HTMLURL,
+		ID:        review.ID,
+		// The review webhook returns state as lowercase, while the review API
+		// returns state as uppercase.
+		ReviewState: github.ReviewState(strings.ToUpper(string(review.State))),
 	}
 }
 
+// commentsFromReviews converts reviews, leaving out those without author, such
+// as the reviews of deleted accounts.
 func commentsFromReviews(reviews []github.Review) []*comment {
 	comments := make([]*comment, 0, len(reviews))
 	for i := range reviews {
+		if reviews[i].User.Login == "" {
+			continue
+		}
 		comments = append(comments, commentFromReview(&reviews[i]))
 	}
 	return comments
 }
 
+// commentsFromReviewsAndReviewComments folds the inline comments of each review
+// into the comment of the review, so that commands given both in the inline
+// comments and the body of the same review count once. The body of the review
+// is submitted last and thus takes precedence. Inline comments that don't
+// belong to any of reviews are kept as separate comments.
+func commentsFromReviewsAndReviewComments(reviews []github.Review, rcs []github.ReviewComment) []*comment {
+	byReview := map[int]*comment{}
+	comments := commentsFromReviews(reviews)
+	for _, c := range comments {
+		byReview[c.ID] = c
+	}
+	sorted := append([]github.ReviewComment(nil), rcs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+	inline := map[int][]string{}
+	for i := range sorted {
+		if _, ok := byReview[sorted[i].ReviewID]; ok && sorted[i].ReviewID != 0 {
+			inline[sorted[i].ReviewID] = append(inline[sorted[i].ReviewID], sorted[i].Body)
+			continue
+		}
+		if sorted[i].User.Login == "" {
+			continue
+		}
+		comments = append(comments, commentFromReviewComment(&sorted[i]))
+	}
+	for id, bodies := range inline {
+		byReview[id].Body = strings.Join(append(bodies, byReview[id].Body), "\n")
+	}
+	return comments
+}
+
 func filterComments(comments []*comment, filter func(*comment) bool) []*comment {
 	filtered := make([]*comment, 0, len(comments))
 	for _, c := range comments {
@@ -17,19 +17,26 @@ limitations under the License.
 package approve
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/yaml"
 
@@ -89,6 +96,15 @@ func newTestCommentTime(t time.Time, user, body string) github.IssueComment {
 	return c
 }
 
+func newTestRename(t time.Time, user, from, to string) github.ListedIssueEvent {
+	return github.ListedIssueEvent{
+		Event:     github.IssueActionRenamed,
+		Actor:     github.User{Login: user},
+		CreatedAt: t,
+		Rename:    &github.IssueRename{From: from, To: to},
+	}
+}
+
 func newTestReview(user, body string, state github.ReviewState) github.Review {
 	return github.Review{User: github.User{Login: user}, Body: body, State: state}
 }
@@ -120,6 +136,7 @@ func newFakeGitHubClient(hasLabel, humanApproved bool, files []string, comments
 	for _, file := range files {
 		changes = append(changes, github.PullRequestChange{Filename: file})
 	}
+	botUserCheckers.reset()
 	fgc := fakegithub.NewFakeClient()
 	fgc.IssueLabelsAdded = labels
 	fgc.PullRequestChanges = map[int][]github.PullRequestChange{prNumber: changes}
@@ -129,15 +146,71 @@ func newFakeGitHubClient(hasLabel, humanApproved bool, files []string, comments
 	return fgc
 }
 
+// testGitHubConfig is the GitHub configuration of the tests, linking to
+// github.com.
+var testGitHubConfig = config.GitHubOptions{LinkURL: &url.URL{Scheme: "https", Host: "github.com"}}
+
+// approvedLabel is the approved label of the PR of the tests, as recorded by
+// the fake GitHub client.
+var approvedLabel = fmt.Sprintf("org/repo#%v:approved", prNumber)
+
+// newTestRepo returns a fakeRepo with an OWNERS file in each of the directories
+// of approvers, listing the given approvers. Each directory contains a file
+// named after it, such as "a/a.go".
+func newTestRepo(approvers map[string][]string) fakeRepo {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{},
+		leafApprovers:  map[string]sets.String{},
+		approverOwners: map[string]string{},
+	}
+	for dir, logins := range approvers {
+		fr.approvers[dir] = layeredsets.NewString(logins...)
+		fr.leafApprovers[dir] = sets.NewString(logins...)
+		fr.approverOwners[dir+"/"+dir+".go"] = dir
+	}
+	return fr
+}
+
+// newTestState returns the state of the PR of the tests, opened by cjwagner
+// against the master branch.
+func newTestState() *state {
+	return &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner"}
+}
+
+// runHandle handles pr with the testGitHubConfig, failing the test if that
+// fails.
+func runHandle(t *testing.T, ghc GitHubClient, repo approvers.Repo, opts *plugins.Approve, pr *state) {
+	t.Helper()
+	if err := handle(logrus.WithField("plugin", "approve"), ghc, repo, testGitHubConfig, opts, pr); err != nil {
+		t.Fatalf("Unexpected error handling event: %v.", err)
+	}
+}
+
 type fakeRepo struct {
 	approvers map[string]layeredsets.String
 	// directory -> approver
 	leafApprovers map[string]sets.String
+	// directory -> reviewers
+	reviewers map[string]layeredsets.String
 	// toApprove -> directoryWithOwnersFile
 	approverOwners map[string]string
 	// dir -> allowed
 	autoApproveUnownedSubfolders map[string]bool
 	dirDenylist                  []*regexp.Regexp
+	// directory -> required reviewers
+	requiredReviewers map[string]sets.String
+	// alias -> members
+	aliases map[string]sets.String
+	// aliasCycle is the cycle among the aliases, if any
+	aliasCycle []string
+}
+
+func (fr fakeRepo) ExpandAlias(alias string) sets.String {
+	return fr.aliases[alias]
+}
+
+func (fr fakeRepo) FindAliasCycle() []string {
+	return fr.aliasCycle
 }
 
 func (fr fakeRepo) Filenames() ownersconfig.Filenames {
@@ -150,6 +223,9 @@ func (fr fakeRepo) Approvers(path string) layeredsets.String {
 func (fr fakeRepo) LeafApprovers(path string) sets.String {
 	return fr.leafApprovers[path]
 }
+func (fr fakeRepo) Reviewers(path string) layeredsets.String {
+	return fr.reviewers[path]
+}
 func (fr fakeRepo) FindApproverOwnersForFile(path string) string {
 	return fr.approverOwners[path]
 }
@@ -159,6 +235,9 @@ func (fr fakeRepo) IsNoParentOwners(path string) bool {
 func (fr fakeRepo) IsAutoApproveUnownedSubfolders(ownerFilePath string) bool {
 	return fr.autoApproveUnownedSubfolders[ownerFilePath]
 }
+func (fr fakeRepo) RequiredReviewers(path string) sets.String {
+	return fr.requiredReviewers[path]
+}
 func (fr fakeRepo) TopLevelApprovers() sets.String {
 	return nil
 }
@@ -209,6 +288,7 @@ func TestHandle(t *testing.T) {
 		hasLabel      bool
 		humanApproved bool
 		files         []string
+		changes       []github.PullRequestChange
 		comments      []github.IssueComment
 		reviews       []github.Review
 
@@ -217,6 +297,9 @@ func TestHandle(t *testing.T) {
 		lgtmActsAsApprove   bool
 		reviewActsAsApprove bool
 		githubLinkURL       *url.URL
+		titleApprovalPhrase string
+		issueEvents         []github.ListedIssueEvent
+		excludedApprovers   []string
 
 		expectDelete    bool
 		expectComment   bool
@@ -243,7 +326,8 @@ func TestHandle(t *testing.T) {
 			expectComment: true,
 			expectedComment: `[APPROVALNOTIFIER] This PR is **APPROVED**
 
-This pull-request has been approved by: *<a href="#" title="Author self-approved">cjwagner</a>*
+This pull-request has been approved by:
+Self-approved (implicit) by the author: *<a href="#" title="Author self-approved">cjwagner</a>*
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -257,7 +341,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["cjwagner"],"hash":"caad8084bd2f69eb"} -->`,
 		},
 		{
 			name:                "initial notification (unapproved)",
@@ -277,7 +362,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by:
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** after the PR has been reviewed.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -289,7 +376,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["cjwagner"]} -->`,
+<!-- META={"approvers":["cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["c"],"approvers":[],"hash":"bd5c820153c44e37"} -->`,
 		},
 		{
 			name:                "no-issue comment",
@@ -324,7 +412,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["Alice"],"hash":"032ff8a326a924b3"} -->`,
 		},
 		{
 			name:                "issue provided in PR body",
@@ -360,7 +449,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["Alice"],"associated_issue":42,"hash":"96318e04606023d6"} -->`,
 		},
 		{
 			name:     "non-implicit self approve no-issue",
@@ -390,7 +480,8 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 				newTestComment("ALIcE", "stuff\n/approve"),
 				newTestCommentTime(time.Now(), "k8s-ci-robot", `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
-This pull-request has been approved by: *<a href="" title="Approved">ALIcE</a>*, *<a href="#" title="Author self-approved">cjwagner</a>*
+This pull-request has been approved by: *<a href="" title="Approved">ALIcE</a>*
+Self-approved (implicit) by the author: *<a href="#" title="Author self-approved">cjwagner</a>*
 
 *No associated issue*. Update pull-request body to add a reference to an issue, or get approval with `+"`/approve no-issue`"+`
 
@@ -407,7 +498,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing `+"`/approve`"+` in a comment
 Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment
 </details>
-<!-- META={"approvers":[]} -->`),
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":[],"approvers":["ALIcE","cjwagner"],"hash":"fd2e8756d44c7b54"} -->`),
 			},
 			reviews:             []github.Review{},
 			selfApprove:         true,
@@ -441,7 +533,8 @@ Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment
 			expectComment: true,
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
-This pull-request has been approved by: *<a href="#" title="Author self-approved">cjwagner</a>*
+This pull-request has been approved by:
+Self-approved (implicit) by the author: *<a href="#" title="Author self-approved">cjwagner</a>*
 To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **alice** after the PR has been reviewed.
 You can assign the PR to them by writing ` + "`/assign @alice`" + ` in a comment when ready.
 
@@ -457,7 +550,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["alice"]} -->`,
+<!-- META={"approvers":["alice"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a"],"approvers":["cjwagner"],"hash":"0398d843bd7311a0"} -->`,
 		},
 		{
 			name:     "remove approval with remove-approve",
@@ -480,7 +574,8 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectComment: true,
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
-This pull-request has been approved by: *<a href="#" title="Author self-approved">cjwagner</a>*
+This pull-request has been approved by:
+Self-approved (implicit) by the author: *<a href="#" title="Author self-approved">cjwagner</a>*
 To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **alice** after the PR has been reviewed.
 You can assign the PR to them by writing ` + "`/assign @alice`" + ` in a comment when ready.
 
@@ -496,7 +591,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["alice"]} -->`,
+<!-- META={"approvers":["alice"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a"],"approvers":["cjwagner"],"hash":"0398d843bd7311a0"} -->`,
 		},
 		{
 			name:     "remove approval after sync",
@@ -603,7 +699,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing `+"`/approve`"+` in a comment
 Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment
 </details>
-<!-- META={"approvers":[]} -->`),
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["alice"],"associated_issue":1,"hash":"b8efcf01ac968cf1"} -->`),
 			},
 			reviews:             []github.Review{},
 			selfApprove:         false,
@@ -672,7 +769,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["alice"]} -->`,
+<!-- META={"approvers":["alice"]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":["a"],"approvers":[],"hash":"f6461df13fa1ba64"} -->`,
 		},
 		{
 			name:     "lgtm means approve",
@@ -716,7 +814,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing `+"`/approve`"+` in a comment
 Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment
 </details>
-<!-- META={"approvers":["alice"]} -->`),
+<!-- META={"approvers":["alice"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a"],"approvers":[],"hash":"d5d2ec93bf536b14"} -->`),
 				newTestCommentTime(time.Now(), "alice", "stuff\n/lgtm\nblah"),
 			},
 			reviews:             []github.Review{},
@@ -761,7 +860,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["Alice"],"hash":"4da60b250f459224"} -->`,
 		},
 		{
 			name:                "approved review but reviewActsAsApprove disabled",
@@ -781,7 +881,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by:
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** after the PR has been reviewed.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -793,7 +895,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["cjwagner"]} -->`,
+<!-- META={"approvers":["cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["c"],"approvers":[],"hash":"bd5c820153c44e37"} -->`,
 		},
 		{
 			name:                "approved review with reviewActsAsApprove enabled",
@@ -826,7 +929,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["Alice"],"hash":"4da60b250f459224"} -->`,
 		},
 		{
 			name:     "reviews in non-approving state (should not approve)",
@@ -850,7 +954,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by:
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** after the PR has been reviewed.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -862,7 +968,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["cjwagner"]} -->`,
+<!-- META={"approvers":["cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["c"],"approvers":[],"hash":"bd5c820153c44e37"} -->`,
 		},
 		{
 			name:     "review in request changes state means cancel",
@@ -887,7 +994,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by:
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** after the PR has been reviewed.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -899,7 +1008,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["cjwagner"]} -->`,
+<!-- META={"approvers":["cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["c"],"approvers":[],"hash":"bd5c820153c44e37"} -->`,
 		},
 		{
 			name:     "dismissed review doesn't cancel prior approval",
@@ -937,7 +1047,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["Alice"],"hash":"4da60b250f459224"} -->`,
 		},
 		{
 			name:     "approve cancel command supersedes earlier approved review",
@@ -962,7 +1073,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by:
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** after the PR has been reviewed.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -974,7 +1087,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["cjwagner"]} -->`,
+<!-- META={"approvers":["cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["c"],"approvers":[],"hash":"bd5c820153c44e37"} -->`,
 		},
 		{
 			name:     "remove-approve command supersedes earlier approved review",
@@ -999,7 +1113,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by:
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** after the PR has been reviewed.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -1011,7 +1127,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["cjwagner"]} -->`,
+<!-- META={"approvers":["cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["c"],"approvers":[],"hash":"bd5c820153c44e37"} -->`,
 		},
 		{
 			name:     "approve cancel command supersedes simultaneous approved review",
@@ -1033,8 +1150,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by:
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** and additionally assign **alice** after the PR has been reviewed.
-You can assign the PR to them by writing ` + "`/assign @alice`" + ` in a comment when ready.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **alice**, **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @alice @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -1047,7 +1165,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["alice","cjwagner"]} -->`,
+<!-- META={"approvers":["alice","cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a","c"],"approvers":[],"hash":"3e00e523a820387b"} -->`,
 		},
 		{
 			name:     "remove-approve command supersedes simultaneous approved review",
@@ -1069,8 +1188,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by:
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** and additionally assign **alice** after the PR has been reviewed.
-You can assign the PR to them by writing ` + "`/assign @alice`" + ` in a comment when ready.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **alice**, **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @alice @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -1083,7 +1203,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["alice","cjwagner"]} -->`,
+<!-- META={"approvers":["alice","cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a","c"],"approvers":[],"hash":"3e00e523a820387b"} -->`,
 		},
 		{
 			name:                "approve command supersedes simultaneous changes requested review",
@@ -1116,7 +1237,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["Alice"],"hash":"4da60b250f459224"} -->`,
 		},
 		{
 			name:                "different branch, initial notification (approved)",
@@ -1136,7 +1258,8 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectComment: true,
 			expectedComment: `[APPROVALNOTIFIER] This PR is **APPROVED**
 
-This pull-request has been approved by: *<a href="#" title="Author self-approved">cjwagner</a>*
+This pull-request has been approved by:
+Self-approved (implicit) by the author: *<a href="#" title="Author self-approved">cjwagner</a>*
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -1150,7 +1273,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["cjwagner"],"hash":"e086820734815665"} -->`,
 		},
 		{
 			name:                "different GitHub link URL",
@@ -1170,7 +1294,8 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectComment: true,
 			expectedComment: `[APPROVALNOTIFIER] This PR is **APPROVED**
 
-This pull-request has been approved by: *<a href="#" title="Author self-approved">cjwagner</a>*
+This pull-request has been approved by:
+Self-approved (implicit) by the author: *<a href="#" title="Author self-approved">cjwagner</a>*
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -1184,7 +1309,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["cjwagner"],"hash":"f1656aba0d6cbeff"} -->`,
 		},
 		{
 			name:                "Approved because of AutoApproveUnownedSubfolders:",
@@ -1215,7 +1341,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`,
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":[],"hash":"2254ff5fe0b3fd98"} -->`,
 		},
 		{
 			name:                "don't suggest to /assign already assigned cjwagner",
@@ -1235,7 +1362,9 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 			expectedComment: `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
 
 This pull-request has been approved by: *<a href="" title="Approved">Alice</a>*
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please ask for approval from **cjwagner** after the PR has been reviewed.
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **cblecker** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @cblecker`" + ` in a comment when ready.
+As an approver, the PR author **cjwagner** can approve their own changes by writing ` + "`/approve`" + ` in a comment.
 
 The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
 
@@ -1248,7 +1377,135 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["cjwagner"]} -->`,
+<!-- META={"approvers":["cblecker"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["c"],"approvers":["Alice"],"hash":"88006190525c5a04"} -->`,
+		},
+		{
+			name:                "title approval phrase from an approver",
+			hasLabel:            false,
+			files:               []string{"a/a.go"},
+			comments:            []github.IssueComment{},
+			reviews:             []github.Review{},
+			selfApprove:         false,
+			needsIssue:          false,
+			lgtmActsAsApprove:   false,
+			reviewActsAsApprove: false,
+			githubLinkURL:       &url.URL{Scheme: "https", Host: "github.com"},
+			titleApprovalPhrase: "[APPROVED-BY-LEAD]",
+			issueEvents:         []github.ListedIssueEvent{newTestRename(time.Time{}, "Alice", "Fix everything", "[APPROVED-BY-LEAD] Fix everything")},
+
+			expectDelete:  false,
+			expectToggle:  true,
+			expectComment: true,
+		},
+		{
+			name:                "title approval phrase cancelled by a later comment",
+			hasLabel:            false,
+			files:               []string{"a/a.go"},
+			comments:            []github.IssueComment{newTestCommentTime(time.Now(), "Alice", "/approve cancel")},
+			reviews:             []github.Review{},
+			selfApprove:         false,
+			needsIssue:          false,
+			lgtmActsAsApprove:   false,
+			reviewActsAsApprove: false,
+			githubLinkURL:       &url.URL{Scheme: "https", Host: "github.com"},
+			titleApprovalPhrase: "[APPROVED-BY-LEAD]",
+			issueEvents:         []github.ListedIssueEvent{newTestRename(time.Now().Add(-time.Hour), "Alice", "Fix everything", "[APPROVED-BY-LEAD] Fix everything")},
+
+			expectDelete:  false,
+			expectToggle:  false,
+			expectComment: true,
+		},
+		{
+			name:                "title approval phrase from an excluded approver",
+			hasLabel:            false,
+			files:               []string{"a/a.go"},
+			comments:            []github.IssueComment{},
+			reviews:             []github.Review{},
+			selfApprove:         false,
+			needsIssue:          false,
+			lgtmActsAsApprove:   false,
+			reviewActsAsApprove: false,
+			githubLinkURL:       &url.URL{Scheme: "https", Host: "github.com"},
+			titleApprovalPhrase: "[APPROVED-BY-LEAD]",
+			issueEvents:         []github.ListedIssueEvent{newTestRename(time.Time{}, "Alice", "Fix everything", "[APPROVED-BY-LEAD] Fix everything")},
+			excludedApprovers:   []string{"alice"},
+
+			expectDelete:  false,
+			expectToggle:  false,
+			expectComment: true,
+		},
+		{
+			name:                "title approval phrase from a non-approver",
+			hasLabel:            false,
+			files:               []string{"a/a.go"},
+			comments:            []github.IssueComment{},
+			reviews:             []github.Review{},
+			selfApprove:         false,
+			needsIssue:          false,
+			lgtmActsAsApprove:   false,
+			reviewActsAsApprove: false,
+			githubLinkURL:       &url.URL{Scheme: "https", Host: "github.com"},
+			titleApprovalPhrase: "[APPROVED-BY-LEAD]",
+			issueEvents:         []github.ListedIssueEvent{newTestRename(time.Time{}, "bob", "Fix everything", "[APPROVED-BY-LEAD] Fix everything")},
+
+			expectDelete:  false,
+			expectToggle:  false,
+			expectComment: true,
+		},
+		{
+			name:                "title approval phrase kept by an approver's edit",
+			hasLabel:            false,
+			files:               []string{"a/a.go"},
+			comments:            []github.IssueComment{},
+			reviews:             []github.Review{},
+			selfApprove:         false,
+			needsIssue:          false,
+			lgtmActsAsApprove:   false,
+			reviewActsAsApprove: false,
+			githubLinkURL:       &url.URL{Scheme: "https", Host: "github.com"},
+			titleApprovalPhrase: "[APPROVED-BY-LEAD]",
+			issueEvents:         []github.ListedIssueEvent{newTestRename(time.Time{}, "Alice", "[APPROVED-BY-LEAD] WIP", "[APPROVED-BY-LEAD] Fix everything")},
+
+			expectDelete:  false,
+			expectToggle:  false,
+			expectComment: true,
+		},
+		{
+			name:     "file renamed across OWNERS boundaries approved by new owner only",
+			hasLabel: false,
+			changes: []github.PullRequestChange{
+				{Filename: "c/c.go", PreviousFilename: "a/aa.go", Status: github.PullRequestFileRenamed},
+			},
+			comments:            []github.IssueComment{newTestComment("cblecker", "/approve")},
+			reviews:             []github.Review{},
+			selfApprove:         false,
+			needsIssue:          false,
+			lgtmActsAsApprove:   false,
+			reviewActsAsApprove: false,
+			githubLinkURL:       &url.URL{Scheme: "https", Host: "github.com"},
+
+			expectDelete:  false,
+			expectToggle:  false,
+			expectComment: true,
+		},
+		{
+			name:     "file renamed across OWNERS boundaries approved by old and new owners",
+			hasLabel: false,
+			changes: []github.PullRequestChange{
+				{Filename: "c/c.go", PreviousFilename: "a/aa.go", Status: github.PullRequestFileRenamed},
+			},
+			comments:            []github.IssueComment{newTestComment("cblecker", "/approve"), newTestComment("Alice", "/approve")},
+			reviews:             []github.Review{},
+			selfApprove:         false,
+			needsIssue:          false,
+			lgtmActsAsApprove:   false,
+			reviewActsAsApprove: false,
+			githubLinkURL:       &url.URL{Scheme: "https", Host: "github.com"},
+
+			expectDelete:  false,
+			expectToggle:  true,
+			expectComment: true,
 		},
 	}
 
@@ -1278,6 +1535,12 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			fghc := newFakeGitHubClient(test.hasLabel, test.humanApproved, test.files, test.comments, test.reviews)
+			if test.changes != nil {
+				fghc.PullRequestChanges[prNumber] = test.changes
+			}
+			if test.issueEvents != nil {
+				fghc.IssueEvents[prNumber] = test.issueEvents
+			}
 			branch := "master"
 			if test.branch != "" {
 				branch = test.branch
@@ -1300,6 +1563,8 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 					IgnoreReviewState:   &irs,
 					CommandHelpLink:     "https://go.k8s.io/bot-commands",
 					PrProcessLink:       "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process",
+					TitleApprovalPhrase: test.titleApprovalPhrase,
+					ExcludedApprovers:   test.excludedApprovers,
 				},
 				&state{
 					org:       "org",
@@ -1384,107 +1649,4186 @@ Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a commen
 	}
 }
 
-// TODO: cache approvers 'GetFilesApprovers' and 'GetCCs' since these are called repeatedly and are
-// expensive.
+func TestForceApprove(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{
+		Repos:               []string{"org/repo"},
+		RequireSelfApproval: &rsa,
+		IssueRequired:       true,
+		AdminApprovers:      []string{"Admin"},
+	}
+	pr := newTestState()
 
-type fakeOwnersClient struct{}
+	tests := []struct {
+		name         string
+		comment      github.IssueComment
+		expectForced bool
+	}{
+		{
+			name:         "force by an admin",
+			comment:      newTestComment("admin", "/approve force fixing the outage"),
+			expectForced: true,
+		},
+		{
+			name:    "force by a non-admin",
+			comment: newTestComment("bob", "/approve force fixing the outage"),
+		},
+		{
+			name:    "force by an admin without a reason",
+			comment: newTestComment("admin", "/approve force"),
+		},
+	}
 
-func (foc fakeOwnersClient) LoadRepoOwners(org, repo, base string) (repoowners.RepoOwner, error) {
-	return fakeRepoOwners{}, nil
-}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.comment.ID = 42
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{test.comment}, nil)
+			fghc.IssueCommentID = 100
 
-type fakeRepoOwners struct {
-	fakeRepo
-}
+			// Handle twice: the second pass simulates a later, unrelated event.
+			for i := 0; i < 2; i++ {
+				runHandle(t, fghc, fr, opts, pr)
+			}
 
-func (fro fakeRepoOwners) FindLabelsForFile(path string) sets.String {
-	return sets.NewString()
+			labelAdded := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel)
+			if labelAdded != test.expectForced {
+				t.Errorf("Expected approved label added: %t, but got %t.", test.expectForced, labelAdded)
+			}
+			if len(fghc.IssueLabelsRemoved) != 0 {
+				t.Errorf("Expected no labels to be removed, but got %v.", fghc.IssueLabelsRemoved)
+			}
+			var audits int
+			for _, c := range fghc.IssueCommentsAdded {
+				if strings.Contains(c, "**Approval forced** by @admin: fixing the outage") {
+					audits++
+				}
+			}
+			if test.expectForced && audits != 1 {
+				t.Errorf("Expected exactly one audit comment, but got %d.", audits)
+			}
+			if !test.expectForced && audits != 0 {
+				t.Errorf("Expected no audit comment, but got %d.", audits)
+			}
+		})
+	}
 }
 
-func (fro fakeRepoOwners) FindReviewersOwnersForFile(path string) string {
-	return ""
-}
+func TestIgnoredAuthors(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
 
-func (fro fakeRepoOwners) LeafReviewers(path string) sets.String {
-	return sets.NewString()
-}
+	tests := []struct {
+		name          string
+		author        string
+		ignoreAuthors []string
+		ignoreBots    bool
+		expectSkip    bool
+	}{
+		{
+			name:          "listed author is ignored",
+			author:        "Dependabot[bot]",
+			ignoreAuthors: []string{"dependabot[bot]"},
+			expectSkip:    true,
+		},
+		{
+			name:       "bot author is ignored with ignore_bots",
+			author:     "renovate[bot]",
+			ignoreBots: true,
+			expectSkip: true,
+		},
+		{
+			name:          "bot author is processed without ignore_bots",
+			author:        "renovate[bot]",
+			ignoreAuthors: []string{"dependabot[bot]"},
+		},
+		{
+			name:          "human author is processed",
+			author:        "alice",
+			ignoreAuthors: []string{"dependabot[bot]"},
+			ignoreBots:    true,
+		},
+	}
 
-func (fro fakeRepoOwners) Reviewers(path string) layeredsets.String {
-	return layeredsets.NewString()
-}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(true, false, []string{"a/a.go"}, nil, nil)
+			opts := &plugins.Approve{
+				Repos:         []string{"org/repo"},
+				IgnoreAuthors: test.ignoreAuthors,
+				IgnoreBots:    test.ignoreBots,
+			}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: test.author}
+			runHandle(t, fghc, fr, opts, pr)
 
-func (fro fakeRepoOwners) RequiredReviewers(path string) sets.String {
-	return sets.NewString()
+			touched := len(fghc.IssueCommentsAdded) != 0 || len(fghc.IssueLabelsRemoved) != 0
+			if touched == test.expectSkip {
+				t.Errorf("Expected skip: %t, but got comments %v and removed labels %v.", test.expectSkip, fghc.IssueCommentsAdded, fghc.IssueLabelsRemoved)
+			}
+		})
+	}
 }
 
-func TestHandleGenericComment(t *testing.T) {
+func TestSkipDrafts(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	comments := []github.IssueComment{newTestComment("alice", "/approve")}
+
 	tests := []struct {
-		name              string
-		commentEvent      github.GenericCommentEvent
-		lgtmActsAsApprove bool
-		expectHandle      bool
-		expectState       *state
+		name          string
+		hasLabel      bool
+		draft         bool
+		skipDrafts    bool
+		expectAdded   bool
+		expectRemoved bool
+		expectNote    bool
 	}{
 		{
-			name: "valid approve command",
-			commentEvent: github.GenericCommentEvent{
-				Action: github.GenericCommentActionCreated,
-				IsPR:   true,
-				Body:   "/approve",
-				Number: 1,
-				User: github.User{
-					Login: "author",
-				},
-				IssueBody: "Fix everything",
-				IssueAuthor: github.User{
-					Login: "P.R. Author",
-				},
-			},
-			expectHandle: true,
-			expectState: &state{
-				org:       "org",
-				repo:      "repo",
-				branch:    "branch",
-				number:    1,
-				body:      "Fix everything",
-				author:    "P.R. Author",
-				assignees: nil,
-				htmlURL:   "",
-			},
+			name:          "converted to draft removes the label",
+			hasLabel:      true,
+			draft:         true,
+			skipDrafts:    true,
+			expectRemoved: true,
+			expectNote:    true,
 		},
 		{
-			name: "not comment created",
-			commentEvent: github.GenericCommentEvent{
-				Action: github.GenericCommentActionEdited,
-				IsPR:   true,
-				Body:   "/approve",
-				Number: 1,
-				User: github.User{
-					Login: "author",
-				},
-			},
-			expectHandle: false,
+			name:        "ready for review restores the label",
+			skipDrafts:  true,
+			expectAdded: true,
 		},
 		{
-			name: "not PR",
-			commentEvent: github.GenericCommentEvent{
-				Action: github.GenericCommentActionEdited,
-				IsPR:   false,
-				Body:   "/approve",
-				Number: 1,
-				User: github.User{
-					Login: "author",
-				},
-			},
-			expectHandle: false,
+			name:     "draft keeps the label without skip_drafts",
+			hasLabel: true,
+			draft:    true,
 		},
-		{
-			name: "closed PR",
-			commentEvent: github.GenericCommentEvent{
-				Action: github.GenericCommentActionCreated,
-				IsPR:   true,
-				Body:   "/approve",
-				Number: 1,
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(test.hasLabel, false, []string{"a/a.go"}, comments, nil)
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, SkipDrafts: test.skipDrafts}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, draft: test.draft, author: "cjwagner"}
+			runHandle(t, fghc, fr, opts, pr)
+			if added := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) && !test.hasLabel; added != test.expectAdded {
+				t.Errorf("Expected approved label to be added: %t, but got labels %v.", test.expectAdded, fghc.IssueLabelsAdded)
+			}
+			if removed := sets.NewString(fghc.IssueLabelsRemoved...).Has(approvedLabel); removed != test.expectRemoved {
+				t.Errorf("Expected approved label to be removed: %t, but got removed labels %v.", test.expectRemoved, fghc.IssueLabelsRemoved)
+			}
+			if len(fghc.IssueCommentsAdded) != 1 {
+				t.Fatalf("Expected a single notification, but got %v.", fghc.IssueCommentsAdded)
+			}
+			if note := strings.Contains(fghc.IssueCommentsAdded[0], "This PR is a draft."); note != test.expectNote {
+				t.Errorf("Expected draft note in notification: %t, but got %q.", test.expectNote, fghc.IssueCommentsAdded[0])
+			}
+		})
+	}
+}
+
+func TestRequireResolvedThreads(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	comments := []github.IssueComment{newTestComment("alice", "/approve")}
+
+	tests := []struct {
+		name                   string
+		threads                []github.ReviewThread
+		requireResolvedThreads bool
+		expectLabel            bool
+		expectNote             string
+	}{
+		{
+			name:                   "all threads resolved",
+			threads:                []github.ReviewThread{{Path: "a/a.go", IsResolved: true}},
+			requireResolvedThreads: true,
+			expectLabel:            true,
+		},
+		{
+			name:                   "no threads",
+			requireResolvedThreads: true,
+			expectLabel:            true,
+		},
+		{
+			name:                   "an unresolved thread",
+			threads:                []github.ReviewThread{{Path: "a/a.go", IsResolved: true}, {Path: "a/a.go"}},
+			requireResolvedThreads: true,
+			expectNote:             "This PR has 1 unresolved review thread. Approval is withheld until it is resolved.",
+		},
+		{
+			name:                   "several unresolved threads",
+			threads:                []github.ReviewThread{{Path: "a/a.go"}, {Path: "a/a.go"}},
+			requireResolvedThreads: true,
+			expectNote:             "This PR has 2 unresolved review threads. Approval is withheld until they are resolved.",
+		},
+		{
+			name:        "unresolved threads without require_resolved_threads",
+			threads:     []github.ReviewThread{{Path: "a/a.go"}},
+			expectLabel: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, comments, nil)
+			fghc.ReviewThreads = map[int][]github.ReviewThread{prNumber: test.threads}
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireResolvedThreads: test.requireResolvedThreads}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if added := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); added != test.expectLabel {
+				t.Errorf("Expected approved label to be added: %t, but got labels %v.", test.expectLabel, fghc.IssueLabelsAdded)
+			}
+			if len(fghc.IssueCommentsAdded) != 1 {
+				t.Fatalf("Expected a single notification, but got %v.", fghc.IssueCommentsAdded)
+			}
+			notification := fghc.IssueCommentsAdded[0]
+			if test.expectNote != "" && !strings.Contains(notification, test.expectNote) {
+				t.Errorf("Expected %q in notification, but got %q.", test.expectNote, notification)
+			}
+			if test.expectNote == "" && strings.Contains(notification, "unresolved review thread") {
+				t.Errorf("Expected no unresolved threads note in notification, but got %q.", notification)
+			}
+		})
+	}
+}
+
+func TestFindAssociatedIssue(t *testing.T) {
+	tests := []struct {
+		name                  string
+		body                  string
+		baseURL               string
+		requireClosingKeyword bool
+		expected              int
+	}{
+		{
+			name:     "mention",
+			body:     "see #1",
+			expected: 1,
+		},
+		{
+			name:                  "mention without closing keyword",
+			body:                  "see #1",
+			requireClosingKeyword: true,
+		},
+		{
+			name:                  "closing keyword",
+			body:                  "Fixes #1",
+			requireClosingKeyword: true,
+			expected:              1,
+		},
+		{
+			name:                  "closing keyword after a mention",
+			body:                  "Follow-up to #2.\n\nresolved: #3",
+			requireClosingKeyword: true,
+			expected:              3,
+		},
+		{
+			name:                  "closing keyword with issue link",
+			body:                  "Closes https://github.com/org/other/issues/4",
+			requireClosingKeyword: true,
+			expected:              4,
+		},
+		{
+			name:                  "keyword as part of another word",
+			body:                  "Prefixes #5",
+			requireClosingKeyword: true,
+		},
+		{
+			name:     "GitHub Enterprise issue link",
+			body:     "see https://github.example.com/org/repo/issues/6",
+			baseURL:  "https://github.example.com",
+			expected: 6,
+		},
+		{
+			name:                  "closing keyword with GitHub Enterprise issue link",
+			body:                  "Fixes https://github.example.com/org/repo/issues/7",
+			baseURL:               "https://github.example.com/",
+			requireClosingKeyword: true,
+			expected:              7,
+		},
+		{
+			name:    "issue link on another host",
+			body:    "see https://github.com/org/repo/issues/8",
+			baseURL: "https://github.example.com",
+		},
+		{
+			name:     "shorthand with base URL",
+			body:     "see #9",
+			baseURL:  "https://github.example.com",
+			expected: 9,
+		},
+		{
+			name:    "link to another org",
+			body:    "see https://github.example.com/other/repo/issues/10",
+			baseURL: "https://github.example.com",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issue, err := findAssociatedIssue(test.body, "org", test.baseURL, test.requireClosingKeyword)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v.", err)
+			}
+			if issue != test.expected {
+				t.Errorf("Expected associated issue %d, but got %d.", test.expected, issue)
+			}
+		})
+	}
+}
+
+func TestFindAssociatedIssues(t *testing.T) {
+	tests := []struct {
+		name                  string
+		body                  string
+		requireClosingKeyword bool
+		expected              []int
+	}{
+		{
+			name:     "multiple issues",
+			body:     "Fixes #1, Fixes #2\n\nsee also #1",
+			expected: []int{1, 2},
+		},
+		{
+			name:                  "only issues with a closing keyword",
+			body:                  "Follow-up to #3, fixes #4 and resolves https://github.com/org/repo/issues/5",
+			requireClosingKeyword: true,
+			expected:              []int{4, 5},
+		},
+		{
+			name: "no issue",
+			body: "Some cleanup.",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issues, err := findAssociatedIssues(test.body, "org", "", test.requireClosingKeyword)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v.", err)
+			}
+			if !reflect.DeepEqual(issues, test.expected) {
+				t.Errorf("Expected issues %v, but got %v.", test.expected, issues)
+			}
+		})
+	}
+}
+
+func TestRequireOpenIssues(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+
+	tests := []struct {
+		name              string
+		body              string
+		requireOpenIssues bool
+		expectApproved    bool
+		expectedLine      string
+	}{
+		{
+			name:           "any associated issue satisfies the requirement",
+			body:           "Fixes #1, Fixes #2",
+			expectApproved: true,
+			expectedLine:   "Associated issues: *#1*, *#2*",
+		},
+		{
+			name:              "closed associated issue with require_open_issues",
+			body:              "Fixes #1, Fixes #2",
+			requireOpenIssues: true,
+			expectedLine:      "*Closed associated issues*: *#2*. All associated issues need to be open, or get approval with `/approve no-issue`",
+		},
+		{
+			name:              "open associated issue with require_open_issues",
+			body:              "Fixes #1",
+			requireOpenIssues: true,
+			expectApproved:    true,
+			expectedLine:      "Associated issue: *#1*",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, IssueRequired: true, RequireOpenIssues: test.requireOpenIssues}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil)
+			fghc.Issues = map[int]*github.Issue{
+				1: {Number: 1, State: "open"},
+				2: {Number: 2, State: "closed"},
+			}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner", body: test.body}
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+			if len(fghc.IssueComments[prNumber]) != 2 || !sets.NewString(strings.Split(fghc.IssueComments[prNumber][1].Body, "\n")...).Has(test.expectedLine) {
+				t.Errorf("Expected the notification to contain %q, but got comments %v.", test.expectedLine, fghc.IssueComments[prNumber])
+			}
+		})
+	}
+}
+
+func TestFindLinkedPullRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []int
+	}{
+		{
+			name: "no references",
+			body: "Fix everything",
+		},
+		{
+			name:     "short references in order",
+			body:     "Part of #12. Followed by #3 and #12 again.",
+			expected: []int{12, 3},
+		},
+		{
+			name:     "pull request links in the same repo",
+			body:     "Depends on https://github.com/org/repo/pull/7",
+			expected: []int{7},
+		},
+		{
+			name: "pull request links in other repos are ignored",
+			body: "Depends on https://github.com/org/other/pull/7",
+		},
+		{
+			name:     "own number is ignored",
+			body:     "This is #1, the next one is #2",
+			expected: []int{2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			linked, err := findLinkedPullRequests(test.body, "org", "repo", prNumber)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v.", err)
+			}
+			if diff := cmp.Diff(test.expected, linked); diff != "" {
+				t.Errorf("Unexpected linked pull requests (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStackApproval(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	body := "Stack: #2, #3 and https://github.com/org/repo/pull/4. Fixes #5."
+
+	tests := []struct {
+		name           string
+		stackApprovals bool
+		comment        github.IssueComment
+		expectCascade  []int
+	}{
+		{
+			name:           "approver cascades to open linked PRs",
+			stackApprovals: true,
+			comment:        newTestComment("alice", "/approve stack"),
+			expectCascade:  []int{2, 3},
+		},
+		{
+			name:           "non-approver does not cascade",
+			stackApprovals: true,
+			comment:        newTestComment("bob", "/approve stack"),
+		},
+		{
+			name:    "disabled by default",
+			comment: newTestComment("alice", "/approve stack"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{test.comment}, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{
+				2: {Number: 2, State: "open"},
+				3: {Number: 3, State: "open"},
+				4: {Number: 4, State: "closed"},
+			}
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, StackApprovals: test.stackApprovals}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, body: body, author: "cjwagner"}
+
+			// Handling the PR again must not cascade twice.
+			for i := 0; i < 2; i++ {
+				runHandle(t, fghc, fr, opts, pr)
+			}
+
+			var cascaded []int
+			for _, added := range fghc.IssueCommentsAdded {
+				var number int
+				if _, err := fmt.Sscanf(added, "org/repo#%d:", &number); err == nil && number != prNumber {
+					if !strings.Contains(added, "approved the stack containing this PR in #1") {
+						t.Errorf("Unexpected comment on #%d: %q.", number, added)
+					}
+					cascaded = append(cascaded, number)
+				}
+			}
+			if diff := cmp.Diff(test.expectCascade, cascaded); diff != "" {
+				t.Errorf("Unexpected cascaded pull requests (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDesignatedApprovers(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	rsa := true
+
+	tests := []struct {
+		name           string
+		comments       []github.IssueComment
+		expectAssigned []string
+		expectMessage  []string
+	}{
+		{
+			name:           "approver designates an owner of an unapproved file",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve assign @bob")},
+			expectAssigned: []string{"org/repo#1:bob"},
+			expectMessage:  []string{"Designated approvers: **bob**"},
+		},
+		{
+			name:           "non-owners and owners of approved files are ignored",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("alice", "/approve assign @bob @carol @alice")},
+			expectAssigned: []string{"org/repo#1:bob"},
+			expectMessage:  []string{"Designated approvers: **bob**", "Not designated, as they can't approve any of the unapproved files: carol, alice"},
+		},
+		{
+			name:     "designation by a non-approver is ignored",
+			comments: []github.IssueComment{newTestComment("carol", "/approve assign @bob")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go"}, test.comments, nil)
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if diff := cmp.Diff(test.expectAssigned, fghc.AssigneesAdded); diff != "" {
+				t.Errorf("Unexpected assignees (-want +got):\n%s", diff)
+			}
+			comments := fghc.IssueComments[prNumber]
+			notification := comments[len(comments)-1].Body
+			for _, expected := range test.expectMessage {
+				if !strings.Contains(notification, expected) {
+					t.Errorf("Expected the notification to contain %q, but got %q.", expected, notification)
+				}
+			}
+			if len(test.expectMessage) == 0 && strings.Contains(notification, "Designated approvers") {
+				t.Errorf("Expected the notification not to list designated approvers, but got %q.", notification)
+			}
+			if strings.Contains(notification, "This PR is **APPROVED**") {
+				t.Errorf("Expected the designation not to count as an approval, but got %q.", notification)
+			}
+		})
+	}
+}
+
+func TestPartialApprovalLabel(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	rsa := true
+	partialLabel := fmt.Sprintf("org/repo#%v:partially-approved", prNumber)
+
+	tests := []struct {
+		name          string
+		comments      []github.IssueComment
+		hasLabel      bool
+		expectLabel   bool
+		expectRemoved bool
+	}{
+		{
+			name: "no approval",
+		},
+		{
+			name:        "partial approval adds the label",
+			comments:    []github.IssueComment{newTestComment("alice", "/approve")},
+			expectLabel: true,
+		},
+		{
+			name:        "partial approval keeps the label",
+			comments:    []github.IssueComment{newTestComment("alice", "/approve")},
+			hasLabel:    true,
+			expectLabel: true,
+		},
+		{
+			name:          "full approval removes the label",
+			comments:      []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("bob", "/approve")},
+			hasLabel:      true,
+			expectRemoved: true,
+		},
+		{
+			name:          "cancelled approval removes the label",
+			comments:      []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("alice", "/approve cancel")},
+			hasLabel:      true,
+			expectRemoved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go"}, test.comments, nil)
+			if test.hasLabel {
+				fghc.IssueLabelsExisting = append(fghc.IssueLabelsExisting, partialLabel)
+			}
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, PartialApprovalLabel: "partially-approved"}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			prLabels, err := fghc.GetIssueLabels("org", "repo", prNumber)
+			if err != nil {
+				t.Fatalf("Unexpected error getting labels: %v.", err)
+			}
+			hasLabel := false
+			for _, label := range prLabels {
+				hasLabel = hasLabel || label.Name == "partially-approved"
+			}
+			if hasLabel != test.expectLabel {
+				t.Errorf("Expected the PR to have the partial approval label: %t, but got labels %v.", test.expectLabel, prLabels)
+			}
+			if removed := sets.NewString(fghc.IssueLabelsRemoved...).Has(partialLabel); removed != test.expectRemoved {
+				t.Errorf("Expected the partial approval label to be removed: %t, but got removed labels %v.", test.expectRemoved, fghc.IssueLabelsRemoved)
+			}
+		})
+	}
+}
+
+func TestUnownedPathFilter(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"a": layeredsets.NewString("alice")},
+		leafApprovers:  map[string]sets.String{"a": sets.NewString("alice")},
+		approverOwners: map[string]string{"a/a.go": "a", "a/zz_generated.deepcopy.go": "a", "vendor/lib/lib.go": "a"},
+	}
+	filters := []*regexp.Regexp{regexp.MustCompile(`(^|/)zz_generated\.`), regexp.MustCompile(`^vendor/`)}
+
+	tests := []struct {
+		name          string
+		files         []string
+		filters       []*regexp.Regexp
+		expectApprove bool
+	}{
+		{
+			name:          "only excluded files",
+			files:         []string{"a/zz_generated.deepcopy.go", "vendor/lib/lib.go"},
+			filters:       filters,
+			expectApprove: true,
+		},
+		{
+			name:    "excluded and owned files",
+			files:   []string{"a/zz_generated.deepcopy.go", "a/a.go"},
+			filters: filters,
+		},
+		{
+			name:  "no filters",
+			files: []string{"a/zz_generated.deepcopy.go", "vendor/lib/lib.go"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, test.files, nil, nil)
+			rsa := true
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, UnownedPathRe: test.filters}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel)
+			if approved != test.expectApprove {
+				t.Errorf("Expected approved label to be added: %t, but got labels %v.", test.expectApprove, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestApproverRemovedFromOwners(t *testing.T) {
+	before := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	after := newTestRepo(map[string][]string{"a": {"bob"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+	pr := newTestState()
+
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil)
+	runHandle(t, fghc, before, opts, pr)
+	if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+		t.Fatalf("Expected alice's approval to add the approved label, but got labels %v.", fghc.IssueLabelsAdded)
+	}
+
+	// alice is removed from the OWNERS file on the base branch while the PR is open.
+	runHandle(t, fghc, after, opts, pr)
+	if !sets.NewString(fghc.IssueLabelsRemoved...).Has(approvedLabel) {
+		t.Errorf("Expected the approved label to be removed once alice is no longer an approver, but got removed labels %v.", fghc.IssueLabelsRemoved)
+	}
+	notification := fghc.IssueCommentsAdded[len(fghc.IssueCommentsAdded)-1]
+	status, err := approvers.ParseNotificationStatus(notification)
+	if err != nil || status == nil {
+		t.Fatalf("Expected the notification to carry its approval status, but got %v and %q.", err, notification)
+	}
+	if len(status.Approvers) != 0 {
+		t.Errorf("Expected no approvers to be listed, but got %v.", status.Approvers)
+	}
+}
+
+// TestApprovalOnLastPage guards against approvals being missed on very active
+// PRs, whose comments, reviews and events span multiple pages of the GitHub API.
+func TestApprovalOnLastPage(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+	pr := newTestState()
+
+	// The GitHub client requests 100 results per page.
+	start := time.Now()
+	var comments []github.IssueComment
+	var reviews []github.Review
+	for i := 0; i < 250; i++ {
+		comments = append(comments, newTestCommentTime(start.Add(time.Duration(i)*time.Second), "random", "ping"))
+		reviews = append(reviews, newTestReviewTime(start.Add(time.Duration(i)*time.Second), "random", "ping", github.ReviewStateCommented))
+	}
+	comments = append(comments, newTestCommentTime(start.Add(time.Hour), "alice", "/approve"))
+	reviews = append(reviews, newTestReviewTime(start.Add(time.Hour), "bob", "", github.ReviewStateApproved))
+
+	tests := []struct {
+		name     string
+		comments []github.IssueComment
+		reviews  []github.Review
+	}{
+		{
+			name:     "approve comment on the last page",
+			comments: comments,
+		},
+		{
+			name:    "approving review on the last page",
+			reviews: reviews,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, test.reviews)
+			runHandle(t, fghc, fr, opts, pr)
+			if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+				t.Errorf("Expected the approval on the last page to add the approved label, but got labels %v.", fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestReapproveOnIssueChange(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, ReapproveOnIssueChange: true}
+
+	tests := []struct {
+		name           string
+		editedBody     string
+		expectApproved bool
+	}{
+		{
+			name:       "approvals reset when the associated issue changes",
+			editedBody: "Fixes #2",
+		},
+		{
+			name:           "approvals persist when the associated issue stays the same",
+			editedBody:     "Fixes #1, now with tests",
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			approval := newTestCommentTime(time.Now().Add(-time.Hour), "alice", "/approve")
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{approval}, nil)
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner", body: "Fixes #1"}
+			runHandle(t, fghc, fr, opts, pr)
+			if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+				t.Fatalf("Expected alice's approval to add the approved label, but got labels %v.", fghc.IssueLabelsAdded)
+			}
+
+			// The PR body is edited, and the PR is handled again on a later event.
+			pr.body = test.editedBody
+			for i := 0; i < 2; i++ {
+				runHandle(t, fghc, fr, opts, pr)
+			}
+			if approved := !sets.NewString(fghc.IssueLabelsRemoved...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected the PR to stay approved: %t, but got removed labels %v.", test.expectApproved, fghc.IssueLabelsRemoved)
+			}
+			if test.expectApproved {
+				return
+			}
+
+			// Approving again under the new issue counts.
+			fghc.IssueComments[prNumber] = append(fghc.IssueComments[prNumber], newTestCommentTime(time.Now().Add(time.Minute), "alice", "/approve"))
+			fghc.IssueLabelsAdded, fghc.IssueLabelsRemoved = nil, nil
+			runHandle(t, fghc, fr, opts, pr)
+			if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+				t.Errorf("Expected the new approval to add the approved label, but got labels %v.", fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestReapproveOnBaseChange(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, ReapproveOnBaseChange: true}
+
+	approval := newTestCommentTime(time.Now().Add(-time.Hour), "alice", "/approve")
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{approval}, nil)
+	pr := &state{org: "org", repo: "repo", branch: "feature", number: prNumber, author: "cjwagner"}
+	runHandle(t, fghc, fr, opts, pr)
+	if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+		t.Fatalf("Expected alice's approval to add the approved label, but got labels %v.", fghc.IssueLabelsAdded)
+	}
+
+	// The PR is retargeted, and handled again on a later event.
+	pr.branch, pr.previousBranch = "main", "feature"
+	runHandle(t, fghc, fr, opts, pr)
+	pr.previousBranch = ""
+	runHandle(t, fghc, fr, opts, pr)
+	if !sets.NewString(fghc.IssueLabelsRemoved...).Has(approvedLabel) {
+		t.Errorf("Expected the retarget to remove the approved label, but got removed labels %v.", fghc.IssueLabelsRemoved)
+	}
+	notification := fghc.IssueComments[prNumber][len(fghc.IssueComments[prNumber])-1].Body
+	if !strings.Contains(notification, "The base branch of this PR changed") {
+		t.Errorf("Expected the notification to explain the reset, but got:\n%s", notification)
+	}
+
+	// Approving again after the retarget counts.
+	fghc.IssueComments[prNumber] = append(fghc.IssueComments[prNumber], newTestCommentTime(time.Now().Add(time.Minute), "alice", "/approve"))
+	fghc.IssueLabelsAdded, fghc.IssueLabelsRemoved = nil, nil
+	runHandle(t, fghc, fr, opts, pr)
+	if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+		t.Errorf("Expected the new approval to add the approved label, but got labels %v.", fghc.IssueLabelsAdded)
+	}
+}
+
+func TestCompactNotification(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	pr := newTestState()
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, nil, nil)
+	run := func(compact bool) {
+		opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, CompactNotification: compact}
+		runHandle(t, fghc, fr, opts, pr)
+	}
+
+	// Switching to the compact format replaces the full notification.
+	run(false)
+	run(true)
+	if len(fghc.IssueComments[prNumber]) != 1 || len(fghc.IssueCommentsDeleted) != 1 {
+		t.Fatalf("Expected the full notification to be replaced, but got comments %v.", fghc.IssueComments[prNumber])
+	}
+	expected := "[APPROVALNOTIFIER] Approval: 0/1 directories approved — needs @alice."
+	if got := strings.SplitN(fghc.IssueComments[prNumber][0].Body, "\n", 2)[0]; got != expected {
+		t.Errorf("Expected the compact notification %q, but got %q.", expected, got)
+	}
+
+	// An unchanged compact notification is deduped.
+	run(true)
+	if len(fghc.IssueCommentsDeleted) != 1 {
+		t.Errorf("Expected the compact notification to be deduped, but got deleted comments %v.", fghc.IssueCommentsDeleted)
+	}
+}
+
+func TestNotificationAsReview(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, NotificationAsReview: true}
+	pr := newTestState()
+
+	// A notification posted as an issue comment before is replaced by a review.
+	oldNotification := newTestComment("k8s-ci-robot", "[APPROVALNOTIFIER] This PR is **NOT APPROVED**\n\nold")
+	oldNotification.ID = 42
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{oldNotification}, nil)
+	runHandle(t, fghc, fr, opts, pr)
+	if expected := []string{"org/repo#42"}; !reflect.DeepEqual(fghc.IssueCommentsDeleted, expected) {
+		t.Errorf("Expected deleted comments %v, but got %v.", expected, fghc.IssueCommentsDeleted)
+	}
+	if len(fghc.IssueComments[prNumber]) != 0 {
+		t.Errorf("Expected no notification comment, but got %v.", fghc.IssueComments[prNumber])
+	}
+	reviews := fghc.Reviews[prNumber]
+	if len(reviews) != 1 || !strings.HasPrefix(reviews[0].Body, "[APPROVALNOTIFIER] This PR is **NOT APPROVED**") {
+		t.Fatalf("Expected one notification review, but got %v.", reviews)
+	}
+
+	// An unchanged notification isn't submitted again.
+	runHandle(t, fghc, fr, opts, pr)
+	if len(fghc.Reviews[prNumber]) != 1 {
+		t.Errorf("Expected the notification review to be deduped, but got %v.", fghc.Reviews[prNumber])
+	}
+
+	// A changed notification is submitted as a new review.
+	fghc.IssueComments[prNumber] = append(fghc.IssueComments[prNumber], newTestComment("alice", "/approve"))
+	runHandle(t, fghc, fr, opts, pr)
+	reviews = fghc.Reviews[prNumber]
+	if len(reviews) != 2 || !strings.HasPrefix(reviews[1].Body, "[APPROVALNOTIFIER] This PR is **APPROVED**") {
+		t.Errorf("Expected an updated notification review, but got %v.", reviews)
+	}
+	if len(fghc.IssueComments[prNumber]) != 1 {
+		t.Errorf("Expected no notification comment, but got %v.", fghc.IssueComments[prNumber])
+	}
+}
+
+func TestChangesRequestedCancelsApproval(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	rsa := true
+	ignoreReviewState := true
+	pr := newTestState()
+	start := time.Now()
+
+	tests := []struct {
+		name              string
+		ignoreReviewState *bool
+		reapprove         bool
+		comments          []github.IssueComment
+		reviews           []github.Review
+		expectApproved    bool
+	}{
+		{
+			name: "changes requested after an approving review cancels it",
+			// Reviews are ordered by when they were submitted, not listed.
+			reviews: []github.Review{
+				newTestReviewTime(start.Add(time.Hour), "alice", "", github.ReviewStateChangesRequested),
+				newTestReviewTime(start, "alice", "", github.ReviewStateApproved),
+			},
+		},
+		{
+			name:     "changes requested after an approve comment cancels it",
+			comments: []github.IssueComment{newTestCommentTime(start, "alice", "/approve")},
+			reviews:  []github.Review{newTestReviewTime(start.Add(time.Hour), "alice", "", "changes_requested")},
+		},
+		{
+			name: "approving review after changes requested counts",
+			reviews: []github.Review{
+				newTestReviewTime(start, "alice", "", github.ReviewStateChangesRequested),
+				newTestReviewTime(start.Add(time.Hour), "alice", "", github.ReviewStateApproved),
+			},
+			expectApproved: true,
+		},
+		{
+			name: "changes requested by another reviewer don't cancel the approval",
+			reviews: []github.Review{
+				newTestReviewTime(start, "alice", "", github.ReviewStateApproved),
+				newTestReviewTime(start.Add(time.Hour), "bob", "", github.ReviewStateChangesRequested),
+			},
+			expectApproved: true,
+		},
+		{
+			name:              "changes requested are ignored without review state",
+			ignoreReviewState: &ignoreReviewState,
+			comments:          []github.IssueComment{newTestCommentTime(start, "alice", "/approve")},
+			reviews:           []github.Review{newTestReviewTime(start.Add(time.Hour), "alice", "", github.ReviewStateChangesRequested)},
+			expectApproved:    true,
+		},
+		{
+			name:           "dismissed changes request restores the earlier approval by default",
+			comments:       []github.IssueComment{newTestCommentTime(start, "alice", "/approve")},
+			reviews:        []github.Review{newTestReviewTime(start.Add(time.Hour), "alice", "", github.ReviewStateDismissed)},
+			expectApproved: true,
+		},
+		{
+			name:      "dismissed changes request keeps the earlier approval invalid with require_reapproval_after_changes",
+			reapprove: true,
+			comments:  []github.IssueComment{newTestCommentTime(start, "alice", "/approve")},
+			reviews:   []github.Review{newTestReviewTime(start.Add(time.Hour), "alice", "", "dismissed")},
+		},
+		{
+			name:      "new approval after the dismissed changes request counts with require_reapproval_after_changes",
+			reapprove: true,
+			comments:  []github.IssueComment{newTestCommentTime(start.Add(2*time.Hour), "alice", "/approve")},
+			reviews: []github.Review{
+				newTestReviewTime(start, "alice", "", github.ReviewStateApproved),
+				newTestReviewTime(start.Add(time.Hour), "alice", "", github.ReviewStateDismissed),
+			},
+			expectApproved: true,
+		},
+		{
+			name:           "dismissal by another reviewer doesn't invalidate the approval with require_reapproval_after_changes",
+			reapprove:      true,
+			comments:       []github.IssueComment{newTestCommentTime(start, "alice", "/approve")},
+			reviews:        []github.Review{newTestReviewTime(start.Add(time.Hour), "bob", "", github.ReviewStateDismissed)},
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, IgnoreReviewState: test.ignoreReviewState, RequireReapprovalAfterChanges: test.reapprove}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, test.reviews)
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected the PR to be approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+type mapResolver map[string]sets.String
+
+func (r mapResolver) RequiredApprovers(filenames []string) (map[string]sets.String, error) {
+	return r, nil
+}
+
+func TestOwnershipResolverFactory(t *testing.T) {
+	RegisterOwnershipResolverFactory(func(org, repo, branch string) approvers.OwnershipResolver {
+		if repo != "repo" {
+			return nil
+		}
+		return mapResolver{"a/a.go": sets.NewString("Carol")}
+	})
+	defer RegisterOwnershipResolverFactory(nil)
+
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+
+	tests := []struct {
+		name           string
+		repo           string
+		approver       string
+		expectApproved bool
+	}{
+		{
+			name:           "approver of the resolver",
+			repo:           "repo",
+			approver:       "carol",
+			expectApproved: true,
+		},
+		{
+			name:     "approver of the OWNERS files",
+			repo:     "repo",
+			approver: "alice",
+		},
+		{
+			name:           "repo without a resolver",
+			repo:           "other",
+			approver:       "alice",
+			expectApproved: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/" + test.repo}, RequireSelfApproval: &rsa}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment(test.approver, "/approve")}, nil)
+			pr := &state{org: "org", repo: test.repo, branch: "master", number: prNumber, author: "cjwagner"}
+			runHandle(t, fghc, fr, opts, pr)
+			label := fmt.Sprintf("org/%s#%v:approved", test.repo, prNumber)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(label); approved != test.expectApproved {
+				t.Errorf("Expected the PR to be approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestApprovalTransitionHandler(t *testing.T) {
+	var transitions []ApprovalTransition
+	RegisterApprovalTransitionHandler(func(_ *logrus.Entry, transition ApprovalTransition) {
+		transitions = append(transitions, transition)
+	})
+	defer func() {
+		approvalTransitionHandlers = nil
+	}()
+
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+	pr := newTestState()
+
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, nil, nil)
+	steps := []struct {
+		comment           string
+		expectTransitions []ApprovalTransition
+	}{
+		{
+			comment:           "/approve",
+			expectTransitions: []ApprovalTransition{{Org: "org", Repo: "repo", Number: prNumber, Approved: true}},
+		},
+		{
+			comment: "/approve",
+		},
+		{
+			comment:           "/approve cancel",
+			expectTransitions: []ApprovalTransition{{Org: "org", Repo: "repo", Number: prNumber, Approved: false}},
+		},
+		{
+			comment: "looks good otherwise",
+		},
+	}
+	for _, step := range steps {
+		transitions = nil
+		fghc.IssueComments[prNumber] = append(fghc.IssueComments[prNumber], newTestComment("alice", step.comment))
+		runHandle(t, fghc, fr, opts, pr)
+		if !reflect.DeepEqual(transitions, step.expectTransitions) {
+			t.Errorf("Expected transitions %+v after %q, but got %+v.", step.expectTransitions, step.comment, transitions)
+		}
+	}
+}
+
+func TestIgnoreDraftApprovals(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	rsa := true
+	pr := newTestState()
+	readyAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name                 string
+		ignoreDraftApprovals bool
+		comments             []github.IssueComment
+		expectApproved       bool
+	}{
+		{
+			name:                 "approval given while draft is dropped",
+			ignoreDraftApprovals: true,
+			comments:             []github.IssueComment{newTestCommentTime(readyAt.Add(-time.Minute), "alice", "/approve")},
+		},
+		{
+			name:                 "approval given after ready for review counts",
+			ignoreDraftApprovals: true,
+			comments:             []github.IssueComment{newTestCommentTime(readyAt.Add(time.Minute), "bob", "/approve")},
+			expectApproved:       true,
+		},
+		{
+			name:           "approval given while draft counts by default",
+			comments:       []github.IssueComment{newTestCommentTime(readyAt.Add(-time.Minute), "alice", "/approve")},
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, SkipDrafts: true, IgnoreDraftApprovals: test.ignoreDraftApprovals}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			fghc.IssueEvents[prNumber] = []github.ListedIssueEvent{
+				{Event: github.IssueActionReadyForReview, CreatedAt: readyAt.Add(-2 * time.Hour)},
+				{Event: github.IssueActionConvertToDraft, CreatedAt: readyAt.Add(-time.Hour)},
+				{Event: github.IssueActionReadyForReview, CreatedAt: readyAt},
+			}
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected the PR to be approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestPublishCommitStatus(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{
+		Repos:               []string{"org/repo"},
+		RequireSelfApproval: &rsa,
+		PublishCommitStatus: true,
+	}
+	pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, headSHA: "abcdef", author: "cjwagner"}
+
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, nil, nil)
+	steps := []struct {
+		comment     github.IssueComment
+		expectState string
+	}{
+		{
+			comment:     newTestComment("alice", "/approve"),
+			expectState: github.StatusSuccess,
+		},
+		{
+			comment:     newTestComment("alice", "/approve cancel"),
+			expectState: github.StatusPending,
+		},
+	}
+	for _, step := range steps {
+		fghc.IssueComments[prNumber] = append(fghc.IssueComments[prNumber], step.comment)
+		runHandle(t, fghc, fr, opts, pr)
+		statuses := fghc.CreatedStatuses["abcdef"]
+		if len(statuses) != 1 {
+			t.Fatalf("Expected exactly one status on the head SHA after %q, but got %v.", step.comment.Body, statuses)
+		}
+		if statuses[0].Context != "approve" || statuses[0].State != step.expectState {
+			t.Errorf("Expected %q status to be %q after %q, but got %+v.", "approve", step.expectState, step.comment.Body, statuses[0])
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	opts := &plugins.Approve{AdminApprovers: []string{"admin"}}
+
+	tests := []struct {
+		name            string
+		comments        []*comment
+		expectApprovers []string
+	}{
+		{
+			name: "self cancel",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "alice", Body: "/approve cancel"},
+			},
+		},
+		{
+			name: "cancel only affects the comment author",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "bob", Body: "/approve"},
+				{Author: "bob", Body: "/approve cancel"},
+			},
+			expectApprovers: []string{"alice"},
+		},
+		{
+			name: "unauthorized cancel of another user",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "bob", Body: "/approve"},
+				{Author: "bob", Body: "/approve cancel @alice"},
+			},
+			expectApprovers: []string{"alice", "bob"},
+		},
+		{
+			name: "admin cancel of another user",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "bob", Body: "/approve"},
+				{Author: "Admin", Body: "/approve cancel @Alice"},
+			},
+			expectApprovers: []string{"bob"},
+		},
+		{
+			name: "admin cancel of another user followed by a new approval",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "admin", Body: "/approve cancel @alice"},
+				{Author: "alice", Body: "/approve"},
+			},
+			expectApprovers: []string{"alice"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owners := approvers.NewOwners(logrus.WithField("plugin", "approve"), []string{"a/a.go"}, fr, prNumber)
+			ap := approvers.NewApprovers(owners)
+			addApprovers(&ap, test.comments, "cjwagner", "", owners, opts)
+			if got, expected := ap.GetCurrentApproversSet(), sets.NewString(test.expectApprovers...); !got.Equal(expected) {
+				t.Errorf("Expected approvers %v, but got %v.", expected.List(), got.List())
+			}
+		})
+	}
+}
+
+func TestCancelKeyword(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	tests := []struct {
+		name            string
+		cancelKeyword   string
+		comments        []*comment
+		expectApprovers []string
+	}{
+		{
+			name:          "custom cancel keyword retracts the approval",
+			cancelKeyword: "retract",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "bob", Body: "/approve"},
+				{Author: "bob", Body: "/approve retract"},
+			},
+			expectApprovers: []string{"alice"},
+		},
+		{
+			name:          "custom cancel keyword is case insensitive",
+			cancelKeyword: "Retract",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "alice", Body: "/approve RETRACT"},
+			},
+		},
+		{
+			name:          "default cancel keyword doesn't cancel with a custom one",
+			cancelKeyword: "retract",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "alice", Body: "/approve cancel"},
+			},
+			expectApprovers: []string{"alice"},
+		},
+		{
+			name:          "cancel keyword only matches whole arguments",
+			cancelKeyword: "no",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve no-issue"},
+				{Author: "bob", Body: "/approve"},
+				{Author: "bob", Body: "/approve no"},
+			},
+			expectApprovers: []string{"alice"},
+		},
+		{
+			name: "default cancel keyword",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve"},
+				{Author: "alice", Body: "/approve cancel"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owners := approvers.NewOwners(logrus.WithField("plugin", "approve"), []string{"a/a.go"}, fr, prNumber)
+			ap := approvers.NewApprovers(owners)
+			addApprovers(&ap, test.comments, "cjwagner", "", owners, &plugins.Approve{CancelKeyword: test.cancelKeyword})
+			if got, expected := ap.GetCurrentApproversSet(), sets.NewString(test.expectApprovers...); !got.Equal(expected) {
+				t.Errorf("Expected approvers %v, but got %v.", expected.List(), got.List())
+			}
+		})
+	}
+
+	t.Run("notification mentions the custom cancel keyword", func(t *testing.T) {
+		fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, nil, nil)
+		opts := &plugins.Approve{Repos: []string{"org/repo"}, CancelKeyword: "retract"}
+		pr := newTestState()
+		runHandle(t, fghc, fr, opts, pr)
+		if len(fghc.IssueCommentsAdded) != 1 || !strings.Contains(fghc.IssueCommentsAdded[0], "`/approve retract`") {
+			t.Errorf("Expected a notification mentioning \"/approve retract\", but got %v.", fghc.IssueCommentsAdded)
+		}
+	})
+}
+
+func TestAddApproversRecordsTimes(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	comments := []*comment{
+		{Author: "alice", Body: "/approve", HTMLURL: "#1", CreatedAt: start},
+		{Author: "bob", Body: "/approve no-issue", HTMLURL: "#2", CreatedAt: start.Add(time.Hour)},
+		// The no-issue approval is kept, and so is its time.
+		{Author: "bob", Body: "/approve", HTMLURL: "#3", CreatedAt: start.Add(2 * time.Hour)},
+	}
+
+	owners := approvers.NewOwners(logrus.WithField("plugin", "approve"), []string{"a/a.go", "b/b.go"}, fr, prNumber)
+	ap := approvers.NewApprovers(owners)
+	addApprovers(&ap, comments, "cjwagner", "", owners, &plugins.Approve{})
+	expected := map[string]time.Time{"a": start, "b": start.Add(time.Hour)}
+	if got := ap.ApprovalTimes(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected approval times %v, but got %v.", expected, got)
+	}
+}
+
+func TestReviewWithInlineComments(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	review := func(body string) github.Review {
+		return github.Review{ID: 1, User: github.User{Login: "alice"}, Body: body, HTMLURL: "#review", SubmittedAt: start.Add(time.Minute), State: github.ReviewStateCommented}
+	}
+	inline := func(reviewID int, body string) github.ReviewComment {
+		return github.ReviewComment{ID: 2, ReviewID: reviewID, User: github.User{Login: "alice"}, Body: body, HTMLURL: "#inline", CreatedAt: start}
+	}
+
+	tests := []struct {
+		name           string
+		review         github.Review
+		reviewComment  github.ReviewComment
+		expectComments int
+		expectApproved bool
+	}{
+		{
+			name:           "body and inline comment both approve",
+			review:         review("/approve"),
+			reviewComment:  inline(1, "/approve"),
+			expectComments: 1,
+			expectApproved: true,
+		},
+		{
+			name:           "body cancels the approval of the inline comment",
+			review:         review("/approve cancel"),
+			reviewComment:  inline(1, "/approve"),
+			expectComments: 1,
+		},
+		{
+			name:           "inline comment of another review is kept",
+			review:         review("looks good"),
+			reviewComment:  inline(2, "/approve"),
+			expectComments: 2,
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			comments := commentsFromReviewsAndReviewComments([]github.Review{test.review}, []github.ReviewComment{test.reviewComment})
+			if len(comments) != test.expectComments {
+				t.Fatalf("Expected %d comments, but got %d.", test.expectComments, len(comments))
+			}
+			owners := approvers.NewOwners(logrus.WithField("plugin", "approve"), []string{"a/a.go"}, fr, prNumber)
+			ap := approvers.NewApprovers(owners)
+			addApprovers(&ap, comments, "cjwagner", "", owners, &plugins.Approve{})
+			if got := ap.IsApproved(); got != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got %t.", test.expectApproved, got)
+			}
+		})
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	created := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	defer func() {
+		pluginClock = clock.RealClock{}
+	}()
+	rsa := true
+	escalation := "This PR has been waiting for approval for a while, escalating to @org/fallback-approvers, @carol.\n" + escalationMarker
+
+	tests := []struct {
+		name             string
+		comments         []github.IssueComment
+		now              time.Time
+		escalateAfter    time.Duration
+		expectEscalation bool
+	}{
+		{
+			name:          "unapproved PR within the escalation window",
+			now:           created.Add(71 * time.Hour),
+			escalateAfter: 72 * time.Hour,
+		},
+		{
+			name:             "unapproved PR past the escalation window",
+			now:              created.Add(72 * time.Hour),
+			escalateAfter:    72 * time.Hour,
+			expectEscalation: true,
+		},
+		{
+			name:          "approved PR past the escalation window",
+			comments:      []github.IssueComment{newTestComment("alice", "/approve")},
+			now:           created.Add(96 * time.Hour),
+			escalateAfter: 72 * time.Hour,
+		},
+		{
+			name: "unapproved PR without escalate_after",
+			now:  created.Add(96 * time.Hour),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pluginClock = clock.NewFakeClock(test.now)
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			opts := &plugins.Approve{
+				Repos:                 []string{"org/repo"},
+				RequireSelfApproval:   &rsa,
+				EscalateAfterDuration: test.escalateAfter,
+				EscalationApprovers:   []string{"org/fallback-approvers", "carol"},
+			}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner", createdAt: created}
+			runHandle(t, fghc, fr, opts, pr)
+			escalations := func() int {
+				var count int
+				for _, c := range fghc.IssueComments[prNumber] {
+					if c.Body == escalation {
+						count++
+					}
+				}
+				return count
+			}
+			if got := escalations() == 1; got != test.expectEscalation {
+				t.Errorf("Expected escalation: %t, but got comments %v.", test.expectEscalation, fghc.IssueComments[prNumber])
+			}
+			for _, c := range fghc.IssueComments[prNumber] {
+				if c.Body != escalation && strings.Contains(c.Body, "escalating") {
+					t.Errorf("Expected the notification not to mention the escalation, but got %q.", c.Body)
+				}
+			}
+
+			// The escalation is only mentioned once.
+			runHandle(t, fghc, fr, opts, pr)
+			if got := escalations(); got > 1 {
+				t.Errorf("Expected the escalation to be mentioned once, but got %d mentions.", got)
+			}
+		})
+	}
+}
+
+func TestAddApproversExpiry(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	defer func() {
+		pluginClock = clock.RealClock{}
+	}()
+
+	tests := []struct {
+		name           string
+		comments       []*comment
+		now            time.Time
+		expectApproved bool
+		expectExpiry   time.Time
+	}{
+		{
+			name:           "time-boxed approval within its window",
+			comments:       []*comment{{Author: "alice", Body: "/approve for:72h", HTMLURL: "#1", CreatedAt: start}},
+			now:            start.Add(71 * time.Hour),
+			expectApproved: true,
+			expectExpiry:   start.Add(72 * time.Hour),
+		},
+		{
+			name:     "time-boxed approval after its window",
+			comments: []*comment{{Author: "alice", Body: "/approve for:72h", HTMLURL: "#1", CreatedAt: start}},
+			now:      start.Add(72 * time.Hour),
+		},
+		{
+			name: "expired time-boxed approval supersedes an earlier approval",
+			comments: []*comment{
+				{Author: "alice", Body: "/approve", HTMLURL: "#1", CreatedAt: start},
+				{Author: "alice", Body: "/approve for:1h", HTMLURL: "#2", CreatedAt: start.Add(time.Hour)},
+			},
+			now: start.Add(3 * time.Hour),
+		},
+		{
+			name:     "invalid duration does not approve",
+			comments: []*comment{{Author: "alice", Body: "/approve for:soon", HTMLURL: "#1", CreatedAt: start}},
+			now:      start,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pluginClock = clock.NewFakeClock(test.now)
+			owners := approvers.NewOwners(logrus.WithField("plugin", "approve"), []string{"a/a.go"}, fr, prNumber)
+			ap := approvers.NewApprovers(owners)
+			addApprovers(&ap, test.comments, "cjwagner", "", owners, &plugins.Approve{})
+			if got := ap.AreFilesApproved(); got != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got %t.", test.expectApproved, got)
+			}
+			if test.expectApproved {
+				if got := ap.ListApprovals()[0].Expiry; !got.Equal(test.expectExpiry) {
+					t.Errorf("Expected the approval to expire at %v, but got %v.", test.expectExpiry, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAreaApprovers(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	rsa := true
+	opts := &plugins.Approve{
+		Repos:               []string{"org/repo"},
+		RequireSelfApproval: &rsa,
+		AreaApprovers:       map[string][]string{"area/networking": {"Bob"}},
+	}
+
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil)
+	fghc.IssueLabelsExisting = append(fghc.IssueLabelsExisting, fmt.Sprintf("org/repo#%v:area/networking", prNumber))
+	pr := newTestState()
+	runHandle(t, fghc, fr, opts, pr)
+	if sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+		t.Errorf("Expected the area label to block approval, but got labels %v.", fghc.IssueLabelsAdded)
+	}
+	notification := fghc.IssueComments[prNumber][len(fghc.IssueComments[prNumber])-1].Body
+	if !strings.Contains(notification, "Still needs approval from an area approver of: `area/networking`") {
+		t.Errorf("Expected the notification to list the unapproved area, but got:\n%s", notification)
+	}
+
+	fghc.IssueComments[prNumber] = append(fghc.IssueComments[prNumber], newTestComment("bob", "/approve"))
+	runHandle(t, fghc, fr, opts, pr)
+	if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+		t.Errorf("Expected the area approver to approve, but got labels %v.", fghc.IssueLabelsAdded)
+	}
+}
+
+func TestApprovalDiff(t *testing.T) {
+	approvedAt := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	commit := func(sha string, at time.Time, files ...string) github.RepositoryCommit {
+		c := github.RepositoryCommit{SHA: sha, Commit: github.GitCommit{Committer: github.CommitAuthor{Date: at}}}
+		for _, file := range files {
+			c.Files = append(c.Files, github.CommitFile{Filename: file})
+		}
+		return c
+	}
+
+	tests := []struct {
+		name            string
+		comments        []github.IssueComment
+		reviews         []github.Review
+		commits         []github.RepositoryCommit
+		expectedComment string
+	}{
+		{
+			name:     "files changed before and after the approval",
+			comments: []github.IssueComment{newTestCommentTime(approvedAt, "alice", "/approve")},
+			commits: []github.RepositoryCommit{
+				commit("1", approvedAt.Add(-time.Hour), "a/before.go"),
+				commit("2", approvedAt.Add(time.Hour), "b/after.go", "a/after.go"),
+				commit("3", approvedAt.Add(2*time.Hour), "a/after.go"),
+			},
+			expectedComment: "@alice, files changed since your approval at 2020-01-01 10:00 UTC:\n\n- `a/after.go`\n- `b/after.go`",
+		},
+		{
+			name: "latest approval counts",
+			comments: []github.IssueComment{
+				newTestCommentTime(approvedAt.Add(-2*time.Hour), "alice", "/approve"),
+				newTestCommentTime(approvedAt.Add(3*time.Hour), "alice", "/approve diff"),
+				newTestCommentTime(approvedAt.Add(4*time.Hour), "bob", "/approve"),
+			},
+			reviews: []github.Review{newTestReviewTime(approvedAt, "alice", "", github.ReviewStateApproved)},
+			commits: []github.RepositoryCommit{
+				commit("1", approvedAt.Add(-time.Hour), "a/before.go"),
+				commit("2", approvedAt.Add(time.Hour), "a/after.go"),
+			},
+			expectedComment: "@alice, files changed since your approval at 2020-01-01 10:00 UTC:\n\n- `a/after.go`",
+		},
+		{
+			name:            "no files changed after the approval",
+			comments:        []github.IssueComment{newTestCommentTime(approvedAt, "alice", "/approve")},
+			commits:         []github.RepositoryCommit{commit("1", approvedAt.Add(-time.Hour), "a/before.go")},
+			expectedComment: "@alice, no files changed since your approval at 2020-01-01 10:00 UTC.",
+		},
+		{
+			name:            "reason mentioning cancel approves",
+			comments:        []github.IssueComment{newTestCommentTime(approvedAt, "alice", `/approve reason:"cancel risk reviewed"`)},
+			commits:         []github.RepositoryCommit{commit("1", approvedAt.Add(-time.Hour), "a/before.go")},
+			expectedComment: "@alice, no files changed since your approval at 2020-01-01 10:00 UTC.",
+		},
+		{
+			name:            "no approval",
+			comments:        []github.IssueComment{newTestCommentTime(approvedAt, "alice", "/approve cancel")},
+			commits:         []github.RepositoryCommit{commit("1", approvedAt.Add(time.Hour), "a/after.go")},
+			expectedComment: "@alice, you haven't approved this PR, so there is nothing to compare against.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, nil, test.comments, test.reviews)
+			fghc.CommitMap = map[string][]github.RepositoryCommit{}
+			fghc.Commits = map[string]github.RepositoryCommit{}
+			for _, c := range test.commits {
+				fghc.Commits[c.SHA] = c
+				listed := c
+				listed.Files = nil
+				fghc.CommitMap[fmt.Sprintf("org/repo#%d", prNumber)] = append(fghc.CommitMap[fmt.Sprintf("org/repo#%d", prNumber)], listed)
+			}
+			if err := postApprovalDiff(logrus.WithField("plugin", "approve"), fghc, "org", "repo", prNumber, "alice", "cancel"); err != nil {
+				t.Fatalf("Unexpected error: %v.", err)
+			}
+			comments := fghc.IssueComments[prNumber]
+			if got := comments[len(comments)-1].Body; got != test.expectedComment {
+				t.Errorf("Expected comment %q, but got %q.", test.expectedComment, got)
+			}
+		})
+	}
+
+	t.Run("diff command does not approve", func(t *testing.T) {
+		fr := newTestRepo(map[string][]string{"a": {"alice"}})
+		rsa := true
+		pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{Repos: []string{"org"}, RequireSelfApproval: &rsa}}}
+		fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, nil, nil)
+		fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+		event := github.GenericCommentEvent{
+			Action:      github.GenericCommentActionCreated,
+			IsPR:        true,
+			Body:        "/approve diff",
+			Number:      prNumber,
+			User:        github.User{Login: "alice"},
+			IssueAuthor: github.User{Login: "cjwagner"},
+			Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+		}
+		if err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event); err != nil {
+			t.Fatalf("Unexpected error handling event: %v.", err)
+		}
+		if sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+			t.Errorf("Expected no approval, but got labels %v.", fghc.IssueLabelsAdded)
+		}
+		if len(fghc.IssueComments[prNumber]) == 0 || !strings.HasPrefix(fghc.IssueComments[prNumber][0].Body, "@alice, you haven't approved this PR") {
+			t.Errorf("Expected a diff comment, but got %v.", fghc.IssueComments[prNumber])
+		}
+	})
+
+	t.Run("failing diff command still updates the approval", func(t *testing.T) {
+		fr := newTestRepo(map[string][]string{"a": {"alice"}})
+		rsa := true
+		pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{Repos: []string{"org"}, RequireSelfApproval: &rsa}}}
+		testGitHubConfig := config.GitHubOptions{LinkURL: &url.URL{Scheme: "https", Host: "github.com"}}
+		fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestCommentTime(approvedAt, "alice", "/approve")}, nil)
+		fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+		event := github.GenericCommentEvent{
+			Action:      github.GenericCommentActionCreated,
+			IsPR:        true,
+			Body:        "/approve diff",
+			Number:      prNumber,
+			User:        github.User{Login: "alice"},
+			IssueAuthor: github.User{Login: "cjwagner"},
+			Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+		}
+		if err := handleGenericComment(logrus.WithField("plugin", "approve"), prCommitsErrorClient{FakeClient: fghc}, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event); err != nil {
+			t.Fatalf("Unexpected error handling event: %v.", err)
+		}
+		if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+			t.Errorf("Expected the approval to be updated, but got labels %v.", fghc.IssueLabelsAdded)
+		}
+	})
+}
+
+type prCommitsErrorClient struct {
+	*fakegithub.FakeClient
+}
+
+func (c prCommitsErrorClient) ListPRCommits(org, repo string, number int) ([]github.RepositoryCommit, error) {
+	return nil, errors.New("injected error")
+}
+
+func TestUndefinedOwnership(t *testing.T) {
+	noSelfApproval := false
+
+	tests := []struct {
+		name            string
+		repo            fakeRepo
+		expectApproved  bool
+		expectUndefined bool
+	}{
+		{
+			name:            "repo without OWNERS files",
+			repo:            fakeRepo{},
+			expectUndefined: true,
+		},
+		{
+			name:            "OWNERS file without approvers",
+			repo:            newTestRepo(map[string][]string{"a": {}}),
+			expectUndefined: true,
+		},
+		{
+			name:           "OWNERS file with approvers",
+			repo:           newTestRepo(map[string][]string{"a": {"cjwagner"}}),
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The author implicitly approves, and anyone tries to approve.
+			comments := []github.IssueComment{newTestComment("alice", "/approve")}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, comments, nil)
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &noSelfApproval}
+			pr := newTestState()
+			runHandle(t, fghc, test.repo, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+			notifications := fghc.IssueComments[prNumber]
+			notification := notifications[len(notifications)-1].Body
+			if undefined := strings.Contains(notification, "ownership is undefined"); undefined != test.expectUndefined {
+				t.Errorf("Expected the notification to report undefined ownership: %t, but got:\n%s", test.expectUndefined, notification)
+			}
+		})
+	}
+}
+
+func TestInheritOriginalApprovals(t *testing.T) {
+	const original = 5
+	approvedBy := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+
+	tests := []struct {
+		name                 string
+		approval             string
+		body                 string
+		owners               fakeRepo
+		author               string
+		open                 bool
+		merged               bool
+		sameHead             bool
+		originalPatch        string
+		comments             []github.IssueComment
+		requireSignedCommits bool
+		expectApproved       bool
+	}{
+		{
+			name:           "approval is carried forward",
+			approval:       "/approve",
+			body:           "Rebased for the merge queue.\n\nOriginal-PR: #5",
+			owners:         approvedBy,
+			expectApproved: true,
+		},
+		{
+			name:           "approval of the same head commit with other changes is carried forward",
+			approval:       "/approve",
+			body:           "Original-PR: #5",
+			owners:         approvedBy,
+			sameHead:       true,
+			originalPatch:  "@@ -1 +1 @@",
+			expectApproved: true,
+		},
+		{
+			name:          "approval of other changes is not carried forward",
+			approval:      "/approve",
+			body:          "Original-PR: #5",
+			owners:        approvedBy,
+			originalPatch: "@@ -1 +1 @@",
+		},
+		{
+			name:     "approval of a PR of another author is not carried forward",
+			approval: "/approve",
+			body:     "Original-PR: #5",
+			owners:   approvedBy,
+			author:   "bob",
+		},
+		{
+			name:     "approval of an open original is not carried forward",
+			approval: "/approve",
+			body:     "Original-PR: #5",
+			owners:   approvedBy,
+			open:     true,
+		},
+		{
+			name:     "approval of a merged original is not carried forward",
+			approval: "/approve",
+			body:     "Original-PR: #5",
+			owners:   approvedBy,
+			merged:   true,
+		},
+		{
+			name:     "approval cancelled on the PR is not carried forward",
+			approval: "/approve",
+			body:     "Original-PR: #5",
+			owners:   approvedBy,
+			comments: []github.IssueComment{newTestCommentTime(time.Now(), "alice", "/approve cancel")},
+		},
+		{
+			name:                 "approval of an approver without verified commits is not carried forward",
+			approval:             "/approve",
+			body:                 "Original-PR: #5",
+			owners:               approvedBy,
+			requireSignedCommits: true,
+		},
+		{
+			name:     "approval of an approver that was removed from OWNERS is stale",
+			approval: "/approve",
+			body:     "Original-PR: #5",
+			owners:   newTestRepo(map[string][]string{"a": {"bob"}}),
+		},
+		{
+			name:     "unapproved original is not carried forward",
+			approval: "/lgtm",
+			body:     "Original-PR: #5",
+			owners:   approvedBy,
+		},
+		{
+			name:     "without trailer",
+			approval: "/approve",
+			body:     "Rebased from #5",
+			owners:   approvedBy,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			fghc.PullRequestChanges[original] = []github.PullRequestChange{{Filename: "a/a.go", Patch: test.originalPatch}}
+			originalHead := "original"
+			if test.sameHead {
+				originalHead = "head"
+			}
+			fghc.PullRequests = map[int]*github.PullRequest{original: {
+				Number: original,
+				User:   github.User{Login: "cjwagner"},
+				State:  "open",
+				Head:   github.PullRequestBranch{SHA: originalHead},
+			}}
+			fghc.IssueComments[original] = []github.IssueComment{newTestComment("alice", test.approval)}
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, InheritOriginalApprovals: true}
+
+			// The original PR is processed while its approvers are OWNERS approvers.
+			originalPR := &state{org: "org", repo: "repo", branch: "master", number: original, author: "cjwagner"}
+			runHandle(t, fghc, approvedBy, opts, originalPR)
+			if !test.open {
+				fghc.PullRequests[original].State = "closed"
+				fghc.PullRequests[original].Merged = test.merged
+			}
+
+			author := "cjwagner"
+			if test.author != "" {
+				author = test.author
+			}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, headSHA: "head", author: author, body: test.body}
+			prOpts := *opts
+			prOpts.RequireSignedCommits = test.requireSignedCommits
+			runHandle(t, fghc, test.owners, &prOpts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestApproverAllowlist(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob", "cjwagner"}})
+	rsa := true
+	noSelfApproval := false
+
+	tests := []struct {
+		name                string
+		allowlist           []string
+		requireSelfApproval *bool
+		comments            []github.IssueComment
+		expectApproved      bool
+	}{
+		{
+			name:           "OWNERS approver without allowlist",
+			comments:       []github.IssueComment{newTestComment("bob", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:      "OWNERS approver not on the allowlist",
+			allowlist: []string{"alice"},
+			comments:  []github.IssueComment{newTestComment("bob", "/approve")},
+		},
+		{
+			name:           "allowlisted OWNERS approver",
+			allowlist:      []string{"Alice"},
+			comments:       []github.IssueComment{newTestComment("alice", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:                "implicit self-approval of an author not on the allowlist",
+			allowlist:           []string{"alice"},
+			requireSelfApproval: &noSelfApproval,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requireSelfApproval := &rsa
+			if test.requireSelfApproval != nil {
+				requireSelfApproval = test.requireSelfApproval
+			}
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: requireSelfApproval, ApproverAllowlist: test.allowlist}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestForkRequiresExtraApprover(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob", "cjwagner"}})
+	rsa := true
+	noSelfApproval := false
+
+	tests := []struct {
+		name                string
+		fromFork            bool
+		requireSelfApproval *bool
+		comments            []github.IssueComment
+		expectApproved      bool
+	}{
+		{
+			name:           "single approval on a same-repo PR",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:     "single approval on a fork PR",
+			fromFork: true,
+			comments: []github.IssueComment{newTestComment("alice", "/approve")},
+		},
+		{
+			name:           "two approvals on a fork PR",
+			fromFork:       true,
+			comments:       []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("bob", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:                "implicit self-approval on a same-repo PR",
+			requireSelfApproval: &noSelfApproval,
+			expectApproved:      true,
+		},
+		{
+			name:                "implicit self-approval on a fork PR",
+			fromFork:            true,
+			requireSelfApproval: &noSelfApproval,
+			comments:            []github.IssueComment{newTestComment("alice", "/approve")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requireSelfApproval := &rsa
+			if test.requireSelfApproval != nil {
+				requireSelfApproval = test.requireSelfApproval
+			}
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: requireSelfApproval, ForkRequiresExtraApprover: true}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner", fromFork: test.fromFork}
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestOwnersChangeRequiredApprovers(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"a": layeredsets.NewString("alice", "bob")},
+		leafApprovers:  map[string]sets.String{"a": sets.NewString("alice", "bob")},
+		approverOwners: map[string]string{"a/a.go": "a", "a/OWNERS": "a", "a/OWNERS_ALIASES": "a"},
+	}
+	rsa := true
+
+	tests := []struct {
+		name           string
+		files          []string
+		comments       []github.IssueComment
+		expectApproved bool
+	}{
+		{
+			name:           "single approval of a code change",
+			files:          []string{"a/a.go"},
+			comments:       []github.IssueComment{newTestComment("alice", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:     "single approval of an OWNERS change",
+			files:    []string{"a/a.go", "a/OWNERS"},
+			comments: []github.IssueComment{newTestComment("alice", "/approve")},
+		},
+		{
+			name:     "single approval of an OWNERS_ALIASES change",
+			files:    []string{"a/OWNERS_ALIASES"},
+			comments: []github.IssueComment{newTestComment("alice", "/approve")},
+		},
+		{
+			name:           "two approvals of an OWNERS change",
+			files:          []string{"a/a.go", "a/OWNERS"},
+			comments:       []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("bob", "/approve")},
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, OwnersChangeRequiredApprovers: 2}
+			fghc := newFakeGitHubClient(false, false, test.files, test.comments, nil)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestTreatDismissalAsCancel(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	start := time.Now()
+	review := func(at time.Time, state github.ReviewState) github.Review {
+		r := newTestReviewTime(at, "alice", "", state)
+		r.ID = 7
+		return r
+	}
+	dismissal := github.ListedIssueEvent{
+		Event:           github.IssueActionReviewDismissed,
+		Actor:           github.User{Login: "maintainer"},
+		CreatedAt:       start.Add(3 * time.Hour),
+		DismissedReview: &github.DismissedReview{State: github.ReviewStateDismissed, ReviewID: 7},
+	}
+
+	tests := []struct {
+		name                   string
+		treatDismissalAsCancel bool
+		comments               []github.IssueComment
+		reviews                []github.Review
+		events                 []github.ListedIssueEvent
+		expectApproved         bool
+	}{
+		{
+			name:           "dismissed review doesn't cancel by default",
+			comments:       []github.IssueComment{newTestCommentTime(start, "alice", "/approve")},
+			reviews:        []github.Review{review(start.Add(time.Hour), github.ReviewStateDismissed)},
+			expectApproved: true,
+		},
+		{
+			name:                   "dismissed review cancels",
+			treatDismissalAsCancel: true,
+			comments:               []github.IssueComment{newTestCommentTime(start, "alice", "/approve")},
+			reviews:                []github.Review{review(start.Add(time.Hour), github.ReviewStateDismissed)},
+		},
+		{
+			name:                   "dismissal event cancels a review listed as approved",
+			treatDismissalAsCancel: true,
+			reviews:                []github.Review{review(start.Add(time.Hour), github.ReviewStateApproved)},
+			events:                 []github.ListedIssueEvent{dismissal},
+		},
+		{
+			name:                   "approval given between the review and its dismissal counts",
+			treatDismissalAsCancel: true,
+			comments:               []github.IssueComment{newTestCommentTime(start.Add(2*time.Hour), "alice", "/approve")},
+			reviews:                []github.Review{review(start.Add(time.Hour), github.ReviewStateApproved)},
+			events:                 []github.ListedIssueEvent{dismissal},
+			expectApproved:         true,
+		},
+		{
+			name:                   "approval after the dismissal counts",
+			treatDismissalAsCancel: true,
+			comments:               []github.IssueComment{newTestCommentTime(start.Add(4*time.Hour), "alice", "/approve")},
+			reviews:                []github.Review{review(start.Add(time.Hour), github.ReviewStateDismissed)},
+			events:                 []github.ListedIssueEvent{dismissal},
+			expectApproved:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, TreatDismissalAsCancel: test.treatDismissalAsCancel}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, test.reviews)
+			fghc.IssueEvents[prNumber] = append(fghc.IssueEvents[prNumber], test.events...)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestRequireSignedCommits(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	rsa := true
+	commit := func(sha, author string, verified bool) github.RepositoryCommit {
+		return github.RepositoryCommit{
+			SHA:    sha,
+			Author: github.User{Login: author},
+			Commit: github.GitCommit{Verification: &github.SignatureVerification{Verified: verified}},
+		}
+	}
+	commits := []github.RepositoryCommit{commit("1111111", "Alice", true), commit("2222222", "bob", false)}
+
+	tests := []struct {
+		name                 string
+		requireSignedCommits bool
+		comments             []github.IssueComment
+		expectApproved       bool
+	}{
+		{
+			name:           "unsigned approver counts by default",
+			comments:       []github.IssueComment{newTestComment("bob", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:                 "approver with a verified commit counts",
+			requireSignedCommits: true,
+			comments:             []github.IssueComment{newTestComment("alice", "/approve")},
+			expectApproved:       true,
+		},
+		{
+			name:                 "approver with an unverified commit doesn't count",
+			requireSignedCommits: true,
+			comments:             []github.IssueComment{newTestComment("bob", "/approve")},
+		},
+		{
+			name:                 "approver without commits doesn't count",
+			requireSignedCommits: true,
+			comments:             []github.IssueComment{newTestComment("carol", "/approve")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, RequireSignedCommits: test.requireSignedCommits}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			fghc.CommitMap = map[string][]github.RepositoryCommit{fmt.Sprintf("org/repo#%d", prNumber): commits}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestRequireLgtmAfterApproval(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	start := time.Now()
+	lgtmAt := func(at time.Time) github.ListedIssueEvent {
+		return github.ListedIssueEvent{Event: github.IssueActionLabeled, Label: github.Label{Name: labels.LGTM}, Actor: github.User{Login: fakegithub.Bot}, CreatedAt: at}
+	}
+
+	tests := []struct {
+		name           string
+		noLgtmLabel    bool
+		events         []github.ListedIssueEvent
+		expectApproved bool
+	}{
+		{
+			name:           "lgtm after the approval",
+			events:         []github.ListedIssueEvent{lgtmAt(start.Add(time.Hour))},
+			expectApproved: true,
+		},
+		{
+			name:   "lgtm before the approval",
+			events: []github.ListedIssueEvent{lgtmAt(start.Add(-time.Hour))},
+		},
+		{
+			name:           "lgtm added again after the approval",
+			events:         []github.ListedIssueEvent{lgtmAt(start.Add(-time.Hour)), lgtmAt(start.Add(time.Hour))},
+			expectApproved: true,
+		},
+		{
+			name:        "no lgtm",
+			noLgtmLabel: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, RequireLgtmAfterApproval: true, PublishCommitStatus: true}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestCommentTime(start, "alice", "/approve")}, nil)
+			fghc.IssueEvents[prNumber] = append(fghc.IssueEvents[prNumber], test.events...)
+			if test.noLgtmLabel {
+				fghc.IssueLabelsAdded = sets.NewString(fghc.IssueLabelsAdded...).Delete(fmt.Sprintf("org/repo#%v:lgtm", prNumber)).List()
+			}
+			pr := newTestState()
+			pr.headSHA = "abc"
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+			explained := false
+			for _, ic := range fghc.IssueComments[prNumber] {
+				if strings.Contains(ic.Body, "label will be applied once the") {
+					explained = true
+				}
+			}
+			if explained == test.expectApproved {
+				t.Errorf("Expected the notification to explain the wait for lgtm: %t, but got comments %v.", !test.expectApproved, fghc.IssueComments[prNumber])
+			}
+			expectStatus := github.StatusPending
+			if test.expectApproved {
+				expectStatus = github.StatusSuccess
+			}
+			if got := fghc.CreatedStatuses["abc"]; len(got) != 1 || got[0].State != expectStatus {
+				t.Errorf("Expected a %q status, but got %v.", expectStatus, got)
+			}
+		})
+	}
+}
+
+func TestExplainApprovalLoss(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, ExplainApprovalLoss: true}
+	pr := newTestState()
+	comments := []github.IssueComment{
+		newTestComment("alice", "/approve"),
+		newTestComment("bob", "/approve"),
+		newTestComment("alice", "/approve cancel"),
+	}
+	lossNotes := func(fghc *fakegithub.FakeClient) []string {
+		var notes []string
+		for _, ic := range fghc.IssueComments[prNumber] {
+			if strings.Contains(ic.Body, approvalLossMarker) {
+				notes = append(notes, ic.Body)
+			}
+		}
+		return notes
+	}
+
+	fghc := newFakeGitHubClient(true, false, []string{"a/a.go", "b/b.go"}, comments, nil)
+	runHandle(t, fghc, fr, opts, pr)
+	notes := lossNotes(fghc)
+	if len(notes) != 1 {
+		t.Fatalf("Expected a single approval loss note, but got %v.", notes)
+	}
+	expected := "**Approval removed**: the `approved` label was removed from this PR.\n\nDirectories that lost approval:\n- `a`\n\nWhy:\n- @alice cancelled their approval.\n" + approvalLossMarker
+	if notes[0] != expected {
+		t.Errorf("Expected the approval loss note %q, but got %q.", expected, notes[0])
+	}
+
+	// The same loss isn't explained twice, e.g. if the label is added back manually.
+	fghc = newFakeGitHubClient(true, false, []string{"a/a.go", "b/b.go"}, append(comments, newTestComment("k8s-ci-robot", expected)), nil)
+	runHandle(t, fghc, fr, opts, pr)
+	if notes := lossNotes(fghc); len(notes) != 1 {
+		t.Errorf("Expected the approval loss note not to be repeated, but got %v.", notes)
+	}
+
+	// Anonymized approvers aren't named in the note either.
+	anonymized := *opts
+	anonymized.AnonymizeApprovers = true
+	fghc = newFakeGitHubClient(true, false, []string{"a/a.go", "b/b.go"}, comments, nil)
+	runHandle(t, fghc, fr, &anonymized, pr)
+	expected = "**Approval removed**: the `approved` label was removed from this PR.\n\nDirectories that lost approval:\n- `a`\n\nWhy:\n- An approver cancelled their approval.\n" + approvalLossMarker
+	if notes := lossNotes(fghc); len(notes) != 1 || notes[0] != expected {
+		t.Errorf("Expected the approval loss note %q, but got %q.", expected, notes)
+	}
+}
+
+func TestAuthorityApprovedLogins(t *testing.T) {
+	opts := &plugins.Approve{AuthorityAccount: "Ticket-Bot"}
+	tests := []struct {
+		name     string
+		author   string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "multiple approvals",
+			author:   "ticket-bot",
+			body:     "Change ticket CHG-1 was approved.\n\nApproved-By: @alice, @bob\napproved-by: carol",
+			expected: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:   "comment without approvals",
+			author: "ticket-bot",
+			body:   "Change ticket CHG-1 is pending.",
+		},
+		{
+			name:   "comment by another account",
+			author: "mallory",
+			body:   "Approved-By: @alice",
+		},
+	}
+	for _, test := range tests {
+		if got := authorityApprovedLogins(opts, test.author, test.body); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected logins %v, but got %v.", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestAuthorityApprovals(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	rsa := true
+	grant := "Change ticket CHG-1 was approved.\n\nApproved-By: @alice, @bob, @mallory"
+	start := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name           string
+		comments       []github.IssueComment
+		events         []github.ListedIssueEvent
+		draftApprovals bool
+		baseChanged    bool
+		expectApproved bool
+	}{
+		{
+			name:           "authority account grants multiple approvals",
+			comments:       []github.IssueComment{newTestComment("ticket-bot", grant)},
+			expectApproved: true,
+		},
+		{
+			name:     "another account can't grant approvals",
+			comments: []github.IssueComment{newTestComment("mallory", grant)},
+		},
+		{
+			name:     "granted approval is cancelled",
+			comments: []github.IssueComment{newTestCommentTime(start, "ticket-bot", grant), newTestCommentTime(start.Add(time.Minute), "bob", "/approve cancel")},
+		},
+		{
+			name:           "cancellation is overridden by a later grant",
+			comments:       []github.IssueComment{newTestCommentTime(start, "bob", "/approve cancel"), newTestCommentTime(start.Add(time.Minute), "ticket-bot", grant)},
+			expectApproved: true,
+		},
+		{
+			name:        "grant before a reset doesn't count",
+			comments:    []github.IssueComment{newTestCommentTime(start, "ticket-bot", grant)},
+			baseChanged: true,
+		},
+		{
+			name:           "grant while the PR was a draft doesn't count",
+			comments:       []github.IssueComment{newTestCommentTime(start, "ticket-bot", grant)},
+			events:         []github.ListedIssueEvent{{Event: github.IssueActionReadyForReview, CreatedAt: start.Add(time.Minute)}},
+			draftApprovals: true,
+		},
+		{
+			name:           "grant after the PR became ready for review",
+			comments:       []github.IssueComment{newTestCommentTime(start.Add(time.Minute), "ticket-bot", grant)},
+			events:         []github.ListedIssueEvent{{Event: github.IssueActionReadyForReview, CreatedAt: start}},
+			draftApprovals: true,
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, AuthorityAccount: "ticket-bot", ReapproveOnBaseChange: test.baseChanged, SkipDrafts: test.draftApprovals, IgnoreDraftApprovals: test.draftApprovals}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go"}, test.comments, nil)
+			fghc.IssueEvents[prNumber] = append(fghc.IssueEvents[prNumber], test.events...)
+			pr := newTestState()
+			if test.baseChanged {
+				pr.previousBranch = "release"
+			}
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestWaiveIssueForTrivial(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"a": layeredsets.NewString("alice")},
+		leafApprovers:  map[string]sets.String{"a": sets.NewString("alice")},
+		approverOwners: map[string]string{"a/a.go": "a", "a/zz_generated.go": "a"},
+	}
+	rsa := true
+	filters := []*regexp.Regexp{regexp.MustCompile(`zz_generated`)}
+
+	tests := []struct {
+		name           string
+		waive          bool
+		files          []string
+		expectApproved bool
+	}{
+		{
+			name:           "generated-only PR waives the issue requirement",
+			waive:          true,
+			files:          []string{"a/zz_generated.go"},
+			expectApproved: true,
+		},
+		{
+			name:  "mixed PR still requires an issue",
+			waive: true,
+			files: []string{"a/a.go", "a/zz_generated.go"},
+		},
+		{
+			name:  "generated-only PR requires an issue without waive_issue_for_trivial",
+			files: []string{"a/zz_generated.go"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, IssueRequired: true, UnownedPathRe: filters, WaiveIssueForTrivial: test.waive}
+			fghc := newFakeGitHubClient(false, false, test.files, []github.IssueComment{newTestComment("alice", "/approve")}, nil)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestDeletionsOnlyApproval(t *testing.T) {
+	fr := fakeRepo{
+		approvers: map[string]layeredsets.String{
+			"a": layeredsets.NewString("alice", "root"),
+			"b": layeredsets.NewString("bob", "root"),
+		},
+		leafApprovers: map[string]sets.String{
+			"a": sets.NewString("alice"),
+			"b": sets.NewString("bob"),
+		},
+		approverOwners: map[string]string{"a/a.go": "a", "b/b.go": "b"},
+	}
+	rsa := true
+
+	tests := []struct {
+		name           string
+		comments       []github.IssueComment
+		expectApproved bool
+	}{
+		{
+			name:     "deletions-only approval leaves modified files unapproved",
+			comments: []github.IssueComment{newTestComment("root", "/approve deletions-only")},
+		},
+		{
+			name:           "deletions-only approval plus approval of modified files",
+			comments:       []github.IssueComment{newTestComment("root", "/approve deletions-only"), newTestComment("bob", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:           "plain approval covers everything",
+			comments:       []github.IssueComment{newTestComment("root", "/approve")},
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+			fghc := newFakeGitHubClient(false, false, nil, test.comments, nil)
+			fghc.PullRequestChanges[prNumber] = []github.PullRequestChange{
+				{Filename: "a/a.go", Status: github.PullRequestFileRemoved},
+				{Filename: "b/b.go", Status: string(github.PullRequestFileModified)},
+			}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestRequireApprovalForDeletions(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	rsa := true
+	no := false
+
+	tests := []struct {
+		name                        string
+		requireApprovalForDeletions *bool
+		changes                     []github.PullRequestChange
+		comments                    []github.IssueComment
+		expectApproved              bool
+	}{
+		{
+			name:     "deletion-only PR needs the directory owner by default",
+			changes:  []github.PullRequestChange{{Filename: "a/a.go", Status: github.PullRequestFileRemoved}},
+			comments: []github.IssueComment{newTestComment("bob", "/approve")},
+		},
+		{
+			name:           "deletion-only PR approved by the directory owner",
+			changes:        []github.PullRequestChange{{Filename: "a/a.go", Status: github.PullRequestFileRemoved}},
+			comments:       []github.IssueComment{newTestComment("alice", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:     "deleted files need approval next to modified ones by default",
+			changes:  []github.PullRequestChange{{Filename: "a/a.go", Status: github.PullRequestFileRemoved}, {Filename: "b/b.go", Status: string(github.PullRequestFileModified)}},
+			comments: []github.IssueComment{newTestComment("bob", "/approve")},
+		},
+		{
+			name:                        "deleted files are left out of the approval if disabled",
+			requireApprovalForDeletions: &no,
+			changes:                     []github.PullRequestChange{{Filename: "a/a.go", Status: github.PullRequestFileRemoved}, {Filename: "b/b.go", Status: string(github.PullRequestFileModified)}},
+			comments:                    []github.IssueComment{newTestComment("bob", "/approve")},
+			expectApproved:              true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, RequireApprovalForDeletions: test.requireApprovalForDeletions}
+			fghc := newFakeGitHubClient(false, false, nil, test.comments, nil)
+			fghc.PullRequestChanges[prNumber] = test.changes
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestAutoApproveRules(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"manifests": layeredsets.NewString("alice"), "src": layeredsets.NewString("bob")},
+		leafApprovers:  map[string]sets.String{"manifests": sets.NewString("alice"), "src": sets.NewString("bob")},
+		approverOwners: map[string]string{"manifests/app.yaml": "manifests", "src/main.go": "src"},
+	}
+	rsa := true
+	rules := []plugins.AutoApproveRule{{Author: "Manifest-Bot", PathFilter: "^manifests/", PathRe: regexp.MustCompile("^manifests/")}}
+
+	tests := []struct {
+		name           string
+		author         string
+		files          []string
+		comments       []github.IssueComment
+		expectApproved bool
+	}{
+		{
+			name:           "matching author and paths auto-approve",
+			author:         "manifest-bot",
+			files:          []string{"manifests/app.yaml"},
+			expectApproved: true,
+		},
+		{
+			name:   "other author is not auto-approved",
+			author: "cjwagner",
+			files:  []string{"manifests/app.yaml"},
+		},
+		{
+			name:   "files outside the path filter still need approval",
+			author: "manifest-bot",
+			files:  []string{"manifests/app.yaml", "src/main.go"},
+		},
+		{
+			name:           "files outside the path filter approved by their owners",
+			author:         "manifest-bot",
+			files:          []string{"manifests/app.yaml", "src/main.go"},
+			comments:       []github.IssueComment{newTestComment("bob", "/approve")},
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, AutoApproveRules: rules}
+			fghc := newFakeGitHubClient(false, false, test.files, test.comments, nil)
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: test.author}
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestFirstTimeInstructions(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+
+	tests := []struct {
+		name               string
+		enabled            bool
+		notOpened          bool
+		merged             []*github.Issue
+		expectInstructions bool
+	}{
+		{
+			name:               "first-time author gets instructions",
+			enabled:            true,
+			expectInstructions: true,
+		},
+		{
+			name:    "repeat contributor gets no instructions",
+			enabled: true,
+			merged:  []*github.Issue{{Number: 7, User: github.User{Login: "cjwagner"}}},
+		},
+		{
+			name:      "first-time author gets no instructions on other events",
+			enabled:   true,
+			notOpened: true,
+		},
+		{
+			name: "no instructions without first_time_instructions",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, FirstTimeInstructions: test.enabled, CommandHelpLink: "https://go.k8s.io/bot-commands"}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, nil, nil)
+			fghc.Issues = map[int]*github.Issue{}
+			for _, issue := range test.merged {
+				fghc.Issues[issue.Number] = issue
+			}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner", opened: !test.notOpened}
+			// The instructions are posted on the first event only.
+			for i := 0; i < 2; i++ {
+				runHandle(t, fghc, fr, opts, pr)
+			}
+			var instructions int
+			for _, c := range fghc.IssueComments[prNumber] {
+				if strings.Contains(c.Body, firstTimeInstructionsMarker) {
+					instructions++
+				}
+			}
+			expected := 0
+			if test.expectInstructions {
+				expected = 1
+			}
+			if instructions != expected {
+				t.Errorf("Expected %d instructions comments, but got %d: %v.", expected, instructions, fghc.IssueComments[prNumber])
+			}
+		})
+	}
+}
+
+func TestQuorumMode(t *testing.T) {
+	fr := fakeRepo{
+		approvers: map[string]layeredsets.String{
+			"a": layeredsets.NewString("alice"),
+			"b": layeredsets.NewString("bob"),
+			"c": layeredsets.NewString("carol"),
+		},
+		leafApprovers: map[string]sets.String{
+			"a": sets.NewString("alice"),
+			"b": sets.NewString("bob"),
+			"c": sets.NewString("carol"),
+		},
+		approverOwners: map[string]string{"a/a.go": "a", "b/b.go": "b", "c/c.go": "c"},
+	}
+	rsa := true
+	comments := []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("bob", "/approve")}
+
+	for _, quorum := range []bool{false, true} {
+		t.Run(fmt.Sprintf("quorum mode %t", quorum), func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, QuorumMode: quorum, QuorumCount: 2}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go", "c/c.go"}, comments, nil)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != quorum {
+				t.Errorf("Expected approved: %t, but got labels %v.", quorum, fghc.IssueLabelsAdded)
+			}
+			notification := fghc.IssueComments[prNumber][len(fghc.IssueComments[prNumber])-1].Body
+			if hasQuorum := strings.Contains(notification, "needs the approval of 2 distinct approvers"); hasQuorum != quorum {
+				t.Errorf("Expected the quorum to be explained: %t, but got %q.", quorum, notification)
+			}
+		})
+	}
+}
+
+func TestEnforceRequiredReviewers(t *testing.T) {
+	fr := fakeRepo{
+		approvers:         map[string]layeredsets.String{"a": layeredsets.NewString("alice", "anne")},
+		leafApprovers:     map[string]sets.String{"a": sets.NewString("alice", "anne")},
+		approverOwners:    map[string]string{"a/a.go": "a"},
+		requiredReviewers: map[string]sets.String{"a": sets.NewString("anne")},
+	}
+	rsa := true
+
+	tests := []struct {
+		name           string
+		enforce        bool
+		approver       string
+		expectApproved bool
+	}{
+		{
+			name:           "required reviewer approves",
+			enforce:        true,
+			approver:       "anne",
+			expectApproved: true,
+		},
+		{
+			name:     "approver outside the required reviewers",
+			enforce:  true,
+			approver: "alice",
+		},
+		{
+			name:           "required reviewers are not enforced without enforce_required_reviewers",
+			approver:       "alice",
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, EnforceRequiredReviewers: test.enforce}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment(test.approver, "/approve")}, nil)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestCommentsWithoutAuthor(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa, irs := true, false
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, IgnoreReviewState: &irs}
+
+	ghost := github.IssueComment{Body: "/approve cancel", HTMLURL: "https://github.com/org/repo/pull/1#issuecomment-1"}
+	comments := []github.IssueComment{newTestComment("alice", "/approve"), ghost}
+	reviews := []github.Review{{Body: "/approve cancel", State: github.ReviewStateChangesRequested}}
+	reviewComments := []github.ReviewComment{{Body: "/approve cancel", ReviewID: 7}}
+
+	if got := commentsFromIssueComments(comments); len(got) != 1 || got[0].Author != "alice" {
+		t.Errorf("Expected only the comment of alice, but got %v.", got)
+	}
+	if got := commentsFromReviewsAndReviewComments(reviews, reviewComments); len(got) != 0 {
+		t.Errorf("Expected no comments, but got %v.", got)
+	}
+	if isApprovalCommand(func(string) bool { return false }, false, commentFromIssueComment(&ghost)) {
+		t.Error("Expected the comment of a deleted account not to be an approval command.")
+	}
+
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, comments, reviews)
+	fghc.PullRequestReviewComments = map[int][]github.ReviewComment{prNumber: reviewComments}
+	pr := newTestState()
+	runHandle(t, fghc, fr, opts, pr)
+	if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+		t.Errorf("Expected the approval of alice to stand, but got labels %v.", fghc.IssueLabelsAdded)
+	}
+
+	if got := sourceLink("comment", ""); got != "" {
+		t.Errorf("Expected no link for an unknown URL, but got %q.", got)
+	}
+	if got, expected := sourceLink("review", "https://example.com"), " ([review](https://example.com))"; got != expected {
+		t.Errorf("Expected %q, but got %q.", expected, got)
+	}
+}
+
+func TestConditionalApproval(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+
+	tests := []struct {
+		name           string
+		comments       []github.IssueComment
+		dependency     *github.PullRequest
+		expectApproved bool
+	}{
+		{
+			name:       "approval waits for the open dependency",
+			comments:   []github.IssueComment{newTestComment("alice", "/approve after:#123")},
+			dependency: &github.PullRequest{Number: 123, State: "open"},
+		},
+		{
+			name:           "approval counts once the dependency merged",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve after:#123")},
+			dependency:     &github.PullRequest{Number: 123, State: "closed", Merged: true},
+			expectApproved: true,
+		},
+		{
+			name:       "closed but unmerged dependency withholds the approval",
+			comments:   []github.IssueComment{newTestComment("alice", "/approve after:#123")},
+			dependency: &github.PullRequest{Number: 123, State: "closed"},
+		},
+		{
+			name:     "unknown dependency withholds the approval",
+			comments: []github.IssueComment{newTestComment("alice", "/approve after:#123")},
+		},
+		{
+			name:       "conditional approval replaces an earlier approval",
+			comments:   []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("alice", "/approve after:123")},
+			dependency: &github.PullRequest{Number: 123, State: "open"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{}
+			if test.dependency != nil {
+				fghc.PullRequests[test.dependency.Number] = test.dependency
+			}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestSensitivePaths(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"": layeredsets.NewString("alice")},
+		leafApprovers:  map[string]sets.String{"": sets.NewString("alice")},
+		approverOwners: map[string]string{"security/keys.go": "", "docs/README.md": ""},
+	}
+	rsa := true
+
+	tests := []struct {
+		name           string
+		files          []string
+		comments       []github.IssueComment
+		expectApproved bool
+		expectReason   string
+	}{
+		{
+			name:     "bare approval doesn't approve sensitive paths",
+			files:    []string{"security/keys.go"},
+			comments: []github.IssueComment{newTestComment("alice", "/approve")},
+		},
+		{
+			name:           "approval with a reason approves sensitive paths",
+			files:          []string{"security/keys.go"},
+			comments:       []github.IssueComment{newTestComment("alice", `/approve reason:"Rotated after the Q3 audit"`)},
+			expectApproved: true,
+			expectReason:   `(reason: "Rotated after the Q3 audit")`,
+		},
+		{
+			name:           "single word reason",
+			files:          []string{"security/keys.go"},
+			comments:       []github.IssueComment{newTestComment("alice", "/approve no-issue reason:CVE-2021-1234")},
+			expectApproved: true,
+			expectReason:   `(reason: "CVE-2021-1234")`,
+		},
+		{
+			name:     "empty reason doesn't approve sensitive paths",
+			files:    []string{"security/keys.go"},
+			comments: []github.IssueComment{newTestComment("alice", `/approve reason:""`)},
+		},
+		{
+			name:           "bare approval approves other paths",
+			files:          []string{"docs/README.md"},
+			comments:       []github.IssueComment{newTestComment("alice", "/approve")},
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, SensitivePaths: []string{"security/"}}
+			fghc := newFakeGitHubClient(false, false, test.files, test.comments, nil)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+			var notification string
+			for _, c := range fghc.IssueComments[prNumber] {
+				if strings.HasPrefix(c.Body, "[APPROVALNOTIFIER]") {
+					notification = c.Body
+				}
+			}
+			if test.expectReason != "" && !strings.Contains(notification, test.expectReason) {
+				t.Errorf("Expected the notification to contain %q, got:\n%s", test.expectReason, notification)
+			}
+			if instructions := strings.Contains(notification, "sensitive paths"); instructions == test.expectApproved {
+				t.Errorf("Expected the notification to explain sensitive paths: %t, got:\n%s", !test.expectApproved, notification)
+			}
+		})
+	}
+}
+
+func TestAliasApproval(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"a": layeredsets.NewString("alice", "bob")},
+		leafApprovers:  map[string]sets.String{"a": sets.NewString("alice", "bob")},
+		approverOwners: map[string]string{"a/a.go": "a"},
+		aliases:        map[string]sets.String{"security-reviewers": sets.NewString("alice")},
+	}
+	rsa := true
+
+	tests := []struct {
+		name           string
+		comments       []github.IssueComment
+		expectApproval string
+		unexpected     string
+	}{
+		{
+			name:           "member approves as the alias",
+			comments:       []github.IssueComment{newTestComment("Alice", "/approve as:security-reviewers")},
+			expectApproval: `title="Approved as security-reviewers">Alice</a>* (as security-reviewers)`,
+		},
+		{
+			name:           "alias of a non-member is ignored",
+			comments:       []github.IssueComment{newTestComment("bob", "/approve as:security-reviewers")},
+			expectApproval: `title="Approved">bob</a>*`,
+			unexpected:     "(as security-reviewers)",
+		},
+		{
+			name:           "unknown alias is ignored",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve as:release-managers")},
+			expectApproval: `title="Approved">alice</a>*`,
+			unexpected:     "(as release-managers)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if !sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+				t.Errorf("Expected the PR to be approved, but got labels %v.", fghc.IssueLabelsAdded)
+			}
+			var notification string
+			for _, c := range fghc.IssueComments[prNumber] {
+				if strings.HasPrefix(c.Body, "[APPROVALNOTIFIER]") {
+					notification = c.Body
+				}
+			}
+			if !strings.Contains(notification, test.expectApproval) {
+				t.Errorf("Expected the notification to contain %q, got:\n%s", test.expectApproval, notification)
+			}
+			if test.unexpected != "" && strings.Contains(notification, test.unexpected) {
+				t.Errorf("Expected the notification not to contain %q, got:\n%s", test.unexpected, notification)
+			}
+		})
+	}
+}
+
+func TestScopedApproval(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"alice"}})
+	rsa := true
+
+	tests := []struct {
+		name           string
+		comments       []github.IssueComment
+		expectApproved bool
+	}{
+		{
+			name:     "files changed after the reviewed commit need approval",
+			comments: []github.IssueComment{newTestComment("alice", "/approve upto:aaaaaaa")},
+		},
+		{
+			name:           "approval up to the last commit covers everything",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve upto:bbbbbbb")},
+			expectApproved: true,
+		},
+		{
+			name:     "commit outside of the PR withholds the approval",
+			comments: []github.IssueComment{newTestComment("alice", "/approve upto:ccccccc")},
+		},
+		{
+			name:           "later approval covers the remaining files",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve upto:aaaaaaa"), newTestComment("alice", "/approve")},
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go"}, test.comments, nil)
+			fghc.CommitMap = map[string][]github.RepositoryCommit{
+				"org/repo#1": {{SHA: "aaaaaaa1111"}, {SHA: "bbbbbbb2222"}},
+			}
+			fghc.Commits = map[string]github.RepositoryCommit{
+				"aaaaaaa1111": {SHA: "aaaaaaa1111", Files: []github.CommitFile{{Filename: "a/a.go"}, {Filename: "b/b.go"}}},
+				"bbbbbbb2222": {SHA: "bbbbbbb2222", Files: []github.CommitFile{{Filename: "b/b.go"}}},
+			}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestApprovalDependency(t *testing.T) {
+	tests := []struct {
+		args         string
+		expectNumber int
+		expectRest   string
+		expectErr    bool
+	}{
+		{args: "", expectNumber: 0},
+		{args: "after:#123", expectNumber: 123},
+		{args: "after:42 no-issue", expectNumber: 42, expectRest: "no-issue"},
+		{args: "after:#abc", expectErr: true},
+		{args: "after:#0", expectErr: true},
+	}
+	for _, test := range tests {
+		number, rest, err := approvalDependency(test.args)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%q: expected error: %t, but got %v.", test.args, test.expectErr, err)
+			continue
+		}
+		if err == nil && (number != test.expectNumber || rest != test.expectRest) {
+			t.Errorf("%q: expected %d and %q, but got %d and %q.", test.args, test.expectNumber, test.expectRest, number, rest)
+		}
+	}
+}
+
+func TestRequireIssueCommand(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+
+	tests := []struct {
+		name           string
+		body           string
+		comments       []github.IssueComment
+		expectApproved bool
+	}{
+		{
+			name:           "approved without require-issue",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve")},
+			expectApproved: true,
+		},
+		{
+			name:     "require-issue withholds approval without an issue",
+			comments: []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("alice", "/approve require-issue")},
+		},
+		{
+			name:           "linked issue satisfies require-issue",
+			body:           "Fixes #42",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("alice", "/approve require-issue")},
+			expectApproved: true,
+		},
+		{
+			name:     "require-issue by an admin approver",
+			comments: []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("admin", "/approve require-issue")},
+		},
+		{
+			name:           "require-issue by a non-approver is ignored",
+			comments:       []github.IssueComment{newTestComment("alice", "/approve"), newTestComment("mallory", "/approve require-issue")},
+			expectApproved: true,
+		},
+		{
+			name:     "require-issue doesn't approve",
+			body:     "Fixes #42",
+			comments: []github.IssueComment{newTestComment("alice", "/approve require-issue")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, AdminApprovers: []string{"admin"}}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner", body: test.body}
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestFreezeWindows(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	windows := []plugins.FreezeWindow{{
+		StartTime: time.Date(2020, 12, 18, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC),
+	}}
+	defer func() {
+		pluginClock = clock.RealClock{}
+	}()
+
+	tests := []struct {
+		name           string
+		now            time.Time
+		expectApproved bool
+		expectFrozen   bool
+	}{
+		{
+			name:           "before the freeze",
+			now:            time.Date(2020, 12, 17, 23, 59, 0, 0, time.UTC),
+			expectApproved: true,
+		},
+		{
+			name:         "during the freeze",
+			now:          time.Date(2020, 12, 18, 0, 0, 0, 0, time.UTC),
+			expectFrozen: true,
+		},
+		{
+			name:           "after the freeze",
+			now:            time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC),
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pluginClock = clock.NewFakeClock(test.now)
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, FreezeWindows: windows}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil)
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectApproved, fghc.IssueLabelsAdded)
+			}
+			notification := fghc.IssueComments[prNumber][len(fghc.IssueComments[prNumber])-1].Body
+			frozen := strings.Contains(notification, "Approvals are frozen until 2021-01-04 00:00 UTC.")
+			if frozen != test.expectFrozen {
+				t.Errorf("Expected frozen notification: %t, but got %q.", test.expectFrozen, notification)
+			}
+		})
+	}
+}
+
+func TestIsForkPR(t *testing.T) {
+	pr := func(head, base string) *github.PullRequest {
+		return &github.PullRequest{
+			Head: github.PullRequestBranch{Repo: github.Repo{FullName: head}},
+			Base: github.PullRequestBranch{Repo: github.Repo{FullName: base}},
+		}
+	}
+	tests := []struct {
+		name     string
+		pr       *github.PullRequest
+		expected bool
+	}{
+		{name: "same repo", pr: pr("org/repo", "org/repo")},
+		{name: "same repo with different case", pr: pr("Org/Repo", "org/repo")},
+		{name: "fork", pr: pr("user/repo", "org/repo"), expected: true},
+		{name: "deleted fork", pr: pr("", "org/repo"), expected: true},
+	}
+	for _, test := range tests {
+		if got := isForkPR(test.pr); got != test.expected {
+			t.Errorf("%s: expected fork: %t, but got %t.", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestAddApproversSHA(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	const head = "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b"
+
+	tests := []struct {
+		name           string
+		body           string
+		headSHA        string
+		expectApproved bool
+	}{
+		{
+			name:           "approval matching the head",
+			body:           "/approve sha:1a2b3c4",
+			headSHA:        head,
+			expectApproved: true,
+		},
+		{
+			name:           "approval matching the full head",
+			body:           "/approve sha:" + strings.ToUpper(head),
+			headSHA:        head,
+			expectApproved: true,
+		},
+		{
+			name:    "approval of a stale commit",
+			body:    "/approve sha:deadbeef",
+			headSHA: head,
+		},
+		{
+			name: "approval with an unknown head",
+			body: "/approve sha:1a2b3c4",
+		},
+		{
+			name:    "too short SHA does not approve",
+			body:    "/approve sha:1a2",
+			headSHA: head,
+		},
+		{
+			name:    "stale approval replaces an earlier approval",
+			body:    "/approve\n/approve sha:deadbeef",
+			headSHA: head,
+		},
+		{
+			name:           "no-issue approval matching the head",
+			body:           "/approve no-issue sha:1a2b3c4",
+			headSHA:        head,
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owners := approvers.NewOwners(logrus.WithField("plugin", "approve"), []string{"a/a.go"}, fr, prNumber)
+			ap := approvers.NewApprovers(owners)
+			addApprovers(&ap, []*comment{{Author: "alice", Body: test.body, HTMLURL: "#1"}}, "cjwagner", test.headSHA, owners, &plugins.Approve{})
+			if got := ap.AreFilesApproved(); got != test.expectApproved {
+				t.Errorf("Expected approved: %t, but got %t.", test.expectApproved, got)
+			}
+		})
+	}
+}
+
+func TestParseApprovalCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		args     string
+		expected approvalCommand
+	}{
+		{
+			name:     "plain approval",
+			command:  approveCommand,
+			expected: approvalCommand{name: approveCommand},
+		},
+		{
+			name:     "remove-approve cancels",
+			command:  removeApproveCommand,
+			args:     "whatever",
+			expected: approvalCommand{name: removeApproveCommand, cancel: true},
+		},
+		{
+			name:     "cancel with targets",
+			command:  approveCommand,
+			args:     "cancel @Alice @bob",
+			expected: approvalCommand{name: approveCommand, cancel: true, targets: []string{"alice", "bob"}},
+		},
+		{
+			name:     "forced approval keeps the case of its reason",
+			command:  approveCommand,
+			args:     "force Release Blocker",
+			expected: approvalCommand{name: approveCommand, forced: true, forceReason: "Release Blocker"},
+		},
+		{
+			name:     "refresh neither approves nor cancels",
+			command:  approveCommand,
+			args:     "refresh",
+			expected: approvalCommand{name: approveCommand, other: true},
+		},
+		{
+			name:     "invalid duration neither approves nor cancels",
+			command:  approveCommand,
+			args:     "for:soon",
+			expected: approvalCommand{name: approveCommand, other: true},
+		},
+		{
+			name:    "all arguments of an approval",
+			command: approveCommand,
+			args:    `no-issue for:72h sha:1A2B3C4 after:#12 upto:abcdef0 as:Security reason:"Checked the Threat Model"`,
+			expected: approvalCommand{
+				name:       approveCommand,
+				noIssue:    true,
+				duration:   72 * time.Hour,
+				sha:        "1a2b3c4",
+				dependency: 12,
+				upto:       "abcdef0",
+				alias:      "security",
+				reason:     "Checked the Threat Model",
+			},
+		},
+		{
+			name:     "reason mentioning cancel doesn't cancel",
+			command:  approveCommand,
+			args:     `reason:"cancel risk reviewed"`,
+			expected: approvalCommand{name: approveCommand, reason: "cancel risk reviewed"},
+		},
+		{
+			name:     "stack approval",
+			command:  approveCommand,
+			args:     "stack",
+			expected: approvalCommand{name: approveCommand, stack: true},
+		},
+		{
+			name:     "deletions-only approval",
+			command:  lgtmCommand,
+			args:     "deletions-only",
+			expected: approvalCommand{name: lgtmCommand, deletionsOnly: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.expected, parseApprovalCommand(test.command, test.args, "cancel"), cmp.AllowUnexported(approvalCommand{})); diff != "" {
+				t.Errorf("Parsed command differs from expected (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnifyLgtmApprove(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"a": layeredsets.NewString("alice", "bob")},
+		leafApprovers:  map[string]sets.String{"a": sets.NewString("alice", "bob")},
+		reviewers:      map[string]layeredsets.String{"a": layeredsets.NewString("alice", "rhonda")},
+		approverOwners: map[string]string{"a/a.go": "a"},
+	}
+	rsa := true
+
+	tests := []struct {
+		name           string
+		unify          bool
+		actor          string
+		body           string
+		expectApproved bool
+		expectLGTM     bool
+	}{
+		{
+			name:           "maintainer approve applies both labels",
+			unify:          true,
+			actor:          "alice",
+			body:           "/approve",
+			expectApproved: true,
+			expectLGTM:     true,
+		},
+		{
+			name:           "maintainer lgtm applies both labels",
+			unify:          true,
+			actor:          "alice",
+			body:           "/lgtm",
+			expectApproved: true,
+			expectLGTM:     true,
+		},
+		{
+			name:           "approver that is not a reviewer only approves",
+			unify:          true,
+			actor:          "bob",
+			body:           "/approve",
+			expectApproved: true,
+		},
+		{
+			name:       "lgtm of a reviewer that is not an approver does not approve",
+			unify:      true,
+			actor:      "rhonda",
+			body:       "/lgtm",
+			expectLGTM: true,
+		},
+		{
+			name:           "maintainer approve only approves when disabled",
+			actor:          "alice",
+			body:           "/approve",
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment(test.actor, test.body)}, nil)
+			fghc.IssueLabelsAdded = nil
+			if test.body == "/lgtm" {
+				// The lgtm plugin applies the label on /lgtm.
+				fghc.IssueLabelsExisting = []string{fmt.Sprintf("org/repo#%v:%s", prNumber, labels.LGTM)}
+			}
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, UnifyLgtmApprove: test.unify}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner", actor: test.actor, commentBody: test.body}
+			runHandle(t, fghc, fr, opts, pr)
+			prLabels, err := fghc.GetIssueLabels("org", "repo", prNumber)
+			if err != nil {
+				t.Fatalf("Unexpected error getting labels: %v.", err)
+			}
+			got := sets.NewString()
+			for _, label := range prLabels {
+				got.Insert(label.Name)
+			}
+			if got.Has(labels.Approved) != test.expectApproved {
+				t.Errorf("Expected the PR to be approved: %t, but got labels %v.", test.expectApproved, got.List())
+			}
+			if got.Has(labels.LGTM) != test.expectLGTM {
+				t.Errorf("Expected the PR to have the lgtm label: %t, but got labels %v.", test.expectLGTM, got.List())
+			}
+		})
+	}
+}
+
+func TestAddApproversRequiresOwners(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"a": layeredsets.NewString("alice")},
+		leafApprovers:  map[string]sets.String{"a": sets.NewString("alice")},
+		reviewers:      map[string]layeredsets.String{"a": layeredsets.NewString("rhonda")},
+		approverOwners: map[string]string{"a/a.go": "a"},
+	}
+	opts := &plugins.Approve{LgtmActsAsApprove: true}
+
+	tests := []struct {
+		name            string
+		comments        []*comment
+		expectApprovers []string
+	}{
+		{
+			name:            "approval from an approver counts",
+			comments:        []*comment{{Author: "alice", Body: "/approve"}},
+			expectApprovers: []string{"alice"},
+		},
+		{
+			name:     "approval from a random user is ignored",
+			comments: []*comment{{Author: "random", Body: "/approve"}},
+		},
+		{
+			name:     "approval from a reviewer is ignored",
+			comments: []*comment{{Author: "rhonda", Body: "/approve"}},
+		},
+		{
+			name:            "lgtm from a reviewer counts",
+			comments:        []*comment{{Author: "Rhonda", Body: "/lgtm"}},
+			expectApprovers: []string{"rhonda"},
+		},
+		{
+			name:            "lgtm from an approver counts",
+			comments:        []*comment{{Author: "alice", Body: "/lgtm"}},
+			expectApprovers: []string{"alice"},
+		},
+		{
+			name:     "lgtm from a random user is ignored",
+			comments: []*comment{{Author: "random", Body: "/lgtm"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owners := approvers.NewOwners(logrus.WithField("plugin", "approve"), []string{"a/a.go"}, fr, prNumber)
+			ap := approvers.NewApprovers(owners)
+			addApprovers(&ap, test.comments, "cjwagner", "", owners, opts)
+			if got, expected := ap.GetCurrentApproversSet(), sets.NewString(test.expectApprovers...); !got.Equal(expected) {
+				t.Errorf("Expected approvers %v, but got %v.", expected.List(), got.List())
+			}
+		})
+	}
+}
+
+func TestAllowedBotApprovers(t *testing.T) {
+	isBot := func(login string) bool { return strings.HasSuffix(login, "[bot]") }
+	opts := &plugins.Approve{
+		AllowedBotApprovers: []string{"release-bot"},
+		ExcludedApprovers:   []string{"alice"},
+	}
+	isIgnoredApprover := ignoredApproverChecker(isBot, opts)
+
+	tests := []struct {
+		name          string
+		comment       *comment
+		expectCounted bool
+	}{
+		{
+			name:          "human approval counts",
+			comment:       &comment{Author: "bob", Body: "/approve"},
+			expectCounted: true,
+		},
+		{
+			name:          "allowlisted bot approval counts",
+			comment:       &comment{Author: "release-bot[bot]", Body: "/approve"},
+			expectCounted: true,
+		},
+		{
+			name:    "default bot approval is filtered",
+			comment: &comment{Author: "k8s-ci-robot[bot]", Body: "/approve"},
+		},
+		{
+			name:    "excluded approver approval is filtered",
+			comment: &comment{Author: "Alice", Body: "/approve"},
+		},
+		{
+			name:    "excluded approver review is filtered",
+			comment: &comment{Author: "alice", ReviewState: github.ReviewStateApproved},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := approvalMatcher(isIgnoredApprover, false, true)(test.comment); got != test.expectCounted {
+				t.Errorf("Expected approval to be counted: %t, but got %t.", test.expectCounted, got)
+			}
+		})
+	}
+}
+
+func TestLegacyNotificationFormat(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, LegacyNotificationFormat: true}
+	pr := newTestState()
+
+	legacyNotification := newTestComment(deprecatedBotName, "[APPROVALNOTIFIER] This PR is **NOT APPROVED**\n\nThis pull-request has been approved by:")
+	legacyNotification.ID = 42
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{legacyNotification, newTestComment("alice", "/approve")}, nil)
+	runHandle(t, fghc, fr, opts, pr)
+
+	if expected := []string{"org/repo#42"}; !reflect.DeepEqual(fghc.IssueCommentsDeleted, expected) {
+		t.Errorf("Expected the deprecated bot's notification to be deleted (%v), but got %v.", expected, fghc.IssueCommentsDeleted)
+	}
+	if len(fghc.IssueCommentsAdded) != 1 {
+		t.Fatalf("Expected exactly one notification, but got %v.", fghc.IssueCommentsAdded)
+	}
+	notification := fghc.IssueCommentsAdded[0]
+	if !strings.Contains(notification, "Needs approval from an approver in each of these OWNERS Files:") {
+		t.Errorf("Expected the notification to be in the legacy format, but got %q.", notification)
+	}
+	if status, err := approvers.ParseNotificationStatus(notification); err != nil || status != nil {
+		t.Errorf("Expected the legacy notification not to carry an approval status, but got %v and %v.", status, err)
+	}
+}
+
+func TestIsApprovalCommand(t *testing.T) {
+	isBot := func(string) bool { return false }
+	tests := []struct {
+		name        string
+		body        string
+		expectMatch bool
+	}{
+		{
+			name:        "approve",
+			body:        "/approve",
+			expectMatch: true,
+		},
+		{
+			name:        "approve with an extra leading slash",
+			body:        "//approve",
+			expectMatch: true,
+		},
+		{
+			name:        "approve with an extra leading slash and arguments",
+			body:        "looks good\n//approve no-issue",
+			expectMatch: true,
+		},
+		{
+			name: "approve with too many leading slashes",
+			body: "///approve",
+		},
+		{
+			name: "URL",
+			body: "http://example.com/approve",
+		},
+		{
+			name: "protocol-relative URL",
+			body: "//example.com/approve",
+		},
+		{
+			name: "approve not at the start of the line",
+			body: "please /approve",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isApprovalCommand(isBot, false, &comment{Author: "alice", Body: test.body}); got != test.expectMatch {
+				t.Errorf("Expected %q to be an approval command: %t, but got %t.", test.body, test.expectMatch, got)
+			}
+		})
+	}
+}
+
+func TestNotificationMatcher(t *testing.T) {
+	isBot := func(login string) bool { return login == "k8s-ci-robot" }
+	current := "[APPROVALNOTIFIER] This PR is **APPROVED**\n\nThis pull-request has been approved by: *<a href=\"REFERENCE\" title=\"Approved\">alice</a>*\n<!-- APPROVAL_STATUS={\"approved\":true} -->"
+	legacy := "[APPROVALNOTIFIER] This PR is **APPROVED**\n\nThis pull-request has been approved by: *<a href=\"REFERENCE\" title=\"Approved\">alice</a>*\n\nNeeds approval from an approver in each of these OWNERS Files:"
+
+	tests := []struct {
+		name        string
+		legacy      bool
+		comment     *comment
+		expectMatch bool
+	}{
+		{
+			name:        "current notification",
+			comment:     &comment{Author: "k8s-ci-robot", Body: current},
+			expectMatch: true,
+		},
+		{
+			name:        "legacy format notification by the bot",
+			comment:     &comment{Author: "k8s-ci-robot", Body: legacy},
+			expectMatch: true,
+		},
+		{
+			name:    "deprecated bot notification without legacy mode",
+			comment: &comment{Author: deprecatedBotName, Body: legacy},
+		},
+		{
+			name:        "deprecated bot notification in legacy mode",
+			legacy:      true,
+			comment:     &comment{Author: deprecatedBotName, Body: legacy},
+			expectMatch: true,
+		},
+		{
+			name:        "current notification in legacy mode",
+			legacy:      true,
+			comment:     &comment{Author: "k8s-ci-robot", Body: current},
+			expectMatch: true,
+		},
+		{
+			name:    "notification by a user in legacy mode",
+			legacy:  true,
+			comment: &comment{Author: "alice", Body: legacy},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := notificationMatcher(isBot, test.legacy)(test.comment); got != test.expectMatch {
+				t.Errorf("Expected match: %t, but got %t.", test.expectMatch, got)
+			}
+		})
+	}
+}
+
+type botCheckerClient struct {
+	*fakegithub.FakeClient
+	botLogin string
+}
+
+func (c botCheckerClient) BotUserChecker() (func(candidate string) bool, error) {
+	return func(candidate string) bool { return candidate == c.botLogin }, nil
+}
+
+type issueEventsErrorClient struct {
+	*fakegithub.FakeClient
+}
+
+func (c issueEventsErrorClient) ListIssueEvents(org, repo string, num int) ([]github.ListedIssueEvent, error) {
+	return nil, errors.New("injected error")
+}
+
+type issueEventsCountingClient struct {
+	*fakegithub.FakeClient
+	calls int
+}
+
+func (c *issueEventsCountingClient) ListIssueEvents(org, repo string, num int) ([]github.ListedIssueEvent, error) {
+	c.calls++
+	return c.FakeClient.ListIssueEvents(org, repo, num)
+}
+
+func TestIssueEventsListedOnce(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{
+		Repos:                    []string{"org/repo"},
+		RequireSelfApproval:      &rsa,
+		TreatDismissalAsCancel:   true,
+		SkipDrafts:               true,
+		IgnoreDraftApprovals:     true,
+		RequireLgtmAfterApproval: true,
+	}
+	fghc := newFakeGitHubClient(true, false, []string{"a/a.go"}, nil, nil)
+	ghc := &issueEventsCountingClient{FakeClient: fghc}
+	pr := newTestState()
+	runHandle(t, ghc, fr, opts, pr)
+	if ghc.calls != 1 {
+		t.Errorf("Expected the issue events to be listed once, but they were listed %d times.", ghc.calls)
+	}
+}
+
+func TestIssueEventsFailure(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+
+	for _, hasLabel := range []bool{true, false} {
+		t.Run(fmt.Sprintf("has label: %t", hasLabel), func(t *testing.T) {
+			fghc := newFakeGitHubClient(hasLabel, false, []string{"a/a.go"}, nil, nil)
+			pr := newTestState()
+			runHandle(t, issueEventsErrorClient{FakeClient: fghc}, fr, opts, pr)
+			if len(fghc.IssueLabelsRemoved) != 0 {
+				t.Errorf("Expected the label to be preserved, but got removed labels %v.", fghc.IssueLabelsRemoved)
+			}
+			if got := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); got != hasLabel {
+				t.Errorf("Expected the approved label: %t, but got labels %v.", hasLabel, fghc.IssueLabelsAdded)
+			}
+			// The label is only preserved, the PR isn't reported as approved.
+			for _, c := range fghc.IssueComments[prNumber] {
+				if strings.Contains(c.Body, "**APPROVED**") || strings.Contains(c.Body, "bypassed by manually added approval") {
+					t.Errorf("Expected the PR not to be reported as approved, but got notification:\n%s", c.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanupNotifications(t *testing.T) {
+	start := time.Now()
+	notification := func(id int, at time.Time) github.IssueComment {
+		c := newTestCommentTime(at, "k8s-ci-robot", "[APPROVALNOTIFIER] This PR is **NOT APPROVED**")
+		c.ID = id
+		return c
+	}
+	other := newTestCommentTime(start, "k8s-ci-robot", "/lgtm")
+	other.ID = 10
+	impostor := newTestCommentTime(start, "alice", "[APPROVALNOTIFIER] This PR is **APPROVED**")
+	impostor.ID = 11
+
+	tests := []struct {
+		name          string
+		comments      []github.IssueComment
+		cancel        bool
+		expectDeleted []string
+		expectErr     bool
+	}{
+		{
+			name: "stale notifications are reduced to the latest",
+			comments: []github.IssueComment{
+				// Comments are ordered by when they were created, not listed.
+				notification(3, start.Add(3*time.Minute)),
+				notification(1, start.Add(time.Minute)),
+				other,
+				notification(2, start.Add(2*time.Minute)),
+				impostor,
+			},
+			expectDeleted: []string{"org/repo#1", "org/repo#2"},
+		},
+		{
+			name:     "single notification is kept",
+			comments: []github.IssueComment{notification(1, start), other},
+		},
+		{
+			name:     "no notifications",
+			comments: []github.IssueComment{other, impostor},
+		},
+		{
+			name:      "done context stops the cleanup",
+			comments:  []github.IssueComment{notification(1, start), notification(2, start.Add(time.Minute))},
+			cancel:    true,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, nil, test.comments, nil)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				cancel()
+			}
+			err := CleanupNotifications(ctx, fghc, "org", "repo", prNumber)
+			if test.expectErr != (err != nil) {
+				t.Errorf("Expected error: %t, but got %v.", test.expectErr, err)
+			}
+			if !reflect.DeepEqual(fghc.IssueCommentsDeleted, test.expectDeleted) {
+				t.Errorf("Expected deleted comments %v, but got %v.", test.expectDeleted, fghc.IssueCommentsDeleted)
+			}
+		})
+	}
+}
+
+type openPullRequestsClient struct {
+	*fakegithub.FakeClient
+	prs []github.PullRequest
+}
+
+func (c openPullRequestsClient) GetPullRequests(org, repo string) ([]github.PullRequest, error) {
+	return c.prs, nil
+}
+
+func TestLabelStaleUnapprovedPRs(t *testing.T) {
+	now := time.Date(2021, time.March, 4, 10, 0, 0, 0, time.UTC)
+	defer func() {
+		pluginClock = clock.RealClock{}
+	}()
+	pluginClock = clock.NewFakeClock(now)
+	pr := func(number int, age time.Duration, labelNames ...string) github.PullRequest {
+		p := github.PullRequest{Number: number, CreatedAt: now.Add(-age)}
+		for _, name := range labelNames {
+			p.Labels = append(p.Labels, github.Label{Name: name})
+		}
+		return p
+	}
+	draft := pr(6, 60*24*time.Hour)
+	draft.Draft = true
+
+	tests := []struct {
+		name          string
+		label         string
+		prs           []github.PullRequest
+		expectAdded   []string
+		expectRemoved []string
+	}{
+		{
+			name: "stale unapproved PRs are labeled",
+			prs: []github.PullRequest{
+				pr(1, 60*24*time.Hour),
+				pr(2, 24*time.Hour),
+				pr(3, 60*24*time.Hour, labels.Approved),
+				pr(4, 60*24*time.Hour, "needs-approver"),
+				draft,
+			},
+			expectAdded: []string{"org/repo#1:needs-approver"},
+		},
+		{
+			name:          "label is removed once approved",
+			prs:           []github.PullRequest{pr(5, 60*24*time.Hour, labels.Approved, "needs-approver")},
+			expectRemoved: []string{"org/repo#5:needs-approver"},
+		},
+		{
+			name:        "configured label",
+			label:       "approval/stale",
+			prs:         []github.PullRequest{pr(1, 60*24*time.Hour)},
+			expectAdded: []string{"org/repo#1:approval/stale"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := fakegithub.NewFakeClient()
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, StaleApprovalLabel: test.label}
+			if err := LabelStaleUnapprovedPRs(context.Background(), openPullRequestsClient{FakeClient: fghc, prs: test.prs}, opts, "org", "repo", 30*24*time.Hour); err != nil {
+				t.Fatalf("Unexpected error: %v.", err)
+			}
+			if !reflect.DeepEqual(fghc.IssueLabelsAdded, test.expectAdded) {
+				t.Errorf("Expected added labels %v, but got %v.", test.expectAdded, fghc.IssueLabelsAdded)
+			}
+			if !reflect.DeepEqual(fghc.IssueLabelsRemoved, test.expectRemoved) {
+				t.Errorf("Expected removed labels %v, but got %v.", test.expectRemoved, fghc.IssueLabelsRemoved)
+			}
+		})
+	}
+}
+
+type countingBotCheckerClient struct {
+	*fakegithub.FakeClient
+	lock  sync.Mutex
+	calls int
+}
+
+func (c *countingBotCheckerClient) BotUserChecker() (func(candidate string) bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls++
+	return c.FakeClient.BotUserChecker()
+}
+
+func TestBotUserCheckerIsCached(t *testing.T) {
+	defer botUserCheckers.reset()
+
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+	ghc := &countingBotCheckerClient{FakeClient: newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil)}
+	for i := 0; i < 3; i++ {
+		pr := newTestState()
+		runHandle(t, ghc, fr, opts, pr)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := botUserCheckers.get(ghc); err != nil {
+				t.Errorf("Unexpected error: %v.", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ghc.calls != 1 {
+		t.Errorf("Expected BotUserChecker to be called once, but it was called %d times.", ghc.calls)
+	}
+
+	botUserCheckers.reset()
+	if _, err := botUserCheckers.get(ghc); err != nil {
+		t.Fatalf("Unexpected error: %v.", err)
+	}
+	if ghc.calls != 2 {
+		t.Errorf("Expected BotUserChecker to be called again after a reset, but it was called %d times.", ghc.calls)
+	}
+}
+
+func TestNormalizedBotUserChecker(t *testing.T) {
+	notification := "[APPROVALNOTIFIER] This PR is **APPROVED**\n\nThis pull-request has been approved by: *<a href=\"REFERENCE\" title=\"Approved\">alice</a>*"
+	for _, botLogin := range []string{"myapp", "myapp[bot]"} {
+		for _, author := range []string{"myapp", "myapp[bot]"} {
+			t.Run(fmt.Sprintf("bot %s, author %s", botLogin, author), func(t *testing.T) {
+				fghc := fakegithub.NewFakeClient()
+				fghc.IssueEvents = map[int][]github.ListedIssueEvent{prNumber: {{
+					Event: github.IssueActionLabeled,
+					Label: github.Label{Name: labels.Approved},
+					Actor: github.User{Login: author},
+				}}}
+				ghc := botCheckerClient{FakeClient: fghc, botLogin: botLogin}
+				isBot, err := normalizedBotUserChecker(ghc)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v.", err)
+				}
+				if isBot("other[bot]") {
+					t.Error("Expected other[bot] not to be the bot.")
+				}
+				if isApprovalCommand(isBot, false, &comment{Author: author, Body: "/approve"}) {
+					t.Error("Expected the approval command of the bot to be ignored.")
+				}
+				if !notificationMatcher(isBot, false)(&comment{Author: author, Body: notification}) {
+					t.Error("Expected the notification of the bot to match.")
+				}
+				pr := &state{org: "org", repo: "repo", number: prNumber}
+				if humanAddedApproved(logrus.WithField("plugin", "approve"), issueEventsLister(ghc, pr), pr, isBot, true)() {
+					t.Error("Expected the label added by the bot not to count as added by a human.")
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateNotification(t *testing.T) {
+	fr := fakeRepo{
+		approvers: map[string]layeredsets.String{
+			"a": layeredsets.NewString("alice"),
+			"b": layeredsets.NewString("bob"),
+		},
+		leafApprovers: map[string]sets.String{
+			"a": sets.NewString("alice"),
+			"b": sets.NewString("bob"),
+		},
+		approverOwners: map[string]string{"a/a.go": "a", "b/b.go": "b"},
+	}
+	linkURL := &url.URL{Scheme: "https", Host: "github.com"}
+	newHandler := func(logins ...string) approvers.Approvers {
+		ap := approvers.NewApprovers(approvers.NewOwners(logrus.WithField("plugin", "approve"), []string{"a/a.go", "b/b.go"}, fr, prNumber))
+		for _, approver := range logins {
+			ap.AddApprover(approver, "REFERENCE", false)
+		}
+		return ap
+	}
+	withFooter := func(footer string, ap approvers.Approvers) approvers.Approvers {
+		ap.NotificationFooter = footer
+		return ap
+	}
+	message := func(ap approvers.Approvers) string {
+		return *approvers.GetMessage(ap, linkURL, "", "", "org", "repo", "master")
+	}
+	// Swapping the lines of the two OWNERS files is a purely cosmetic change.
+	reorder := func(body string) string {
+		lines := strings.Split(body, "\n")
+		a, b := -1, -1
+		for i, line := range lines {
+			if strings.Contains(line, "a/OWNERS") {
+				a = i
+			}
+			if strings.Contains(line, "b/OWNERS") {
+				b = i
+			}
+		}
+		lines[a], lines[b] = lines[b], lines[a]
+		return strings.Join(lines, "\n")
+	}
+	legacy := func(body string) string {
+		return regexp.MustCompile(`\n<!-- APPROVAL_STATUS=.* -->`).ReplaceAllString(body, "")
+	}
+
+	tests := []struct {
+		name          string
+		latest        string
+		handler       approvers.Approvers
+		expectMessage bool
+	}{
+		{
+			name:    "identical notification",
+			latest:  message(newHandler("alice")),
+			handler: newHandler("alice"),
+		},
+		{
+			name:    "notification differing only in file ordering",
+			latest:  reorder(message(newHandler("alice"))),
+			handler: newHandler("alice"),
+		},
+		{
+			name:          "approvers changed",
+			latest:        message(newHandler("alice")),
+			handler:       newHandler("alice", "bob"),
+			expectMessage: true,
+		},
+		{
+			name:    "identical footer",
+			latest:  message(withFooter("See CONTRIBUTING.md.", newHandler("alice"))),
+			handler: withFooter("See CONTRIBUTING.md.", newHandler("alice")),
+		},
+		{
+			name:          "footer added",
+			latest:        message(newHandler("alice")),
+			handler:       withFooter("See CONTRIBUTING.md.", newHandler("alice")),
+			expectMessage: true,
+		},
+		{
+			name:          "footer changed",
+			latest:        message(withFooter("See CONTRIBUTING.md.", newHandler("alice"))),
+			handler:       withFooter("See the contributor guide.", newHandler("alice")),
+			expectMessage: true,
+		},
+		{
+			name:          "notification without hash differing in file ordering",
+			latest:        legacy(reorder(message(newHandler("alice")))),
+			handler:       newHandler("alice"),
+			expectMessage: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.latest == reorder(test.latest) {
+				t.Fatalf("Expected the notification to list both OWNERS files, but got %q.", test.latest)
+			}
+			got := updateNotification(linkURL, "", "", "org", "repo", "master", &comment{Body: test.latest}, test.handler)
+			if (got != nil) != test.expectMessage {
+				t.Errorf("Expected new message: %t, but got %v.", test.expectMessage, got)
+			}
+		})
+	}
+}
+
+// TODO: cache approvers 'GetFilesApprovers' and 'GetCCs' since these are called repeatedly and are
+// expensive.
+
+type fakeOwnersClient struct {
+	repo fakeRepo
+	err  error
+}
+
+func (foc fakeOwnersClient) LoadRepoOwners(org, repo, base string) (repoowners.RepoOwner, error) {
+	if foc.err != nil {
+		return nil, foc.err
+	}
+	return fakeRepoOwners{fakeRepo: foc.repo}, nil
+}
+
+type fakeRepoOwners struct {
+	fakeRepo
+}
+
+func (fro fakeRepoOwners) FindLabelsForFile(path string) sets.String {
+	return sets.NewString()
+}
+
+func (fro fakeRepoOwners) FindReviewersOwnersForFile(path string) string {
+	return ""
+}
+
+func (fro fakeRepoOwners) LeafReviewers(path string) sets.String {
+	return sets.NewString()
+}
+
+func (fro fakeRepoOwners) Reviewers(path string) layeredsets.String {
+	return layeredsets.NewString()
+}
+
+func (fro fakeRepoOwners) RequiredReviewers(path string) sets.String {
+	return sets.NewString()
+}
+
+func TestHandleGenericComment(t *testing.T) {
+	tests := []struct {
+		name              string
+		commentEvent      github.GenericCommentEvent
+		lgtmActsAsApprove bool
+		expectHandle      bool
+		expectState       *state
+	}{
+		{
+			name: "valid approve command",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionCreated,
+				IsPR:   true,
+				Body:   "/approve",
+				Number: 1,
+				User: github.User{
+					Login: "author",
+				},
+				IssueBody: "Fix everything",
+				IssueAuthor: github.User{
+					Login: "P.R. Author",
+				},
+			},
+			expectHandle: true,
+			expectState: &state{
+				org:         "org",
+				repo:        "repo",
+				branch:      "branch",
+				number:      1,
+				body:        "Fix everything",
+				author:      "P.R. Author",
+				assignees:   nil,
+				htmlURL:     "",
+				actor:       "author",
+				commentBody: "/approve",
+			},
+		},
+		{
+			name: "comment edited to approve",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionEdited,
+				IsPR:   true,
+				Body:   "/approve",
+				Number: 1,
+				User: github.User{
+					Login: "author",
+				},
+			},
+			expectHandle: true,
+		},
+		{
+			name: "comment edited to no longer approve",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionEdited,
+				IsPR:   true,
+				Body:   "stuff",
+				Number: 1,
+				User: github.User{
+					Login: "author",
+				},
+			},
+			expectHandle: true,
+		},
+		{
+			name: "comment edited by a bot",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionEdited,
+				IsPR:   true,
+				Body:   "stuff",
+				Number: 1,
+				User: github.User{
+					Login: fakegithub.Bot,
+				},
+			},
+			expectHandle: false,
+		},
+		{
+			name: "comment deleted",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionDeleted,
+				IsPR:   true,
+				Body:   "/approve",
+				Number: 1,
+				User: github.User{
+					Login: "author",
+				},
+			},
+			expectHandle: false,
+		},
+		{
+			name: "not PR",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionEdited,
+				IsPR:   false,
+				Body:   "/approve",
+				Number: 1,
+				User: github.User{
+					Login: "author",
+				},
+			},
+			expectHandle: false,
+		},
+		{
+			name: "closed PR",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionCreated,
+				IsPR:   true,
+				Body:   "/approve",
+				Number: 1,
 				User: github.User{
 					Login: "author",
 				},
@@ -1493,111 +5837,994 @@ func TestHandleGenericComment(t *testing.T) {
 			expectHandle: false,
 		},
 		{
-			name: "no approve command",
-			commentEvent: github.GenericCommentEvent{
-				Action: github.GenericCommentActionCreated,
-				IsPR:   true,
-				Body:   "stuff",
-				Number: 1,
-				User: github.User{
-					Login: "author",
-				},
+			name: "no approve command",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionCreated,
+				IsPR:   true,
+				Body:   "stuff",
+				Number: 1,
+				User: github.User{
+					Login: "author",
+				},
+			},
+			expectHandle: false,
+		},
+		{
+			name: "lgtm without lgtmActsAsApprove",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionCreated,
+				IsPR:   true,
+				Body:   "/lgtm",
+				Number: 1,
+				User: github.User{
+					Login: "author",
+				},
+			},
+			expectHandle: false,
+		},
+		{
+			name: "lgtm with lgtmActsAsApprove",
+			commentEvent: github.GenericCommentEvent{
+				Action: github.GenericCommentActionCreated,
+				IsPR:   true,
+				Body:   "/lgtm",
+				Number: 1,
+				User: github.User{
+					Login: "author",
+				},
+			},
+			lgtmActsAsApprove: true,
+			expectHandle:      true,
+		},
+	}
+
+	var handled bool
+	var gotState *state
+	handleFunc = func(log *logrus.Entry, ghc GitHubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr *state) error {
+		gotState = pr
+		handled = true
+		return nil
+	}
+	defer func() {
+		handleFunc = handle
+	}()
+
+	repo := github.Repo{
+		Owner: github.User{
+			Login: "org",
+		},
+		Name: "repo",
+	}
+	pr := github.PullRequest{
+		Base: github.PullRequestBranch{
+			Ref: "branch",
+		},
+		Number: 1,
+	}
+	botUserCheckers.reset()
+	fghc := fakegithub.NewFakeClient()
+	fghc.PullRequests = map[int]*github.PullRequest{1: &pr}
+
+	for _, test := range tests {
+		test.commentEvent.Repo = repo
+		githubConfig := config.GitHubOptions{
+			LinkURL: &url.URL{
+				Scheme: "https",
+				Host:   "github.com",
+			},
+		}
+		config := &plugins.Configuration{}
+		config.Approve = append(config.Approve, plugins.Approve{
+			Repos:             []string{test.commentEvent.Repo.Owner.Login},
+			LgtmActsAsApprove: test.lgtmActsAsApprove,
+		})
+		err := handleGenericComment(
+			logrus.WithField("plugin", "approve"),
+			fghc,
+			fakeOwnersClient{},
+			githubConfig,
+			config,
+			&test.commentEvent,
+		)
+
+		if test.expectHandle && !handled {
+			t.Errorf("%s: expected call to handleFunc, but it wasn't called", test.name)
+		}
+
+		if !test.expectHandle && handled {
+			t.Errorf("%s: expected no call to handleFunc, but it was called", test.name)
+		}
+
+		if test.expectState != nil && !reflect.DeepEqual(test.expectState, gotState) {
+			t.Errorf("%s: expected PR state to equal: %#v, but got: %#v", test.name, test.expectState, gotState)
+		}
+
+		if err != nil {
+			t.Errorf("%s: error calling handleGenericComment: %v", test.name, err)
+		}
+		handled = false
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{
+		Repos:               []string{"org"},
+		RequireSelfApproval: &rsa,
+		AdminApprovers:      []string{"admin"},
+	}}}
+
+	tests := []struct {
+		name        string
+		comments    []github.IssueComment
+		commenter   string
+		expectLabel bool
+	}{
+		{
+			name:        "refresh by an admin recomputes the label without new approvals",
+			comments:    []github.IssueComment{newTestComment("alice", "/approve")},
+			commenter:   "admin",
+			expectLabel: true,
+		},
+		{
+			name:      "refresh by a user that is not an admin is ignored",
+			comments:  []github.IssueComment{newTestComment("alice", "/approve")},
+			commenter: "bob",
+		},
+		{
+			name:      "refresh does not count as an approval",
+			commenter: "admin",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The approved label was never added, e.g. because an event was missed.
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, test.comments, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+			event := github.GenericCommentEvent{
+				Action:      github.GenericCommentActionCreated,
+				IsPR:        true,
+				Body:        "/approve refresh",
+				Number:      prNumber,
+				User:        github.User{Login: test.commenter},
+				IssueAuthor: github.User{Login: "cjwagner"},
+				Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+			}
+			if err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event); err != nil {
+				t.Fatalf("Unexpected error handling event: %v.", err)
+			}
+			if got := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); got != test.expectLabel {
+				t.Errorf("Expected the approved label to be added: %t, but got labels %v.", test.expectLabel, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+// branchOwnersClient resolves different OWNERS per base branch.
+type branchOwnersClient map[string]fakeRepo
+
+func (boc branchOwnersClient) LoadRepoOwners(org, repo, base string) (repoowners.RepoOwner, error) {
+	fr, ok := boc[base]
+	if !ok {
+		return nil, fmt.Errorf("no OWNERS for branch %q", base)
+	}
+	return fakeRepoOwners{fakeRepo: fr}, nil
+}
+
+func TestApprovalFollowsBaseBranchOwners(t *testing.T) {
+	ownedBy := func(approver string) fakeRepo {
+		return newTestRepo(map[string][]string{"a": {approver}})
+	}
+	oc := branchOwnersClient{
+		"master":      ownedBy("alice"),
+		"release-1.0": ownedBy("bob"),
+	}
+	rsa := true
+	pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{
+		Repos:               []string{"org"},
+		RequireSelfApproval: &rsa,
+	}}}
+
+	tests := []struct {
+		name        string
+		base        string
+		approver    string
+		expectLabel bool
+	}{
+		{
+			name:        "approver of the default branch approves a PR against it",
+			base:        "master",
+			approver:    "alice",
+			expectLabel: true,
+		},
+		{
+			name:     "approver of the default branch can't approve a PR against a release branch",
+			base:     "release-1.0",
+			approver: "alice",
+		},
+		{
+			name:        "approver of the release branch approves a PR against it",
+			base:        "release-1.0",
+			approver:    "bob",
+			expectLabel: true,
+		},
+		{
+			name:     "approver of the release branch can't approve a PR against the default branch",
+			base:     "master",
+			approver: "bob",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment(test.approver, "/approve")}, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: test.base}, Number: prNumber}}
+			event := github.GenericCommentEvent{
+				Action:      github.GenericCommentActionCreated,
+				IsPR:        true,
+				Body:        "/approve",
+				Number:      prNumber,
+				User:        github.User{Login: test.approver},
+				IssueAuthor: github.User{Login: "cjwagner"},
+				Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+			}
+			if err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, oc, testGitHubConfig, pluginConfig, &event); err != nil {
+				t.Fatalf("Unexpected error handling event: %v.", err)
+			}
+			if got := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); got != test.expectLabel {
+				t.Errorf("Expected the approved label to be added: %t, but got labels %v.", test.expectLabel, fghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+func TestManageLabelExclusively(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	no := false
+
+	tests := []struct {
+		name          string
+		exclusive     *bool
+		labeledBy     string
+		expectRemoved bool
+	}{
+		{
+			name:          "exclusive by default, removing labels of unknown origin",
+			expectRemoved: true,
+		},
+		{
+			name:      "label of unknown origin is preserved",
+			exclusive: &no,
+		},
+		{
+			name:          "label added by the bot is removed",
+			exclusive:     &no,
+			labeledBy:     fakegithub.Bot,
+			expectRemoved: true,
+		},
+		{
+			name:      "label added by another tool is preserved as a manual approval",
+			labeledBy: "other-tool[bot]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, ManageLabelExclusively: test.exclusive}
+			fghc := newFakeGitHubClient(true, false, []string{"a/a.go"}, nil, nil)
+			if test.labeledBy != "" {
+				fghc.IssueEvents[prNumber] = []github.ListedIssueEvent{{
+					Event: github.IssueActionLabeled,
+					Label: github.Label{Name: labels.Approved},
+					Actor: github.User{Login: test.labeledBy},
+				}}
+			}
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if removed := sets.NewString(fghc.IssueLabelsRemoved...).Has(approvedLabel); removed != test.expectRemoved {
+				t.Errorf("Expected removed: %t, but got removed labels %v.", test.expectRemoved, fghc.IssueLabelsRemoved)
+			}
+		})
+	}
+}
+
+func TestClosedDuringProcessing(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+
+	tests := []struct {
+		name          string
+		current       *github.PullRequest
+		expectChanges bool
+	}{
+		{
+			name:          "open PR is updated",
+			current:       &github.PullRequest{Number: prNumber, State: "open"},
+			expectChanges: true,
+		},
+		{
+			name:          "PR that can't be looked up is updated",
+			expectChanges: true,
+		},
+		{
+			name:    "merged PR isn't updated",
+			current: &github.PullRequest{Number: prNumber, State: "closed", Merged: true},
+		},
+		{
+			name:    "closed PR isn't updated",
+			current: &github.PullRequest{Number: prNumber, State: "closed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &plugins.Approve{Repos: []string{"org/repo"}}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{}
+			if test.current != nil {
+				fghc.PullRequests[prNumber] = test.current
+			}
+			// The PR was still open when the event was received.
+			pr := newTestState()
+			runHandle(t, fghc, fr, opts, pr)
+			if approved := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel); approved != test.expectChanges {
+				t.Errorf("Expected approved: %t, but got labels %v.", test.expectChanges, fghc.IssueLabelsAdded)
+			}
+			if commented := len(fghc.IssueComments[prNumber]) > 1; commented != test.expectChanges {
+				t.Errorf("Expected a notification: %t, but got comments %v.", test.expectChanges, fghc.IssueComments[prNumber])
+			}
+
+			// Nor are designated approvers assigned.
+			fghc = newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go"}, []github.IssueComment{newTestComment("alice", "/approve assign @bob")}, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{}
+			if test.current != nil {
+				fghc.PullRequests[prNumber] = test.current
+			}
+			runHandle(t, fghc, fr, opts, pr)
+			if assigned := len(fghc.AssigneesAdded) != 0; assigned != test.expectChanges {
+				t.Errorf("Expected designated approvers to be assigned: %t, but got assignees %v.", test.expectChanges, fghc.AssigneesAdded)
+			}
+		})
+	}
+}
+
+func TestApprovalMetrics(t *testing.T) {
+	fr := fakeRepo{
+		approvers: map[string]layeredsets.String{
+			"a":   layeredsets.NewString("alice"),
+			"b/c": layeredsets.NewString("bob"),
+		},
+		leafApprovers: map[string]sets.String{
+			"a":   sets.NewString("alice"),
+			"b/c": sets.NewString("bob"),
+		},
+		approverOwners: map[string]string{"a/a.go": "a", "b/c/c.go": "b/c"},
+	}
+	opts := &plugins.Approve{Repos: []string{"org/repo"}}
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go", "b/c/c.go"}, nil, nil)
+	pr := newTestState()
+
+	suggestedApprovers.Reset()
+	ownersDepth.Reset()
+	runHandle(t, fghc, fr, opts, pr)
+
+	expectedSuggestedApprovers := `
+	# HELP approve_suggested_approvers Number of approvers suggested in the approval notification of a PR.
+	# TYPE approve_suggested_approvers histogram
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="0"} 0
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="1"} 0
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="2"} 1
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="3"} 1
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="5"} 1
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="8"} 1
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="13"} 1
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="21"} 1
+	approve_suggested_approvers_bucket{org="org",repo="repo",le="+Inf"} 1
+	approve_suggested_approvers_sum{org="org",repo="repo"} 2
+	approve_suggested_approvers_count{org="org",repo="repo"} 1
+	`
+	if err := testutil.CollectAndCompare(suggestedApprovers, strings.NewReader(expectedSuggestedApprovers)); err != nil {
+		t.Errorf("Unexpected metrics for suggested approvers:\n%s", err)
+	}
+	expectedOwnersDepth := `
+	# HELP approve_owners_max_depth Directory depth of the deepest OWNERS file that needs to approve a PR.
+	# TYPE approve_owners_max_depth histogram
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="0"} 0
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="1"} 0
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="2"} 1
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="3"} 1
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="4"} 1
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="5"} 1
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="6"} 1
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="8"} 1
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="10"} 1
+	approve_owners_max_depth_bucket{org="org",repo="repo",le="+Inf"} 1
+	approve_owners_max_depth_sum{org="org",repo="repo"} 2
+	approve_owners_max_depth_count{org="org",repo="repo"} 1
+	`
+	if err := testutil.CollectAndCompare(ownersDepth, strings.NewReader(expectedOwnersDepth)); err != nil {
+		t.Errorf("Unexpected metrics for OWNERS depth:\n%s", err)
+	}
+}
+
+func TestRequireLgtmLabel(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	lgtmLabel := fmt.Sprintf("org/repo#%v:lgtm", prNumber)
+	rsa := true
+
+	tests := []struct {
+		name             string
+		comments         []github.IssueComment
+		requireLgtmLabel bool
+		hasLgtmLabel     bool
+		hasLabel         bool
+		expectLabel      bool
+		expectRemoved    bool
+		expectExplained  bool
+		expectStatus     string
+	}{
+		{
+			name:             "approved PR with the lgtm label",
+			comments:         []github.IssueComment{newTestComment("alice", "/approve")},
+			requireLgtmLabel: true,
+			hasLgtmLabel:     true,
+			expectLabel:      true,
+			expectStatus:     github.StatusSuccess,
+		},
+		{
+			name:             "approved PR without the lgtm label",
+			comments:         []github.IssueComment{newTestComment("alice", "/approve")},
+			requireLgtmLabel: true,
+			expectExplained:  true,
+			expectStatus:     github.StatusPending,
+		},
+		{
+			name:             "PR with the lgtm label but without approval",
+			requireLgtmLabel: true,
+			hasLgtmLabel:     true,
+			expectStatus:     github.StatusPending,
+		},
+		{
+			name:             "approved label of the bot is removed with the lgtm label",
+			comments:         []github.IssueComment{newTestComment("alice", "/approve")},
+			requireLgtmLabel: true,
+			hasLabel:         true,
+			expectRemoved:    true,
+			expectExplained:  true,
+			expectStatus:     github.StatusPending,
+		},
+		{
+			name:         "approved PR without the lgtm label when it isn't required",
+			comments:     []github.IssueComment{newTestComment("alice", "/approve")},
+			expectLabel:  true,
+			expectStatus: github.StatusSuccess,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(test.hasLabel, false, []string{"a/a.go"}, test.comments, nil)
+			if test.hasLabel {
+				fghc.IssueEvents[prNumber] = append(fghc.IssueEvents[prNumber], github.ListedIssueEvent{Event: github.IssueActionLabeled, Label: github.Label{Name: labels.Approved}, Actor: github.User{Login: fakegithub.Bot}})
+			}
+			if !test.hasLgtmLabel {
+				fghc.IssueLabelsAdded = sets.NewString(fghc.IssueLabelsAdded...).Delete(lgtmLabel).List()
+			}
+			opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, RequireLgtmLabel: test.requireLgtmLabel, PublishCommitStatus: true}
+			pr := &state{org: "org", repo: "repo", branch: "master", number: prNumber, author: "cjwagner", headSHA: "abc"}
+			runHandle(t, fghc, fr, opts, pr)
+			if got := sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) && !test.hasLabel; got != test.expectLabel {
+				t.Errorf("Expected the approved label to be added: %t, but got labels %v.", test.expectLabel, fghc.IssueLabelsAdded)
+			}
+			if got := sets.NewString(fghc.IssueLabelsRemoved...).Has(approvedLabel); got != test.expectRemoved {
+				t.Errorf("Expected the approved label to be removed: %t, but got removed labels %v.", test.expectRemoved, fghc.IssueLabelsRemoved)
+			}
+			if got := fghc.CreatedStatuses["abc"]; len(got) != 1 || got[0].State != test.expectStatus {
+				t.Errorf("Expected a %q status, but got %v.", test.expectStatus, got)
+			}
+			explained := false
+			for _, ic := range fghc.IssueComments[prNumber] {
+				if strings.Contains(ic.Body, "label will be applied once the PR has the `lgtm` label") {
+					explained = true
+				}
+			}
+			if explained != test.expectExplained {
+				t.Errorf("Expected the notification to explain the missing lgtm: %t, but got comments %v.", test.expectExplained, fghc.IssueComments[prNumber])
+			}
+		})
+	}
+}
+
+func TestMisspelledCommands(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected []string
+	}{
+		{body: "/aprove", expected: []string{"approve"}},
+		{body: "/approove no-issue", expected: []string{"approve"}},
+		{body: "/lgmt", expected: []string{"lgtm"}},
+		{body: "/approve"},
+		{body: "/lgtm"},
+		{body: "/approve cancel"},
+		{body: "/hold"},
+		{body: "/retest"},
+		{body: "please aprove"},
+	}
+	for _, test := range tests {
+		if got := misspelledCommands(test.body); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%q: expected %v, but got %v.", test.body, test.expected, got)
+		}
+	}
+}
+
+func TestSuggestOnTypo(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+
+	tests := []struct {
+		name         string
+		suggest      bool
+		comments     []string
+		expectedHint int
+	}{
+		{
+			name:         "typo gets a hint",
+			suggest:      true,
+			comments:     []string{"/aprove"},
+			expectedHint: 1,
+		},
+		{
+			name:         "repeated typos of the same commenter get a single hint",
+			suggest:      true,
+			comments:     []string{"/aprove", "/approove"},
+			expectedHint: 1,
+		},
+		{
+			name:     "exact commands get no hint",
+			suggest:  true,
+			comments: []string{"/approve", "/lgtm"},
+		},
+		{
+			name:     "typos get no hint without suggest_on_typo",
+			comments: []string{"/aprove"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{Repos: []string{"org"}, SuggestOnTypo: test.suggest}}}
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, nil, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+			for _, body := range test.comments {
+				event := github.GenericCommentEvent{
+					Action:      github.GenericCommentActionCreated,
+					IsPR:        true,
+					Body:        body,
+					Number:      prNumber,
+					User:        github.User{Login: "alice"},
+					IssueAuthor: github.User{Login: "cjwagner"},
+					Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+				}
+				if err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event); err != nil {
+					t.Fatalf("Unexpected error handling event: %v.", err)
+				}
+			}
+			var hints int
+			for _, c := range fghc.IssueComments[prNumber] {
+				if strings.Contains(c.Body, typoHintMarker("alice")) {
+					hints++
+				}
+			}
+			if hints != test.expectedHint {
+				t.Errorf("Expected %d hints, but got %d: %v.", test.expectedHint, hints, fghc.IssueComments[prNumber])
+			}
+		})
+	}
+}
+
+func TestDump(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	rsa := true
+	pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{
+		Repos:               []string{"org"},
+		RequireSelfApproval: &rsa,
+		AdminApprovers:      []string{"admin"},
+	}}}
+
+	tests := []struct {
+		name       string
+		commenter  string
+		expectDump bool
+	}{
+		{
+			name:       "dump by an admin posts the approval state",
+			commenter:  "admin",
+			expectDump: true,
+		},
+		{
+			name:      "dump by a user that is not an admin is ignored",
+			commenter: "bob",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+			labelsBefore := append([]string{}, fghc.IssueLabelsAdded...)
+			event := github.GenericCommentEvent{
+				Action:      github.GenericCommentActionCreated,
+				IsPR:        true,
+				Body:        "/approve dump",
+				Number:      prNumber,
+				User:        github.User{Login: test.commenter},
+				IssueAuthor: github.User{Login: "cjwagner"},
+				Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+			}
+			if err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event); err != nil {
+				t.Fatalf("Unexpected error handling event: %v.", err)
+			}
+			if !reflect.DeepEqual(fghc.IssueLabelsAdded, labelsBefore) || len(fghc.IssueLabelsRemoved) != 0 {
+				t.Errorf("Expected labels to be left untouched, but added %v and removed %v.", fghc.IssueLabelsAdded, fghc.IssueLabelsRemoved)
+			}
+			var dumps []string
+			for _, c := range fghc.IssueComments[prNumber] {
+				if strings.Contains(c.Body, "<details>") {
+					dumps = append(dumps, c.Body)
+				}
+			}
+			if !test.expectDump {
+				if len(dumps) != 0 {
+					t.Errorf("Expected no dump, but got %v.", dumps)
+				}
+				return
+			}
+			if len(dumps) != 1 {
+				t.Fatalf("Expected one dump, but got %v.", dumps)
+			}
+			body := dumps[0]
+			start, end := strings.Index(body, "```json\n"), strings.LastIndex(body, "\n```")
+			if start < 0 || end < start {
+				t.Fatalf("Expected a JSON block, but got %q.", body)
+			}
+			var dump approvalDump
+			if err := json.Unmarshal([]byte(body[start+len("```json\n"):end]), &dump); err != nil {
+				t.Fatalf("Expected valid JSON, but got %v: %q.", err, body)
+			}
+			if !reflect.DeepEqual(dump.Status.Approvers, []string{"alice"}) {
+				t.Errorf("Expected approvers [alice], but got %v.", dump.Status.Approvers)
+			}
+			if !reflect.DeepEqual(dump.Status.UnapprovedDirs, []string{"b"}) {
+				t.Errorf("Expected unapproved dirs [b], but got %v.", dump.Status.UnapprovedDirs)
+			}
+			if dump.Options == nil || !reflect.DeepEqual(dump.Options.AdminApprovers, []string{"admin"}) {
+				t.Errorf("Expected the options in effect, but got %+v.", dump.Options)
+			}
+		})
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	rsa := true
+	pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{Repos: []string{"org"}, RequireSelfApproval: &rsa}}}
+
+	tests := []struct {
+		name            string
+		commenter       string
+		body            string
+		expectedMessage string
+	}{
+		{
+			name:            "approval of the missing approver would complete approval",
+			commenter:       "cjwagner",
+			body:            "/approve simulate @bob",
+			expectedMessage: "If @bob approved, this PR would be approved.",
+		},
+		{
+			name:            "approval of a user that isn't an approver wouldn't complete approval",
+			commenter:       "cjwagner",
+			body:            "/approve simulate @carol",
+			expectedMessage: "If @carol approved, this PR would still not be approved. These OWNERS files would still need approval: `b/OWNERS`. Approval would be blocked by: `unapproved-files`.",
+		},
+		{
+			name:            "simulation by an approver doesn't approve",
+			commenter:       "bob",
+			body:            "/approve simulate @alice",
+			expectedMessage: "If @alice approved, this PR would still not be approved. These OWNERS files would still need approval: `b/OWNERS`. Approval would be blocked by: `unapproved-files`.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simulation := newTestComment(test.commenter, test.body)
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go"}, []github.IssueComment{newTestComment("alice", "/approve"), simulation}, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+			labelsBefore := append([]string{}, fghc.IssueLabelsAdded...)
+			event := github.GenericCommentEvent{
+				Action:      github.GenericCommentActionCreated,
+				IsPR:        true,
+				Body:        test.body,
+				Number:      prNumber,
+				User:        github.User{Login: test.commenter},
+				IssueAuthor: github.User{Login: "cjwagner"},
+				Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+			}
+			if err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event); err != nil {
+				t.Fatalf("Unexpected error handling event: %v.", err)
+			}
+			if !reflect.DeepEqual(fghc.IssueLabelsAdded, labelsBefore) || len(fghc.IssueLabelsRemoved) != 0 {
+				t.Errorf("Expected labels to be left untouched, but added %v and removed %v.", fghc.IssueLabelsAdded, fghc.IssueLabelsRemoved)
+			}
+			var messages []string
+			for _, c := range fghc.IssueComments[prNumber] {
+				if strings.HasPrefix(c.Body, "If @") {
+					messages = append(messages, c.Body)
+				}
+			}
+			if !reflect.DeepEqual(messages, []string{test.expectedMessage}) {
+				t.Errorf("Expected the simulation %q, but got %q.", test.expectedMessage, messages)
+			}
+		})
+	}
+}
+
+func TestApprovalHistory(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice", "bob"}})
+	rsa := true
+	start := time.Date(2021, time.March, 4, 10, 0, 0, 0, time.UTC)
+	comment := func(at time.Time, user, body string) github.IssueComment {
+		c := newTestCommentTime(at, user, body)
+		c.HTMLURL = fmt.Sprintf("https://github.com/org/repo/pull/%d#%s", prNumber, user)
+		return c
+	}
+
+	tests := []struct {
+		name               string
+		cancelKeyword      string
+		anonymizeApprovers bool
+		comments           []github.IssueComment
+		reviews            []github.Review
+		expectedMessage    string
+	}{
+		{
+			name:            "no approvals",
+			comments:        []github.IssueComment{comment(start, "alice", "looks good")},
+			expectedMessage: "Nobody approved or cancelled approval of this PR yet.",
+		},
+		{
+			name: "approvals and cancellations in chronological order",
+			comments: []github.IssueComment{
+				comment(start.Add(3*time.Hour), "alice", "/approve cancel"),
+				comment(start, "alice", "/approve"),
+				comment(start.Add(time.Hour), "bob", "/lgtm"),
+				comment(start.Add(4*time.Hour), "alice", "/approve refresh"),
 			},
-			expectHandle: false,
+			reviews: []github.Review{newTestReviewTime(start.Add(2*time.Hour), "bob", "", github.ReviewStateApproved)},
+			expectedMessage: `Approval history of this PR:
+
+- 2021-03-04 10:00 UTC @alice approved ([comment](https://github.com/org/repo/pull/1#alice))
+- 2021-03-04 11:00 UTC @bob lgtm'd ([comment](https://github.com/org/repo/pull/1#bob))
+- 2021-03-04 12:00 UTC @bob approved
+- 2021-03-04 13:00 UTC @alice cancelled their approval ([comment](https://github.com/org/repo/pull/1#alice))`,
 		},
 		{
-			name: "lgtm without lgtmActsAsApprove",
-			commentEvent: github.GenericCommentEvent{
-				Action: github.GenericCommentActionCreated,
-				IsPR:   true,
-				Body:   "/lgtm",
-				Number: 1,
-				User: github.User{
-					Login: "author",
-				},
+			name:          "lgtm is cancelled as with the lgtm plugin regardless of the cancel keyword",
+			cancelKeyword: "retract",
+			comments: []github.IssueComment{
+				comment(start, "bob", "/lgtm"),
+				comment(start.Add(time.Hour), "bob", "/lgtm cancel"),
+				comment(start.Add(2*time.Hour), "alice", "/approve retract"),
 			},
-			expectHandle: false,
+			expectedMessage: `Approval history of this PR:
+
+- 2021-03-04 10:00 UTC @bob lgtm'd ([comment](https://github.com/org/repo/pull/1#bob))
+- 2021-03-04 11:00 UTC @bob cancelled their lgtm ([comment](https://github.com/org/repo/pull/1#bob))
+- 2021-03-04 12:00 UTC @alice cancelled their approval ([comment](https://github.com/org/repo/pull/1#alice))`,
 		},
 		{
-			name: "lgtm with lgtmActsAsApprove",
-			commentEvent: github.GenericCommentEvent{
-				Action: github.GenericCommentActionCreated,
-				IsPR:   true,
-				Body:   "/lgtm",
-				Number: 1,
-				User: github.User{
-					Login: "author",
-				},
+			name:               "anonymized approvers",
+			anonymizeApprovers: true,
+			comments: []github.IssueComment{
+				comment(start, "alice", "/approve"),
+				comment(start.Add(time.Hour), "alice", "/approve cancel"),
 			},
-			lgtmActsAsApprove: true,
-			expectHandle:      true,
+			expectedMessage: `Approval history of this PR:
+
+- 2021-03-04 10:00 UTC an approver approved
+- 2021-03-04 11:00 UTC an approver cancelled their approval`,
 		},
 	}
 
-	var handled bool
-	var gotState *state
-	handleFunc = func(log *logrus.Entry, ghc githubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr *state) error {
-		gotState = pr
-		handled = true
-		return nil
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{Repos: []string{"org"}, RequireSelfApproval: &rsa, LgtmActsAsApprove: true, CancelKeyword: test.cancelKeyword, AnonymizeApprovers: test.anonymizeApprovers}}}
+			request := newTestCommentTime(start.Add(5*time.Hour), "carol", "/approve history")
+			fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, append(test.comments, request), test.reviews)
+			fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+			labelsBefore := append([]string{}, fghc.IssueLabelsAdded...)
+			event := github.GenericCommentEvent{
+				Action:      github.GenericCommentActionCreated,
+				IsPR:        true,
+				Body:        request.Body,
+				Number:      prNumber,
+				User:        github.User{Login: "carol"},
+				IssueAuthor: github.User{Login: "cjwagner"},
+				Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+			}
+			if err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event); err != nil {
+				t.Fatalf("Unexpected error handling event: %v.", err)
+			}
+			if !reflect.DeepEqual(fghc.IssueLabelsAdded, labelsBefore) || len(fghc.IssueLabelsRemoved) != 0 {
+				t.Errorf("Expected labels to be left untouched, but added %v and removed %v.", fghc.IssueLabelsAdded, fghc.IssueLabelsRemoved)
+			}
+			if !reflect.DeepEqual(fghc.IssueCommentsAdded, []string{fmt.Sprintf("org/repo#%d:%s", prNumber, test.expectedMessage)}) {
+				t.Errorf("Expected the history %q, but got %q.", test.expectedMessage, fghc.IssueCommentsAdded)
+			}
+		})
 	}
-	defer func() {
-		handleFunc = handle
-	}()
+}
 
-	repo := github.Repo{
-		Owner: github.User{
-			Login: "org",
+func TestEditedComment(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{
+		Repos:               []string{"org"},
+		RequireSelfApproval: &rsa,
+	}}}
+
+	tests := []struct {
+		name           string
+		editedBody     string
+		hasLabel       bool
+		expectApproved bool
+	}{
+		{
+			name:           "edit introducing an approve command approves",
+			editedBody:     "looks good\n/approve",
+			expectApproved: true,
+		},
+		{
+			name:       "edit removing an approve command drops the approval",
+			editedBody: "looks good",
+			hasLabel:   true,
 		},
-		Name: "repo",
 	}
-	pr := github.PullRequest{
-		Base: github.PullRequestBranch{
-			Ref: "branch",
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The comments listed by GitHub already contain the edited body.
+			fghc := newFakeGitHubClient(test.hasLabel, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", test.editedBody)}, nil)
+			fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+			event := github.GenericCommentEvent{
+				Action:      github.GenericCommentActionEdited,
+				IsPR:        true,
+				Body:        test.editedBody,
+				Number:      prNumber,
+				User:        github.User{Login: "alice"},
+				IssueAuthor: github.User{Login: "cjwagner"},
+				Repo:        github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+			}
+			if err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event); err != nil {
+				t.Fatalf("Unexpected error handling event: %v.", err)
+			}
+			prLabels, err := fghc.GetIssueLabels("org", "repo", prNumber)
+			if err != nil {
+				t.Fatalf("Unexpected error getting labels: %v.", err)
+			}
+			approved := false
+			for _, l := range prLabels {
+				approved = approved || l.Name == labels.Approved
+			}
+			if approved != test.expectApproved {
+				t.Errorf("Expected the PR to be approved: %t, but got labels %v.", test.expectApproved, prLabels)
+			}
+		})
+	}
+}
+
+func TestReportOwnersErrors(t *testing.T) {
+	tests := []struct {
+		name               string
+		reportOwnersErrors bool
+		expectComment      bool
+	}{
+		{
+			name:               "owners load error is reported",
+			reportOwnersErrors: true,
+			expectComment:      true,
+		},
+		{
+			name: "owners load error is not reported by default",
 		},
-		Number: 1,
 	}
-	fghc := fakegithub.NewFakeClient()
-	fghc.PullRequests = map[int]*github.PullRequest{1: &pr}
 
+	repo := github.Repo{Owner: github.User{Login: "org"}, Name: "repo"}
 	for _, test := range tests {
-		test.commentEvent.Repo = repo
-		githubConfig := config.GitHubOptions{
-			LinkURL: &url.URL{
-				Scheme: "https",
-				Host:   "github.com",
-			},
-		}
-		config := &plugins.Configuration{}
-		config.Approve = append(config.Approve, plugins.Approve{
-			Repos:             []string{test.commentEvent.Repo.Owner.Login},
-			LgtmActsAsApprove: test.lgtmActsAsApprove,
-		})
-		err := handleGenericComment(
-			logrus.WithField("plugin", "approve"),
-			fghc,
-			fakeOwnersClient{},
-			githubConfig,
-			config,
-			&test.commentEvent,
-		)
+		t.Run(test.name, func(t *testing.T) {
+			botUserCheckers.reset()
+			fghc := fakegithub.NewFakeClient()
+			fghc.PullRequests = map[int]*github.PullRequest{1: {Base: github.PullRequestBranch{Ref: "branch"}, Number: 1}}
+			fghc.IssueComments = map[int][]github.IssueComment{}
+			config := &plugins.Configuration{Approve: []plugins.Approve{{
+				Repos:              []string{"org"},
+				ReportOwnersErrors: test.reportOwnersErrors,
+			}}}
+			event := github.GenericCommentEvent{
+				Action: github.GenericCommentActionCreated,
+				IsPR:   true,
+				Body:   "/approve",
+				Number: 1,
+				User:   github.User{Login: "alice"},
+				Repo:   repo,
+			}
 
-		if test.expectHandle && !handled {
-			t.Errorf("%s: expected call to handleFunc, but it wasn't called", test.name)
-		}
+			// Handling the same event twice must only explain the error once.
+			for i := 0; i < 2; i++ {
+				err := handleGenericComment(
+					logrus.WithField("plugin", "approve"),
+					fghc,
+					fakeOwnersClient{err: errors.New("error converting YAML to JSON")},
+					testGitHubConfig,
+					config,
+					&event,
+				)
+				var loadErr *OwnersLoadError
+				if !errors.As(err, &loadErr) {
+					t.Fatalf("Expected an *OwnersLoadError, but got %v.", err)
+				}
+				if loadErr.Base != "branch" {
+					t.Errorf("Expected the error to reference the %q branch, but got %q.", "branch", loadErr.Base)
+				}
+			}
 
-		if !test.expectHandle && handled {
-			t.Errorf("%s: expected no call to handleFunc, but it was called", test.name)
-		}
+			expectComments := 0
+			if test.expectComment {
+				expectComments = 1
+			}
+			if len(fghc.IssueCommentsAdded) != expectComments {
+				t.Fatalf("Expected comment: %t, but got %v.", test.expectComment, fghc.IssueCommentsAdded)
+			}
+			if test.expectComment && !strings.Contains(fghc.IssueCommentsAdded[0], "error converting YAML to JSON") {
+				t.Errorf("Expected the comment to explain the error, but got %q.", fghc.IssueCommentsAdded[0])
+			}
+		})
+	}
+}
 
-		if test.expectState != nil && !reflect.DeepEqual(test.expectState, gotState) {
-			t.Errorf("%s: expected PR state to equal: %#v, but got: %#v", test.name, test.expectState, gotState)
-		}
+func TestAliasCycle(t *testing.T) {
+	fr := fakeRepo{
+		approvers:      map[string]layeredsets.String{"a": layeredsets.NewString("alice")},
+		leafApprovers:  map[string]sets.String{"a": sets.NewString("alice")},
+		approverOwners: map[string]string{"a/a.go": "a"},
+		aliases:        map[string]sets.String{"team-a": sets.NewString("team-b"), "team-b": sets.NewString("team-a")},
+		aliasCycle:     []string{"team-a", "team-b", "team-a"},
+	}
+	fghc := newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil)
+	fghc.PullRequests = map[int]*github.PullRequest{prNumber: {Base: github.PullRequestBranch{Ref: "master"}, Number: prNumber}}
+	pluginConfig := &plugins.Configuration{Approve: []plugins.Approve{{Repos: []string{"org"}, ReportOwnersErrors: true}}}
+	event := github.GenericCommentEvent{
+		Action: github.GenericCommentActionCreated,
+		IsPR:   true,
+		Body:   "/approve",
+		Number: prNumber,
+		User:   github.User{Login: "alice"},
+		Repo:   github.Repo{Owner: github.User{Login: "org"}, Name: "repo"},
+	}
 
-		if err != nil {
-			t.Errorf("%s: error calling handleGenericComment: %v", test.name, err)
-		}
-		handled = false
+	err := handleGenericComment(logrus.WithField("plugin", "approve"), fghc, fakeOwnersClient{repo: fr}, testGitHubConfig, pluginConfig, &event)
+	var loadErr *OwnersLoadError
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("Expected an *OwnersLoadError, but got %v.", err)
+	}
+	if expected := "the OWNERS aliases form a cycle: team-a -> team-b -> team-a"; loadErr.Err.Error() != expected {
+		t.Errorf("Expected the error %q, but got %q.", expected, loadErr.Err)
+	}
+	if sets.NewString(fghc.IssueLabelsAdded...).Has(approvedLabel) {
+		t.Errorf("Expected no approval, but got labels %v.", fghc.IssueLabelsAdded)
+	}
+	if len(fghc.IssueCommentsAdded) != 1 || !strings.Contains(fghc.IssueCommentsAdded[0], "team-a -> team-b -> team-a") {
+		t.Errorf("Expected a comment explaining the cycle, but got %v.", fghc.IssueCommentsAdded)
 	}
 }
 
@@ -1630,14 +6857,16 @@ func TestHandleReview(t *testing.T) {
 			reviewActsAsApprove: true,
 			expectHandle:        true,
 			expectState: &state{
-				org:       "org",
-				repo:      "repo",
-				branch:    "branch",
-				number:    1,
-				body:      "Fix everything",
-				author:    "P.R. Author",
-				assignees: nil,
-				htmlURL:   "",
+				org:         "org",
+				repo:        "repo",
+				branch:      "branch",
+				number:      1,
+				body:        "Fix everything",
+				author:      "P.R. Author",
+				assignees:   nil,
+				htmlURL:     "",
+				actor:       "author",
+				commentBody: "looks good",
 			},
 		},
 		{
@@ -1750,7 +6979,7 @@ func TestHandleReview(t *testing.T) {
 
 	var handled bool
 	var gotState *state
-	handleFunc = func(log *logrus.Entry, ghc githubClient, repo approvers.Repo, config config.GitHubOptions, opts *plugins.Approve, pr *state) error {
+	handleFunc = func(log *logrus.Entry, ghc GitHubClient, repo approvers.Repo, config config.GitHubOptions, opts *plugins.Approve, pr *state) error {
 		gotState = pr
 		handled = true
 		return nil
@@ -1775,6 +7004,7 @@ func TestHandleReview(t *testing.T) {
 		Number: 1,
 		Body:   "Fix everything",
 	}
+	botUserCheckers.reset()
 	fghc := fakegithub.NewFakeClient()
 	fghc.PullRequests = map[int]*github.PullRequest{1: &pr}
 
@@ -1824,10 +7054,14 @@ func TestHandleReview(t *testing.T) {
 
 func TestHandlePullRequest(t *testing.T) {
 	tests := []struct {
-		name         string
-		prEvent      github.PullRequestEvent
-		expectHandle bool
-		expectState  *state
+		name                  string
+		prEvent               github.PullRequestEvent
+		triggerOnActions      []string
+		reapproveOnBaseChange bool
+		requireLgtmLabel      bool
+		titleApprovalPhrase   string
+		expectHandle          bool
+		expectState           *state
 	}{
 		{
 			name: "pr opened",
@@ -1854,6 +7088,7 @@ func TestHandlePullRequest(t *testing.T) {
 				author:    "P.R. Author",
 				assignees: nil,
 				htmlURL:   "",
+				opened:    true,
 			},
 		},
 		{
@@ -1890,6 +7125,48 @@ func TestHandlePullRequest(t *testing.T) {
 			},
 			expectHandle: false,
 		},
+		{
+			name: "pr lgtm label",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionLabeled,
+				Label: github.Label{
+					Name: labels.LGTM,
+				},
+			},
+			expectHandle: false,
+		},
+		{
+			name: "pr lgtm label with require_lgtm_label",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionLabeled,
+				Label: github.Label{
+					Name: labels.LGTM,
+				},
+			},
+			requireLgtmLabel: true,
+			expectHandle:     true,
+		},
+		{
+			name: "pr lgtm label removed",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionUnlabeled,
+				Label: github.Label{
+					Name: labels.LGTM,
+				},
+			},
+			expectHandle: false,
+		},
+		{
+			name: "pr lgtm label removed with require_lgtm_label",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionUnlabeled,
+				Label: github.Label{
+					Name: labels.LGTM,
+				},
+			},
+			requireLgtmLabel: true,
+			expectHandle:     true,
+		},
 		{
 			name: "pr closed",
 			prEvent: github.PullRequestEvent{
@@ -1903,6 +7180,38 @@ func TestHandlePullRequest(t *testing.T) {
 			},
 			expectHandle: false,
 		},
+		{
+			name: "pr converted to draft",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionConvertedToDraft,
+				PullRequest: github.PullRequest{
+					User: github.User{
+						Login: "P.R. Author",
+					},
+					Base: github.PullRequestBranch{
+						Ref: "branch",
+					},
+					Draft: true,
+				},
+				Number: 1,
+			},
+			expectHandle: true,
+			expectState: &state{
+				org:    "org",
+				repo:   "repo",
+				branch: "branch",
+				number: 1,
+				draft:  true,
+				author: "P.R. Author",
+			},
+		},
+		{
+			name: "pr ready for review",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionReadyForReview,
+			},
+			expectHandle: true,
+		},
 		{
 			name: "pr review requested",
 			prEvent: github.PullRequestEvent{
@@ -1910,11 +7219,94 @@ func TestHandlePullRequest(t *testing.T) {
 			},
 			expectHandle: false,
 		},
+		{
+			name: "pr synchronized with included action",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionSynchronize,
+			},
+			triggerOnActions: []string{"opened", "synchronize"},
+			expectHandle:     true,
+		},
+		{
+			name: "pr labeled with excluded action",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionLabeled,
+				Label: github.Label{
+					Name: "approved",
+				},
+			},
+			triggerOnActions: []string{"opened", "synchronize"},
+			expectHandle:     false,
+		},
+		{
+			name: "pr retargeted",
+			prEvent: github.PullRequestEvent{
+				Action: github.PullRequestActionEdited,
+				PullRequest: github.PullRequest{
+					User: github.User{
+						Login: "P.R. Author",
+					},
+					Base: github.PullRequestBranch{
+						Ref: "main",
+					},
+				},
+				Number:  1,
+				Changes: json.RawMessage(`{"base":{"ref":{"from":"feature"},"sha":{"from":"abc"}}}`),
+			},
+			reapproveOnBaseChange: true,
+			expectHandle:          true,
+			expectState: &state{
+				org:            "org",
+				repo:           "repo",
+				branch:         "main",
+				number:         1,
+				author:         "P.R. Author",
+				previousBranch: "feature",
+			},
+		},
+		{
+			name: "pr title edited",
+			prEvent: github.PullRequestEvent{
+				Action:  github.PullRequestActionEdited,
+				Changes: json.RawMessage(`{"title":{"from":"WIP"}}`),
+			},
+			reapproveOnBaseChange: true,
+			expectHandle:          false,
+		},
+		{
+			name: "pr title edited to add the title approval phrase",
+			prEvent: github.PullRequestEvent{
+				Action:      github.PullRequestActionEdited,
+				PullRequest: github.PullRequest{Title: "[APPROVED-BY-LEAD] Fix everything"},
+				Changes:     json.RawMessage(`{"title":{"from":"Fix everything"}}`),
+			},
+			reapproveOnBaseChange: true,
+			titleApprovalPhrase:   "[APPROVED-BY-LEAD]",
+			expectHandle:          true,
+		},
+		{
+			name: "pr title edited keeping the title approval phrase",
+			prEvent: github.PullRequestEvent{
+				Action:      github.PullRequestActionEdited,
+				PullRequest: github.PullRequest{Title: "[APPROVED-BY-LEAD] Fix everything"},
+				Changes:     json.RawMessage(`{"title":{"from":"[APPROVED-BY-LEAD] WIP"}}`),
+			},
+			titleApprovalPhrase: "[APPROVED-BY-LEAD]",
+			expectHandle:        false,
+		},
+		{
+			name: "pr retargeted without reapprove_on_base_change",
+			prEvent: github.PullRequestEvent{
+				Action:  github.PullRequestActionEdited,
+				Changes: json.RawMessage(`{"base":{"ref":{"from":"feature"}}}`),
+			},
+			expectHandle: false,
+		},
 	}
 
 	var handled bool
 	var gotState *state
-	handleFunc = func(log *logrus.Entry, ghc githubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr *state) error {
+	handleFunc = func(log *logrus.Entry, ghc GitHubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr *state) error {
 		gotState = pr
 		handled = true
 		return nil
@@ -1929,6 +7321,7 @@ func TestHandlePullRequest(t *testing.T) {
 		},
 		Name: "repo",
 	}
+	botUserCheckers.reset()
 	fghc := fakegithub.NewFakeClient()
 
 	for _, test := range tests {
@@ -1943,7 +7336,7 @@ func TestHandlePullRequest(t *testing.T) {
 					Host:   "github.com",
 				},
 			},
-			&plugins.Configuration{},
+			&plugins.Configuration{Approve: []plugins.Approve{{Repos: []string{"org"}, TriggerOnActions: test.triggerOnActions, ReapproveOnBaseChange: test.reapproveOnBaseChange, RequireLgtmLabel: test.requireLgtmLabel, TitleApprovalPhrase: test.titleApprovalPhrase}}},
 			&test.prEvent,
 		)
 
@@ -2007,3 +7400,249 @@ func TestHelpProvider(t *testing.T) {
 		})
 	}
 }
+
+// flakyCommentClient fails the first failures attempts to create a comment.
+type flakyCommentClient struct {
+	*fakegithub.FakeClient
+	failures int
+	attempts int
+}
+
+func (c *flakyCommentClient) CreateComment(org, repo string, number int, comment string) error {
+	c.attempts++
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("injected error")
+	}
+	return c.FakeClient.CreateComment(org, repo, number, comment)
+}
+
+func TestNotificationRetry(t *testing.T) {
+	var sleeps []time.Duration
+	notificationSleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	defer func() { notificationSleep = time.Sleep }()
+
+	fr := newTestRepo(map[string][]string{"a": {"alice"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa}
+
+	tests := []struct {
+		name               string
+		failures           int
+		expectAttempts     int
+		expectSleeps       []time.Duration
+		expectNotification bool
+	}{
+		{
+			name:               "notification is posted at once",
+			expectAttempts:     1,
+			expectNotification: true,
+		},
+		{
+			name:               "notification is posted after a transient failure",
+			failures:           1,
+			expectAttempts:     2,
+			expectSleeps:       []time.Duration{time.Second},
+			expectNotification: true,
+		},
+		{
+			name:           "notification is given up after persistent failures",
+			failures:       notificationAttempts,
+			expectAttempts: notificationAttempts,
+			expectSleeps:   []time.Duration{time.Second, 2 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ghc := &flakyCommentClient{
+				FakeClient: newFakeGitHubClient(false, false, []string{"a/a.go"}, []github.IssueComment{newTestComment("alice", "/approve")}, nil),
+				failures:   test.failures,
+			}
+			sleeps = nil
+			pr := newTestState()
+			runHandle(t, ghc, fr, opts, pr)
+			if ghc.attempts != test.expectAttempts {
+				t.Errorf("Expected %d attempts to post the notification, but got %d.", test.expectAttempts, ghc.attempts)
+			}
+			if !reflect.DeepEqual(sleeps, test.expectSleeps) {
+				t.Errorf("Expected to back off for %v, but got %v.", test.expectSleeps, sleeps)
+			}
+			var posted bool
+			for _, c := range ghc.IssueComments[prNumber] {
+				posted = posted || notificationMatcher(func(login string) bool { return login == "k8s-ci-robot" }, false)(commentFromIssueComment(&c))
+			}
+			if posted != test.expectNotification {
+				t.Errorf("Expected the notification to be posted: %t, but got comments %v.", test.expectNotification, ghc.IssueComments[prNumber])
+			}
+			if !sets.NewString(ghc.IssueLabelsAdded...).Has(approvedLabel) {
+				t.Errorf("Expected the approved label to be added regardless, but got labels %v.", ghc.IssueLabelsAdded)
+			}
+		})
+	}
+}
+
+// editingClient applies comment edits, which fakegithub ignores.
+type editingClient struct {
+	*fakegithub.FakeClient
+	edited []int
+}
+
+func (c *editingClient) EditComment(org, repo string, id int, body string) error {
+	c.edited = append(c.edited, id)
+	for number, ics := range c.IssueComments {
+		for i := range ics {
+			if ics[i].ID == id {
+				c.IssueComments[number][i].Body = body
+			}
+		}
+	}
+	return nil
+}
+
+func TestEditNotificationInPlace(t *testing.T) {
+	fr := newTestRepo(map[string][]string{"a": {"alice"}, "b": {"bob"}})
+	rsa := true
+	opts := &plugins.Approve{Repos: []string{"org/repo"}, RequireSelfApproval: &rsa, EditNotificationInPlace: true}
+	pr := newTestState()
+	ghc := &editingClient{FakeClient: newFakeGitHubClient(false, false, []string{"a/a.go", "b/b.go"}, nil, nil)}
+	run := func() {
+		runHandle(t, ghc, fr, opts, pr)
+	}
+	notification := func() github.IssueComment {
+		var notifications []github.IssueComment
+		for _, ic := range ghc.IssueComments[prNumber] {
+			if strings.HasPrefix(ic.Body, "[APPROVALNOTIFIER]") {
+				notifications = append(notifications, ic)
+			}
+		}
+		if len(notifications) != 1 {
+			t.Fatalf("Expected a single notification, but got %v.", notifications)
+		}
+		if !strings.Contains(notifications[0].Body, notificationIDMarker(notifications[0].ID)) {
+			t.Errorf("Expected the notification to carry its ID %d, but got %q.", notifications[0].ID, notifications[0].Body)
+		}
+		return notifications[0]
+	}
+
+	run()
+	created := notification()
+
+	// An approval edits the notification instead of reposting it.
+	ghc.IssueComments[prNumber] = append(ghc.IssueComments[prNumber], newTestComment("alice", "/approve"))
+	run()
+	if edited := notification(); edited.ID != created.ID || !strings.Contains(edited.Body, "alice") {
+		t.Errorf("Expected notification %d to be edited, but got %+v.", created.ID, edited)
+	}
+	if len(ghc.IssueCommentsDeleted) != 0 {
+		t.Errorf("Expected no comment to be deleted, but got %v.", ghc.IssueCommentsDeleted)
+	}
+
+	// A deleted notification is recreated and tracked under its new ID.
+	if err := ghc.DeleteComment("org", "repo", created.ID); err != nil {
+		t.Fatalf("Unexpected error deleting the notification: %v.", err)
+	}
+	run()
+	recreated := notification()
+	if recreated.ID == created.ID {
+		t.Errorf("Expected the notification to be recreated, but got %+v.", recreated)
+	}
+
+	// Untracked duplicates are deleted, the tracked notification is kept.
+	if err := ghc.CreateComment("org", "repo", prNumber, "[APPROVALNOTIFIER] This PR is **NOT APPROVED**"); err != nil {
+		t.Fatalf("Unexpected error creating a duplicate notification: %v.", err)
+	}
+	run()
+	if kept := notification(); kept.ID != recreated.ID {
+		t.Errorf("Expected notification %d to be kept, but got %+v.", recreated.ID, kept)
+	}
+}
+
+func TestHandleGolden(t *testing.T) {
+	fr := fakeRepo{
+		approvers: map[string]layeredsets.String{
+			"a": layeredsets.NewString("alice"),
+			"b": layeredsets.NewString("bob"),
+		},
+		leafApprovers: map[string]sets.String{
+			"a": sets.NewString("alice"),
+			"b": sets.NewString("bob"),
+		},
+		approverOwners: map[string]string{"a/a.go": "a", "b/b.go": "b"},
+	}
+	rsa := true
+	opts := &plugins.Approve{
+		Repos:               []string{"org/repo"},
+		RequireSelfApproval: &rsa,
+		CommandHelpLink:     "https://go.k8s.io/bot-commands",
+		PrProcessLink:       "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process",
+	}
+
+	tests := []struct {
+		name     string
+		hasLabel bool
+		comments []github.IssueComment
+		reviews  []github.Review
+	}{
+		{
+			name: "unapproved",
+		},
+		{
+			name:     "partially approved",
+			comments: []github.IssueComment{newTestComment("alice", "/approve")},
+		},
+		{
+			name:     "approved",
+			comments: []github.IssueComment{newTestComment("alice", "/approve")},
+			reviews:  []github.Review{newTestReview("bob", "", github.ReviewStateApproved)},
+		},
+		{
+			name:     "approval cancelled",
+			hasLabel: true,
+			comments: []github.IssueComment{
+				newTestComment("alice", "/approve"),
+				newTestComment("bob", "/approve"),
+				newTestComment("bob", "/approve cancel"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fghc := newFakeGitHubClient(test.hasLabel, false, []string{"a/a.go", "b/b.go"}, test.comments, test.reviews)
+			existingLabels := sets.NewString(fghc.IssueLabelsAdded...)
+			pr := PullRequestState{
+				Org:     "org",
+				Repo:    "repo",
+				Branch:  "master",
+				Number:  prNumber,
+				Author:  "cjwagner",
+				HTMLURL: "https://github.com/org/repo/pull/1",
+			}
+			if err := Handle(logrus.WithField("plugin", "approve"), fghc, fr, testGitHubConfig, opts, pr); err != nil {
+				t.Fatalf("Unexpected error handling PR: %v.", err)
+			}
+
+			var actual strings.Builder
+			fmt.Fprintf(&actual, "labels added: %v\n", sets.NewString(fghc.IssueLabelsAdded...).Difference(existingLabels).List())
+			fmt.Fprintf(&actual, "labels removed: %v\n", sets.NewString(fghc.IssueLabelsRemoved...).List())
+			for _, comment := range fghc.IssueCommentsAdded {
+				fmt.Fprintf(&actual, "--- comment\n%s\n", comment)
+			}
+
+			fixtureName := filepath.Join("testdata", fmt.Sprintf("%s.golden", strings.ReplaceAll(t.Name(), "/", "_")))
+			if os.Getenv("UPDATE") != "" {
+				if err := ioutil.WriteFile(fixtureName, []byte(actual.String()), 0644); err != nil {
+					t.Errorf("Failed to update fixture: %v.", err)
+				}
+			}
+			expected, err := ioutil.ReadFile(fixtureName)
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v.", err)
+			}
+			if diff := cmp.Diff(string(expected), actual.String()); diff != "" {
+				t.Errorf("Unexpected result (-want +got):\n%s\nIf this is expected, re-run the tests with the UPDATE env var set to update the fixture:\n\tUPDATE=true go test ./prow/plugins/approve/... -run TestHandleGolden", diff)
+			}
+		})
+	}
+}
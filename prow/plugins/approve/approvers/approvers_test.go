@@ -19,7 +19,10 @@ package approvers
 import (
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -27,6 +30,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/util/sets"
 
+	"k8s.io/test-infra/prow/pkg/layeredsets"
 	"k8s.io/test-infra/prow/plugins/ownersconfig"
 )
 
@@ -258,6 +262,7 @@ func TestGetCCs(t *testing.T) {
 		// testSeed affects who is chosen for CC
 		testSeed  int64
 		assignees []string
+		author    string
 		// order matters for CCs
 		expectedCCs          []string
 		expectedAssignedCCs  []string
@@ -291,13 +296,14 @@ func TestGetCCs(t *testing.T) {
 			expectedSuggestedCCs: []string{"alice"},
 		},
 		{
-			testName:             "Single Root File PR No One Seed = 10",
-			filenames:            []string{"kubernetes.go"},
-			testSeed:             10,
-			currentlyApproved:    sets.NewString(),
-			expectedCCs:          []string{"bob"},
+			testName:          "Single Root File PR No One Seed = 10",
+			filenames:         []string{"kubernetes.go"},
+			testSeed:          10,
+			currentlyApproved: sets.NewString(),
+			// Suggestions are deterministic and do not depend on the seed
+			expectedCCs:          []string{"alice"},
 			expectedAssignedCCs:  []string{},
-			expectedSuggestedCCs: []string{"bob"},
+			expectedSuggestedCCs: []string{"alice"},
 		},
 		{
 			testName:             "Combo and Other; Neither Approved",
@@ -331,10 +337,10 @@ func TestGetCCs(t *testing.T) {
 			filenames:         []string{"a/combo/test.go", "a/d/test.go", "c/test"},
 			testSeed:          0,
 			currentlyApproved: sets.NewString(),
-			// chris can approve c and combo, debbie can approve d
-			expectedCCs:          []string{"chris", "debbie"},
+			// carol can approve c and combo, dan can approve d
+			expectedCCs:          []string{"carol", "dan"},
 			expectedAssignedCCs:  []string{},
-			expectedSuggestedCCs: []string{"chris", "debbie"},
+			expectedSuggestedCCs: []string{"carol", "dan"},
 		},
 		{
 			testName:          "A, B, C; Nothing Approved",
@@ -342,9 +348,9 @@ func TestGetCCs(t *testing.T) {
 			testSeed:          0,
 			currentlyApproved: sets.NewString(),
 			// Need an approver from each of the three owners files
-			expectedCCs:          []string{"anne", "bill", "carol"},
+			expectedCCs:          []string{"anne", "barbara", "carol"},
 			expectedAssignedCCs:  []string{},
-			expectedSuggestedCCs: []string{"anne", "bill", "carol"},
+			expectedSuggestedCCs: []string{"anne", "barbara", "carol"},
 		},
 		{
 			testName:  "A, B, C; Partially approved by non-suggested approvers",
@@ -365,7 +371,7 @@ func TestGetCCs(t *testing.T) {
 			currentlyApproved: sets.NewString(),
 			assignees:         []string{"Art", "Ben"},
 			// We suggest assigned people rather than "suggested" people
-			// Suggested would be "Anne", "Bill", "Carol" if no one was assigned.
+			// Suggested would be "Anne", "Barbara", "Carol" if no one was assigned.
 			expectedCCs:          []string{"art", "ben", "carol"},
 			expectedAssignedCCs:  []string{"art", "ben"},
 			expectedSuggestedCCs: []string{"carol"},
@@ -393,6 +399,28 @@ func TestGetCCs(t *testing.T) {
 			expectedAssignedCCs:  []string{"alice"},
 			expectedSuggestedCCs: []string{},
 		},
+		{
+			testName:             "Author is not suggested",
+			filenames:            []string{"kubernetes.go"},
+			testSeed:             13,
+			currentlyApproved:    sets.NewString(),
+			author:               "Alice",
+			expectedCCs:          []string{"bob"},
+			expectedAssignedCCs:  []string{},
+			expectedSuggestedCCs: []string{"bob"},
+		},
+		{
+			testName:          "Assigned author is not suggested",
+			filenames:         []string{"a/test.go"},
+			testSeed:          0,
+			currentlyApproved: sets.NewString(),
+			// The author is a root approver
+			assignees:            []string{"alice"},
+			author:               "alice",
+			expectedCCs:          []string{"anne"},
+			expectedAssignedCCs:  []string{},
+			expectedSuggestedCCs: []string{"anne"},
+		},
 	}
 
 	for _, test := range tests {
@@ -402,6 +430,7 @@ func TestGetCCs(t *testing.T) {
 			testApprovers.AddApprover(approver, "REFERENCE", false)
 		}
 		testApprovers.AddAssignees(test.assignees...)
+		testApprovers.Author = test.author
 		calculated := testApprovers.GetCCs()
 		if !reflect.DeepEqual(test.expectedCCs, calculated) {
 			t.Errorf("Failed for test %v.  Expected CCs: %v. Found %v", test.testName, test.expectedCCs, calculated)
@@ -417,6 +446,55 @@ func TestGetCCs(t *testing.T) {
 	}
 }
 
+func TestAuthorCanSelfApprove(t *testing.T) {
+	FakeRepoMap := map[string]sets.String{
+		"":  sets.NewString("Alice"),
+		"a": sets.NewString("Art", "Anne"),
+		"b": sets.NewString("Bill"),
+	}
+	tests := []struct {
+		name      string
+		filenames []string
+		author    string
+		approvers []string
+		expected  bool
+	}{
+		{
+			name:      "author approves unapproved files",
+			filenames: []string{"a/test.go", "b/test.go"},
+			author:    "art",
+			expected:  true,
+		},
+		{
+			name:      "author only approves approved files",
+			filenames: []string{"a/test.go", "b/test.go"},
+			author:    "art",
+			approvers: []string{"Anne"},
+		},
+		{
+			name:      "author already approved",
+			filenames: []string{"a/test.go"},
+			author:    "Art",
+			approvers: []string{"art"},
+		},
+		{
+			name:      "author is no approver",
+			filenames: []string{"a/test.go"},
+			author:    "john",
+		},
+	}
+	for _, test := range tests {
+		ap := NewApprovers(Owners{filenames: test.filenames, repo: createFakeRepo(FakeRepoMap), seed: 0, log: logrus.WithField("plugin", "some_plugin")})
+		ap.Author = test.author
+		for _, approver := range test.approvers {
+			ap.AddApprover(approver, "REFERENCE", false)
+		}
+		if got := ap.AuthorCanSelfApprove(); got != test.expected {
+			t.Errorf("%s: expected %t, but got %t", test.name, test.expected, got)
+		}
+	}
+}
+
 func TestIsApproved(t *testing.T) {
 	rootApprovers := sets.NewString("Alice", "Bob")
 	aApprovers := sets.NewString("Art", "Anne")
@@ -564,6 +642,101 @@ func TestIsApproved(t *testing.T) {
 	}
 }
 
+func TestIsApprovedWeighted(t *testing.T) {
+	FakeRepoMap := map[string]sets.String{
+		"":  sets.NewString("Alice", "Bob"),
+		"a": sets.NewString("Art", "Anne", "Principal"),
+		"b": sets.NewString("Bill", "Ben"),
+	}
+	weights := map[string]int{"principal": 2}
+	tests := []struct {
+		testName          string
+		filenames         []string
+		requiredApprovers int
+		currentlyApproved sets.String
+		isApproved        bool
+	}{
+		{
+			testName:          "default requires a single approval",
+			filenames:         []string{"a/test.go"},
+			currentlyApproved: sets.NewString("Art"),
+			isApproved:        true,
+		},
+		{
+			testName:          "single unweighted approval does not satisfy two required",
+			filenames:         []string{"a/test.go"},
+			requiredApprovers: 2,
+			currentlyApproved: sets.NewString("Art"),
+			isApproved:        false,
+		},
+		{
+			testName:          "two unweighted approvals satisfy two required",
+			filenames:         []string{"a/test.go"},
+			requiredApprovers: 2,
+			currentlyApproved: sets.NewString("Art", "Anne"),
+			isApproved:        true,
+		},
+		{
+			testName:          "weighted approval alone satisfies two required",
+			filenames:         []string{"a/test.go"},
+			requiredApprovers: 2,
+			currentlyApproved: sets.NewString("Principal"),
+			isApproved:        true,
+		},
+		{
+			testName:          "weighted approval only counts for the files it owns",
+			filenames:         []string{"a/test.go", "b/test.go"},
+			requiredApprovers: 2,
+			currentlyApproved: sets.NewString("Principal", "Bill"),
+			isApproved:        false,
+		},
+		{
+			testName:          "approvals from parent and leaf OWNERS add up",
+			filenames:         []string{"b/test.go"},
+			requiredApprovers: 2,
+			currentlyApproved: sets.NewString("Alice", "Ben"),
+			isApproved:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			testApprovers := NewApprovers(Owners{filenames: test.filenames, repo: createFakeRepo(FakeRepoMap), seed: 0, log: logrus.WithField("plugin", "some_plugin")})
+			testApprovers.RequiredApprovers = test.requiredApprovers
+			testApprovers.ApproverWeights = weights
+			for approver := range test.currentlyApproved {
+				testApprovers.AddApprover(approver, "REFERENCE", false)
+			}
+			calculated := testApprovers.IsApproved()
+			if test.isApproved != calculated {
+				t.Errorf("Failed for test %v.  Expected Approval Status: %v. Found %v", test.testName, test.isApproved, calculated)
+			}
+		})
+	}
+}
+
+func TestRemoveNonApprovers(t *testing.T) {
+	ap := NewApprovers(Owners{
+		filenames: []string{"a/a.go"},
+		repo: createFakeRepo(map[string]sets.String{"a": sets.NewString("Alice")}, func(fr *FakeRepo) {
+			fr.reviewersMap = map[string]layeredsets.String{"a": layeredsets.NewString("erin")}
+		}),
+		log: logrus.WithField("plugin", "some_plugin"),
+	})
+	ap.AddApprover("Alice", "REFERENCE", false)
+	ap.AddApprover("Bob", "REFERENCE", false)
+	ap.AddLGTMer("Carol", "REFERENCE", false)
+	ap.AddAuthorSelfApprover("Dave", "REFERENCE", false)
+	ap.AddLGTMer("Erin", "REFERENCE", false)
+
+	if removed, expected := ap.RemoveNonApprovers(), []string{"Bob", "Carol"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected %v to be removed, but got %v.", expected, removed)
+	}
+	if got, expected := ap.GetCurrentApproversSet(), sets.NewString("alice", "dave", "erin"); !got.Equal(expected) {
+		t.Errorf("Expected remaining approvers %v, but got %v.", expected.List(), got.List())
+	}
+}
+
 func TestIsApprovedWithIssue(t *testing.T) {
 	aApprovers := sets.NewString("Author", "Anne", "Carl")
 	bApprovers := sets.NewString("Bill", "Carl")
@@ -573,6 +746,7 @@ func TestIsApprovedWithIssue(t *testing.T) {
 		filenames         []string
 		currentlyApproved map[string]bool
 		associatedIssue   int
+		closedIssues      []int
 		isApproved        bool
 	}{
 		{
@@ -582,6 +756,22 @@ func TestIsApprovedWithIssue(t *testing.T) {
 			associatedIssue:   0,
 			isApproved:        false,
 		},
+		{
+			testName:          "Single file with closed issue",
+			filenames:         []string{"a/file.go"},
+			currentlyApproved: map[string]bool{"Carl": false},
+			associatedIssue:   100,
+			closedIssues:      []int{100},
+			isApproved:        false,
+		},
+		{
+			testName:          "Single file with closed issue no-issue",
+			filenames:         []string{"a/file.go"},
+			currentlyApproved: map[string]bool{"Carl": true},
+			associatedIssue:   100,
+			closedIssues:      []int{100},
+			isApproved:        true,
+		},
 		{
 			testName:          "Single file missing issue",
 			filenames:         []string{"a/file.go"},
@@ -693,6 +883,101 @@ func TestIsApprovedWithIssue(t *testing.T) {
 		testApprovers := NewApprovers(Owners{filenames: test.filenames, repo: createFakeRepo(FakeRepoMap), seed: 0, log: logrus.WithField("plugin", "some_plugin")})
 		testApprovers.RequireIssue = true
 		testApprovers.AssociatedIssue = test.associatedIssue
+		testApprovers.ClosedIssues = test.closedIssues
+		for approver, noissue := range test.currentlyApproved {
+			testApprovers.AddApprover(approver, "REFERENCE", noissue)
+		}
+		testApprovers.AddAuthorSelfApprover("Author", "REFERENCE", false)
+		calculated := testApprovers.IsApproved()
+		if test.isApproved != calculated {
+			t.Errorf("Failed for test %v.  Expected Approval Status: %v. Found %v", test.testName, test.isApproved, calculated)
+		}
+	}
+}
+
+func TestAuthorSelfApprovalScope(t *testing.T) {
+	FakeRepoMap := map[string]sets.String{
+		"a":   sets.NewString("Author"),
+		"a/b": sets.NewString("Bill"),
+		"c":   sets.NewString("Carl"),
+	}
+	tests := []struct {
+		testName           string
+		implicit           bool
+		expectedUnapproved sets.String
+	}{
+		{
+			testName:           "explicit self-approval only approves the directories owned by the author",
+			expectedUnapproved: sets.NewString("c"),
+		},
+		{
+			testName:           "implicit self-approval only approves the directories owned by the author",
+			implicit:           true,
+			expectedUnapproved: sets.NewString("c"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			testApprovers := NewApprovers(Owners{filenames: []string{"a/file.go", "a/b/file.go", "c/file.go"}, repo: createFakeRepo(FakeRepoMap), seed: 0, log: logrus.WithField("plugin", "some_plugin")})
+			if test.implicit {
+				testApprovers.AddImplicitSelfApprover("Author", "REFERENCE")
+			} else {
+				testApprovers.AddAuthorSelfApprover("Author", "REFERENCE", false)
+			}
+			if got := testApprovers.UnapprovedFiles(); !got.Equal(test.expectedUnapproved) {
+				t.Errorf("Expected unapproved files %v, but got %v", test.expectedUnapproved.List(), got.List())
+			}
+			if testApprovers.IsApproved() {
+				t.Error("Expected the PR not to be approved by the author alone")
+			}
+		})
+	}
+}
+
+func TestIsApprovedWithIssueConsensus(t *testing.T) {
+	FakeRepoMap := map[string]sets.String{"a": sets.NewString("Author", "Anne", "Carl"), "b": sets.NewString("Bill", "Carl")}
+	tests := []struct {
+		testName          string
+		consensus         bool
+		currentlyApproved map[string]bool
+		isApproved        bool
+	}{
+		{
+			testName:          "one of two approvers no-issue without consensus",
+			currentlyApproved: map[string]bool{"Anne": true, "Bill": false},
+			isApproved:        true,
+		},
+		{
+			testName:          "one of two approvers no-issue with consensus",
+			consensus:         true,
+			currentlyApproved: map[string]bool{"Anne": true, "Bill": false},
+			isApproved:        false,
+		},
+		{
+			testName:          "both approvers no-issue with consensus",
+			consensus:         true,
+			currentlyApproved: map[string]bool{"Anne": true, "Bill": true},
+			isApproved:        true,
+		},
+		{
+			testName:          "non-approver without no-issue doesn't block consensus",
+			consensus:         true,
+			currentlyApproved: map[string]bool{"Carl": true, "Dave": false},
+			isApproved:        true,
+		},
+		{
+			testName:          "no no-issue approvers with consensus",
+			consensus:         true,
+			currentlyApproved: map[string]bool{"Anne": false, "Bill": false},
+			isApproved:        false,
+		},
+	}
+
+	for _, test := range tests {
+		testApprovers := NewApprovers(Owners{filenames: []string{"a/file.go", "b/file2.go"}, repo: createFakeRepo(FakeRepoMap), seed: 0, log: logrus.WithField("plugin", "some_plugin")})
+		testApprovers.RequireIssue = true
+		testApprovers.NoIssueRequiresConsensus = test.consensus
 		for approver, noissue := range test.currentlyApproved {
 			testApprovers.AddApprover(approver, "REFERENCE", noissue)
 		}
@@ -704,6 +989,55 @@ func TestIsApprovedWithIssue(t *testing.T) {
 	}
 }
 
+func TestApprovalTimes(t *testing.T) {
+	FakeRepoMap := map[string]sets.String{"a": sets.NewString("Alice", "Anne"), "b": sets.NewString("Bill")}
+	t1 := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+	tests := []struct {
+		testName          string
+		requiredApprovers int
+		approvalTimes     map[string]time.Time
+		expected          map[string]time.Time
+	}{
+		{
+			testName:      "each file is approved by its approval",
+			approvalTimes: map[string]time.Time{"Alice": t1, "Bill": t2},
+			expected:      map[string]time.Time{"a": t1, "b": t2},
+		},
+		{
+			testName:      "the earliest approval approves the file",
+			approvalTimes: map[string]time.Time{"Anne": t3, "Alice": t1},
+			expected:      map[string]time.Time{"a": t1},
+		},
+		{
+			testName:          "the approval reaching the required number approves the file",
+			requiredApprovers: 2,
+			approvalTimes:     map[string]time.Time{"Anne": t3, "Alice": t1, "Bill": t2},
+			expected:          map[string]time.Time{"a": t3},
+		},
+		{
+			testName:      "approvals without a time are left out",
+			approvalTimes: map[string]time.Time{"Alice": {}, "Bill": t2},
+			expected:      map[string]time.Time{"b": t2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			testApprovers := NewApprovers(Owners{filenames: []string{"a/file.go", "b/file2.go"}, repo: createFakeRepo(FakeRepoMap), seed: 0, log: logrus.WithField("plugin", "some_plugin")})
+			testApprovers.RequiredApprovers = test.requiredApprovers
+			for approver, at := range test.approvalTimes {
+				testApprovers.AddApprover(approver, "REFERENCE-"+approver, false)
+				testApprovers.SetApprovalTime(approver, "REFERENCE-"+approver, at)
+			}
+			if got := testApprovers.ApprovalTimes(); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Expected approval times %v, but got %v", test.expected, got)
+			}
+		})
+	}
+}
+
 func TestGetFilesApprovers(t *testing.T) {
 	tests := []struct {
 		testName       string
@@ -780,54 +1114,520 @@ func TestGetFilesApprovers(t *testing.T) {
 	}
 }
 
-func TestGetMessage(t *testing.T) {
-	ap := NewApprovers(
-		Owners{
-			filenames: []string{"a/a.go", "b/b.go"},
-			repo: createFakeRepo(map[string]sets.String{
-				"a": sets.NewString("Alice"),
-				"b": sets.NewString("Bill"),
-			}),
-			log: logrus.WithField("plugin", "some_plugin"),
+func TestDeletionsOnlyApproval(t *testing.T) {
+	owners := map[string]sets.String{
+		"":  sets.NewString("RootApprover"),
+		"a": sets.NewString("AApprover"),
+		"c": sets.NewString("CApprover"),
+	}
+	tests := []struct {
+		testName       string
+		deleted        []string
+		expectedStatus map[string]sets.String
+	}{
+		{
+			testName: "Only deleted files are approved",
+			deleted:  []string{"a/a"},
+			expectedStatus: map[string]sets.String{
+				"a": sets.NewString("RootApprover"),
+				"c": {},
+			},
 		},
-	)
-	ap.RequireIssue = true
-	ap.AddApprover("Bill", "REFERENCE", false)
-
-	want := `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
-
-This pull-request has been approved by: *<a href="REFERENCE" title="Approved">Bill</a>*
-To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **alice** after the PR has been reviewed.
-You can assign the PR to them by writing ` + "`/assign @alice`" + ` in a comment when ready.
-
-*No associated issue*. Update pull-request body to add a reference to an issue, or get approval with ` + "`/approve no-issue`" + `
-
-The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
-
-<details open>
-Needs approval from an approver in each of these files:
-
-- **[a/OWNERS](https://github.com/org/repo/blob/dev/a/OWNERS)**
-- ~~[b/OWNERS](https://github.com/org/repo/blob/dev/b/OWNERS)~~ [Bill]
+		{
+			testName: "Nothing is deleted",
+			expectedStatus: map[string]sets.String{
+				"a": {},
+				"c": {},
+			},
+		},
+		{
+			testName: "Everything is deleted",
+			deleted:  []string{"a/a", "c/c"},
+			expectedStatus: map[string]sets.String{
+				"a": sets.NewString("RootApprover"),
+				"c": sets.NewString("RootApprover"),
+			},
+		},
+	}
 
-Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
-Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
-</details>
-<!-- META={"approvers":["alice"]} -->`
-	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "dev"); got == nil {
-		t.Error("GetMessage() failed")
-	} else if *got != want {
-		t.Errorf("GetMessage() = %+v, want = %+v", *got, want)
+	for _, test := range tests {
+		testApprovers := NewApprovers(Owners{filenames: []string{"a/a", "c/c"}, repo: createFakeRepo(owners), log: logrus.WithField("plugin", "some_plugin")})
+		testApprovers.DeletedFiles = sets.NewString(test.deleted...)
+		testApprovers.AddApprover("RootApprover", "REFERENCE", false)
+		testApprovers.SetApprovalDeletionsOnly("RootApprover", "REFERENCE")
+		calculated := testApprovers.GetFilesApprovers()
+		if !reflect.DeepEqual(test.expectedStatus, calculated) {
+			t.Errorf("Failed for test %v.  Expected approval status: %v. Found %v", test.testName, test.expectedStatus, calculated)
+		}
 	}
 }
 
-func TestGetMessageAllApproved(t *testing.T) {
-	ap := NewApprovers(
-		Owners{
-			filenames: []string{"a/a.go", "b/b.go"},
-			repo: createFakeRepo(map[string]sets.String{
-				"a": sets.NewString("Alice"),
-				"b": sets.NewString("Bill"),
+func TestUnreviewedFilesApproval(t *testing.T) {
+	owners := map[string]sets.String{
+		"":  sets.NewString("RootApprover"),
+		"a": sets.NewString("AApprover"),
+		"c": sets.NewString("CApprover"),
+	}
+	tests := []struct {
+		testName       string
+		unreviewed     []string
+		expectedStatus map[string]sets.String
+	}{
+		{
+			testName: "Everything was reviewed",
+			expectedStatus: map[string]sets.String{
+				"a": sets.NewString("RootApprover"),
+				"c": sets.NewString("RootApprover"),
+			},
+		},
+		{
+			testName:   "Files changed later aren't approved",
+			unreviewed: []string{"c/c"},
+			expectedStatus: map[string]sets.String{
+				"a": sets.NewString("RootApprover"),
+				"c": {},
+			},
+		},
+		{
+			testName:   "Nothing was reviewed",
+			unreviewed: []string{"a/a", "c/c"},
+			expectedStatus: map[string]sets.String{
+				"a": {},
+				"c": {},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		testApprovers := NewApprovers(Owners{filenames: []string{"a/a", "c/c"}, repo: createFakeRepo(owners), log: logrus.WithField("plugin", "some_plugin")})
+		testApprovers.AddApprover("RootApprover", "REFERENCE", false)
+		testApprovers.SetApprovalUnreviewedFiles("RootApprover", "REFERENCE", sets.NewString(test.unreviewed...))
+		calculated := testApprovers.GetFilesApprovers()
+		if !reflect.DeepEqual(test.expectedStatus, calculated) {
+			t.Errorf("Failed for test %v.  Expected approval status: %v. Found %v", test.testName, test.expectedStatus, calculated)
+		}
+	}
+}
+
+func TestSensitiveFilesApproval(t *testing.T) {
+	owners := map[string]sets.String{
+		"":  sets.NewString("RootApprover"),
+		"a": sets.NewString("AApprover"),
+		"c": sets.NewString("CApprover"),
+	}
+	tests := []struct {
+		testName       string
+		sensitive      []string
+		reason         string
+		expectedStatus map[string]sets.String
+	}{
+		{
+			testName: "Nothing is sensitive",
+			expectedStatus: map[string]sets.String{
+				"a": sets.NewString("RootApprover"),
+				"c": sets.NewString("RootApprover"),
+			},
+		},
+		{
+			testName:  "Sensitive files need a reason",
+			sensitive: []string{"c/c"},
+			expectedStatus: map[string]sets.String{
+				"a": sets.NewString("RootApprover"),
+				"c": {},
+			},
+		},
+		{
+			testName:  "Approval with a reason approves sensitive files",
+			sensitive: []string{"c/c"},
+			reason:    "Reviewed the threat model",
+			expectedStatus: map[string]sets.String{
+				"a": sets.NewString("RootApprover"),
+				"c": sets.NewString("RootApprover"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		testApprovers := NewApprovers(Owners{filenames: []string{"a/a", "c/c"}, repo: createFakeRepo(owners), log: logrus.WithField("plugin", "some_plugin")})
+		testApprovers.SensitiveFiles = sets.NewString(test.sensitive...)
+		testApprovers.AddApprover("RootApprover", "REFERENCE", false)
+		if test.reason != "" {
+			testApprovers.SetApprovalReason("RootApprover", "REFERENCE", test.reason)
+		}
+		calculated := testApprovers.GetFilesApprovers()
+		if !reflect.DeepEqual(test.expectedStatus, calculated) {
+			t.Errorf("Failed for test %v.  Expected approval status: %v. Found %v", test.testName, test.expectedStatus, calculated)
+		}
+	}
+}
+
+func TestWithHypotheticalApprovers(t *testing.T) {
+	ap := NewApprovers(Owners{filenames: []string{"a/a", "c/c"}, repo: createFakeRepo(map[string]sets.String{
+		"a": sets.NewString("AApprover"),
+		"c": sets.NewString("CApprover"),
+	}), log: logrus.WithField("plugin", "some_plugin")})
+	ap.AddApprover("AApprover", "REFERENCE", false)
+
+	if simulated := ap.WithHypotheticalApprovers("SIMULATION", "CApprover"); !simulated.AreFilesApproved() {
+		t.Errorf("Expected the approval of CApprover to approve all files, but %v are unapproved.", simulated.UnapprovedFiles().List())
+	}
+	if simulated := ap.WithHypotheticalApprovers("SIMULATION", "Someone"); simulated.AreFilesApproved() {
+		t.Error("Expected the approval of someone who isn't an approver not to approve all files.")
+	}
+	if !reflect.DeepEqual(ap.GetCurrentApproversSet().List(), []string{"aapprover"}) {
+		t.Errorf("Expected the approvers to be left unchanged, but got %v.", ap.GetCurrentApproversSet().List())
+	}
+}
+
+func TestExplainFile(t *testing.T) {
+	owners := map[string]sets.String{
+		"":  sets.NewString("RootApprover"),
+		"a": sets.NewString("AApprover"),
+		"c": sets.NewString("CApprover"),
+	}
+	tests := []struct {
+		testName  string
+		path      string
+		approvers []string
+		expected  FileApprovalStatus
+	}{
+		{
+			testName: "Governed file without approval",
+			path:     "a/a",
+			expected: FileApprovalStatus{
+				Path:       "a/a",
+				Governed:   true,
+				OwnersFile: "a",
+				Candidates: sets.NewString("rootapprover", "aapprover"),
+				ApprovedBy: sets.NewString(),
+			},
+		},
+		{
+			testName:  "Governed file approved by an approver of a parent OWNERS file",
+			path:      "a/a",
+			approvers: []string{"RootApprover", "CApprover"},
+			expected: FileApprovalStatus{
+				Path:       "a/a",
+				Governed:   true,
+				OwnersFile: "a",
+				Candidates: sets.NewString("rootapprover", "aapprover"),
+				Approved:   true,
+				ApprovedBy: sets.NewString("RootApprover"),
+			},
+		},
+		{
+			testName:  "Ungoverned file",
+			path:      "b/b",
+			approvers: []string{"CApprover"},
+			expected: FileApprovalStatus{
+				Path:       "b/b",
+				Candidates: sets.NewString(),
+				Approved:   true,
+				ApprovedBy: sets.NewString(),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		testApprovers := NewApprovers(Owners{filenames: []string{"a/a", "c/c"}, repo: createFakeRepo(owners), log: logrus.WithField("plugin", "some_plugin")})
+		for _, approver := range test.approvers {
+			testApprovers.AddApprover(approver, "REFERENCE", false)
+		}
+		calculated := testApprovers.ExplainFile(test.path)
+		if !reflect.DeepEqual(test.expected, calculated) {
+			t.Errorf("Failed for test %v.  Expected file approval status: %+v. Found %+v", test.testName, test.expected, calculated)
+		}
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	owners := map[string]sets.String{
+		"a": sets.NewString("Alice"),
+		"b": sets.NewString("Bill"),
+		"c": sets.NewString("Carol"),
+	}
+	tests := []struct {
+		testName       string
+		quorumCount    int
+		author         string
+		approvers      []string
+		expectApproved bool
+	}{
+		{
+			testName:  "Per-directory approval misses a directory",
+			approvers: []string{"Alice", "Bill"},
+		},
+		{
+			testName:       "Quorum of distinct approvers is reached",
+			quorumCount:    2,
+			approvers:      []string{"Alice", "Bill"},
+			expectApproved: true,
+		},
+		{
+			testName:    "Quorum is not reached",
+			quorumCount: 3,
+			approvers:   []string{"Alice", "Bill"},
+		},
+		{
+			testName:    "Approvers outside the OWNERS files don't count towards the quorum",
+			quorumCount: 2,
+			approvers:   []string{"Alice", "Mallory"},
+		},
+		{
+			testName:    "Implicit self-approval of the author doesn't count towards the quorum",
+			quorumCount: 2,
+			author:      "Carol",
+			approvers:   []string{"Alice"},
+		},
+		{
+			testName:       "Quorum is reached besides the implicit self-approval of the author",
+			quorumCount:    2,
+			author:         "Carol",
+			approvers:      []string{"Alice", "Bill"},
+			expectApproved: true,
+		},
+		{
+			testName:       "Per-directory approval covers all directories",
+			approvers:      []string{"Alice", "Bill", "Carol"},
+			expectApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		testApprovers := NewApprovers(Owners{filenames: []string{"a/a", "b/b", "c/c"}, repo: createFakeRepo(owners), log: logrus.WithField("plugin", "some_plugin")})
+		testApprovers.QuorumCount = test.quorumCount
+		if test.author != "" {
+			testApprovers.AddImplicitSelfApprover(test.author, "REFERENCE")
+		}
+		for _, approver := range test.approvers {
+			testApprovers.AddApprover(approver, "REFERENCE", false)
+		}
+		if approved := testApprovers.AreFilesApproved(); approved != test.expectApproved {
+			t.Errorf("Failed for test %v.  Expected files approved: %t. Found %t", test.testName, test.expectApproved, approved)
+		}
+	}
+}
+
+func TestEnforceRequiredReviewers(t *testing.T) {
+	owners := map[string]sets.String{
+		"a": sets.NewString("Alice", "Anne"),
+		"b": sets.NewString("Bill"),
+	}
+	tests := []struct {
+		testName           string
+		enforce            bool
+		approvers          []string
+		expectedUnapproved sets.String
+	}{
+		{
+			testName:           "Approval from a required reviewer",
+			enforce:            true,
+			approvers:          []string{"Anne", "Bill"},
+			expectedUnapproved: sets.NewString(),
+		},
+		{
+			testName:           "Approval from outside the required reviewers",
+			enforce:            true,
+			approvers:          []string{"Alice", "Bill"},
+			expectedUnapproved: sets.NewString("a"),
+		},
+		{
+			testName:           "Required reviewers are not enforced by default",
+			approvers:          []string{"Alice", "Bill"},
+			expectedUnapproved: sets.NewString(),
+		},
+	}
+
+	for _, test := range tests {
+		repo := createFakeRepo(owners, func(fr *FakeRepo) {
+			fr.requiredReviewersMap = map[string]sets.String{"a": sets.NewString("anne")}
+		})
+		testApprovers := NewApprovers(Owners{filenames: []string{"a/a", "b/b"}, repo: repo, log: logrus.WithField("plugin", "some_plugin")})
+		testApprovers.EnforceRequiredReviewers = test.enforce
+		for _, approver := range test.approvers {
+			testApprovers.AddApprover(approver, "REFERENCE", false)
+		}
+		if calculated := testApprovers.UnapprovedFiles(); !test.expectedUnapproved.Equal(calculated) {
+			t.Errorf("Failed for test %v.  Expected unapproved files: %v. Found %v", test.testName, test.expectedUnapproved, calculated)
+		}
+	}
+}
+
+func TestGetMessage(t *testing.T) {
+	ap := NewApprovers(
+		Owners{
+			filenames: []string{"a/a.go", "b/b.go"},
+			repo: createFakeRepo(map[string]sets.String{
+				"a": sets.NewString("Alice"),
+				"b": sets.NewString("Bill"),
+			}),
+			log: logrus.WithField("plugin", "some_plugin"),
+		},
+	)
+	ap.RequireIssue = true
+	ap.AddApprover("Bill", "REFERENCE", false)
+
+	want := `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
+
+This pull-request has been approved by: *<a href="REFERENCE" title="Approved">Bill</a>*
+To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), please assign **alice** after the PR has been reviewed.
+You can assign the PR to them by writing ` + "`/assign @alice`" + ` in a comment when ready.
+
+*No associated issue*. Update pull-request body to add a reference to an issue, or get approval with ` + "`/approve no-issue`" + `
+
+The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands?repo=org%2Frepo).
+
+<details open>
+Needs approval from an approver in each of these files:
+
+- **[a/OWNERS](https://github.com/org/repo/blob/dev/a/OWNERS)**
+- ~~[b/OWNERS](https://github.com/org/repo/blob/dev/b/OWNERS)~~ [Bill]
+
+Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
+Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
+</details>
+<!-- META={"approvers":["alice"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a"],"approvers":["Bill"],"hash":"6fc41a03023f91ce"} -->`
+	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "dev"); got == nil {
+		t.Error("GetMessage() failed")
+	} else if *got != want {
+		t.Errorf("GetMessage() = %+v, want = %+v", *got, want)
+	}
+}
+
+func TestGetMessageAnonymized(t *testing.T) {
+	tests := []struct {
+		name       string
+		anonymize  bool
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "named approvers",
+			expected: []string{
+				"This pull-request has been approved by: *<a href=\"REFERENCE-BILL\" title=\"Approved\">Bill</a>*, *<a href=\"REFERENCE-BOB\" title=\"Approved\">Bob</a>*",
+				"- ~~[b/OWNERS](https://github.com/org/repo/blob/dev/b/OWNERS)~~ [Bill,Bob]",
+				`"approvers":["Bill","Bob"]`,
+			},
+		},
+		{
+			name:      "anonymized approvers",
+			anonymize: true,
+			expected: []string{
+				"This pull-request has been approved by: 2 approvers\n",
+				"- ~~[b/OWNERS](https://github.com/org/repo/blob/dev/b/OWNERS)~~ [2 approvers]",
+				`"approvers":[]`,
+			},
+			unexpected: []string{"Bill", "Bob", "REFERENCE"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ap := NewApprovers(
+				Owners{
+					filenames: []string{"a/a.go", "b/b.go"},
+					repo: createFakeRepo(map[string]sets.String{
+						"a": sets.NewString("Alice"),
+						"b": sets.NewString("Bill", "Bob"),
+					}),
+					log: logrus.WithField("plugin", "some_plugin"),
+				},
+			)
+			ap.AnonymizeApprovers = test.anonymize
+			ap.AddApprover("Bill", "REFERENCE-BILL", false)
+			ap.AddApprover("Bob", "REFERENCE-BOB", false)
+
+			got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "dev")
+			if got == nil {
+				t.Fatal("GetMessage() failed")
+			}
+			for _, line := range test.expected {
+				if !strings.Contains(*got, line) {
+					t.Errorf("Expected the notification to contain %q, got:\n%s", line, *got)
+				}
+			}
+			for _, line := range test.unexpected {
+				if strings.Contains(*got, line) {
+					t.Errorf("Expected the notification not to contain %q, got:\n%s", line, *got)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMessageLocale(t *testing.T) {
+	tests := []struct {
+		name     string
+		locale   string
+		expected []string
+	}{
+		{
+			name:   "german",
+			locale: "de",
+			expected: []string{
+				"[APPROVALNOTIFIER] Dieser PR ist **NICHT GENEHMIGT**",
+				"Dieser Pull-Request wurde genehmigt von: *<a href=\"REFERENCE\" title=\"Approved\">Bill</a>*",
+				"Benötigt die Genehmigung eines Approvers aus jeder dieser Dateien:",
+				"- **[a/OWNERS](https://github.com/org/repo/blob/dev/a/OWNERS)**",
+				"Approver können ihre Genehmigung zurückziehen, indem sie `/approve cancel` in einem Kommentar schreiben",
+			},
+		},
+		{
+			name:   "spanish of a region falls back to spanish",
+			locale: "es_MX",
+			expected: []string{
+				"[APPROVALNOTIFIER] Este PR **NO está APROBADO**",
+				"La lista completa de comandos aceptados por este bot se encuentra [aquí](https://go.k8s.io/bot-commands?repo=org%2Frepo).",
+				"Los aprobadores pueden indicar su aprobación escribiendo `/approve` en un comentario",
+			},
+		},
+		{
+			name:   "unknown locale falls back to english",
+			locale: "xx",
+			expected: []string{
+				"[APPROVALNOTIFIER] This PR is **NOT APPROVED**",
+				"This pull-request has been approved by:",
+				"Approvers can cancel approval by writing `/approve cancel` in a comment",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ap := NewApprovers(
+				Owners{
+					filenames: []string{"a/a.go", "b/b.go"},
+					repo: createFakeRepo(map[string]sets.String{
+						"a": sets.NewString("Alice"),
+						"b": sets.NewString("Bill"),
+					}),
+					log: logrus.WithField("plugin", "some_plugin"),
+				},
+			)
+			ap.NotificationLocale = test.locale
+			ap.AddApprover("Bill", "REFERENCE", false)
+
+			got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "dev")
+			if got == nil {
+				t.Fatal("GetMessage() failed")
+			}
+			for _, line := range test.expected {
+				if !strings.Contains(*got, line) {
+					t.Errorf("Expected the notification to contain %q, got:\n%s", line, *got)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMessageAllApproved(t *testing.T) {
+	ap := NewApprovers(
+		Owners{
+			filenames: []string{"a/a.go", "b/b.go"},
+			repo: createFakeRepo(map[string]sets.String{
+				"a": sets.NewString("Alice"),
+				"b": sets.NewString("Bill"),
 			}),
 			log: logrus.WithField("plugin", "some_plugin"),
 		},
@@ -855,7 +1655,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":[],"approvers":["Alice","Bill"],"hash":"f9d5bd8b9625aeff"} -->`
 	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master"); got == nil {
 		t.Error("GetMessage() failed")
 	} else if *got != want {
@@ -895,7 +1696,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["alice","bill"]} -->`
+<!-- META={"approvers":["alice","bill"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a","b"],"approvers":["John"],"hash":"2dd9c2b6d33f1254"} -->`
 	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master"); got == nil {
 		t.Error("GetMessage() failed")
 	} else if *got != want {
@@ -939,7 +1741,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["Alice","Bill","John"],"associated_issue":12345,"hash":"5617c09498ababbc"} -->`
 	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master"); got == nil {
 		t.Error("GetMessage() failed")
 	} else if *got != want {
@@ -982,7 +1785,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":[]} -->`
+<!-- META={"approvers":[]} -->
+<!-- APPROVAL_STATUS={"approved":true,"unapproved_dirs":[],"approvers":["Alice","Bill","John"],"hash":"38e77373accf34b2"} -->`
 	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master"); got == nil {
 		t.Error("GetMessage() failed")
 	} else if *got != want {
@@ -1023,7 +1827,8 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["alice","doctor"]} -->`
+<!-- META={"approvers":["alice","doctor"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a","b/README.md"],"approvers":["John"],"hash":"cc8dc2127687b8e8"} -->`
 	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master"); got == nil {
 		t.Error("GetMessage() failed")
 	} else if *got != want {
@@ -1064,10 +1869,520 @@ Needs approval from an approver in each of these files:
 Approvers can indicate their approval by writing ` + "`/approve`" + ` in a comment
 Approvers can cancel approval by writing ` + "`/approve cancel`" + ` in a comment
 </details>
-<!-- META={"approvers":["alice","doctor"]} -->`
+<!-- META={"approvers":["alice","doctor"]} -->
+<!-- APPROVAL_STATUS={"approved":false,"unapproved_dirs":["a","b/README.md"],"approvers":["John"],"hash":"6e331cf6005fd4f7"} -->`
 	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.mycorp.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master"); got == nil {
 		t.Error("GetMessage() failed")
 	} else if *got != want {
 		t.Errorf("GetMessage() = %+v, want = %+v", *got, want)
 	}
 }
+
+func TestGetMessageLegacy(t *testing.T) {
+	ap := NewApprovers(
+		Owners{
+			filenames: []string{"a/a.go", "b/b.go"},
+			repo: createFakeRepo(map[string]sets.String{
+				"a": sets.NewString("Alice"),
+				"b": sets.NewString("Bill"),
+			}),
+			log: logrus.WithField("plugin", "some_plugin"),
+		},
+	)
+	ap.RequireIssue = true
+	ap.LegacyNotificationFormat = true
+	ap.AddApprover("Bill", "REFERENCE", false)
+
+	want := `[APPROVALNOTIFIER] This PR is **NOT APPROVED**
+
+This pull-request has been approved by: *<a href="REFERENCE" title="Approved">Bill</a>*
+We suggest the following additional approver: **alice**
+
+Assign the PR to them by writing ` + "`/assign @alice`" + ` in a comment when ready.
+
+*No associated issue*. Update pull-request body to add a reference to an issue, or get approval with ` + "`/approve no-issue`" + `
+
+The full list of commands accepted by this bot can be found [here](https://go.k8s.io/bot-commands).
+
+<details open>
+Needs approval from an approver in each of these OWNERS Files:
+
+- **[a/OWNERS](https://github.com/org/repo/blob/dev/a/OWNERS)**
+- ~~[b/OWNERS](https://github.com/org/repo/blob/dev/b/OWNERS)~~ [Bill]
+
+You can indicate your approval by writing ` + "`/approve`" + ` in a comment
+You can cancel your approval by writing ` + "`/approve cancel`" + ` in a comment
+</details>
+<!-- META={"approvers":["alice"]} -->`
+	if got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "dev"); got == nil {
+		t.Error("GetMessage() failed")
+	} else if *got != want {
+		t.Errorf("GetMessage() = %+v, want = %+v", *got, want)
+	}
+}
+
+func TestGetMessageImplicitSelfApproval(t *testing.T) {
+	tests := []struct {
+		name       string
+		hide       bool
+		expectLine string
+	}{
+		{
+			name:       "implicit self-approval is shown apart",
+			expectLine: "This pull-request has been approved by: *<a href=\"REFERENCE\" title=\"Approved\">Bill</a>*\nSelf-approved (implicit) by the author: *<a href=\"#\" title=\"Author self-approved\">Alice</a>*\n",
+		},
+		{
+			name:       "implicit self-approval is hidden",
+			hide:       true,
+			expectLine: "This pull-request has been approved by: *<a href=\"REFERENCE\" title=\"Approved\">Bill</a>*\n\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ap := NewApprovers(
+				Owners{
+					filenames: []string{"a/a.go", "b/b.go"},
+					repo: createFakeRepo(map[string]sets.String{
+						"a": sets.NewString("Alice"),
+						"b": sets.NewString("Bill"),
+					}),
+					log: logrus.WithField("plugin", "some_plugin"),
+				},
+			)
+			ap.HideImplicitSelfApprove = test.hide
+			ap.AddImplicitSelfApprover("Alice", "#")
+			ap.AddApprover("Bill", "REFERENCE", false)
+
+			if !ap.IsApproved() {
+				t.Error("Expected the implicit self-approval to count towards approval.")
+			}
+			got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master")
+			if got == nil {
+				t.Fatal("GetMessage() failed")
+			}
+			if !strings.Contains(*got, test.expectLine) {
+				t.Errorf("Expected GetMessage() to contain %q, but got %q", test.expectLine, *got)
+			}
+		})
+	}
+}
+
+func TestGetMessageApprovalTimes(t *testing.T) {
+	ap := NewApprovers(
+		Owners{
+			filenames: []string{"a/a.go", "b/b.go"},
+			repo: createFakeRepo(map[string]sets.String{
+				"a": sets.NewString("Alice"),
+				"b": sets.NewString("Bill"),
+			}),
+			log: logrus.WithField("plugin", "some_plugin"),
+		},
+	)
+	ap.ShowApprovalTimes = true
+	ap.AddApprover("Alice", "REFERENCE", false)
+	ap.SetApprovalTime("Alice", "REFERENCE", time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+
+	got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master")
+	if got == nil {
+		t.Fatal("GetMessage() failed")
+	}
+	want := "- ~~[a/OWNERS](https://github.com/org/repo/blob/master/a/OWNERS)~~ [Alice] (approved 2020-01-01 10:00 UTC)\n- **[b/OWNERS](https://github.com/org/repo/blob/master/b/OWNERS)**\n"
+	if !strings.Contains(*got, want) {
+		t.Errorf("Expected GetMessage() to contain %q, but got %q", want, *got)
+	}
+}
+
+func TestGetMessageFooter(t *testing.T) {
+	newApprovers := func(footer string) Approvers {
+		ap := NewApprovers(
+			Owners{
+				filenames: []string{"a/a.go"},
+				repo: createFakeRepo(map[string]sets.String{
+					"a": sets.NewString("Alice"),
+				}),
+				log: logrus.WithField("plugin", "some_plugin"),
+			},
+		)
+		ap.NotificationFooter = footer
+		return ap
+	}
+	message := func(ap Approvers) string {
+		got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master")
+		if got == nil {
+			t.Fatal("GetMessage() failed")
+		}
+		return *got
+	}
+
+	got := message(newApprovers("Please read CONTRIBUTING.md."))
+	want := "</details>\n\nPlease read CONTRIBUTING.md.\n<!-- META="
+	if !strings.Contains(got, want) {
+		t.Errorf("Expected GetMessage() to contain %q, but got %q", want, got)
+	}
+
+	got = message(newApprovers(strings.Repeat("é", maxCommentLength)))
+	if len(got) > maxCommentLength {
+		t.Errorf("Expected GetMessage() to be at most %d bytes long, but got %d", maxCommentLength, len(got))
+	}
+	if !utf8.ValidString(got) {
+		t.Error("Expected the truncated footer to be valid UTF-8")
+	}
+	if !strings.Contains(got, approvalStatusPrefix) {
+		t.Errorf("Expected GetMessage() to keep the approval status, but got %q", got[len(got)-200:])
+	}
+}
+
+func TestGetMessageCompact(t *testing.T) {
+	tests := []struct {
+		name          string
+		approvers     []string
+		requireIssue  bool
+		expectedTitle string
+	}{
+		{
+			name:          "unapproved",
+			expectedTitle: "[APPROVALNOTIFIER] Approval: 0/2 directories approved — needs @alice, @bill.",
+		},
+		{
+			name:          "partially approved",
+			approvers:     []string{"Alice"},
+			expectedTitle: "[APPROVALNOTIFIER] Approval: 1/2 directories approved — needs @bill.",
+		},
+		{
+			name:          "files approved, but missing issue",
+			approvers:     []string{"Alice", "Bill"},
+			requireIssue:  true,
+			expectedTitle: "[APPROVALNOTIFIER] Approval: 2/2 directories approved — not approved yet.",
+		},
+		{
+			name:          "approved",
+			approvers:     []string{"Alice", "Bill"},
+			expectedTitle: "[APPROVALNOTIFIER] Approval: 2/2 directories approved.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ap := NewApprovers(
+				Owners{
+					filenames: []string{"a/a.go", "b/b.go"},
+					repo: createFakeRepo(map[string]sets.String{
+						"a": sets.NewString("Alice"),
+						"b": sets.NewString("Bill"),
+					}),
+					log: logrus.WithField("plugin", "some_plugin"),
+				},
+			)
+			for _, approver := range test.approvers {
+				ap.AddApprover(approver, "REFERENCE", false)
+			}
+			ap.RequireIssue = test.requireIssue
+			ap.CompactNotification = true
+			got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master")
+			if got == nil {
+				t.Fatal("GetMessage() failed")
+			}
+			lines := strings.Split(*got, "\n")
+			if lines[0] != test.expectedTitle {
+				t.Errorf("Expected title %q, but got %q", test.expectedTitle, lines[0])
+			}
+			status, err := ParseNotificationStatus(*got)
+			if err != nil || status == nil || status.Hash == "" {
+				t.Errorf("Expected the compact message to embed the approval status, but got %q", *got)
+			}
+			if len(lines) != 3 {
+				t.Errorf("Expected the compact message to consist of the title and the approval status, but got %q", *got)
+			}
+		})
+	}
+}
+
+func TestBlockedReasons(t *testing.T) {
+	tests := []struct {
+		name             string
+		approvers        []string
+		requireIssue     bool
+		associatedIssue  int
+		draft            bool
+		unresolved       int
+		manuallyApproved bool
+		expected         []BlockedReason
+	}{
+		{
+			name:     "unapproved files",
+			expected: []BlockedReason{BlockedReasonUnapprovedFiles},
+		},
+		{
+			name:         "unapproved files and missing issue",
+			requireIssue: true,
+			expected:     []BlockedReason{BlockedReasonUnapprovedFiles, BlockedReasonMissingIssue},
+		},
+		{
+			name:         "missing issue",
+			approvers:    []string{"Alice", "Bill"},
+			requireIssue: true,
+			expected:     []BlockedReason{BlockedReasonMissingIssue},
+		},
+		{
+			name:            "associated issue",
+			approvers:       []string{"Alice", "Bill"},
+			requireIssue:    true,
+			associatedIssue: 1,
+		},
+		{
+			name:      "draft",
+			approvers: []string{"Alice", "Bill"},
+			draft:     true,
+			expected:  []BlockedReason{BlockedReasonDraft},
+		},
+		{
+			name:       "unresolved review threads",
+			approvers:  []string{"Alice"},
+			unresolved: 2,
+			expected:   []BlockedReason{BlockedReasonUnresolvedThreads, BlockedReasonUnapprovedFiles},
+		},
+		{
+			name:             "manually approved",
+			requireIssue:     true,
+			manuallyApproved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ap := NewApprovers(
+				Owners{
+					filenames: []string{"a/a.go", "b/b.go"},
+					repo: createFakeRepo(map[string]sets.String{
+						"a": sets.NewString("Alice"),
+						"b": sets.NewString("Bill"),
+					}),
+					log: logrus.WithField("plugin", "some_plugin"),
+				},
+			)
+			for _, approver := range test.approvers {
+				ap.AddApprover(approver, "REFERENCE", false)
+			}
+			ap.RequireIssue = test.requireIssue
+			ap.AssociatedIssue = test.associatedIssue
+			ap.Draft = test.draft
+			ap.UnresolvedThreads = test.unresolved
+			ap.ManuallyApproved = func() bool { return test.manuallyApproved }
+			if diff := cmp.Diff(test.expected, ap.BlockedReasons()); diff != "" {
+				t.Errorf("Unexpected blocked reasons (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnapprovedAreas(t *testing.T) {
+	tests := []struct {
+		name          string
+		approvers     []string
+		areaApprovers map[string][]string
+		expected      []string
+	}{
+		{
+			name:      "no area labels",
+			approvers: []string{"Alice", "Bill"},
+		},
+		{
+			name:          "area approver approved",
+			approvers:     []string{"alice", "Bill"},
+			areaApprovers: map[string][]string{"area/networking": {"Alice", "Carl"}},
+		},
+		{
+			name:          "no area approver approved",
+			approvers:     []string{"Alice", "Bill"},
+			areaApprovers: map[string][]string{"area/networking": {"Carl"}, "area/storage": {"Bill"}, "area/api": {"Dan"}},
+			expected:      []string{"area/api", "area/networking"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ap := NewApprovers(
+				Owners{
+					filenames: []string{"a/a.go", "b/b.go"},
+					repo: createFakeRepo(map[string]sets.String{
+						"a": sets.NewString("Alice"),
+						"b": sets.NewString("Bill"),
+					}),
+					log: logrus.WithField("plugin", "some_plugin"),
+				},
+			)
+			for _, approver := range test.approvers {
+				ap.AddApprover(approver, "REFERENCE", false)
+			}
+			ap.AreaApprovers = test.areaApprovers
+			if diff := cmp.Diff(test.expected, ap.UnapprovedAreas()); diff != "" {
+				t.Errorf("Unexpected unapproved areas (-want +got):\n%s", diff)
+			}
+			if got, expected := ap.RequirementsMet(), len(test.expected) == 0; got != expected {
+				t.Errorf("Expected requirements met: %t, but got %t.", expected, got)
+			}
+		})
+	}
+}
+
+func TestGetMessageBlockedReasons(t *testing.T) {
+	ap := NewApprovers(
+		Owners{
+			filenames: []string{"a/a.go"},
+			repo: createFakeRepo(map[string]sets.String{
+				"a": sets.NewString("Alice"),
+			}),
+			log: logrus.WithField("plugin", "some_plugin"),
+		},
+	)
+	ap.RequireIssue = true
+	message := func() string {
+		got := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master")
+		if got == nil {
+			t.Fatal("GetMessage() failed")
+		}
+		return *got
+	}
+
+	if got := message(); strings.Contains(got, "blocked") {
+		t.Errorf("Expected GetMessage() not to list blocked reasons by default, but got %q", got)
+	}
+
+	ap.ShowBlockedReasons = true
+	got := message()
+	want := "Approval is blocked by:\n- `unapproved-files`\n- `missing-issue`\n\nThe full list of commands"
+	if !strings.Contains(got, want) {
+		t.Errorf("Expected GetMessage() to contain %q, but got %q", want, got)
+	}
+	status, err := ParseNotificationStatus(got)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the approval status: %v", err)
+	}
+	if diff := cmp.Diff([]BlockedReason{BlockedReasonUnapprovedFiles, BlockedReasonMissingIssue}, status.BlockedReasons); diff != "" {
+		t.Errorf("Unexpected blocked reasons in the approval status (-want +got):\n%s", diff)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	repo := createFakeRepo(map[string]sets.String{
+		"a": sets.NewString("Alice"),
+		"b": sets.NewString("Bill"),
+		"c": sets.NewString("Carol"),
+	})
+	newApprovers := func(filenames []string, approvers ...string) Approvers {
+		ap := NewApprovers(Owners{filenames: filenames, repo: repo, log: logrus.WithField("plugin", "some_plugin")})
+		ap.Author = "Alice"
+		for _, approver := range approvers {
+			ap.AddApprover(approver, "REFERENCE", false)
+		}
+		return ap
+	}
+	hash := func(ap Approvers) string {
+		message := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master")
+		if message == nil {
+			t.Fatal("GetMessage() failed")
+		}
+		status, err := ParseNotificationStatus(*message)
+		if err != nil || status == nil {
+			t.Fatalf("Expected the message to embed the approval status, but got %q", *message)
+		}
+		return status.Hash
+	}
+
+	base := hash(newApprovers([]string{"a/a.go", "b/b.go", "c/c.go"}, "Alice", "Bill"))
+	if got := hash(newApprovers([]string{"c/c.go", "a/a.go", "b/b.go"}, "Bill", "Alice")); got != base {
+		t.Errorf("Expected the hash not to depend on ordering, but got %q and %q.", base, got)
+	}
+	if got := hash(newApprovers([]string{"a/a.go", "b/b.go", "c/c.go"}, "Alice")); got == base {
+		t.Errorf("Expected the hash to change when an approval is removed, but got %q for both.", got)
+	}
+	if got := hash(newApprovers([]string{"a/a.go", "b/b.go"}, "Alice", "Bill")); got == base {
+		t.Errorf("Expected the hash to change when the unapproved files change, but got %q for both.", got)
+	}
+
+	shownTimes := newApprovers([]string{"a/a.go", "b/b.go", "c/c.go"}, "Alice", "Bill")
+	shownTimes.ShowApprovalTimes = true
+	shownTimes.SetApprovalTime("Alice", "REFERENCE", time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+	shownTimesHash := hash(shownTimes)
+	shownTimes.SetApprovalTime("Alice", "REFERENCE", time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC))
+	if got := hash(shownTimes); got == shownTimesHash {
+		t.Errorf("Expected the hash to change with the shown approval times, but got %q for both.", got)
+	}
+	reset := newApprovers([]string{"a/a.go", "b/b.go", "c/c.go"}, "Alice", "Bill")
+	reset.ApprovalsResetAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	resetHash := hash(reset)
+	if resetHash == base {
+		t.Errorf("Expected the hash to change when approvals were reset, but got %q for both.", resetHash)
+	}
+	reset.ApprovalsResetOnBaseChange = true
+	if got := hash(reset); got == resetHash {
+		t.Errorf("Expected the hash to change with the cause of the reset, but got %q for both.", got)
+	}
+
+	selfApproved := newApprovers([]string{"a/a.go", "b/b.go", "c/c.go"}, "Bill")
+	selfApproved.AddImplicitSelfApprover("Alice", "REFERENCE")
+	selfApprovedHash := hash(selfApproved)
+	selfApproved.HideImplicitSelfApprove = true
+	if got := hash(selfApproved); got == selfApprovedHash {
+		t.Errorf("Expected the hash to change when the implicit self-approval is hidden, but got %q for both.", got)
+	}
+}
+
+func TestParseNotificationStatus(t *testing.T) {
+	ap := NewApprovers(
+		Owners{
+			filenames: []string{"a/a.go", "b/b.go"},
+			repo: createFakeRepo(map[string]sets.String{
+				"a": sets.NewString("Alice"),
+				"b": sets.NewString("Bill"),
+			}),
+			log: logrus.WithField("plugin", "some_plugin"),
+		},
+	)
+	ap.AddApprover("Alice", "REFERENCE", false)
+	ap.AssociatedIssue = 12
+	message := GetMessage(ap, &url.URL{Scheme: "https", Host: "github.com"}, "https://go.k8s.io/bot-commands", "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process", "org", "repo", "master")
+	if message == nil {
+		t.Fatal("GetMessage() failed")
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		expected    *NotificationStatus
+		expectedErr bool
+	}{
+		{
+			name: "round trip through GetMessage",
+			body: *message,
+			expected: &NotificationStatus{
+				Approved:        false,
+				UnapprovedDirs:  []string{"b"},
+				Approvers:       []string{"Alice"},
+				AssociatedIssue: 12,
+				Hash:            "03c7ef07aec57dfc",
+			},
+		},
+		{
+			name: "notification without status",
+			body: "[APPROVALNOTIFIER] This PR is **APPROVED**\n\nblah",
+		},
+		{
+			name:        "malformed status",
+			body:        "blah\n" + approvalStatusPrefix + "{not json} -->",
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, err := ParseNotificationStatus(test.body)
+			if test.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %t, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expected, status); diff != "" {
+				t.Errorf("unexpected status (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -18,14 +18,19 @@ package approvers
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/url"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 
@@ -38,18 +43,110 @@ import (
 const (
 	// ApprovalNotificationName defines the name used in the title for the approval notifications.
 	ApprovalNotificationName = "ApprovalNotifier"
+
+	// approvalStatusPrefix marks the HTML comment holding the NotificationStatus of a notification.
+	approvalStatusPrefix = "<!-- APPROVAL_STATUS="
+
+	// maxCommentLength is the maximum length of a comment accepted by GitHub.
+	maxCommentLength = 65536
 )
 
+var approvalStatusRegex = regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(approvalStatusPrefix) + `(.*) -->$`)
+
 // Repo allows querying and interacting with OWNERS information in a repo.
 type Repo interface {
 	Approvers(path string) layeredsets.String
 	LeafApprovers(path string) sets.String
+	Reviewers(path string) layeredsets.String
 	FindApproverOwnersForFile(file string) string
 	IsNoParentOwners(path string) bool
 	IsAutoApproveUnownedSubfolders(directory string) bool
+	RequiredReviewers(path string) sets.String
 	Filenames() ownersconfig.Filenames
 }
 
+// OwnershipResolver resolves the approvers required by the files of a change.
+// It allows computing approvals with an ownership system that is not based on
+// OWNERS files.
+type OwnershipResolver interface {
+	// RequiredApprovers maps each of filenames to the approvers that can
+	// approve it. Any one of them is enough to approve the file.
+	RequiredApprovers(filenames []string) (map[string]sets.String, error)
+}
+
+// NewRepoResolver returns the OwnershipResolver based on the OWNERS files of r.
+func NewRepoResolver(r Repo) OwnershipResolver {
+	return repoResolver{repo: r}
+}
+
+type repoResolver struct {
+	repo Repo
+}
+
+func (r repoResolver) RequiredApprovers(filenames []string) (map[string]sets.String, error) {
+	approvers := map[string]sets.String{}
+	for _, filename := range filenames {
+		approvers[filename] = r.repo.Approvers(r.repo.FindApproverOwnersForFile(filename)).Set()
+	}
+	return approvers, nil
+}
+
+// NewOwnersFromResolver constructs a new Owners instance whose approvers are
+// resolved by resolver. Every file is approved on its own, so the notification
+// lists the files themselves instead of OWNERS files.
+func NewOwnersFromResolver(log *logrus.Entry, filenames []string, resolver OwnershipResolver, s int64) (Owners, error) {
+	required, err := resolver.RequiredApprovers(filenames)
+	if err != nil {
+		return Owners{}, fmt.Errorf("failed to resolve approvers: %w", err)
+	}
+	r := resolverRepo{approvers: map[string]layeredsets.String{}}
+	for _, filename := range filenames {
+		approvers := sets.NewString()
+		for _, approver := range required[filename].List() {
+			approvers.Insert(strings.ToLower(approver))
+		}
+		r.approvers[filename] = layeredsets.NewString(approvers.List()...)
+	}
+	return NewOwners(log, filenames, r, s), nil
+}
+
+// resolverRepo is a Repo in which every file is its own OWNERS file.
+type resolverRepo struct {
+	approvers map[string]layeredsets.String
+}
+
+func (r resolverRepo) Approvers(path string) layeredsets.String {
+	return r.approvers[path]
+}
+
+func (r resolverRepo) LeafApprovers(path string) sets.String {
+	return r.approvers[path].Set()
+}
+
+func (r resolverRepo) Reviewers(path string) layeredsets.String {
+	return r.approvers[path]
+}
+
+func (r resolverRepo) FindApproverOwnersForFile(file string) string {
+	return file
+}
+
+func (r resolverRepo) IsNoParentOwners(path string) bool {
+	return true
+}
+
+func (r resolverRepo) IsAutoApproveUnownedSubfolders(directory string) bool {
+	return false
+}
+
+func (r resolverRepo) RequiredReviewers(path string) sets.String {
+	return nil
+}
+
+func (r resolverRepo) Filenames() ownersconfig.Filenames {
+	return ownersconfig.Filenames{}
+}
+
 // Owners provides functionality related to owners of a specific code change.
 type Owners struct {
 	// filenamesUnfiltered contains all files in a given PR, including those
@@ -70,6 +167,30 @@ func NewOwners(log *logrus.Entry, filenames []string, r Repo, s int64) Owners {
 	return Owners{filenamesUnfiltered: filenames, filenames: filenames, repo: r, seed: s, log: log}
 }
 
+// ExcludeFiles returns a copy of o in which the files matching any of filters
+// don't need approval. Like files in auto-approved unowned subfolders, they
+// still count as part of the change.
+func (o Owners) ExcludeFiles(filters []*regexp.Regexp) Owners {
+	if len(filters) == 0 {
+		return o
+	}
+	var filenames []string
+	for _, filename := range o.filenames {
+		excluded := false
+		for _, filter := range filters {
+			if filter.MatchString(filename) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filenames = append(filenames, filename)
+		}
+	}
+	o.filenames = filenames
+	return o
+}
+
 // GetApprovers returns a map from ownersFiles -> people that are approvers in them
 func (o Owners) GetApprovers() map[string]sets.String {
 	ownersToApprovers := map[string]sets.String{}
@@ -81,6 +202,17 @@ func (o Owners) GetApprovers() map[string]sets.String {
 	return ownersToApprovers
 }
 
+// GetReviewers returns a map from ownersFiles -> people that are reviewers in them
+func (o Owners) GetReviewers() map[string]sets.String {
+	ownersToReviewers := map[string]sets.String{}
+
+	for ownersFilename := range o.GetOwnersSet() {
+		ownersToReviewers[ownersFilename] = o.repo.Reviewers(ownersFilename).Set()
+	}
+
+	return ownersToReviewers
+}
+
 // GetLeafApprovers returns a map from ownersFiles -> people that are approvers in them (only the leaf)
 func (o Owners) GetLeafApprovers() map[string]sets.String {
 	ownersToApprovers := map[string]sets.String{}
@@ -122,19 +254,6 @@ func (o Owners) GetReverseMap(approvers map[string]sets.String) map[string]sets.
 	return approverOwnersfiles
 }
 
-func findMostCoveringApprover(allApprovers []string, reverseMap map[string]sets.String, unapproved sets.String) string {
-	maxCovered := 0
-	var bestPerson string
-	for _, approver := range allApprovers {
-		filesCanApprove := reverseMap[approver]
-		if filesCanApprove.Intersection(unapproved).Len() > maxCovered {
-			maxCovered = len(filesCanApprove)
-			bestPerson = approver
-		}
-	}
-	return bestPerson
-}
-
 // temporaryUnapprovedFiles returns the list of files that wouldn't be
 // approved by the given set of approvers.
 func (o Owners) temporaryUnapprovedFiles(approvers sets.String) sets.String {
@@ -164,20 +283,116 @@ func (o Owners) KeepCoveringApprovers(reverseMap map[string]sets.String, knownAp
 	return keptApprovers
 }
 
-// GetSuggestedApprovers solves the exact cover problem, finding an approver capable of
-// approving every OWNERS file in the PR
+// GetSuggestedApprovers solves the set cover problem, finding a minimal set of
+// potential approvers capable of approving every OWNERS file in the PR
 func (o Owners) GetSuggestedApprovers(reverseMap map[string]sets.String, potentialApprovers []string) sets.String {
-	ap := NewApprovers(o)
-	for !ap.RequirementsMet() {
-		newApprover := findMostCoveringApprover(potentialApprovers, reverseMap, ap.UnapprovedFiles())
-		if newApprover == "" {
-			o.log.Debugf("Couldn't find/suggest approvers for each files. Unapproved: %q", ap.UnapprovedFiles().List())
-			return ap.GetCurrentApproversSet()
+	owners := map[string]sets.String{}
+	for _, approver := range potentialApprovers {
+		for ownersFile := range reverseMap[approver] {
+			if _, ok := owners[ownersFile]; !ok {
+				owners[ownersFile] = sets.NewString()
+			}
+			owners[ownersFile].Insert(approver)
 		}
-		ap.AddApprover(newApprover, "", false)
 	}
 
-	return ap.GetCurrentApproversSet()
+	unapproved := NewApprovers(o).UnapprovedFiles()
+	suggested := sets.NewString(SuggestApprovers(unapproved, owners)...)
+	if uncovered := o.temporaryUnapprovedFiles(suggested); uncovered.Len() != 0 {
+		o.log.Debugf("Couldn't find/suggest approvers for each files. Unapproved: %q", uncovered.List())
+	}
+	return suggested
+}
+
+// maxSetCoverSteps bounds the search in SuggestApprovers, beyond which the
+// best cover found so far is used.
+const maxSetCoverSteps = 10000
+
+// SuggestApprovers returns a minimal set of approvers that can approve every
+// OWNERS file in unapproved together, where owners maps OWNERS files to their
+// approvers. Among minimal sets, the one whose members can approve the fewest
+// OWNERS files in total is preferred, following least privilege, and remaining
+// ties are broken alphabetically. OWNERS files without approvers are ignored.
+func SuggestApprovers(unapproved sets.String, owners map[string]sets.String) []string {
+	search := setCoverSearch{owners: owners, reverse: map[string]sets.String{}, steps: maxSetCoverSteps}
+	for ownersFile, approvers := range owners {
+		for approver := range approvers {
+			if _, ok := search.reverse[approver]; !ok {
+				search.reverse[approver] = sets.NewString()
+			}
+			search.reverse[approver].Insert(ownersFile)
+		}
+	}
+	coverable := sets.NewString()
+	for ownersFile := range unapproved {
+		if owners[ownersFile].Len() != 0 {
+			coverable.Insert(ownersFile)
+		}
+	}
+	search.run(coverable, nil)
+	return search.best
+}
+
+// setCoverSearch is a branch and bound search for the cover SuggestApprovers returns.
+type setCoverSearch struct {
+	owners  map[string]sets.String
+	reverse map[string]sets.String
+	steps   int
+	best    []string
+}
+
+func (s *setCoverSearch) run(uncovered sets.String, chosen []string) {
+	if s.steps == 0 {
+		return
+	}
+	s.steps--
+	if uncovered.Len() == 0 {
+		candidate := append([]string{}, chosen...)
+		sort.Strings(candidate)
+		if s.best == nil || s.better(candidate, s.best) {
+			s.best = candidate
+		}
+		return
+	}
+	// Covering uncovered needs at least one more approver.
+	if s.best != nil && len(chosen) >= len(s.best) {
+		return
+	}
+	// Branch on the OWNERS file with the fewest approvers to keep the search narrow.
+	var next string
+	for _, ownersFile := range uncovered.List() {
+		if next == "" || s.owners[ownersFile].Len() < s.owners[next].Len() {
+			next = ownersFile
+		}
+	}
+	for _, approver := range s.owners[next].List() {
+		s.run(uncovered.Difference(s.reverse[approver]), append(chosen, approver))
+	}
+}
+
+// better returns whether cover a is preferable to cover b.
+func (s *setCoverSearch) better(a, b []string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	if wa, wb := s.privilege(a), s.privilege(b); wa != wb {
+		return wa < wb
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// privilege is the total number of OWNERS files the approvers can approve.
+func (s *setCoverSearch) privilege(approvers []string) int {
+	total := 0
+	for _, approver := range approvers {
+		total += s.reverse[approver].Len()
+	}
+	return total
 }
 
 // GetOwnersSet returns a set containing all the Owners files necessary to get the PR approved
@@ -213,6 +428,27 @@ func (o Owners) GetShuffledApprovers() []string {
 	return people
 }
 
+// covers returns true if dir is ownersFile or one of its subdirectories whose
+// approvers are inherited from ownersFile, following removeSubdirs.
+func (o Owners) covers(ownersFile, dir string) bool {
+	canonicalize := func(p string) string {
+		if p == "." {
+			return ""
+		}
+		return p
+	}
+	path := dir
+	for {
+		if canonicalize(path) == ownersFile {
+			return true
+		}
+		if o.repo.IsNoParentOwners(path) || canonicalize(path) == "" {
+			return false
+		}
+		path = filepath.Dir(path)
+	}
+}
+
 // removeSubdirs takes a set of directories as an input and removes all subdirectories.
 // E.g. [a, a/b/c, d/e, d/e/f] -> [a, d/e]
 // Subdirs will not be removed if they are configured to have no parent OWNERS files or if any
@@ -242,22 +478,62 @@ func (o Owners) removeSubdirs(dirs sets.String) {
 
 // Approval has the information about each approval on a PR
 type Approval struct {
-	Login     string // Login of the approver (can include uppercase)
-	How       string // How did the approver approved
-	Reference string // Where did the approver approved
-	NoIssue   bool   // Approval also accepts missing associated issue
+	Login     string    // Login of the approver (can include uppercase)
+	How       string    // How did the approver approved
+	Reference string    // Where did the approver approved
+	NoIssue   bool      // Approval also accepts missing associated issue
+	Implicit  bool      // Approval is the implicit self-approval of the author
+	Time      time.Time // When the approval was given, if known
+	Expiry    time.Time // When the approval expires, if it was time-boxed
+	// DeletionsOnly limits the approval to OWNERS files whose files the PR
+	// only deletes.
+	DeletionsOnly bool
+	// UnreviewedFiles are the files changed after the commit a scoped
+	// approval reviewed the PR through. The approval doesn't approve the
+	// OWNERS files covering them.
+	UnreviewedFiles sets.String
+	// Alias is the OWNERS_ALIASES alias the approver approved as, if any.
+	Alias string
+	// Reason is the justification the approver gave, which the approval of
+	// SensitiveFiles requires.
+	Reason string
 }
 
 // String creates a link for the approval. Use `Login` if you just want the name.
 func (a Approval) String() string {
-	return fmt.Sprintf(
+	link := fmt.Sprintf(
 		`*<a href="%s" title="%s">%s</a>*`,
 		a.Reference,
 		a.How,
 		a.Login,
 	)
+	if a.Alias != "" {
+		link += fmt.Sprintf(" (as %s)", a.Alias)
+	}
+	if a.Reason != "" {
+		link += fmt.Sprintf(" (reason: %q)", a.Reason)
+	}
+	return link
 }
 
+// BlockedReason is a discrete cause that withholds approval of a PR.
+type BlockedReason string
+
+const (
+	// BlockedReasonDraft means that approval is withheld because the PR is a draft.
+	BlockedReasonDraft BlockedReason = "draft"
+	// BlockedReasonUnapprovedFiles means that some OWNERS files still need approval.
+	BlockedReasonUnapprovedFiles BlockedReason = "unapproved-files"
+	// BlockedReasonMissingIssue means that the required associated issue is missing.
+	BlockedReasonMissingIssue BlockedReason = "missing-issue"
+	// BlockedReasonUnapprovedAreas means that some area labels of the PR still
+	// need approval from their area approvers.
+	BlockedReasonUnapprovedAreas BlockedReason = "unapproved-areas"
+	// BlockedReasonUnresolvedThreads means that some review threads of the PR
+	// are still unresolved.
+	BlockedReasonUnresolvedThreads BlockedReason = "unresolved-threads"
+)
+
 // Approvers is struct that provide functionality with regard to approvals of a specific
 // code change.
 type Approvers struct {
@@ -267,6 +543,112 @@ type Approvers struct {
 	AssociatedIssue int
 	RequireIssue    bool
 
+	// EnforceRequiredReviewers makes the approval of an OWNERS file whose
+	// OWNERS declare required_reviewers need one of them among its approvers.
+	EnforceRequiredReviewers bool
+
+	// DeletedFiles are the files that the PR deletes, which are the only ones
+	// "deletions-only" approvals approve.
+	DeletedFiles sets.String
+
+	// SensitiveFiles are the files of the PR in sensitive paths. Their OWNERS
+	// files are only approved by approvals with a Reason.
+	SensitiveFiles sets.String
+
+	// UnmergedDependencies are the PRs which conditional approvals such as
+	// "/approve after:#123" wait for, and which haven't merged yet.
+	UnmergedDependencies map[int]bool
+
+	// FilesChangedAfter maps the commits that scoped approvals such as
+	// "/approve upto:abc1234" reviewed the PR through to the files changed by
+	// the later commits of the PR. Commits that aren't part of the PR are
+	// missing.
+	FilesChangedAfter map[string]sets.String
+
+	// AliasMembers maps the OWNERS_ALIASES aliases that approvals such as
+	// "/approve as:security-reviewers" name to their lower cased members.
+	AliasMembers map[string]sets.String
+
+	// AssociatedIssues are all issues associated with the PR, the first of
+	// which is AssociatedIssue.
+	AssociatedIssues []int
+	// ClosedIssues are the closed AssociatedIssues. Any of them keeps the
+	// associated issue requirement from being met.
+	ClosedIssues []int
+
+	// RequiredApprovers is the number of approvals needed for each OWNERS file.
+	// Values below 1 mean a single approval is enough.
+	RequiredApprovers int
+	// ApproverWeights is how many approvals an approval by the given login
+	// counts as. Logins are matched case-insensitively and default to 1.
+	ApproverWeights map[string]int
+	// AreaApprovers maps the area labels of the PR to the logins of which at
+	// least one has to approve explicitly, in addition to the OWNERS files.
+	AreaApprovers map[string][]string
+	// Draft withholds approval because the PR is still a draft.
+	Draft bool
+	// UnresolvedThreads is the number of unresolved review threads of the PR,
+	// which withhold approval.
+	UnresolvedThreads int
+	// FrozenUntil is when the ongoing freeze window ends, if any. The approved
+	// label isn't applied during a freeze.
+	FrozenUntil time.Time
+	// LgtmMissing is set if the PR is approved, but the approved label waits
+	// for the lgtm label.
+	LgtmMissing bool
+	// AwaitingLgtm is set if the PR is approved, but the approved label waits
+	// for the lgtm label to be added after the approval.
+	AwaitingLgtm bool
+	// QuorumCount, if positive, replaces approval per OWNERS file: the files
+	// are approved once that many distinct approvers of any of the OWNERS
+	// files approved.
+	QuorumCount int
+	// ApprovalsResetAt is when the approvals were reset because the associated
+	// issue or the base branch changed. Approvals given before then don't count.
+	ApprovalsResetAt time.Time
+	// ApprovalsResetOnBaseChange is set when the approvals were reset because
+	// the PR was retargeted to another base branch.
+	ApprovalsResetOnBaseChange bool
+	// DesignatedApprovers are the approvers assigned with "/approve assign".
+	DesignatedApprovers []string
+	// IgnoredDesignatedApprovers are the logins named with "/approve assign"
+	// that can't approve any of the unapproved files.
+	IgnoredDesignatedApprovers []string
+	// NotificationFooter is appended to the notification.
+	NotificationFooter string
+	// ShowApprovalTimes adds the time each OWNERS file became approved to the
+	// notification.
+	ShowApprovalTimes bool
+	// ShowBlockedReasons adds the BlockedReasons to the notification and its
+	// approval status.
+	ShowBlockedReasons bool
+	// HideImplicitSelfApprove omits the implicit self-approval of the author
+	// from the notification. It still counts towards approval.
+	HideImplicitSelfApprove bool
+	// NoIssueRequiresConsensus only waives the associated issue requirement if
+	// all current approvers asked for it with "/approve no-issue".
+	NoIssueRequiresConsensus bool
+	// LegacyNotificationFormat makes GetMessage emit the notification in the
+	// format of the old k8s-merge-robot, without the approval status.
+	LegacyNotificationFormat bool
+	// CompactNotification makes GetMessage emit a one-line summary of the
+	// approval state instead of the full notification.
+	CompactNotification bool
+	// CancelKeyword is the argument of "/approve" that the notification tells
+	// approvers to cancel their approval with, "cancel" if empty.
+	CancelKeyword string
+	// NotificationLocale is the language, such as "de" or "es-MX", that
+	// GetMessage renders the phrases of the notification in. Unknown locales
+	// fall back to English.
+	NotificationLocale string
+	// AnonymizeApprovers makes GetMessage show how many approvers approved
+	// instead of who did, without links to their approvals.
+	AnonymizeApprovers bool
+
+	// Author is the login of the PR author, who is never suggested as an
+	// approver of their own PR.
+	Author string
+
 	ManuallyApproved func() bool
 }
 
@@ -338,7 +720,24 @@ func (ap *Approvers) AddApprover(login, reference string, noIssue bool) {
 	}
 }
 
-// AddAuthorSelfApprover adds the author self approval
+// WithHypotheticalApprovers returns a copy of ap in which logins approved at
+// reference as well, e.g. to tell whether their approval would approve the PR.
+// ap itself is left unchanged.
+func (ap Approvers) WithHypotheticalApprovers(reference string, logins ...string) Approvers {
+	approvals := make(map[string]Approval, len(ap.approvers)+len(logins))
+	for login, approval := range ap.approvers {
+		approvals[login] = approval
+	}
+	ap.approvers = approvals
+	for _, login := range logins {
+		ap.AddApprover(login, reference, false)
+	}
+	return ap
+}
+
+// AddAuthorSelfApprover adds the author self approval. Like any other
+// approval, it only approves the OWNERS files that list the author as an
+// approver, not every file of the PR.
 func (ap *Approvers) AddAuthorSelfApprover(login, reference string, noIssue bool) {
 	if ap.shouldNotOverrideApproval(login, noIssue) {
 		return
@@ -351,11 +750,113 @@ func (ap *Approvers) AddAuthorSelfApprover(login, reference string, noIssue bool
 	}
 }
 
+// AddImplicitSelfApprover adds the self approval that the author gives by
+// opening the PR, if it doesn't require explicit self-approval. It is shown
+// apart from the other approvals in the notification.
+func (ap *Approvers) AddImplicitSelfApprover(login, reference string) {
+	if ap.shouldNotOverrideApproval(login, false) {
+		return
+	}
+	ap.approvers[strings.ToLower(login)] = Approval{
+		Login:     login,
+		How:       "Author self-approved",
+		Reference: reference,
+		Implicit:  true,
+	}
+}
+
+// SetApprovalTime records when the approval of login given at reference was
+// made. Nothing is recorded if that approval didn't override an earlier one.
+func (ap *Approvers) SetApprovalTime(login, reference string, at time.Time) {
+	login = strings.ToLower(login)
+	if approval, ok := ap.approvers[login]; ok && approval.Reference == reference {
+		approval.Time = at
+		ap.approvers[login] = approval
+	}
+}
+
+// SetApprovalExpiry records when the approval of login given at reference
+// expires. Nothing is recorded if that approval didn't override an earlier one.
+func (ap *Approvers) SetApprovalExpiry(login, reference string, expiry time.Time) {
+	login = strings.ToLower(login)
+	if approval, ok := ap.approvers[login]; ok && approval.Reference == reference {
+		approval.Expiry = expiry
+		ap.approvers[login] = approval
+	}
+}
+
+// SetApprovalDeletionsOnly limits the approval of login given at reference to
+// the OWNERS files whose files are all in DeletedFiles. Nothing is recorded if
+// that approval didn't override an earlier one.
+func (ap *Approvers) SetApprovalDeletionsOnly(login, reference string) {
+	login = strings.ToLower(login)
+	if approval, ok := ap.approvers[login]; ok && approval.Reference == reference {
+		approval.How = "Approved deletions only"
+		approval.DeletionsOnly = true
+		ap.approvers[login] = approval
+	}
+}
+
+// SetApprovalUnreviewedFiles limits the approval of login given at reference
+// to the OWNERS files not covering any of files. Nothing is recorded if that
+// approval didn't override an earlier one.
+func (ap *Approvers) SetApprovalUnreviewedFiles(login, reference string, files sets.String) {
+	login = strings.ToLower(login)
+	if approval, ok := ap.approvers[login]; ok && approval.Reference == reference {
+		approval.How = "Approved up to a commit"
+		approval.UnreviewedFiles = files
+		ap.approvers[login] = approval
+	}
+}
+
+// SetApprovalAlias attributes the approval of login given at reference to
+// alias. Nothing is recorded if that approval didn't override an earlier one.
+func (ap *Approvers) SetApprovalAlias(login, reference, alias string) {
+	login = strings.ToLower(login)
+	if approval, ok := ap.approvers[login]; ok && approval.Reference == reference {
+		approval.How = "Approved as " + alias
+		approval.Alias = alias
+		ap.approvers[login] = approval
+	}
+}
+
+// SetApprovalReason records the justification of the approval of login given
+// at reference. Nothing is recorded if that approval didn't override an
+// earlier one.
+func (ap *Approvers) SetApprovalReason(login, reference, reason string) {
+	login = strings.ToLower(login)
+	if approval, ok := ap.approvers[login]; ok && approval.Reference == reference {
+		approval.How = "Approved with a reason"
+		approval.Reason = reason
+		ap.approvers[login] = approval
+	}
+}
+
 // RemoveApprover removes an approver from the list.
 func (ap *Approvers) RemoveApprover(login string) {
 	delete(ap.approvers, strings.ToLower(login))
 }
 
+// RemoveNonApprovers removes the approvals of users that are not approvers in
+// any of the OWNERS files of the change, e.g. because they were removed from
+// OWNERS after approving. It returns the logins of the removed approvals.
+// Author self-approvals are kept, they only ever count for files the author owns,
+// and so are LGTMs of reviewers.
+func (ap *Approvers) RemoveNonApprovers() []string {
+	approverFiles := ap.owners.GetReverseMap(ap.owners.GetApprovers())
+	reviewerFiles := ap.owners.GetReverseMap(ap.owners.GetReviewers())
+	var removed []string
+	for _, login := range ap.GetCurrentApproversSet().List() {
+		approval := ap.approvers[login]
+		if len(approverFiles[login]) != 0 || approval.How == "Author self-approved" || (approval.How == "LGTM" && len(reviewerFiles[login]) != 0) {
+			continue
+		}
+		removed = append(removed, approval.Login)
+		ap.RemoveApprover(login)
+	}
+	return removed
+}
+
 // AddAssignees adds assignees to the list
 func (ap *Approvers) AddAssignees(logins ...string) {
 	for _, login := range logins {
@@ -413,9 +914,46 @@ func (ap Approvers) GetFilesApprovers() map[string]sets.String {
 		filesApprovers[ownersFilename] = CaseInsensitiveIntersection(currentApprovers, potentialApprovers)
 	}
 
+	for _, approval := range ap.approvers {
+		unjustified := approval.Reason == "" && ap.SensitiveFiles.Len() != 0
+		if !approval.DeletionsOnly && approval.UnreviewedFiles.Len() == 0 && !unjustified {
+			continue
+		}
+		for ownersFilename, approvers := range filesApprovers {
+			if (approval.DeletionsOnly && !ap.onlyDeletes(ownersFilename)) || ap.coversAny(ownersFilename, approval.UnreviewedFiles) ||
+				(unjustified && ap.coversAny(ownersFilename, ap.SensitiveFiles)) {
+				approvers.Delete(approval.Login)
+			}
+		}
+	}
+
 	return filesApprovers
 }
 
+// onlyDeletes returns true if all files of the PR that ownersFile covers are
+// in DeletedFiles.
+func (ap Approvers) onlyDeletes(ownersFile string) bool {
+	for _, filename := range ap.owners.filenames {
+		if ap.DeletedFiles.Has(filename) {
+			continue
+		}
+		if ap.owners.covers(ownersFile, ap.owners.repo.FindApproverOwnersForFile(filename)) {
+			return false
+		}
+	}
+	return true
+}
+
+// coversAny returns true if ownersFile covers any of files.
+func (ap Approvers) coversAny(ownersFile string, files sets.String) bool {
+	for file := range files {
+		if ap.owners.covers(ownersFile, ap.owners.repo.FindApproverOwnersForFile(file)) {
+			return true
+		}
+	}
+	return false
+}
+
 // NoIssueApprovers returns the list of people who have "no-issue"
 // approved the pull-request. They are included in the list if they can
 // approve one of the files.
@@ -438,23 +976,167 @@ func (ap Approvers) NoIssueApprovers() map[string]Approval {
 	return nia
 }
 
+// IsIssueWaived returns true if the approvers waived the associated issue
+// requirement. Without NoIssueRequiresConsensus a single "no-issue" approval is
+// enough, otherwise every approver that can approve one of the files must have
+// asked for it. The implicit approval of the author doesn't count either way.
+func (ap Approvers) IsIssueWaived() bool {
+	noIssueApprovers := ap.NoIssueApprovers()
+	if len(noIssueApprovers) == 0 {
+		return false
+	}
+	if !ap.NoIssueRequiresConsensus {
+		return true
+	}
+
+	reverseMap := ap.owners.GetReverseMap(ap.owners.GetApprovers())
+	for login, approver := range ap.approvers {
+		if len(reverseMap[login]) == 0 || (approver.How == "Author self-approved" && !approver.NoIssue) {
+			continue
+		}
+		if _, ok := noIssueApprovers[login]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// approverWeight returns how many approvals an approval by login counts as.
+func (ap Approvers) approverWeight(login string) int {
+	for approver, weight := range ap.ApproverWeights {
+		if strings.EqualFold(approver, login) {
+			return weight
+		}
+	}
+	return 1
+}
+
+// isFileApproved returns whether the weighted sum of approvers reaches the
+// number of approvals required for ownersFile. With EnforceRequiredReviewers,
+// one of the approvers also has to be a required reviewer of ownersFile, if it
+// has any.
+func (ap Approvers) isFileApproved(ownersFile string, approvers sets.String) bool {
+	if ap.EnforceRequiredReviewers {
+		if reviewers := ap.owners.repo.RequiredReviewers(ownersFile); reviewers.Len() > 0 && CaseInsensitiveIntersection(approvers, reviewers).Len() == 0 {
+			return false
+		}
+	}
+	required := ap.RequiredApprovers
+	if required < 1 {
+		required = 1
+	}
+	total := 0
+	for approver := range approvers {
+		total += ap.approverWeight(approver)
+	}
+	return total >= required
+}
+
+// ApprovalTimes returns when each approved OWNERS file became approved, that is
+// the time of the approval that made it reach the required number of approvals.
+// Files whose deciding approval has no known time, such as the implicit
+// self-approval of the author, are left out.
+func (ap Approvers) ApprovalTimes() map[string]time.Time {
+	required := ap.RequiredApprovers
+	if required < 1 {
+		required = 1
+	}
+	times := map[string]time.Time{}
+	for fn, approvers := range ap.GetFilesApprovers() {
+		if !ap.isFileApproved(fn, approvers) {
+			continue
+		}
+		var approvals []Approval
+		for approver := range approvers {
+			approvals = append(approvals, ap.approvers[strings.ToLower(approver)])
+		}
+		sort.SliceStable(approvals, func(i, j int) bool {
+			return approvals[i].Time.Before(approvals[j].Time)
+		})
+		total := 0
+		for _, approval := range approvals {
+			total += ap.approverWeight(approval.Login)
+			if total >= required {
+				if !approval.Time.IsZero() {
+					times[fn] = approval.Time
+				}
+				break
+			}
+		}
+	}
+	return times
+}
+
 // UnapprovedFiles returns owners files that still need approval
 func (ap Approvers) UnapprovedFiles() sets.String {
 	unapproved := sets.NewString()
 	for fn, approvers := range ap.GetFilesApprovers() {
-		if len(approvers) == 0 {
+		if !ap.isFileApproved(fn, approvers) {
 			unapproved.Insert(fn)
 		}
 	}
 	return unapproved
 }
 
+// FileApprovalStatus explains the approval of a single file of a PR.
+type FileApprovalStatus struct {
+	// Path is the explained file.
+	Path string
+	// Governed is false if Path doesn't need approval, e.g. because it is
+	// excluded, in an auto-approved unowned subfolder or not part of the PR.
+	Governed bool
+	// OwnersFile is the directory of the OWNERS file whose approval Path
+	// requires, empty if Path isn't governed.
+	OwnersFile string
+	// Candidates are the approvers of OwnersFile.
+	Candidates sets.String
+	// Approved is whether OwnersFile is approved. Files that aren't governed
+	// are always approved.
+	Approved bool
+	// ApprovedBy are the current approvers of OwnersFile.
+	ApprovedBy sets.String
+}
+
+// ExplainFile returns which OWNERS file governs path and whether it is
+// approved, to tell why a file blocks the approval of the PR.
+func (ap Approvers) ExplainFile(path string) FileApprovalStatus {
+	status := FileApprovalStatus{Path: path, Candidates: sets.NewString(), Approved: true, ApprovedBy: sets.NewString()}
+	needsApproval := false
+	for _, filename := range ap.owners.filenames {
+		if filename == path {
+			needsApproval = true
+			break
+		}
+	}
+	if !needsApproval {
+		return status
+	}
+	dir := ap.owners.repo.FindApproverOwnersForFile(path)
+	for _, ownersFile := range ap.owners.GetOwnersSet().List() {
+		if !ap.owners.covers(ownersFile, dir) {
+			continue
+		}
+		approvedBy := ap.GetFilesApprovers()[ownersFile]
+		status.Governed = true
+		status.OwnersFile = ownersFile
+		status.Candidates = ap.owners.repo.Approvers(ownersFile).Set()
+		status.Approved = ap.isFileApproved(ownersFile, approvedBy)
+		status.ApprovedBy = approvedBy
+		break
+	}
+	return status
+}
+
 // GetFiles returns owners files that still need approval.
 func (ap Approvers) GetFiles(baseURL *url.URL, branch string) []File {
 	var allOwnersFiles []File
 	filesApprovers := ap.GetFilesApprovers()
+	var approvalTimes map[string]time.Time
+	if ap.ShowApprovalTimes {
+		approvalTimes = ap.ApprovalTimes()
+	}
 	for _, file := range ap.owners.GetOwnersSet().List() {
-		if len(filesApprovers[file]) == 0 {
+		if !ap.isFileApproved(file, filesApprovers[file]) {
 			allOwnersFiles = append(allOwnersFiles, UnapprovedFile{
 				baseURL:        baseURL,
 				filepath:       file,
@@ -462,13 +1144,20 @@ func (ap Approvers) GetFiles(baseURL *url.URL, branch string) []File {
 				branch:         branch,
 			})
 		} else {
-			allOwnersFiles = append(allOwnersFiles, ApprovedFile{
+			approved := ApprovedFile{
 				baseURL:        baseURL,
 				filepath:       file,
 				ownersFilename: ap.owners.repo.Filenames().Owners,
 				approvers:      filesApprovers[file],
 				branch:         branch,
-			})
+			}
+			if approvedAt, ok := approvalTimes[file]; ok {
+				allOwnersFiles = append(allOwnersFiles, TimedApprovedFile{ApprovedFile: approved, approvedAt: approvedAt, anonymized: ap.AnonymizeApprovers})
+			} else if ap.AnonymizeApprovers {
+				allOwnersFiles = append(allOwnersFiles, AnonymizedApprovedFile{ApprovedFile: approved})
+			} else {
+				allOwnersFiles = append(allOwnersFiles, approved)
+			}
 		}
 	}
 
@@ -491,19 +1180,45 @@ func (ap Approvers) GetFiles(baseURL *url.URL, branch string) []File {
 // assignees.
 // The goal of this second step is to only keep the assignees that are
 // the most useful.
+//
+// The author is never suggested, see AuthorCanSelfApprove instead.
 func (ap Approvers) GetCCs() []string {
-	randomizedApprovers := ap.owners.GetShuffledApprovers()
+	var randomizedApprovers []string
+	for _, approver := range ap.owners.GetShuffledApprovers() {
+		if !strings.EqualFold(approver, ap.Author) {
+			randomizedApprovers = append(randomizedApprovers, approver)
+		}
+	}
+	assignees := ap.assignees
+	if ap.Author != "" {
+		assignees = assignees.Difference(sets.NewString(strings.ToLower(ap.Author)))
+	}
 
 	currentApprovers := ap.GetCurrentApproversSet()
-	approversAndAssignees := currentApprovers.Union(ap.assignees)
+	approversAndAssignees := currentApprovers.Union(assignees)
 	leafReverseMap := ap.owners.GetReverseMap(ap.owners.GetLeafApprovers())
 	suggested := ap.owners.KeepCoveringApprovers(leafReverseMap, approversAndAssignees, randomizedApprovers)
 	approversAndSuggested := currentApprovers.Union(suggested)
-	everyone := approversAndSuggested.Union(ap.assignees)
+	everyone := approversAndSuggested.Union(assignees)
 	fullReverseMap := ap.owners.GetReverseMap(ap.owners.GetApprovers())
 	keepAssignees := ap.owners.KeepCoveringApprovers(fullReverseMap, approversAndSuggested, everyone.List())
 
-	return suggested.Union(keepAssignees).List()
+	ccs := suggested.Union(keepAssignees)
+	if ap.Author != "" {
+		ccs.Delete(strings.ToLower(ap.Author))
+	}
+	return ccs.List()
+}
+
+// AuthorCanSelfApprove returns true if the author hasn't approved yet but is an
+// approver of one of the unapproved OWNERS files, so that they can approve
+// those themselves with "/approve".
+func (ap Approvers) AuthorCanSelfApprove() bool {
+	if ap.Author == "" || ap.GetCurrentApproversSet().Has(strings.ToLower(ap.Author)) {
+		return false
+	}
+	reverseMap := ap.owners.GetReverseMap(ap.owners.GetApprovers())
+	return reverseMap[strings.ToLower(ap.Author)].Intersection(ap.UnapprovedFiles()).Len() != 0
 }
 
 // AreFilesApproved returns a bool indicating whether or not OWNERS files associated with
@@ -511,17 +1226,132 @@ func (ap Approvers) GetCCs() []string {
 // returns true, the PR may still not be fully approved depending on the associated issue
 // requirement
 func (ap Approvers) AreFilesApproved() bool {
+	if ap.QuorumCount > 0 {
+		return len(ap.owners.filenames) != 0 && ap.QuorumApprovers().Len() >= ap.QuorumCount
+	}
 	return (len(ap.owners.filenames) != 0 || len(ap.owners.filenamesUnfiltered) != 0) && ap.UnapprovedFiles().Len() == 0
 }
 
+// QuorumApprovers returns the current approvers, lower cased, that are
+// approvers in any of the OWNERS files of the PR and thus count towards
+// QuorumCount. The implicit self-approval of the author doesn't count, so that
+// a quorum always needs that many approvers to act.
+func (ap Approvers) QuorumApprovers() sets.String {
+	potential := sets.NewString()
+	for _, approvers := range ap.owners.GetApprovers() {
+		potential = potential.Union(approvers)
+	}
+	current := ap.GetCurrentApproversSet()
+	if implicit := ap.ImplicitSelfApproval(); implicit != nil {
+		current.Delete(strings.ToLower(implicit.Login))
+	}
+	return CaseInsensitiveIntersection(current, potential)
+}
+
+// IsOwnershipUndefined returns true if files need approval, but none of the
+// OWNERS files covering them lists any approver, e.g. because the repo has no
+// OWNERS files at all. Such a PR can only be approved manually, never
+// vacuously.
+func (ap Approvers) IsOwnershipUndefined() bool {
+	ownersApprovers := ap.owners.GetApprovers()
+	if len(ownersApprovers) == 0 {
+		return false
+	}
+	for _, approvers := range ownersApprovers {
+		if approvers.Len() != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPartiallyApproved returns true if the PR is not approved, but some of its
+// OWNERS files are.
+func (ap Approvers) IsPartiallyApproved() bool {
+	if ap.IsApproved() {
+		return false
+	}
+	approved, unapproved := 0, 0
+	for fn, approvers := range ap.GetFilesApprovers() {
+		if ap.isFileApproved(fn, approvers) {
+			approved++
+		} else {
+			unapproved++
+		}
+	}
+	return approved > 0 && unapproved > 0
+}
+
 // RequirementsMet returns a bool indicating whether the PR has met all approval requirements:
+// - the PR is not a draft whose approval is withheld AND
 // - all OWNERS files associated with the PR have been approved AND
+// - all area labels of the PR have been approved by one of their area approvers AND
 // EITHER
 // 	- the munger config is such that an issue is not required to be associated with the PR
 // 	- that there is an associated issue with the PR
 // 	- an OWNER has indicated that the PR is trivial enough that an issue need not be associated with the PR
+// 	  (all of them, if NoIssueRequiresConsensus is set)
 func (ap Approvers) RequirementsMet() bool {
-	return ap.AreFilesApproved() && (!ap.RequireIssue || ap.AssociatedIssue != 0 || len(ap.NoIssueApprovers()) != 0)
+	return !ap.Draft && ap.UnresolvedThreads == 0 && ap.AreFilesApproved() && ap.IsIssueRequirementMet() && len(ap.UnapprovedAreas()) == 0
+}
+
+// UnapprovedAreas returns the sorted area labels of AreaApprovers that none of
+// their area approvers approved explicitly yet.
+func (ap Approvers) UnapprovedAreas() []string {
+	if len(ap.AreaApprovers) == 0 {
+		return nil
+	}
+	approved := sets.NewString()
+	for _, approval := range ap.ListExplicitApprovals() {
+		approved.Insert(strings.ToLower(approval.Login))
+	}
+	var unapproved []string
+	for area, logins := range ap.AreaApprovers {
+		satisfied := false
+		for _, login := range logins {
+			if approved.Has(strings.ToLower(login)) {
+				satisfied = true
+				break
+			}
+		}
+		if !satisfied {
+			unapproved = append(unapproved, area)
+		}
+	}
+	sort.Strings(unapproved)
+	return unapproved
+}
+
+// BlockedReasons returns the causes that withhold approval of the PR, in the
+// order they should be addressed. Only the requirements that are enabled, such
+// as RequireIssue, can block approval. It is empty if the PR is approved.
+func (ap Approvers) BlockedReasons() []BlockedReason {
+	if ap.IsApproved() {
+		return nil
+	}
+	var reasons []BlockedReason
+	if ap.Draft {
+		reasons = append(reasons, BlockedReasonDraft)
+	}
+	if ap.UnresolvedThreads != 0 {
+		reasons = append(reasons, BlockedReasonUnresolvedThreads)
+	}
+	if !ap.AreFilesApproved() {
+		reasons = append(reasons, BlockedReasonUnapprovedFiles)
+	}
+	if len(ap.UnapprovedAreas()) != 0 {
+		reasons = append(reasons, BlockedReasonUnapprovedAreas)
+	}
+	if !ap.IsIssueRequirementMet() {
+		reasons = append(reasons, BlockedReasonMissingIssue)
+	}
+	return reasons
+}
+
+// IsIssueRequirementMet returns true unless an associated issue is required
+// but missing or closed, and the requirement wasn't waived.
+func (ap Approvers) IsIssueRequirementMet() bool {
+	return !ap.RequireIssue || (ap.AssociatedIssue != 0 && len(ap.ClosedIssues) == 0) || ap.IsIssueWaived()
 }
 
 // IsApproved returns a bool indicating whether the PR is fully approved.
@@ -541,6 +1371,30 @@ func (ap Approvers) ListApprovals() []Approval {
 	return approvals
 }
 
+// ListExplicitApprovals returns the list of approvals, except for the implicit
+// self-approval of the author.
+func (ap Approvers) ListExplicitApprovals() []Approval {
+	approvals := []Approval{}
+
+	for _, approval := range ap.ListApprovals() {
+		if !approval.Implicit {
+			approvals = append(approvals, approval)
+		}
+	}
+
+	return approvals
+}
+
+// ImplicitSelfApproval returns the implicit self-approval of the author, if any.
+func (ap Approvers) ImplicitSelfApproval() *Approval {
+	for _, approval := range ap.approvers {
+		if approval.Implicit {
+			return &approval
+		}
+	}
+	return nil
+}
+
 // ListNoIssueApprovals returns the list of "no-issue" approvals
 func (ap Approvers) ListNoIssueApprovals() []Approval {
 	approvals := []Approval{}
@@ -577,6 +1431,19 @@ type ApprovedFile struct {
 	branch    string
 }
 
+// TimedApprovedFile is an ApprovedFile that also shows when it became approved.
+type TimedApprovedFile struct {
+	ApprovedFile
+	approvedAt time.Time
+	anonymized bool
+}
+
+// AnonymizedApprovedFile is an ApprovedFile that shows how many users approved
+// it instead of who did.
+type AnonymizedApprovedFile struct {
+	ApprovedFile
+}
+
 // UnapprovedFile contains the information of a an unapproved file.
 type UnapprovedFile struct {
 	baseURL        *url.URL
@@ -586,6 +1453,15 @@ type UnapprovedFile struct {
 }
 
 func (a ApprovedFile) String() string {
+	return a.format(strings.Join(a.approvers.List(), ","))
+}
+
+func (a AnonymizedApprovedFile) String() string {
+	return a.format(approverCount(a.approvers.Len()))
+}
+
+// format renders a with the given description of its approvers.
+func (a ApprovedFile) format(approvers string) string {
 	fullOwnersPath := filepath.Join(a.filepath, a.ownersFilename)
 	if strings.HasSuffix(a.filepath, ".md") {
 		fullOwnersPath = a.filepath
@@ -595,7 +1471,29 @@ func (a ApprovedFile) String() string {
 		a.branch,
 		fullOwnersPath,
 	)
-	return fmt.Sprintf("- ~~[%s](%s)~~ [%v]\n", fullOwnersPath, link, strings.Join(a.approvers.List(), ","))
+	return fmt.Sprintf("- ~~[%s](%s)~~ [%s]\n", fullOwnersPath, link, approvers)
+}
+
+// approverCount describes n approvers, e.g. "2 approvers".
+func approverCount(n int) string {
+	if n == 1 {
+		return "1 approver"
+	}
+	return fmt.Sprintf("%d approvers", n)
+}
+
+// ApproverCount describes how many users gave approvals, e.g. "2 approvers",
+// for notifications that don't name them.
+func (ap Approvers) ApproverCount(approvals []Approval) string {
+	return approverCount(len(approvals))
+}
+
+func (t TimedApprovedFile) String() string {
+	var approved File = t.ApprovedFile
+	if t.anonymized {
+		approved = AnonymizedApprovedFile{ApprovedFile: t.ApprovedFile}
+	}
+	return strings.TrimSuffix(approved.String(), "\n") + fmt.Sprintf(" (approved %s)\n", t.approvedAt.UTC().Format("2006-01-02 15:04 MST"))
 }
 
 func (ua UnapprovedFile) String() string {
@@ -611,11 +1509,67 @@ func (ua UnapprovedFile) String() string {
 	return fmt.Sprintf("- **[%s](%s)**\n", fullOwnersPath, link)
 }
 
+// notificationCatalog translates the phrases of the notification, keyed by
+// language and then by the English phrase. Phrases missing from the catalog of
+// a language are rendered in English.
+var notificationCatalog = map[string]map[string]string{
+	"de": {
+		"This PR is **APPROVED**":                                            "Dieser PR ist **GENEHMIGT**",
+		"This PR is **NOT APPROVED**":                                        "Dieser PR ist **NICHT GENEHMIGT**",
+		"Approval requirements bypassed by manually added approval.":         "Die Genehmigungsanforderungen wurden durch eine manuell hinzugefügte Genehmigung umgangen.",
+		"This pull-request has been approved by:":                            "Dieser Pull-Request wurde genehmigt von:",
+		"Approval is blocked by:":                                            "Die Genehmigung wird blockiert durch:",
+		"The full list of commands accepted by this bot can be found [here]": "Die vollständige Liste der Befehle, die dieser Bot akzeptiert, findet sich [hier]",
+		"The pull request process is described [here]":                       "Der Pull-Request-Prozess ist [hier] beschrieben",
+		"Needs approval from an approver in each of these files:":            "Benötigt die Genehmigung eines Approvers aus jeder dieser Dateien:",
+		"Approvers can indicate their approval by writing %s in a comment":   "Approver können ihre Genehmigung erteilen, indem sie %s in einem Kommentar schreiben",
+		"Approvers can cancel approval by writing %s in a comment":           "Approver können ihre Genehmigung zurückziehen, indem sie %s in einem Kommentar schreiben",
+	},
+	"es": {
+		"This PR is **APPROVED**":                                            "Este PR está **APROBADO**",
+		"This PR is **NOT APPROVED**":                                        "Este PR **NO está APROBADO**",
+		"Approval requirements bypassed by manually added approval.":         "Los requisitos de aprobación se omitieron mediante una aprobación añadida manualmente.",
+		"This pull-request has been approved by:":                            "Este pull request ha sido aprobado por:",
+		"Approval is blocked by:":                                            "La aprobación está bloqueada por:",
+		"The full list of commands accepted by this bot can be found [here]": "La lista completa de comandos aceptados por este bot se encuentra [aquí]",
+		"The pull request process is described [here]":                       "El proceso de pull request se describe [aquí]",
+		"Needs approval from an approver in each of these files:":            "Necesita la aprobación de un aprobador de cada uno de estos archivos:",
+		"Approvers can indicate their approval by writing %s in a comment":   "Los aprobadores pueden indicar su aprobación escribiendo %s en un comentario",
+		"Approvers can cancel approval by writing %s in a comment":           "Los aprobadores pueden cancelar su aprobación escribiendo %s en un comentario",
+	},
+}
+
+// localize returns the function translating phrases to locale, such as "de"
+// or "es-MX", and formatting them with the given arguments. Locales without a
+// catalog of their own use the catalog of their language, if any.
+func localize(locale string) func(string, ...interface{}) string {
+	locale = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+	catalog, ok := notificationCatalog[locale]
+	if !ok {
+		catalog = notificationCatalog[strings.SplitN(locale, "-", 2)[0]]
+	}
+	return func(phrase string, args ...interface{}) string {
+		if translated, ok := catalog[phrase]; ok {
+			phrase = translated
+		}
+		if len(args) == 0 {
+			return phrase
+		}
+		return fmt.Sprintf(phrase, args...)
+	}
+}
+
 // GenerateTemplate takes a template, name and data, and generates
 // the corresponding string.
 func GenerateTemplate(templ, name string, data interface{}) (string, error) {
+	return generateTemplate(templ, name, "", data)
+}
+
+// generateTemplate is GenerateTemplate with the phrases passed to the "t"
+// template function translated to locale.
+func generateTemplate(templ, name, locale string, data interface{}) (string, error) {
 	buf := bytes.NewBufferString("")
-	if messageTempl, err := template.New(name).Parse(templ); err != nil {
+	if messageTempl, err := template.New(name).Funcs(template.FuncMap{"t": localize(locale)}).Parse(templ); err != nil {
 		return "", fmt.Errorf("failed to parse template for %s: %v", name, err)
 	} else if err := messageTempl.Execute(buf, data); err != nil {
 		return "", fmt.Errorf("failed to execute template for %s: %v", name, err)
@@ -632,11 +1586,64 @@ func GenerateTemplate(templ, name string, data interface{}) (string, error) {
 // 	- how an approver can cancel their approval
 func GetMessage(ap Approvers, linkURL *url.URL, commandHelpLink, prProcessLink, org, repo, branch string) *string {
 	linkURL.Path = org + "/" + repo
-	message, err := GenerateTemplate(`{{if (and (not .ap.RequirementsMet) (call .ap.ManuallyApproved )) }}
-Approval requirements bypassed by manually added approval.
+	if ap.LegacyNotificationFormat {
+		return getLegacyMessage(ap, linkURL, commandHelpLink, branch)
+	}
+	if ap.CompactNotification {
+		return getCompactMessage(ap)
+	}
+	message, err := generateTemplate(`{{if (and (not .ap.RequirementsMet) (call .ap.ManuallyApproved )) }}
+{{t "Approval requirements bypassed by manually added approval."}}
+
+{{end -}}
+{{if not .ap.ApprovalsResetAt.IsZero -}}
+The {{if .ap.ApprovalsResetOnBaseChange}}base branch{{else}}associated issue{{end}} of this PR changed, so approvals given before {{.ap.ApprovalsResetAt.UTC.Format "2006-01-02 15:04 MST"}} no longer count.
+
+{{end -}}
+{{if .ap.Draft -}}
+This PR is a draft. Approval is withheld until it is marked as ready for review.
 
 {{end -}}
-This pull-request has been approved by:{{range $index, $approval := .ap.ListApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}
+{{if .ap.UnresolvedThreads -}}
+This PR has {{.ap.UnresolvedThreads}} unresolved review thread{{if gt .ap.UnresolvedThreads 1}}s{{end}}. Approval is withheld until {{if gt .ap.UnresolvedThreads 1}}they are{{else}}it is{{end}} resolved.
+
+{{end -}}
+{{if not .ap.FrozenUntil.IsZero -}}
+Approvals are frozen until {{.ap.FrozenUntil.UTC.Format "2006-01-02 15:04 MST"}}. The `+"`approved`"+` label won't be applied before then.
+
+{{end -}}
+{{if .ap.LgtmMissing -}}
+The `+"`approved`"+` label will be applied once the PR has the `+"`lgtm`"+` label.
+
+{{else if .ap.AwaitingLgtm -}}
+The `+"`approved`"+` label will be applied once the `+"`lgtm`"+` label is added after the approval.
+
+{{end -}}
+{{if .ap.QuorumCount -}}
+This PR needs the approval of {{.ap.QuorumCount}} distinct approvers from any of the OWNERS files below, regardless of which directories they own. {{.ap.QuorumApprovers.Len}} of them approved so far.
+
+{{end -}}
+{{if (and .ap.SensitiveFiles.Len (not .ap.AreFilesApproved)) -}}
+This PR changes sensitive paths, which are only approved by approvals giving a reason, such as `+"`/approve reason:\"...\"`"+`.
+
+{{end -}}
+{{if .ap.IsOwnershipUndefined -}}
+No OWNERS file with approvers covers the changed files, so ownership is undefined and this PR can't be approved through OWNERS. Please add OWNERS files, or ask for the approval label to be applied manually.
+
+{{end -}}
+{{t "This pull-request has been approved by:"}}{{if .ap.AnonymizeApprovers}}{{with .ap.ListExplicitApprovals}} {{$.ap.ApproverCount .}}{{end}}{{else}}{{range $index, $approval := .ap.ListExplicitApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}{{end}}
+{{- if not .ap.HideImplicitSelfApprove}}{{with .ap.ImplicitSelfApproval}}
+Self-approved (implicit) by the author: {{.}}
+{{- end}}{{end}}
+{{- with .ap.DesignatedApprovers}}
+Designated approvers: {{range $index, $login := .}}{{if $index}}, {{end}}**{{$login}}**{{end}}
+{{- end}}
+{{- with .ap.IgnoredDesignatedApprovers}}
+Not designated, as they can't approve any of the unapproved files: {{range $index, $login := .}}{{if $index}}, {{end}}{{$login}}{{end}}
+{{- end}}
+{{- with .ap.UnapprovedAreas}}
+Still needs approval from an area approver of: {{range $index, $area := .}}{{if $index}}, {{end}}`+"`{{$area}}`"+`{{end}}
+{{- end}}
 
 {{- if (and (not .ap.AreFilesApproved) (not (call .ap.ManuallyApproved))) }}
 {{ if len .ap.SuggestedCCs -}}
@@ -651,14 +1658,24 @@ You can assign the PR to them by writing `+"`/assign {{range $index, $cc := .ap.
 To complete the [pull request process]({{ .prProcessLink }}), please ask for approval from {{range $index, $cc := .ap.AssignedCCs}}{{if $index}}, {{end}}**{{$cc}}**{{end}} after the PR has been reviewed.
 {{- end}}
 {{- end}}
+{{- if .ap.AuthorCanSelfApprove}}
+As an approver, the PR author **{{.ap.Author}}** can approve their own changes by writing `+"`/approve`"+` in a comment.
+{{- end}}
 {{- end}}
 
 {{if not .ap.RequireIssue -}}
 {{else if .ap.AssociatedIssue -}}
+{{if gt (len .ap.AssociatedIssues) 1 -}}
+Associated issues: {{range $index, $issue := .ap.AssociatedIssues}}{{if $index}}, {{end}}*#{{$issue}}*{{end}}
+{{- else -}}
 Associated issue: *#{{.ap.AssociatedIssue}}*
+{{- end}}
+{{- with .ap.ClosedIssues}}
+*Closed associated issues*: {{range $index, $issue := .}}{{if $index}}, {{end}}*#{{$issue}}*{{end}}. All associated issues need to be open, or get approval with `+"`/approve no-issue`"+`
+{{- end}}
 
-{{ else if len .ap.NoIssueApprovers -}}
-Associated issue requirement bypassed by:{{range $index, $approval := .ap.ListNoIssueApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}
+{{ else if .ap.IsIssueWaived -}}
+Associated issue requirement bypassed by:{{if .ap.AnonymizeApprovers}} {{.ap.ApproverCount .ap.ListNoIssueApprovals}}{{else}}{{range $index, $approval := .ap.ListNoIssueApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}{{end}}
 
 {{ else if call .ap.ManuallyApproved -}}
 *No associated issue*. Requirement bypassed by manually added approval.
@@ -668,24 +1685,92 @@ Associated issue requirement bypassed by:{{range $index, $approval := .ap.ListNo
 
 {{ end -}}
 
-The full list of commands accepted by this bot can be found [here]({{ .commandHelpLink }}?repo={{ .org }}%2F{{ .repo }}).
+{{if .ap.ShowBlockedReasons}}{{with .ap.BlockedReasons -}}
+{{t "Approval is blocked by:"}}
+{{range .}}- `+"`{{.}}`"+`
+{{end}}
+{{end}}{{end -}}
+{{t "The full list of commands accepted by this bot can be found [here]"}}({{ .commandHelpLink }}?repo={{ .org }}%2F{{ .repo }}).
 
 {{ if (or .ap.AreFilesApproved (call .ap.ManuallyApproved)) -}}
-The pull request process is described [here]({{ .prProcessLink }})
+{{t "The pull request process is described [here]"}}({{ .prProcessLink }})
 
 {{ end -}}
 <details {{if (and (not .ap.AreFilesApproved) (not (call .ap.ManuallyApproved))) }}open{{end}}>
-Needs approval from an approver in each of these files:
+{{t "Needs approval from an approver in each of these files:"}}
 
 {{range .ap.GetFiles .baseURL .branch}}{{.}}{{end}}
-Approvers can indicate their approval by writing `+"`/approve`"+` in a comment
-Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment
-</details>`, "message", map[string]interface{}{"ap": ap, "baseURL": linkURL, "commandHelpLink": commandHelpLink, "prProcessLink": prProcessLink, "org": org, "repo": repo, "branch": branch})
+{{t "Approvers can indicate their approval by writing %s in a comment" "`+"`/approve`"+`"}}
+{{t "Approvers can cancel approval by writing %s in a comment" (printf "`+"`/approve %s`"+`" (or .ap.CancelKeyword "cancel"))}}
+</details>`, "message", ap.NotificationLocale, map[string]interface{}{"ap": ap, "baseURL": linkURL, "commandHelpLink": commandHelpLink, "prProcessLink": prProcessLink, "org": org, "repo": repo, "branch": branch})
 	if err != nil {
 		ap.owners.log.WithError(err).Errorf("Error generating message.")
 		return nil
 	}
-	message += getGubernatorMetadata(ap.GetCCs())
+	title, err := generateTemplate(`{{if .IsApproved}}{{t "This PR is **APPROVED**"}}{{else}}{{t "This PR is **NOT APPROVED**"}}{{end}}`, "title", ap.NotificationLocale, ap)
+	if err != nil {
+		ap.owners.log.WithError(err).Errorf("Error generating title.")
+		return nil
+	}
+	gubernatorMetadata := getGubernatorMetadata(ap.GetCCs())
+	metadata := gubernatorMetadata + getApprovalStatus(ap, title, message, gubernatorMetadata, ap.NotificationFooter)
+
+	return notification(ApprovalNotificationName, title, addFooter(ap, message, title, metadata)+metadata)
+}
+
+// getCompactMessage returns the notification as a one-line summary such as
+// "Approval: 3/5 directories approved — needs @alice, @bob.". It still embeds
+// the approval status, so that it is deduped like the full notification.
+func getCompactMessage(ap Approvers) *string {
+	total := ap.owners.GetOwnersSet().Len()
+	title, err := GenerateTemplate(`Approval: {{.approved}}/{{.total}} directories approved
+{{- if .ap.IsApproved}}.
+{{- else if (and (not .ap.AreFilesApproved) (len .ap.GetCCs))}} — needs {{range $index, $cc := .ap.GetCCs}}{{if $index}}, {{end}}@{{$cc}}{{end}}.
+{{- else}} — not approved yet.
+{{- end}}`, "compact message", map[string]interface{}{"ap": ap, "approved": total - ap.UnapprovedFiles().Len(), "total": total})
+	if err != nil {
+		ap.owners.log.WithError(err).Errorf("Error generating compact message.")
+		return nil
+	}
+	metadata := getApprovalStatus(ap, title, ap.NotificationFooter)
+	return notification(ApprovalNotificationName, title, addFooter(ap, "", title, metadata)+metadata)
+}
+
+// getLegacyMessage returns the notification in the format used by the old
+// k8s-merge-robot, for tooling that still parses it.
+func getLegacyMessage(ap Approvers, linkURL *url.URL, commandHelpLink, branch string) *string {
+	message, err := GenerateTemplate(`This pull-request has been approved by:{{if .ap.AnonymizeApprovers}}{{with .ap.ListApprovals}} {{$.ap.ApproverCount .}}{{end}}{{else}}{{range $index, $approval := .ap.ListApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}{{end}}
+{{- if (and (not .ap.AreFilesApproved) (not (call .ap.ManuallyApproved)) (len .ap.SuggestedCCs)) }}
+We suggest the following additional approver{{if ne 1 (len .ap.SuggestedCCs)}}s{{end}}: {{range $index, $cc := .ap.SuggestedCCs}}{{if $index}}, {{end}}**{{$cc}}**{{end}}
+
+Assign the PR to them by writing `+"`/assign {{range $index, $cc := .ap.SuggestedCCs}}{{if $index}} {{end}}@{{$cc}}{{end}}`"+` in a comment when ready.
+{{- end}}
+
+{{if not .ap.RequireIssue -}}
+{{else if .ap.AssociatedIssue -}}
+Associated issue: *#{{.ap.AssociatedIssue}}*
+
+{{ else if .ap.IsIssueWaived -}}
+Associated issue requirement bypassed by:{{if .ap.AnonymizeApprovers}} {{.ap.ApproverCount .ap.ListNoIssueApprovals}}{{else}}{{range $index, $approval := .ap.ListNoIssueApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}{{end}}
+
+{{ else -}}
+*No associated issue*. Update pull-request body to add a reference to an issue, or get approval with `+"`/approve no-issue`"+`
+
+{{ end -}}
+The full list of commands accepted by this bot can be found [here]({{ .commandHelpLink }}).
+
+<details {{if (and (not .ap.AreFilesApproved) (not (call .ap.ManuallyApproved))) }}open{{end}}>
+Needs approval from an approver in each of these OWNERS Files:
+
+{{range .ap.GetFiles .baseURL .branch}}{{.}}{{end}}
+You can indicate your approval by writing `+"`/approve`"+` in a comment
+You can cancel your approval by writing `+"`/approve {{or .ap.CancelKeyword \"cancel\"}}`"+` in a comment
+</details>`, "legacy message", map[string]interface{}{"ap": ap, "baseURL": linkURL, "commandHelpLink": commandHelpLink, "branch": branch})
+	if err != nil {
+		ap.owners.log.WithError(err).Errorf("Error generating legacy message.")
+		return nil
+	}
+	metadata := getGubernatorMetadata(ap.GetCCs())
 
 	title, err := GenerateTemplate("This PR is **{{if not .IsApproved}}NOT {{end}}APPROVED**", "title", ap)
 	if err != nil {
@@ -693,7 +1778,29 @@ Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment
 		return nil
 	}
 
-	return notification(ApprovalNotificationName, title, message)
+	return notification(ApprovalNotificationName, title, addFooter(ap, message, title, metadata)+metadata)
+}
+
+// addFooter appends the NotificationFooter of ap to message. The footer is
+// truncated so that the notification with the given title and metadata stays
+// within the length of comments accepted by GitHub.
+func addFooter(ap Approvers, message, title, metadata string) string {
+	footer := strings.TrimSpace(ap.NotificationFooter)
+	if footer == "" {
+		return message
+	}
+	footer = "\n\n" + footer
+	available := maxCommentLength - len(*notification(ApprovalNotificationName, title, message)) - len(metadata)
+	if available <= len("\n\n") {
+		return message
+	}
+	if len(footer) > available {
+		footer = footer[:available]
+		for !utf8.ValidString(footer) {
+			footer = footer[:len(footer)-1]
+		}
+	}
+	return message + footer
 }
 
 func notification(name, arguments, context string) *string {
@@ -712,6 +1819,89 @@ func notification(name, arguments, context string) *string {
 	return &str
 }
 
+// NotificationStatus is the machine-readable approval state embedded in the
+// notification as an HTML comment, so that tooling does not need to parse the prose.
+type NotificationStatus struct {
+	Approved        bool     `json:"approved"`
+	UnapprovedDirs  []string `json:"unapproved_dirs"`
+	Approvers       []string `json:"approvers"`
+	AssociatedIssue int      `json:"associated_issue,omitempty"`
+	// ApprovalsResetAt is when approvals were last reset because the
+	// associated issue or the base branch changed.
+	ApprovalsResetAt *time.Time `json:"approvals_reset_at,omitempty"`
+	// ApprovalsResetOnBaseChange is set if the last reset was caused by a
+	// change of the base branch.
+	ApprovalsResetOnBaseChange bool `json:"approvals_reset_on_base_change,omitempty"`
+	// BlockedReasons are the causes that withhold approval, if shown.
+	BlockedReasons []BlockedReason `json:"blocked_reasons,omitempty"`
+	// Hash identifies the content of the notification, see contentHash.
+	Hash string `json:"hash,omitempty"`
+}
+
+// NewNotificationStatus computes the NotificationStatus of ap.
+func NewNotificationStatus(ap Approvers) NotificationStatus {
+	status := NotificationStatus{
+		Approved:        ap.IsApproved(),
+		UnapprovedDirs:  ap.UnapprovedFiles().List(),
+		Approvers:       []string{},
+		AssociatedIssue: ap.AssociatedIssue,
+	}
+	if !ap.ApprovalsResetAt.IsZero() {
+		resetAt := ap.ApprovalsResetAt.UTC()
+		status.ApprovalsResetAt = &resetAt
+		status.ApprovalsResetOnBaseChange = ap.ApprovalsResetOnBaseChange
+	}
+	if ap.ShowBlockedReasons {
+		status.BlockedReasons = ap.BlockedReasons()
+	}
+	if ap.AnonymizeApprovers {
+		return status
+	}
+	for _, approval := range ap.ListApprovals() {
+		status.Approvers = append(status.Approvers, approval.Login)
+	}
+	return status
+}
+
+// contentHash returns a hash of the rendered parts of a notification. Files
+// and approvals are rendered in sorted order, so the hash does not depend on
+// the order in which they are listed, but anything else the notification
+// shows changes it.
+func contentHash(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+// ParseNotificationStatus extracts the NotificationStatus embedded in a
+// notification body. It returns nil if the body does not contain one.
+func ParseNotificationStatus(body string) (*NotificationStatus, error) {
+	match := approvalStatusRegex.FindStringSubmatch(body)
+	if match == nil {
+		return nil, nil
+	}
+	status := &NotificationStatus{}
+	if err := json.Unmarshal([]byte(match[1]), status); err != nil {
+		return nil, fmt.Errorf("failed to parse approval status: %v", err)
+	}
+	return status, nil
+}
+
+// getApprovalStatus returns the NotificationStatus of ap as an HTML comment,
+// along with the hash of the rendered parts of the notification.
+func getApprovalStatus(ap Approvers, parts ...string) string {
+	status := NewNotificationStatus(ap)
+	status.Hash = contentHash(parts...)
+	bytes, err := json.Marshal(status)
+	if err == nil {
+		return fmt.Sprintf("\n%s%s -->", approvalStatusPrefix, bytes)
+	}
+	return ""
+}
+
 // getGubernatorMetadata returns a JSON string with machine-readable information about approvers.
 // This MUST be kept in sync with gubernator/github/classifier.py, particularly get_approvers.
 func getGubernatorMetadata(toBeAssigned []string) string {
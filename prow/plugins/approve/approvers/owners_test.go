@@ -17,6 +17,7 @@ limitations under the License.
 package approvers
 
 import (
+	"errors"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -37,8 +38,10 @@ const (
 type FakeRepo struct {
 	approversMap                 map[string]layeredsets.String
 	leafApproversMap             map[string]sets.String
+	reviewersMap                 map[string]layeredsets.String
 	noParentOwnersMap            map[string]bool
 	autoApproveUnownedSubfolders map[string]bool
+	requiredReviewersMap         map[string]sets.String
 }
 
 func (f FakeRepo) Filenames() ownersconfig.Filenames {
@@ -53,6 +56,10 @@ func (f FakeRepo) LeafApprovers(path string) sets.String {
 	return f.leafApproversMap[path]
 }
 
+func (f FakeRepo) Reviewers(path string) layeredsets.String {
+	return f.reviewersMap[path]
+}
+
 func (f FakeRepo) FindApproverOwnersForFile(path string) string {
 	for dir := path; dir != "."; dir = filepath.Dir(dir) {
 		if _, ok := f.leafApproversMap[dir]; ok {
@@ -70,6 +77,10 @@ func (f FakeRepo) IsAutoApproveUnownedSubfolders(ownerFilePath string) bool {
 	return f.autoApproveUnownedSubfolders[ownerFilePath]
 }
 
+func (f FakeRepo) RequiredReviewers(path string) sets.String {
+	return f.requiredReviewersMap[path]
+}
+
 func canonicalize(path string) string {
 	if path == "." {
 		return ""
@@ -474,84 +485,70 @@ func TestGetAllPotentialApprovers(t *testing.T) {
 	}
 }
 
-func TestFindMostCoveringApprover(t *testing.T) {
-	rootApprovers := sets.NewString("Alice", "Bob")
-	aApprovers := sets.NewString("Art", "Anne")
-	bApprovers := sets.NewString("Bill", "Ben", "Barbara")
-	cApprovers := sets.NewString("Chris", "Carol")
-	dApprovers := sets.NewString("David", "Dan", "Debbie")
-	eApprovers := sets.NewString("Eve", "Erin")
-	edcApprovers := eApprovers.Union(dApprovers).Union(cApprovers)
-	FakeRepoMap := map[string]sets.String{
-		"":        rootApprovers,
-		"a":       aApprovers,
-		"b":       bApprovers,
-		"c":       cApprovers,
-		"a/d":     dApprovers,
-		"a/combo": edcApprovers,
-	}
+func TestSuggestApprovers(t *testing.T) {
 	tests := []struct {
 		testName   string
-		filenames  []string
 		unapproved sets.String
-		// because most covering could be two or more people
-		expectedMostCovering sets.String
+		owners     map[string]sets.String
+		expected   []string
 	}{
 		{
-			testName:             "Empty PR",
-			filenames:            []string{},
-			unapproved:           sets.String{},
-			expectedMostCovering: sets.NewString(""),
-		},
-		{
-			testName:             "Single Root File PR",
-			filenames:            []string{"kubernetes.go"},
-			unapproved:           sets.NewString(""),
-			expectedMostCovering: setToLower(rootApprovers),
-		},
-		{
-			testName:             "Internal Node File PR",
-			filenames:            []string{"a/test.go"},
-			unapproved:           sets.NewString("a"),
-			expectedMostCovering: setToLower(aApprovers),
-		},
-		{
-			testName:             "Combo and Intersecting Leaf PR",
-			filenames:            []string{"a/combo/test.go", "a/d/test.go"},
-			unapproved:           sets.NewString("a/combo", "a/d"),
-			expectedMostCovering: setToLower(edcApprovers.Intersection(dApprovers)),
+			testName:   "Nothing Unapproved",
+			unapproved: sets.NewString(),
+			owners:     map[string]sets.String{"a": sets.NewString("alice")},
+			expected:   []string{},
+		},
+		{
+			testName:   "Minimal Cover Beats Greedy Choice",
+			unapproved: sets.NewString("1", "2", "3", "4", "5", "6"),
+			owners: map[string]sets.String{
+				// xavier covers the most files, but yolanda and zach cover them all together
+				"1": sets.NewString("xavier", "yolanda"),
+				"2": sets.NewString("xavier", "yolanda"),
+				"3": sets.NewString("xavier", "zach"),
+				"4": sets.NewString("xavier", "zach"),
+				"5": sets.NewString("yolanda"),
+				"6": sets.NewString("zach"),
+			},
+			expected: []string{"yolanda", "zach"},
 		},
 		{
-			testName:             "Three Leaf PR Only B Approved",
-			filenames:            []string{"a/combo/test.go", "c/test.go", "b/test.go"},
-			unapproved:           sets.NewString("a/combo", "c/"),
-			expectedMostCovering: setToLower(edcApprovers.Intersection(cApprovers)),
+			testName:   "Least Privileged Approver Preferred",
+			unapproved: sets.NewString("a"),
+			owners: map[string]sets.String{
+				"":  sets.NewString("alice"),
+				"a": sets.NewString("alice", "bob"),
+				"b": sets.NewString("alice"),
+			},
+			expected: []string{"bob"},
 		},
 		{
-			testName:             "Three Leaf PR Only B Left Unapproved",
-			filenames:            []string{"a/combo/test.go", "a/d/test.go", "b/test.go"},
-			unapproved:           sets.NewString("b"),
-			expectedMostCovering: setToLower(bApprovers),
+			testName:   "Alphabetical Tie Break",
+			unapproved: sets.NewString("a", "b"),
+			owners: map[string]sets.String{
+				"a": sets.NewString("anne", "art"),
+				"b": sets.NewString("bill", "ben"),
+			},
+			expected: []string{"anne", "ben"},
 		},
 		{
-			testName:             "Leaf and Parent 2 File PR",
-			filenames:            []string{"a/test.go", "a/d/test.go"},
-			unapproved:           sets.NewString("a", "a/d"),
-			expectedMostCovering: setToLower(aApprovers.Union(dApprovers)),
+			testName:   "OWNERS File Without Approvers Ignored",
+			unapproved: sets.NewString("a", "d"),
+			owners: map[string]sets.String{
+				"a": sets.NewString("anne"),
+				"d": sets.NewString(),
+			},
+			expected: []string{"anne"},
 		},
 	}
 
 	for _, test := range tests {
-		testOwners := Owners{
-			filenames: test.filenames,
-			repo:      createFakeRepo(FakeRepoMap),
-			seed:      TestSeed,
-			log:       logrus.WithField("plugin", "some_plugin"),
-		}
-		bestPerson := findMostCoveringApprover(testOwners.GetAllPotentialApprovers(), testOwners.GetReverseMap(testOwners.GetLeafApprovers()), test.unapproved)
-		if test.expectedMostCovering.Intersection(sets.NewString(bestPerson)).Len() != 1 {
-			t.Errorf("Failed for test %v.  Didn't correct approvers list.  Expected: %v. Found %v", test.testName, test.expectedMostCovering, bestPerson)
-		}
+		t.Run(test.testName, func(t *testing.T) {
+			suggested := SuggestApprovers(test.unapproved, test.owners)
+			if !reflect.DeepEqual(test.expected, suggested) {
+				t.Errorf("Expected suggested approvers %v, but got %v.", test.expected, suggested)
+			}
+		})
 	}
 }
 
@@ -753,3 +750,88 @@ func TestRemoveSubdirs(t *testing.T) {
 		}
 	}
 }
+
+type stubResolver struct {
+	approvers map[string]sets.String
+	err       error
+}
+
+func (s stubResolver) RequiredApprovers(filenames []string) (map[string]sets.String, error) {
+	return s.approvers, s.err
+}
+
+func TestNewOwnersFromResolver(t *testing.T) {
+	resolver := stubResolver{approvers: map[string]sets.String{
+		"a/a.go": sets.NewString("Alice"),
+		"a/b.go": sets.NewString("Bob", "Alice"),
+		"c.go":   sets.NewString("Carol"),
+	}}
+	tests := []struct {
+		testName           string
+		approvers          []string
+		expectedUnapproved sets.String
+		expectedApproved   bool
+	}{
+		{
+			testName:           "No approvals",
+			expectedUnapproved: sets.NewString("a/a.go", "a/b.go", "c.go"),
+		},
+		{
+			testName:           "Approval covering several files",
+			approvers:          []string{"alice"},
+			expectedUnapproved: sets.NewString("c.go"),
+		},
+		{
+			testName:           "Approval covering a single file",
+			approvers:          []string{"Bob"},
+			expectedUnapproved: sets.NewString("a/a.go", "c.go"),
+		},
+		{
+			testName:           "All files approved",
+			approvers:          []string{"Alice", "Carol"},
+			expectedUnapproved: sets.NewString(),
+			expectedApproved:   true,
+		},
+	}
+
+	for _, test := range tests {
+		owners, err := NewOwnersFromResolver(logrus.WithField("plugin", "some_plugin"), []string{"a/a.go", "a/b.go", "c.go"}, resolver, TestSeed)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.testName, err)
+		}
+		ap := NewApprovers(owners)
+		for _, approver := range test.approvers {
+			ap.AddApprover(approver, "REFERENCE", false)
+		}
+		if got := ap.UnapprovedFiles(); !got.Equal(test.expectedUnapproved) {
+			t.Errorf("%s: expected unapproved files %q, but got %q", test.testName, test.expectedUnapproved.List(), got.List())
+		}
+		if got := ap.IsApproved(); got != test.expectedApproved {
+			t.Errorf("%s: expected approved: %t, but got %t", test.testName, test.expectedApproved, got)
+		}
+	}
+
+	if _, err := NewOwnersFromResolver(logrus.WithField("plugin", "some_plugin"), []string{"a/a.go"}, stubResolver{err: errors.New("unavailable")}, TestSeed); err == nil {
+		t.Error("Expected an error when the resolver fails, but got none")
+	}
+}
+
+func TestRepoResolver(t *testing.T) {
+	resolver := NewRepoResolver(createFakeRepo(map[string]sets.String{
+		"":  sets.NewString("Alice"),
+		"a": sets.NewString("Art"),
+		"b": sets.NewString("Bill"),
+	}))
+	got, err := resolver.RequiredApprovers([]string{"a/a.go", "b/b.go", "c.go"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]sets.String{
+		"a/a.go": sets.NewString("alice", "art"),
+		"b/b.go": sets.NewString("alice", "bill"),
+		"c.go":   sets.NewString("alice"),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected required approvers %v, but got %v", expected, got)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"path"
 	"reflect"
 	"regexp"
@@ -288,17 +289,35 @@ type Blockade struct {
 //
 // The configuration for the approve plugin is defined as a list of these structures.
 type Approve struct {
-	// Repos is either of the form org/repos or just org.
+	// Repos is either of the form org/repos or just org. The repos may be
+	// glob patterns such as org/service-*, see ApproveFor for precedence.
 	Repos []string `json:"repos,omitempty"`
 	// IssueRequired indicates if an associated issue is required for approval in
 	// the specified repos.
 	IssueRequired bool `json:"issue_required,omitempty"`
+	// RequireClosingKeyword only associates issues referenced with one of the
+	// keywords GitHub recognizes for closing issues, such as "Fixes #1", so that
+	// a stray mention like "see #1" doesn't satisfy IssueRequired.
+	RequireClosingKeyword bool `json:"require_closing_keyword,omitempty"`
+	// RequireOpenIssues only satisfies IssueRequired if all issues associated
+	// with the PR are open, rather than if any issue is associated. Issues are
+	// looked up in the repo of the PR.
+	RequireOpenIssues bool `json:"require_open_issues,omitempty"`
+	// IssueBaseURL is the base URL of the GitHub instance hosting associated
+	// issues, e.g. https://github.example.com for GitHub Enterprise. When set,
+	// only full issue links on that host (besides #N shorthand) are associated.
+	// When empty, issue links on any host are accepted.
+	IssueBaseURL string `json:"issue_base_url,omitempty"`
 	// RequireSelfApproval requires PR authors to explicitly approve their PRs.
 	// Otherwise the plugin assumes the author of the PR approves the changes in the PR.
 	RequireSelfApproval *bool `json:"require_self_approval,omitempty"`
 	// LgtmActsAsApprove indicates that the lgtm command should be used to
 	// indicate approval
 	LgtmActsAsApprove bool `json:"lgtm_acts_as_approve,omitempty"`
+	// UnifyLgtmApprove lets users that are both reviewers and approvers of a PR
+	// use a single command: their "/lgtm" counts as approval, and their
+	// "/approve" also applies the lgtm label.
+	UnifyLgtmApprove bool `json:"unify_lgtm_approve,omitempty"`
 	// IgnoreReviewState causes the approve plugin to ignore the GitHub review state. Otherwise:
 	// * an APPROVE github review is equivalent to leaving an "/approve" message.
 	// * A REQUEST_CHANGES github review is equivalent to leaving an /approve cancel" message.
@@ -310,12 +329,292 @@ type Approve struct {
 	// PrProcessLink is the link to the help page which explains the code review process.
 	// The default value is "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process".
 	PrProcessLink string `json:"pr_process_link,omitempty"`
+	// TitleApprovalPhrase is a phrase that, when an OWNERS approver adds it to the
+	// PR title, counts as their "/approve" at the time of the edit. A later cancel
+	// wins, and removing the phrase again doesn't cancel. Leave empty to disable.
+	TitleApprovalPhrase string `json:"title_approval_phrase,omitempty"`
+	// AdminApprovers is a list of GitHub logins that may use the break-glass
+	// "/approve force <reason>" command, which applies the approved label
+	// regardless of OWNERS and associated issue requirements.
+	AdminApprovers []string `json:"admin_approvers,omitempty"`
+	// IgnoreAuthors is a list of GitHub logins whose PRs are ignored by the
+	// approve plugin: no notification is posted and labels are left untouched.
+	IgnoreAuthors []string `json:"ignore_authors,omitempty"`
+	// IgnoreBots causes PRs authored by GitHub Apps (logins ending in "[bot]")
+	// to be ignored in the same way as IgnoreAuthors.
+	IgnoreBots bool `json:"ignore_bots,omitempty"`
+	// PublishCommitStatus causes the approve plugin to also report the approval
+	// state as an "approve" commit status on the PR head, for consumers that
+	// key off statuses (e.g. required checks in branch protection) rather than labels.
+	PublishCommitStatus bool `json:"publish_commit_status,omitempty"`
+	// RequiredApprovers is the number of approvals each OWNERS file touched by
+	// the PR needs before the PR is approved. Defaults to 1.
+	RequiredApprovers int `json:"required_approvers,omitempty"`
+	// OwnersChangeRequiredApprovers is the number of approvals each OWNERS
+	// file touched by a PR that modifies OWNERS or OWNERS_ALIASES files needs,
+	// if higher than RequiredApprovers, so that changes of ownership can't be
+	// approved by a single approver.
+	OwnersChangeRequiredApprovers int `json:"owners_change_required_approvers,omitempty"`
+	// ApproverWeights maps approver GitHub logins to how many approvals their
+	// approval counts as towards RequiredApprovers. Approvers that are not
+	// listed have a weight of 1.
+	ApproverWeights map[string]int `json:"approver_weights,omitempty"`
+	// AreaApprovers maps labels, such as area/networking, to a group of GitHub
+	// logins. PRs carrying one of the labels additionally need an approval
+	// from at least one login of its group. Like any approver, the group
+	// members need to be OWNERS approvers of the PR to approve it.
+	AreaApprovers map[string][]string `json:"area_approvers,omitempty"`
+	// SkipDrafts withholds the approved label from draft PRs, removing it if
+	// the PR is converted back to a draft. The label is restored once the PR
+	// is marked as ready for review.
+	SkipDrafts bool `json:"skip_drafts,omitempty"`
+	// IgnoreDraftApprovals discards the approvals given before the PR was last
+	// marked as ready for review, as they may be premature. Requires SkipDrafts.
+	IgnoreDraftApprovals bool `json:"ignore_draft_approvals,omitempty"`
+	// ReportOwnersErrors causes the approve plugin to comment on PRs whose
+	// approval state cannot be computed because the OWNERS files failed to load.
+	ReportOwnersErrors bool `json:"report_owners_errors,omitempty"`
+	// StackApprovals enables the "/approve stack" command, which approves the PR
+	// and asks for approval of the stacked PRs referenced in its body.
+	StackApprovals bool `json:"stack_approvals,omitempty"`
+	// InheritOriginalApprovals carries the approvals of the PR named by an
+	// "Original-PR: #N" trailer in the PR body forward, e.g. for PRs rebased
+	// into a merge queue branch. They are only carried forward from a closed and
+	// unmerged PR of the same author with the same head commit or the same
+	// changes, if it was approved and all of its approvers still are OWNERS
+	// approvers. Inherited approvals count as given when the original PR was
+	// approved, and are filtered like the approval comments on the PR.
+	InheritOriginalApprovals bool `json:"inherit_original_approvals,omitempty"`
+	// UnownedPathFilter is a list of regular expressions matching paths of
+	// files, such as generated or vendored code, that never require approval.
+	// Unlike the OWNERS options, this can be configured centrally for a whole org.
+	UnownedPathFilter []string `json:"unowned_path_filter,omitempty"`
+	// UnownedPathRe holds the compiled UnownedPathFilter regular expressions.
+	UnownedPathRe []*regexp.Regexp `json:"-"`
+	// WaiveIssueForTrivial waives the associated issue requirement of PRs that
+	// only change files matched by UnownedPathFilter, such as generated code.
+	WaiveIssueForTrivial bool `json:"waive_issue_for_trivial,omitempty"`
+	// AllowedBotApprovers is a list of bot logins, such as trusted release
+	// automation, whose approval commands are counted instead of being ignored
+	// like those of other bots. They still need to be OWNERS approvers.
+	AllowedBotApprovers []string `json:"allowed_bot_approvers,omitempty"`
+	// ExcludedApprovers is a list of GitHub logins whose approvals are never
+	// counted, even if they are OWNERS approvers. It takes precedence over
+	// AllowedBotApprovers.
+	ExcludedApprovers []string `json:"excluded_approvers,omitempty"`
+	// ApproverAllowlist, if non-empty, lists the only GitHub logins whose
+	// approvals are counted, in addition to being OWNERS approvers. This
+	// includes the implicit self-approval of PR authors. It is meant as an
+	// additional control for sensitive repos.
+	ApproverAllowlist []string `json:"approver_allowlist,omitempty"`
+	// AuthorityAccount is the GitHub login of a service account, e.g. of a
+	// ticketing integration, whose comments grant approvals on behalf of
+	// others. Each "Approved-By: @alice, @bob" line of its comments counts as
+	// an approval by the listed logins that are OWNERS approvers, given at the
+	// time of the comment like an "/approve" of theirs.
+	AuthorityAccount string `json:"authority_account,omitempty"`
+	// ReapproveOnIssueChange resets the approvals of a PR when its associated
+	// issue changes, as they were given in a different context. The issue is
+	// tracked in the approval notification.
+	ReapproveOnIssueChange bool `json:"reapprove_on_issue_change,omitempty"`
+	// ReapproveOnBaseChange resets the approvals of a PR when it is retargeted
+	// to another base branch, as its diff changes with it.
+	ReapproveOnBaseChange bool `json:"reapprove_on_base_change,omitempty"`
+	// NotificationFooter is appended to every approval notification, e.g. to
+	// link to the contribution guidelines. Changing it updates the existing
+	// notifications.
+	NotificationFooter string `json:"notification_footer,omitempty"`
+	// PartialApprovalLabel is the label applied to PRs that are approved for
+	// some, but not all, of their OWNERS files. Leave empty to disable.
+	PartialApprovalLabel string `json:"partial_approval_label,omitempty"`
+	// ShowApprovalTimes adds the time each OWNERS file became approved to the
+	// approval notification.
+	ShowApprovalTimes bool `json:"show_approval_times,omitempty"`
+	// ShowBlockedReasons adds a section to the approval notification listing the
+	// discrete causes that withhold approval, such as "unapproved-files" or
+	// "missing-issue", and adds them to its approval status. Only requirements
+	// that are enabled are listed.
+	ShowBlockedReasons bool `json:"show_blocked_reasons,omitempty"`
+	// HideImplicitSelfApprove omits the implicit self-approval of the author,
+	// given unless RequireSelfApproval is set, from the approval notification.
+	// The self-approval still counts towards approval.
+	HideImplicitSelfApprove bool `json:"hide_implicit_self_approve,omitempty"`
+	// NoIssueRequiresConsensus makes "/approve no-issue" only waive the
+	// IssueRequired requirement if all approvers of the PR asked for it,
+	// rather than any one of them.
+	NoIssueRequiresConsensus bool `json:"no_issue_requires_consensus,omitempty"`
+	// LegacyNotificationFormat makes the approval notification use the format
+	// of the old k8s-merge-robot, and replaces notifications posted by that bot,
+	// for tooling that still depends on it during a migration.
+	LegacyNotificationFormat bool `json:"legacy_notification_format,omitempty"`
+	// NotificationAsReview posts the approval notification as a PR review with
+	// a COMMENT event instead of an issue comment, so that it shows up in the
+	// "Reviews" section. Reviews can't be deleted, so an updated notification
+	// is submitted as a new review.
+	NotificationAsReview bool `json:"notification_as_review,omitempty"`
+	// EditNotificationInPlace edits the approval notification comment instead
+	// of deleting it and posting a new one, which keeps long-lived PRs with
+	// many events readable. The notification carries its own comment ID in a
+	// hidden marker, so that it is recreated just once if a user deletes it
+	// and duplicate notifications are deleted.
+	EditNotificationInPlace bool `json:"edit_notification_in_place,omitempty"`
+	// CompactNotification makes the approval notification a one-line summary
+	// such as "Approval: 3/5 directories approved — needs @alice, @bob.",
+	// which is less noisy e.g. on mobile.
+	CompactNotification bool `json:"compact_notification,omitempty"`
+	// ForkRequiresExtraApprover makes PRs opened from a fork need one approval
+	// more per OWNERS file than RequiredApprovers, and withholds the implicit
+	// self-approval from their authors.
+	ForkRequiresExtraApprover bool `json:"fork_requires_extra_approver,omitempty"`
+	// ExplainApprovalLoss makes the approve plugin comment on the PR when it
+	// removes the approved label, listing the directories that lost approval
+	// and why, e.g. because an approver cancelled their approval.
+	ExplainApprovalLoss bool `json:"explain_approval_loss,omitempty"`
+	// EnforceRequiredReviewers makes the approval of a directory whose OWNERS
+	// declare required_reviewers need one of them among its approvers.
+	EnforceRequiredReviewers bool `json:"enforce_required_reviewers,omitempty"`
+	// RequireReapprovalAfterChanges makes a changes requested review invalidate
+	// the earlier approvals of its author even once it is dismissed, e.g. by
+	// branch protection when new commits are pushed, so that only a new approval
+	// counts. It requires the review state to be considered.
+	RequireReapprovalAfterChanges bool `json:"require_reapproval_after_changes,omitempty"`
+	// SuggestOnTypo makes the approve plugin reply once per commenter to slash
+	// commands that are close misspellings of "/approve" or "/lgtm", such as
+	// "/aprove", with the correct command.
+	SuggestOnTypo bool `json:"suggest_on_typo,omitempty"`
+	// QuorumMode replaces the per OWNERS file approval model: the files of a PR
+	// are approved once QuorumCount distinct approvers from any of the OWNERS
+	// files touched by the PR approved, regardless of which directories they
+	// own. This suits cross-cutting PRs.
+	QuorumMode bool `json:"quorum_mode,omitempty"`
+	// QuorumCount is the number of distinct approvers QuorumMode requires. The
+	// implicit self-approval of the author doesn't count towards it.
+	QuorumCount int `json:"quorum_count,omitempty"`
+	// FirstTimeInstructions makes the approve plugin comment once on the PRs of
+	// authors without merged PRs in the repo, explaining how approval works.
+	// The author is only looked up when the PR is opened or reopened.
+	FirstTimeInstructions bool `json:"first_time_instructions,omitempty"`
+	// RequireLgtmLabel withholds the approved label until the lgtm label is
+	// present as well, for repos that want both OWNERS approval and lgtm
+	// before the approved label is applied. The approved label is removed
+	// again when the lgtm label is.
+	RequireLgtmLabel bool `json:"require_lgtm_label,omitempty"`
+	// RequireResolvedThreads withholds approval until all review threads of
+	// the PR are resolved.
+	RequireResolvedThreads bool `json:"require_resolved_threads,omitempty"`
+	// CancelKeyword is the argument of "/approve" that cancels an approval,
+	// for teams whose conventions conflict with the default "cancel", e.g.
+	// "retract". "/approve cancel" doesn't cancel approvals once it's set.
+	// It must not be one of the other arguments of "/approve", like "no-issue".
+	CancelKeyword string `json:"cancel_keyword,omitempty"`
+	// EscalateAfter is a duration, such as "72h", after the creation of a PR
+	// past which, while the PR isn't approved, the EscalationApprovers are
+	// mentioned once in a separate comment.
+	EscalateAfter string `json:"escalate_after,omitempty"`
+	// EscalateAfterDuration is EscalateAfter parsed.
+	EscalateAfterDuration time.Duration `json:"-"`
+	// EscalationApprovers are the fallback approvers, such as GitHub logins
+	// or org/team names, mentioned when the approval of a PR stalls.
+	EscalationApprovers []string `json:"escalation_approvers,omitempty"`
+	// NotificationLocale is the language, such as "de" or "es", that the
+	// structural phrases of the notification are rendered in. Defaults to
+	// English, which unknown locales fall back to as well. The compact and
+	// legacy notification formats are always in English.
+	NotificationLocale string `json:"notification_locale,omitempty"`
+	// ManageLabelExclusively lets the approve plugin remove the approved label
+	// of unapproved PRs unless someone else added it, which counts as a manual
+	// approval. Set it to false in repos where the label is managed by other
+	// tools too, so that labels that no issue event attributes to the bot, e.g.
+	// because they were added before the events were recorded, are kept as well.
+	// Defaults to true.
+	ManageLabelExclusively *bool `json:"manage_label_exclusively,omitempty"`
+	// SensitivePaths are directories, such as "security/", whose files, and
+	// those of their subdirectories, are only approved by approvals giving a
+	// justification like `/approve reason:"reviewed the threat model"`.
+	SensitivePaths []string `json:"sensitive_paths,omitempty"`
+	// TreatDismissalAsCancel makes the dismissal of a review, e.g. of a stale
+	// approval by a maintainer, cancel the earlier approvals of its author as
+	// "/approve cancel" would. It requires the review state to be considered.
+	TreatDismissalAsCancel bool `json:"treat_dismissal_as_cancel,omitempty"`
+	// RequireApprovalForDeletions requires the files deleted by a PR to be
+	// approved by their OWNERS like any other change, since removing code
+	// affects its owners as well. Set it to false to leave deleted files out of
+	// the approval. Defaults to true.
+	RequireApprovalForDeletions *bool `json:"require_approval_for_deletions,omitempty"`
+	// StaleApprovalLabel is the label that LabelStaleUnapprovedPRs applies to
+	// PRs left unapproved for too long. Defaults to "needs-approver".
+	StaleApprovalLabel string `json:"stale_approval_label,omitempty"`
+	// RequireSignedCommits makes approvals only count if their approver
+	// authored a commit of the PR with a verified signature, tying approvals
+	// to signing keys rather than to GitHub accounts alone.
+	RequireSignedCommits bool `json:"require_signed_commits,omitempty"`
+	// AnonymizeApprovers makes the approval notification show how many
+	// approvers approved rather than who did, without links to their
+	// approvals. The explanations of lost approvals and "/approve history"
+	// refer to "an approver" instead. The approvers are still logged.
+	// Approvals can't be inherited from anonymized notifications by stacked PRs.
+	AnonymizeApprovers bool `json:"anonymize_approvers,omitempty"`
+	// RequireLgtmAfterApproval withholds the approved label until the lgtm
+	// label is present and was added after the PR became approved, for
+	// workflows where OWNERS approval precedes the final lgtm.
+	RequireLgtmAfterApproval bool `json:"require_lgtm_after_approval,omitempty"`
+	// TriggerOnActions restricts the pull request actions that cause the PR to
+	// be reprocessed, e.g. to not react to "labeled" events in repos with heavy
+	// label churn. Defaults to all of opened, reopened, synchronize, labeled,
+	// converted_to_draft and ready_for_review.
+	TriggerOnActions []string `json:"trigger_on_actions,omitempty"`
+	// FreezeWindows are time ranges, such as code freezes, during which the
+	// approved label isn't applied. The approval state is still computed and
+	// reported in the notification, and the label is applied once the freeze
+	// is over.
+	FreezeWindows []FreezeWindow `json:"freeze_windows,omitempty"`
+	// AutoApproveRules approve the files of PRs by trusted automation, such as
+	// a bot bumping manifests, without an "/approve". Files matching the path
+	// filter of a rule for the PR author don't require approval.
+	AutoApproveRules []AutoApproveRule `json:"auto_approve_rules,omitempty"`
+}
+
+// AutoApproveRule approves the files matching PathFilter for PRs of Author.
+type AutoApproveRule struct {
+	// Author is the GitHub login of the PR author the rule applies to.
+	Author string `json:"author"`
+	// PathFilter is a regular expression matching the paths of files that are
+	// approved, e.g. "^manifests/".
+	PathFilter string `json:"path_filter"`
+
+	PathRe *regexp.Regexp `json:"-"`
+}
+
+// FreezeWindow is a time range during which approvals are frozen.
+type FreezeWindow struct {
+	// Start is when the freeze begins, as an RFC 3339 time such as
+	// "2020-12-18T00:00:00Z".
+	Start string `json:"start"`
+	// End is when the freeze ends, as an RFC 3339 time.
+	End string `json:"end"`
+
+	StartTime time.Time `json:"-"`
+	EndTime   time.Time `json:"-"`
+}
+
+// Contains returns true if t lies within the freeze window.
+func (w FreezeWindow) Contains(t time.Time) bool {
+	return !t.Before(w.StartTime) && t.Before(w.EndTime)
 }
 
 var (
 	warnDependentBugTargetRelease time.Time
 )
 
+// approveTriggerActions are the pull request actions the approve plugin can
+// react to.
+var approveTriggerActions = sets.NewString("opened", "reopened", "synchronize", "labeled", "converted_to_draft", "ready_for_review")
+
+// approveArguments are the other arguments of "/approve", which the
+// cancel_keyword must not shadow. Arguments with a value, like "for:72h", are
+// listed by their prefix.
+var approveArguments = sets.NewString("after:", "as:", "assign", "deletions-only", "diff", "dump", "for:", "force", "history", "no-issue", "reason:", "refresh", "require-issue", "sha:", "simulate", "stack", "upto:")
+
 func (a Approve) HasSelfApproval() bool {
 	if a.RequireSelfApproval != nil {
 		return !*a.RequireSelfApproval
@@ -323,6 +622,58 @@ func (a Approve) HasSelfApproval() bool {
 	return true
 }
 
+// LgtmMayApprove returns true if "/lgtm" commands may count as approval, for
+// everyone or only for users that are both reviewers and approvers.
+func (a Approve) LgtmMayApprove() bool {
+	return a.LgtmActsAsApprove || a.UnifyLgtmApprove
+}
+
+// CancelArgument returns the lower cased argument of "/approve" that cancels an
+// approval.
+func (a Approve) CancelArgument() string {
+	if a.CancelKeyword != "" {
+		return strings.ToLower(a.CancelKeyword)
+	}
+	return "cancel"
+}
+
+// StaleApprovalLabelName returns the label of PRs left unapproved for too long.
+func (a Approve) StaleApprovalLabelName() string {
+	if a.StaleApprovalLabel != "" {
+		return a.StaleApprovalLabel
+	}
+	return "needs-approver"
+}
+
+// ManagesLabelExclusively returns true if the approve plugin may remove the
+// approved label regardless of who added it.
+func (a Approve) ManagesLabelExclusively() bool {
+	if a.ManageLabelExclusively != nil {
+		return *a.ManageLabelExclusively
+	}
+	return true
+}
+
+// RequiresApprovalForDeletions returns true if the files deleted by a PR need
+// the approval of their OWNERS.
+func (a Approve) RequiresApprovalForDeletions() bool {
+	if a.RequireApprovalForDeletions != nil {
+		return *a.RequireApprovalForDeletions
+	}
+	return true
+}
+
+// IsSensitivePath returns true if file lies in one of the SensitivePaths.
+func (a Approve) IsSensitivePath(file string) bool {
+	for _, dir := range a.SensitivePaths {
+		dir = strings.Trim(dir, "/")
+		if file == dir || strings.HasPrefix(file, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (a Approve) ConsiderReviewState() bool {
 	if a.IgnoreReviewState != nil {
 		return !*a.IgnoreReviewState
@@ -330,6 +681,15 @@ func (a Approve) ConsiderReviewState() bool {
 	return true
 }
 
+// TriggersOn returns true if the given pull request action causes the PR to be
+// reprocessed.
+func (a Approve) TriggersOn(action string) bool {
+	if len(a.TriggerOnActions) == 0 {
+		return approveTriggerActions.Has(action)
+	}
+	return approveTriggerActions.Has(action) && sets.NewString(a.TriggerOnActions...).Has(action)
+}
+
 // Lgtm specifies a configuration for a single lgtm.
 // The configuration for the lgtm plugin is defined as a list of these structures.
 type Lgtm struct {
@@ -743,8 +1103,10 @@ func (r RequireMatchingLabel) Describe() string {
 }
 
 // ApproveFor finds the Approve for a repo, if one exists.
-// Approval configuration can be listed for a repository
-// or an organization.
+// Approval configuration can be listed for a repository, for the
+// repositories matching a glob pattern such as "org/service-*",
+// or for an organization. A repository takes precedence over a
+// glob pattern, which takes precedence over an organization.
 func (c *Configuration) ApproveFor(org, repo string) *Approve {
 	fullName := fmt.Sprintf("%s/%s", org, repo)
 
@@ -757,6 +1119,15 @@ func (c *Configuration) ApproveFor(org, repo string) *Approve {
 			return &approve
 		}
 
+		// Then look for a glob pattern matching the repo
+		for _, approve := range c.Approve {
+			for _, pattern := range approve.Repos {
+				if matched, _ := path.Match(pattern, fullName); matched {
+					return &approve
+				}
+			}
+		}
+
 		// If you don't find anything, loop again looking for an org config
 		for _, approve := range c.Approve {
 			if !sets.NewString(approve.Repos...).Has(org) {
@@ -1111,6 +1482,131 @@ func validateConfigUpdater(updater *ConfigUpdater) error {
 	return nil
 }
 
+// ValidateApproveConfig checks the approve plugin configuration of c for
+// mistakes that would otherwise only surface when handling events.
+func ValidateApproveConfig(c *Configuration) error {
+	var errs []error
+	configuredBy := map[string]int{}
+	for i, approve := range c.Approve {
+		if len(approve.Repos) == 0 {
+			errs = append(errs, fmt.Errorf("approve config #%d: repos must not be empty", i))
+		}
+		for _, repo := range approve.Repos {
+			if _, err := path.Match(repo, ""); err != nil {
+				errs = append(errs, fmt.Errorf("approve config #%d: repos entry %q is not a valid glob pattern: %v", i, repo, err))
+			}
+			if j, ok := configuredBy[repo]; ok {
+				errs = append(errs, fmt.Errorf("approve config #%d: %q is already configured by approve config #%d, only the first one is used", i, repo, j))
+				continue
+			}
+			configuredBy[repo] = i
+		}
+		for _, link := range []struct{ name, value string }{
+			{name: "commandHelpLink", value: approve.CommandHelpLink},
+			{name: "pr_process_link", value: approve.PrProcessLink},
+			{name: "issue_base_url", value: approve.IssueBaseURL},
+		} {
+			if link.value == "" {
+				continue
+			}
+			if u, err := url.Parse(link.value); err != nil || !u.IsAbs() {
+				errs = append(errs, fmt.Errorf("approve config #%d: %s %q must be an absolute URL", i, link.name, link.value))
+			}
+		}
+		if approve.CancelKeyword != "" && len(strings.Fields(approve.CancelKeyword)) != 1 {
+			errs = append(errs, fmt.Errorf("approve config #%d: cancel_keyword %q must be a single word", i, approve.CancelKeyword))
+		} else if keyword := approve.CancelArgument(); approveArguments.Has(keyword) || strings.HasPrefix(keyword, "@") || strings.Contains(keyword, ":") {
+			errs = append(errs, fmt.Errorf("approve config #%d: cancel_keyword %q clashes with the other arguments of /approve", i, approve.CancelKeyword))
+		}
+		if approve.TitleApprovalPhrase != "" && strings.TrimSpace(approve.TitleApprovalPhrase) == "" {
+			errs = append(errs, fmt.Errorf("approve config #%d: title_approval_phrase must not consist of whitespace only", i))
+		}
+		if approve.RequiredApprovers < 0 {
+			errs = append(errs, fmt.Errorf("approve config #%d: invalid required_approvers: %d (needs to be positive)", i, approve.RequiredApprovers))
+		}
+		if approve.OwnersChangeRequiredApprovers < 0 {
+			errs = append(errs, fmt.Errorf("approve config #%d: invalid owners_change_required_approvers: %d (needs to be positive)", i, approve.OwnersChangeRequiredApprovers))
+		}
+		for _, login := range sets.StringKeySet(approve.ApproverWeights).List() {
+			if weight := approve.ApproverWeights[login]; weight < 1 {
+				errs = append(errs, fmt.Errorf("approve config #%d: invalid approver_weights for %q: %d (needs to be positive)", i, login, weight))
+			}
+		}
+		for _, area := range sets.StringKeySet(approve.AreaApprovers).List() {
+			if len(approve.AreaApprovers[area]) == 0 {
+				errs = append(errs, fmt.Errorf("approve config #%d: area_approvers for %q must not be empty", i, area))
+			}
+		}
+		if strings.EqualFold(approve.StaleApprovalLabel, labels.Approved) {
+			errs = append(errs, fmt.Errorf("approve config #%d: stale_approval_label must not be the %q label", i, labels.Approved))
+		}
+		if approve.PartialApprovalLabel == labels.Approved {
+			errs = append(errs, fmt.Errorf("approve config #%d: partial_approval_label must not be the %q label", i, labels.Approved))
+		}
+		for _, action := range approve.TriggerOnActions {
+			if !approveTriggerActions.Has(action) {
+				errs = append(errs, fmt.Errorf("approve config #%d: invalid trigger_on_actions %q, must be one of %v", i, action, approveTriggerActions.List()))
+			}
+		}
+		if approve.IgnoreDraftApprovals && !approve.SkipDrafts {
+			errs = append(errs, fmt.Errorf("approve config #%d: ignore_draft_approvals requires skip_drafts", i))
+		}
+		if approve.EditNotificationInPlace && approve.NotificationAsReview {
+			errs = append(errs, fmt.Errorf("approve config #%d: edit_notification_in_place can't be combined with notification_as_review", i))
+		}
+		for j, window := range approve.FreezeWindows {
+			if !window.EndTime.After(window.StartTime) {
+				errs = append(errs, fmt.Errorf("approve config #%d: freeze_windows[%d] must end after it starts", i, j))
+			}
+		}
+		for j, rule := range approve.AutoApproveRules {
+			if rule.Author == "" || rule.PathFilter == "" {
+				errs = append(errs, fmt.Errorf("approve config #%d: auto_approve_rules[%d] needs an author and a path_filter", i, j))
+			}
+		}
+		if approve.EscalateAfter != "" && approve.EscalateAfterDuration <= 0 {
+			errs = append(errs, fmt.Errorf("approve config #%d: escalate_after must be positive, got %q", i, approve.EscalateAfter))
+		}
+		if (approve.EscalateAfter != "") != (len(approve.EscalationApprovers) != 0) {
+			errs = append(errs, fmt.Errorf("approve config #%d: escalate_after and escalation_approvers must be set together", i))
+		}
+		for _, dir := range approve.SensitivePaths {
+			if strings.Trim(dir, "/") == "" {
+				errs = append(errs, fmt.Errorf("approve config #%d: sensitive_paths entries must name a directory, got %q", i, dir))
+			}
+		}
+		if approve.WaiveIssueForTrivial && len(approve.UnownedPathFilter) == 0 {
+			errs = append(errs, fmt.Errorf("approve config #%d: waive_issue_for_trivial requires unowned_path_filter", i))
+		}
+		// The notification keeps its machine-readable status in HTML comments,
+		// which a comment in the footer could break.
+		if strings.Contains(approve.NotificationFooter, "<!--") {
+			errs = append(errs, fmt.Errorf("approve config #%d: notification_footer must not contain HTML comments", i))
+		}
+		if approve.QuorumMode && approve.QuorumCount < 1 {
+			errs = append(errs, fmt.Errorf("approve config #%d: quorum_mode requires a positive quorum_count, got %d", i, approve.QuorumCount))
+		} else if !approve.QuorumMode && approve.QuorumCount != 0 {
+			errs = append(errs, fmt.Errorf("approve config #%d: quorum_count has no effect without quorum_mode", i))
+		}
+		if approve.RequireReapprovalAfterChanges && !approve.ConsiderReviewState() {
+			errs = append(errs, fmt.Errorf("approve config #%d: require_reapproval_after_changes can't be combined with ignore_review_state", i))
+		}
+		if approve.TreatDismissalAsCancel && !approve.ConsiderReviewState() {
+			errs = append(errs, fmt.Errorf("approve config #%d: treat_dismissal_as_cancel can't be combined with ignore_review_state", i))
+		}
+		excluded := sets.NewString()
+		for _, login := range approve.ExcludedApprovers {
+			excluded.Insert(strings.ToLower(login))
+		}
+		for _, login := range approve.AllowedBotApprovers {
+			if excluded.Has(strings.ToLower(login)) {
+				errs = append(errs, fmt.Errorf("approve config #%d: %q is listed in both allowed_bot_approvers and excluded_approvers", i, login))
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
 func validateRequireMatchingLabel(rs []RequireMatchingLabel) error {
 	for i, r := range rs {
 		if err := r.validate(); err != nil {
@@ -1195,6 +1691,45 @@ func compileRegexpsAndDurations(pc *Configuration) error {
 		pc.Blockades[i].BranchRe = branchRe
 	}
 
+	for i := range pc.Approve {
+		pc.Approve[i].UnownedPathRe = nil
+		for _, filter := range pc.Approve[i].UnownedPathFilter {
+			re, err := regexp.Compile(filter)
+			if err != nil {
+				return fmt.Errorf("failed to compile approve unowned_path_filter: %q, error: %v", filter, err)
+			}
+			pc.Approve[i].UnownedPathRe = append(pc.Approve[i].UnownedPathRe, re)
+		}
+		for j := range pc.Approve[i].FreezeWindows {
+			window := &pc.Approve[i].FreezeWindows[j]
+			start, err := time.Parse(time.RFC3339, window.Start)
+			if err != nil {
+				return fmt.Errorf("failed to parse approve freeze_windows start: %q, error: %v", window.Start, err)
+			}
+			end, err := time.Parse(time.RFC3339, window.End)
+			if err != nil {
+				return fmt.Errorf("failed to parse approve freeze_windows end: %q, error: %v", window.End, err)
+			}
+			window.StartTime, window.EndTime = start, end
+		}
+		for j := range pc.Approve[i].AutoApproveRules {
+			rule := &pc.Approve[i].AutoApproveRules[j]
+			re, err := regexp.Compile(rule.PathFilter)
+			if err != nil {
+				return fmt.Errorf("failed to compile approve auto_approve_rules path_filter: %q, error: %v", rule.PathFilter, err)
+			}
+			rule.PathRe = re
+		}
+		pc.Approve[i].EscalateAfterDuration = 0
+		if escalateAfter := pc.Approve[i].EscalateAfter; escalateAfter != "" {
+			dur, err := time.ParseDuration(escalateAfter)
+			if err != nil {
+				return fmt.Errorf("failed to parse approve escalate_after: %q, error: %v", escalateAfter, err)
+			}
+			pc.Approve[i].EscalateAfterDuration = dur
+		}
+	}
+
 	commentRe, err := regexp.Compile(pc.Heart.CommentRegexp)
 	if err != nil {
 		return err
@@ -1258,6 +1793,9 @@ func (c *Configuration) Validate() error {
 	if err := validateTrigger(c.Triggers); err != nil {
 		return err
 	}
+	if err := ValidateApproveConfig(c); err != nil {
+		return err
+	}
 
 	return nil
 }
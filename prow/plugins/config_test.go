@@ -281,6 +281,262 @@ func TestSetApproveDefaults(t *testing.T) {
 	}
 }
 
+func TestApproveForGlob(t *testing.T) {
+	c := &Configuration{
+		Approve: []Approve{
+			{
+				Repos:             []string{"org"},
+				LgtmActsAsApprove: true,
+			},
+			{
+				Repos:         []string{"org/service-*"},
+				IssueRequired: true,
+			},
+			{
+				Repos:      []string{"org/service-legacy"},
+				SkipDrafts: true,
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		repo     string
+		expected []string
+	}{
+		{
+			name:     "glob matches a repo",
+			repo:     "service-a",
+			expected: []string{"org/service-*"},
+		},
+		{
+			name:     "glob matches another repo",
+			repo:     "service-b",
+			expected: []string{"org/service-*"},
+		},
+		{
+			name:     "exact repo overrides glob",
+			repo:     "service-legacy",
+			expected: []string{"org/service-legacy"},
+		},
+		{
+			name:     "org for repo not matching the glob",
+			repo:     "website",
+			expected: []string{"org"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if a := c.ApproveFor("org", test.repo); !reflect.DeepEqual(a.Repos, test.expected) {
+				t.Errorf("expected the approve config for %v, but got the one for %v", test.expected, a.Repos)
+			}
+		})
+	}
+}
+
+func TestValidateApproveConfig(t *testing.T) {
+	yes := true
+	testCases := []struct {
+		name        string
+		approve     []Approve
+		expectedErr string
+	}{
+		{
+			name: "valid config",
+			approve: []Approve{
+				{
+					Repos:             []string{"org", "other-org/repo"},
+					CommandHelpLink:   "https://prow.k8s.io/command-help",
+					PrProcessLink:     "https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process",
+					RequiredApprovers: 2,
+					ApproverWeights:   map[string]int{"alice": 2},
+				},
+				{
+					Repos: []string{"org/repo"},
+				},
+			},
+		},
+		{
+			name:        "empty repos",
+			approve:     []Approve{{Repos: []string{"org"}}, {}},
+			expectedErr: "approve config #1: repos must not be empty",
+		},
+		{
+			name:        "repo configured twice",
+			approve:     []Approve{{Repos: []string{"org/repo"}}, {Repos: []string{"org/repo"}}},
+			expectedErr: `approve config #1: "org/repo" is already configured by approve config #0, only the first one is used`,
+		},
+		{
+			name:        "relative link",
+			approve:     []Approve{{Repos: []string{"org"}, PrProcessLink: "contributors/guide/owners.md"}},
+			expectedErr: `approve config #0: pr_process_link "contributors/guide/owners.md" must be an absolute URL`,
+		},
+		{
+			name:        "whitespace title phrase",
+			approve:     []Approve{{Repos: []string{"org"}, TitleApprovalPhrase: " "}},
+			expectedErr: "approve config #0: title_approval_phrase must not consist of whitespace only",
+		},
+		{
+			name:        "escalate_after without escalation_approvers",
+			approve:     []Approve{{Repos: []string{"org"}, EscalateAfter: "72h", EscalateAfterDuration: 72 * time.Hour}},
+			expectedErr: "approve config #0: escalate_after and escalation_approvers must be set together",
+		},
+		{
+			name:        "negative escalate_after",
+			approve:     []Approve{{Repos: []string{"org"}, EscalateAfter: "-1h", EscalateAfterDuration: -time.Hour, EscalationApprovers: []string{"alice"}}},
+			expectedErr: `approve config #0: escalate_after must be positive, got "-1h"`,
+		},
+		{
+			name:    "escalation",
+			approve: []Approve{{Repos: []string{"org"}, EscalateAfter: "72h", EscalateAfterDuration: 72 * time.Hour, EscalationApprovers: []string{"org/team"}}},
+		},
+		{
+			name:        "negative owners change required approvers",
+			approve:     []Approve{{Repos: []string{"org"}, OwnersChangeRequiredApprovers: -1}},
+			expectedErr: "approve config #0: invalid owners_change_required_approvers: -1 (needs to be positive)",
+		},
+		{
+			name:        "sensitive path naming the root",
+			approve:     []Approve{{Repos: []string{"org"}, SensitivePaths: []string{"security/", "/"}}},
+			expectedErr: `approve config #0: sensitive_paths entries must name a directory, got "/"`,
+		},
+		{
+			name:    "sensitive paths",
+			approve: []Approve{{Repos: []string{"org"}, SensitivePaths: []string{"security/", "pkg/auth"}}},
+		},
+		{
+			name:        "invalid repo glob",
+			approve:     []Approve{{Repos: []string{"org/service-["}}},
+			expectedErr: `approve config #0: repos entry "org/service-[" is not a valid glob pattern: syntax error in pattern`,
+		},
+		{
+			name:        "cancel keyword with several words",
+			approve:     []Approve{{Repos: []string{"org"}, CancelKeyword: "take back"}},
+			expectedErr: `approve config #0: cancel_keyword "take back" must be a single word`,
+		},
+		{
+			name:        "cancel keyword shadowing another argument",
+			approve:     []Approve{{Repos: []string{"org"}, CancelKeyword: "No-Issue"}},
+			expectedErr: `approve config #0: cancel_keyword "No-Issue" clashes with the other arguments of /approve`,
+		},
+		{
+			name:        "cancel keyword with a prefix",
+			approve:     []Approve{{Repos: []string{"org"}, CancelKeyword: "for:ever"}},
+			expectedErr: `approve config #0: cancel_keyword "for:ever" clashes with the other arguments of /approve`,
+		},
+		{
+			name:    "single word cancel keyword",
+			approve: []Approve{{Repos: []string{"org"}, CancelKeyword: "retract"}},
+		},
+		{
+			name:        "negative required approvers",
+			approve:     []Approve{{Repos: []string{"org"}, RequiredApprovers: -1}},
+			expectedErr: "approve config #0: invalid required_approvers: -1 (needs to be positive)",
+		},
+		{
+			name:        "zero approver weight",
+			approve:     []Approve{{Repos: []string{"org"}, ApproverWeights: map[string]int{"alice": 0}}},
+			expectedErr: `approve config #0: invalid approver_weights for "alice": 0 (needs to be positive)`,
+		},
+		{
+			name:        "empty area approvers",
+			approve:     []Approve{{Repos: []string{"org"}, AreaApprovers: map[string][]string{"area/networking": nil}}},
+			expectedErr: `approve config #0: area_approvers for "area/networking" must not be empty`,
+		},
+		{
+			name:        "ignore draft approvals without skip drafts",
+			approve:     []Approve{{Repos: []string{"org"}, IgnoreDraftApprovals: true}},
+			expectedErr: "approve config #0: ignore_draft_approvals requires skip_drafts",
+		},
+		{
+			name:        "edit notification in place with notification as review",
+			approve:     []Approve{{Repos: []string{"org"}, EditNotificationInPlace: true, NotificationAsReview: true}},
+			expectedErr: "approve config #0: edit_notification_in_place can't be combined with notification_as_review",
+		},
+		{
+			name: "freeze window ending before it starts",
+			approve: []Approve{{Repos: []string{"org"}, FreezeWindows: []FreezeWindow{{
+				StartTime: time.Date(2020, 12, 18, 0, 0, 0, 0, time.UTC),
+				EndTime:   time.Date(2020, 12, 17, 0, 0, 0, 0, time.UTC),
+			}}}},
+			expectedErr: "approve config #0: freeze_windows[0] must end after it starts",
+		},
+		{
+			name:        "quorum mode without quorum count",
+			approve:     []Approve{{Repos: []string{"org"}, QuorumMode: true}},
+			expectedErr: "approve config #0: quorum_mode requires a positive quorum_count, got 0",
+		},
+		{
+			name:        "quorum count without quorum mode",
+			approve:     []Approve{{Repos: []string{"org"}, QuorumCount: 2}},
+			expectedErr: "approve config #0: quorum_count has no effect without quorum_mode",
+		},
+		{
+			name:        "auto approve rule without path filter",
+			approve:     []Approve{{Repos: []string{"org"}, AutoApproveRules: []AutoApproveRule{{Author: "manifest-bot"}}}},
+			expectedErr: "approve config #0: auto_approve_rules[0] needs an author and a path_filter",
+		},
+		{
+			name:        "waive issue for trivial without unowned path filter",
+			approve:     []Approve{{Repos: []string{"org"}, WaiveIssueForTrivial: true}},
+			expectedErr: "approve config #0: waive_issue_for_trivial requires unowned_path_filter",
+		},
+		{
+			// Issues may still be required per PR with "/approve require-issue".
+			name:    "issue requirement options without issue required",
+			approve: []Approve{{Repos: []string{"org"}, RequireClosingKeyword: true, RequireOpenIssues: true, NoIssueRequiresConsensus: true}},
+		},
+		{
+			name:        "notification footer with an HTML comment",
+			approve:     []Approve{{Repos: []string{"org"}, NotificationFooter: "See the guidelines. <!-- META={} -->"}},
+			expectedErr: "approve config #0: notification_footer must not contain HTML comments",
+		},
+		{
+			name:        "require reapproval after changes ignoring the review state",
+			approve:     []Approve{{Repos: []string{"org"}, RequireReapprovalAfterChanges: true, IgnoreReviewState: &yes}},
+			expectedErr: "approve config #0: require_reapproval_after_changes can't be combined with ignore_review_state",
+		},
+		{
+			name:        "treat dismissal as cancel ignoring the review state",
+			approve:     []Approve{{Repos: []string{"org"}, TreatDismissalAsCancel: true, IgnoreReviewState: &yes}},
+			expectedErr: "approve config #0: treat_dismissal_as_cancel can't be combined with ignore_review_state",
+		},
+		{
+			name:        "stale approval label is the approved label",
+			approve:     []Approve{{Repos: []string{"org"}, StaleApprovalLabel: "Approved"}},
+			expectedErr: `approve config #0: stale_approval_label must not be the "approved" label`,
+		},
+		{
+			name:        "partial approval label is the approved label",
+			approve:     []Approve{{Repos: []string{"org"}, PartialApprovalLabel: "approved"}},
+			expectedErr: `approve config #0: partial_approval_label must not be the "approved" label`,
+		},
+		{
+			name:        "unknown trigger action",
+			approve:     []Approve{{Repos: []string{"org"}, TriggerOnActions: []string{"opened", "assigned"}}},
+			expectedErr: `approve config #0: invalid trigger_on_actions "assigned", must be one of [converted_to_draft labeled opened ready_for_review reopened synchronize]`,
+		},
+		{
+			name:        "bot both allowed and excluded",
+			approve:     []Approve{{Repos: []string{"org"}, AllowedBotApprovers: []string{"release-bot"}, ExcludedApprovers: []string{"Release-Bot"}}},
+			expectedErr: `approve config #0: "release-bot" is listed in both allowed_bot_approvers and excluded_approvers`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var errMsg string
+			if err := ValidateApproveConfig(&Configuration{Approve: tc.approve}); err != nil {
+				errMsg = err.Error()
+			}
+			if errMsg != tc.expectedErr {
+				t.Errorf("expected error %q, got %q", tc.expectedErr, errMsg)
+			}
+		})
+	}
+}
+
 func TestSetHelpDefaults(t *testing.T) {
 	tests := []struct {
 		name              string
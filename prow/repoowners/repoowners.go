@@ -425,6 +425,52 @@ func (a RepoAliases) ExpandAllAliases() sets.String {
 	return result
 }
 
+// FindAliasCycle returns a cycle of aliases listing each other as members,
+// such as "a" listing "b" and "b" listing "a", as the path starting and ending
+// with the same alias, e.g. [a b a]. It returns nil if there is no cycle.
+// Aliases are only expanded one level deep, so a cycle doesn't make expansion
+// loop, but it leaves alias names where logins are expected.
+func (a RepoAliases) FindAliasCycle() []string {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(alias string) []string
+	visit = func(alias string) []string {
+		switch state[alias] {
+		case visited:
+			return nil
+		case visiting:
+			for i, p := range path {
+				if p == alias {
+					return append(append([]string{}, path[i:]...), alias)
+				}
+			}
+		}
+		state[alias] = visiting
+		path = append(path, alias)
+		for _, member := range a[alias].List() {
+			if _, ok := a[member]; !ok {
+				continue
+			}
+			if cycle := visit(member); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[alias] = visited
+		return nil
+	}
+	for _, alias := range sets.StringKeySet(a).List() {
+		if cycle := visit(alias); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
 func loadAliasesFrom(baseDir, filename string, log *logrus.Entry) RepoAliases {
 	path := filepath.Join(baseDir, filename)
 	b, err := ioutil.ReadFile(path)
@@ -1187,6 +1187,48 @@ func TestExpandAliases(t *testing.T) {
 	}
 }
 
+func TestFindAliasCycle(t *testing.T) {
+	tests := []struct {
+		name          string
+		aliases       RepoAliases
+		expectedCycle []string
+	}{
+		{
+			name:    "No aliases.",
+			aliases: nil,
+		},
+		{
+			name: "Aliases without cycle.",
+			aliases: RepoAliases{
+				"team/t1": sets.NewString("u1", "team/t2"),
+				"team/t2": sets.NewString("u2"),
+			},
+		},
+		{
+			name: "Alias listing itself.",
+			aliases: RepoAliases{
+				"team/t1": sets.NewString("u1", "team/t1"),
+			},
+			expectedCycle: []string{"team/t1", "team/t1"},
+		},
+		{
+			name: "Aliases listing each other.",
+			aliases: RepoAliases{
+				"team/t1": sets.NewString("u1", "team/t2"),
+				"team/t2": sets.NewString("team/t3"),
+				"team/t3": sets.NewString("team/t1", "u3"),
+			},
+			expectedCycle: []string{"team/t1", "team/t2", "team/t3", "team/t1"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.aliases.FindAliasCycle(); !reflect.DeepEqual(got, test.expectedCycle) {
+			t.Errorf("[%s] Expected cycle %q, but got %q.", test.name, test.expectedCycle, got)
+		}
+	}
+}
+
 func TestSaveSimpleConfig(t *testing.T) {
 	dir, err := ioutil.TempDir("", "simpleConfig")
 	if err != nil {